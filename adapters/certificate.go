@@ -3,31 +3,86 @@ package adapters
 import (
 	"context"
 	"crypto/sha256"
-	"crypto/tls"
 	"crypto/x509"
 	"encoding/hex"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/overmindtech/sdp-go"
+	"github.com/overmindtech/sdpcache"
 )
 
 // CertToName Returns the name of a cert as a string. This is in the format of:
 //
 // {Subject.CommonName} (SHA-256: {fingerprint})
 func CertToName(cert *x509.Certificate) string {
-	sum := sha256.Sum256(cert.Raw)
-	hexString := toHex(sum[:])
-
 	return fmt.Sprintf(
 		"%v (SHA-256: %v)",
 		cert.Subject.CommonName,
-		hexString,
+		certificateFingerprint(cert),
 	)
 }
 
+// certificateFingerprint Returns the SHA-256 fingerprint of a cert, which is
+// the unique attribute of certificate items
+func certificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return toHex(sum[:])
+}
+
+// normalizeFingerprint Converts a SHA-256 fingerprint to the format of the
+// `fingerprint` attribute. Colons, whitespace and case are ignored, and the
+// output of `openssl x509 -fingerprint -sha256` can be used as is
+func normalizeFingerprint(query string) (string, error) {
+	fingerprint := query
+	if i := strings.LastIndex(fingerprint, "="); i >= 0 {
+		fingerprint = fingerprint[i+1:]
+	}
+
+	fingerprint = strings.ReplaceAll(strings.Join(strings.Fields(fingerprint), ""), ":", "")
+
+	b, err := hex.DecodeString(fingerprint)
+	if err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("%v is not a SHA-256 fingerprint", query)
+	}
+
+	return toHex(b), nil
+}
+
+// certificateBundleLink Returns a query for the certificates that a server
+// presented, as a PEM bundle in the order they were sent
+func certificateBundleLink(certs []*x509.Certificate, scope string) *sdp.LinkedItemQuery {
+	pems := make([]string, 0, len(certs))
+
+	for _, cert := range certs {
+		block := pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: cert.Raw,
+		}
+
+		pems = append(pems, string(pem.EncodeToMemory(&block)))
+	}
+
+	return &sdp.LinkedItemQuery{
+		Query: &sdp.Query{
+			Type:   "certificate",
+			Method: sdp.QueryMethod_SEARCH,
+			Query:  strings.Join(pems, "\n"),
+			Scope:  scope,
+		},
+		BlastPropagation: &sdp.BlastPropagation{
+			// Changing the cert will affect the server
+			In: true,
+			// The server won't affect the cert
+			Out: false,
+		},
+	}
+}
+
 // toHex converts bytes to their uppercase hex representation, separated by colons
 func toHex(b []byte) string {
 	if len(b) == 0 {
@@ -45,9 +100,47 @@ func toHex(b []byte) string {
 	return strings.ToUpper(s)
 }
 
-// CertificateAdapter This adapter only responds to Search() requests. See the
-// docs for the Search() method for more info
-type CertificateAdapter struct{}
+// CertificateAdapter Parses certificates that are passed to Search(), after
+// which they can be got by fingerprint. See the docs for the Search() method
+// for more info
+type CertificateAdapter struct {
+	// The CAs to verify certificates against, nil means the system roots
+	RootCAs *x509.CertPool
+	// How long before expiry a certificate's health becomes a warning,
+	// defaults to DefaultCertificateExpiryWarning
+	ExpiryWarning time.Duration
+	// Passwords to try when decrypting PKCS#12 files, after an empty password
+	PKCS12Passwords []string
+
+	cache       *sdpcache.Cache // The sdpcache of this adapter
+	cacheInitMu sync.Mutex      // Mutex to ensure cache is only initialised once
+}
+
+// certificateCacheDuration How long certificates found by Search() can be got
+// for. Their health depends on the current time so this is kept short
+const certificateCacheDuration = time.Hour
+
+func (s *CertificateAdapter) ensureCache() {
+	s.cacheInitMu.Lock()
+	defer s.cacheInitMu.Unlock()
+
+	if s.cache == nil {
+		s.cache = sdpcache.NewCache()
+	}
+}
+
+func (s *CertificateAdapter) Cache() *sdpcache.Cache {
+	s.ensureCache()
+	return s.cache
+}
+
+func (s *CertificateAdapter) expiryWarning() time.Duration {
+	if s.ExpiryWarning > 0 {
+		return s.ExpiryWarning
+	}
+
+	return DefaultCertificateExpiryWarning
+}
 
 // Type The type of items that this adapter is capable of finding
 func (s *CertificateAdapter) Type() string {
@@ -67,10 +160,13 @@ var certificateMetadata = Metadata.Register(&sdp.AdapterMetadata{
 	DescriptiveName: "Certificate",
 	Type:            "certificate",
 	SupportedQueryMethods: &sdp.AdapterSupportedQueryMethods{
+		Get:               true,
+		GetDescription:    "A SHA-256 fingerprint of a certificate that has already been found by a search, with or without colons",
 		Search:            true,
-		SearchDescription: "Takes a full certificate, or certificate bundle as input in PEM encoded format",
+		SearchDescription: "Takes a full certificate, or certificate bundle as input. This can be PEM, DER, PKCS#7, PKCS#12 or a JKS keystore, with binary formats optionally base64 encoded. Each certificate is verified using the rest of the bundle as intermediates, and its health reflects whether it has expired or is about to",
 	},
-	Category: sdp.AdapterCategory_ADAPTER_CATEGORY_NETWORK,
+	PotentialLinks: []string{"certificate", "dns", "ip"},
+	Category:       sdp.AdapterCategory_ADAPTER_CATEGORY_NETWORK,
 })
 
 // List of scopes that this adapter is capable of find items for. If the
@@ -82,18 +178,38 @@ func (s *CertificateAdapter) Scopes() []string {
 	}
 }
 
-// Get This adapter does not respond to Get() requests. The logic here is that
-// there are many places we might find a certificate, for example after making a
-// HTTP connection, sitting on disk, after making a database connection, etc.
-// Rather than implement a adapter that knows how to make each of these
-// connections, instead we have created this adapter which takes the cert itself
-// as an input to Search() and parses it and returns the info
+// Get Returns a certificate by its SHA-256 fingerprint. There are many places
+// we might find a certificate, for example after making a HTTP connection,
+// sitting on disk, after making a database connection, etc. Rather than
+// implement a adapter that knows how to make each of these connections,
+// instead we have created this adapter which takes the cert itself as an input
+// to Search() and parses it and returns the info. Get() can only return certs
+// that have been found by Search() recently, so `ignoreCache` has no effect
 func (s *CertificateAdapter) Get(ctx context.Context, scope string, query string, ignoreCache bool) (*sdp.Item, error) {
-	return nil, &sdp.QueryError{
-		ErrorType:   sdp.QueryError_NOTFOUND,
-		ErrorString: "certificate only responds to Search() requests. Consult the documentation",
-		Scope:       scope,
+	fingerprint, err := normalizeFingerprint(query)
+	if err != nil {
+		return nil, &sdp.QueryError{
+			ErrorType:   sdp.QueryError_OTHER,
+			ErrorString: err.Error(),
+			Scope:       scope,
+		}
 	}
+
+	s.ensureCache()
+	cacheHit, _, cachedItems, qErr := s.cache.Lookup(ctx, s.Name(), sdp.QueryMethod_GET, scope, s.Type(), fingerprint, false)
+	if qErr != nil {
+		return nil, qErr
+	}
+
+	if !cacheHit || len(cachedItems) == 0 {
+		return nil, &sdp.QueryError{
+			ErrorType:   sdp.QueryError_NOTFOUND,
+			ErrorString: fmt.Sprintf("certificate %v has not been found by a search. Certificates must be passed to Search() before they can be got", fingerprint),
+			Scope:       scope,
+		}
+	}
+
+	return cachedItems[0], nil
 }
 
 // List Is not implemented for HTTP as this would require scanning many
@@ -104,42 +220,39 @@ func (s *CertificateAdapter) List(ctx context.Context, scope string, ignoreCache
 	return items, nil
 }
 
-// Search This method takes a full certificate, or certificate bundle as input
-// (in PEM encoded format), parses them, and returns a items, one for each
-// certificate that was found
+// Search This method takes a full certificate, or certificate bundle as input,
+// parses them, and returns a items, one for each certificate that was found.
+// The input can be PEM, DER, PKCS#7, PKCS#12 or a Java keystore, and binary
+// formats can be base64 encoded. If only some of the input can be parsed the
+// items are returned along with an error listing the parts that failed. Each
+// certificate can then be got by its fingerprint
 func (s *CertificateAdapter) Search(ctx context.Context, scope string, query string, ignoreCache bool) ([]*sdp.Item, error) {
-	var errors []error
 	var items []*sdp.Item
 
-	bundle, err := decodePem(query)
+	certs, errs := decodeCertificates(query, s.PKCS12Passwords)
 
-	if err != nil {
+	if len(certs) == 0 {
 		return nil, &sdp.QueryError{
 			ErrorType:   sdp.QueryError_OTHER,
-			ErrorString: err.Error(),
+			ErrorString: fmt.Sprintf("no certificates could be parsed:\n%v", errors.Join(errs...)),
 			Scope:       scope,
 		}
 	}
 
+	now := time.Now()
+
+	s.ensureCache()
+	ck := sdpcache.CacheKeyFromParts(s.Name(), sdp.QueryMethod_SEARCH, scope, s.Type(), query)
+
 	// Range over all the parsed certs
-	for _, b := range bundle.Certificate {
-		var cert *x509.Certificate
+	for i, cert := range certs {
 		var err error
 		var attributes *sdp.ItemAttributes
 
-		cert, err = x509.ParseCertificate(b)
-
-		if err != nil {
-			errors = append(errors, err)
-			// Skip this cert
-			continue
-		}
-
 		attributes, err = sdp.ToAttributes(map[string]interface{}{
+			"fingerprint":        certificateFingerprint(cert),
 			"issuer":             cert.Issuer.String(),
 			"subject":            cert.Subject.String(),
-			"notBefore":          cert.NotBefore.String(),
-			"notAfter":           cert.NotAfter.String(),
 			"signatureAlgorithm": cert.SignatureAlgorithm.String(),
 			"signature":          toHex(cert.Signature),
 			"publicKeyAlgorithm": cert.PublicKeyAlgorithm.String(),
@@ -165,6 +278,29 @@ func (s *CertificateAdapter) Search(ctx context.Context, scope string, query str
 			}
 		}
 
+		lifecycle := certificateLifecycle{
+			NotBefore: cert.NotBefore,
+			NotAfter:  cert.NotAfter,
+			Now:       now,
+		}
+
+		for k, v := range lifecycle.Attributes() {
+			attributes.Set(k, v)
+		}
+
+		key := newCertificateKey(cert)
+		for k, v := range key.Attributes() {
+			attributes.Set(k, v)
+		}
+
+		// Put this cert first, followed by the rest of the bundle
+		chain := append([]*x509.Certificate{cert}, certs[:i]...)
+		chain = append(chain, certs[i+1:]...)
+
+		for k, v := range verifyChain(chain, s.RootCAs, "", false).Attributes() {
+			attributes.Set(k, v)
+		}
+
 		if len(cert.OCSPServer) > 0 {
 			attributes.Set("ocspServer", strings.Join(cert.OCSPServer, ","))
 		}
@@ -179,6 +315,17 @@ func (s *CertificateAdapter) Search(ctx context.Context, scope string, query str
 
 		if len(cert.DNSNames) > 0 {
 			attributes.Set("dnsNames", cert.DNSNames)
+
+			// Internationalised names are stored as A-labels, so include
+			// the readable form too
+			unicodeNames := make([]string, 0, len(cert.DNSNames))
+			for _, name := range cert.DNSNames {
+				unicodeNames = append(unicodeNames, UnicodeDomain(name))
+			}
+
+			if strings.Join(unicodeNames, ",") != strings.Join(cert.DNSNames, ",") {
+				attributes.Set("unicodeDnsNames", unicodeNames)
+			}
 		}
 
 		if len(cert.IPAddresses) > 0 {
@@ -233,24 +380,65 @@ func (s *CertificateAdapter) Search(ctx context.Context, scope string, query str
 
 		item := sdp.Item{
 			Type:            "certificate",
-			UniqueAttribute: "subject",
+			UniqueAttribute: "fingerprint",
 			Attributes:      attributes,
 			Scope:           scope,
+			Health:          certificateHealth(cert, lifecycle, key, s.expiryWarning()),
 		}
 
 		items = append(items, &item)
 
-		// If not self signed, add a link to the issuer
-		if cert.Issuer.String() != cert.Subject.String() {
-			// Even though this adapter doesn't support Get() requests, this will
-			// still work for linking as long as the referenced cert has been
-			// included in the bundle since the cache will correctly return the
-			// Get() request when it is run
+		// Link to the names that the certificate is valid for. Wildcards
+		// can't be looked up so are skipped
+		seen := make(map[string]bool)
+		for _, san := range cert.DNSNames {
+			name, err := NormalizeDomain(san)
+			if err != nil || strings.HasPrefix(name, "*") || seen[name] {
+				continue
+			}
+			seen[name] = true
+
+			item.LinkedItemQueries = append(item.LinkedItemQueries, &sdp.LinkedItemQuery{
+				Query: &sdp.Query{
+					Type:   "dns",
+					Method: sdp.QueryMethod_SEARCH,
+					Query:  name,
+					Scope:  "global",
+				},
+				BlastPropagation: &sdp.BlastPropagation{
+					// The certificate and the DNS entries for its names can
+					// change independently
+					In:  false,
+					Out: false,
+				},
+			})
+		}
+
+		for _, ip := range cert.IPAddresses {
+			item.LinkedItemQueries = append(item.LinkedItemQueries, &sdp.LinkedItemQuery{
+				Query: &sdp.Query{
+					Type:   "ip",
+					Method: sdp.QueryMethod_GET,
+					Query:  ip.String(),
+					Scope:  "global",
+				},
+				BlastPropagation: &sdp.BlastPropagation{
+					// The certificate and the IPs it is valid for can change
+					// independently
+					In:  false,
+					Out: false,
+				},
+			})
+		}
+
+		// Link to the issuer if it is in the bundle, which means it will be
+		// in the cache when the link is followed
+		if issuer := findIssuer(cert, certs); issuer != nil {
 			item.LinkedItemQueries = append(item.LinkedItemQueries, &sdp.LinkedItemQuery{
 				Query: &sdp.Query{
 					Type:   "certificate",
 					Method: sdp.QueryMethod_GET,
-					Query:  cert.Issuer.String(),
+					Query:  certificateFingerprint(issuer),
 					Scope:  scope,
 				},
 				BlastPropagation: &sdp.BlastPropagation{
@@ -261,13 +449,16 @@ func (s *CertificateAdapter) Search(ctx context.Context, scope string, query str
 				},
 			})
 		}
+
+		s.cache.StoreItem(&item, certificateCacheDuration, ck)
 	}
 
-	// If all failed return an error
-	if len(errors) == len(bundle.Certificate) {
+	// Return the certificates that could be parsed, along with which parts
+	// of the input couldn't
+	if len(errs) > 0 {
 		return items, &sdp.QueryError{
 			ErrorType:   sdp.QueryError_OTHER,
-			ErrorString: fmt.Sprintf("parsing all certs failed, errors: %v", errors),
+			ErrorString: fmt.Sprintf("some certificates could not be parsed:\n%v", errors.Join(errs...)),
 			Scope:       scope,
 		}
 	}
@@ -275,25 +466,20 @@ func (s *CertificateAdapter) Search(ctx context.Context, scope string, query str
 	return items, nil
 }
 
-func decodePem(certInput string) (tls.Certificate, error) {
-	var bundle tls.Certificate
-	certPEMBlock := []byte(certInput)
-	var certDERBlock *pem.Block
-	for {
-		certDERBlock, certPEMBlock = pem.Decode(certPEMBlock)
-		if certDERBlock == nil {
-			break
-		}
-		if certDERBlock.Type == "CERTIFICATE" {
-			bundle.Certificate = append(bundle.Certificate, certDERBlock.Bytes)
-		}
+// findIssuer Returns the cert in the bundle that signed `cert`, or nil if it
+// isn't there or `cert` is self-signed
+func findIssuer(cert *x509.Certificate, bundle []*x509.Certificate) *x509.Certificate {
+	if cert.Issuer.String() == cert.Subject.String() {
+		return nil
 	}
 
-	if len(bundle.Certificate) == 0 {
-		return bundle, errors.New("no certificates could be parsed")
+	for _, candidate := range bundle {
+		if candidate != cert && candidate.Subject.String() == cert.Issuer.String() && cert.CheckSignatureFrom(candidate) == nil {
+			return candidate
+		}
 	}
 
-	return bundle, nil
+	return nil
 }
 
 // Weight Returns the priority weighting of items returned by this adapter.
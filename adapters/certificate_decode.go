@@ -0,0 +1,445 @@
+package adapters
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// oidPKCS7SignedData The content type of PKCS#7 certificate bundles
+var oidPKCS7SignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+
+// pkcs7ContentInfo The outer structure of a PKCS#7 file
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+// pkcs7SignedData The start of a PKCS#7 SignedData, the CRLs and signer infos
+// are ignored
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      asn1.RawValue
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+// Magic numbers at the start of Java keystores
+const (
+	jksMagic   = 0xFEEDFEED
+	jceksMagic = 0xCECECECE
+)
+
+// decodeCertificates Finds every certificate in the input. The input can be
+// PEM, binary DER, a PKCS#7 bundle, a PKCS#12 file or a Java keystore, and
+// binary formats can be base64 encoded. PKCS#12 files are decrypted by trying
+// an empty password and then each of `passwords`. An error is returned for
+// each part of the input that couldn't be parsed, saying which part it was
+func decodeCertificates(input string, passwords []string) ([]*x509.Certificate, []error) {
+	if strings.Contains(input, "-----BEGIN") {
+		return decodePEMCertificates(input, passwords)
+	}
+
+	b := []byte(input)
+
+	if derFormat(b) == "" && !isJavaKeystore(b) {
+		// Binary formats are often base64 encoded so that they can be
+		// stored as text, such as in Kubernetes secrets
+		decoded, err := decodeBase64(input)
+		if err != nil {
+			return nil, []error{errors.New("input is not PEM, base64 or a binary certificate format")}
+		}
+
+		if bytes.Contains(decoded, []byte("-----BEGIN")) {
+			return decodePEMCertificates(string(decoded), passwords)
+		}
+
+		b = decoded
+	}
+
+	certs, err := decodeBinaryCertificates(b, passwords)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	return certs, nil
+}
+
+// decodeBase64 Decodes standard or URL-safe base64, with or without padding,
+// ignoring whitespace
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.Join(strings.Fields(s), "")
+
+	var err error
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		var b []byte
+		if b, err = encoding.DecodeString(s); err == nil {
+			return b, nil
+		}
+	}
+
+	return nil, err
+}
+
+// decodePEMCertificates Parses each PEM block that contains certificates.
+// Anything around the blocks, and blocks such as private keys, are ignored.
+// Indentation and escaped newlines, such as from YAML or JSON, are removed
+// first
+func decodePEMCertificates(input string, passwords []string) ([]*x509.Certificate, []error) {
+	if !strings.Contains(input, "\n") {
+		input = strings.ReplaceAll(input, `\n`, "\n")
+	}
+
+	lines := strings.Split(input, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+
+	rest := []byte(strings.Join(lines, "\n"))
+
+	certs := make([]*x509.Certificate, 0)
+	errs := make([]error, 0)
+
+	var found int
+	for {
+		var block *pem.Block
+
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		found++
+
+		var blockCerts []*x509.Certificate
+		var err error
+
+		switch block.Type {
+		case "CERTIFICATE", "X509 CERTIFICATE":
+			blockCerts, err = x509.ParseCertificates(block.Bytes)
+		case "TRUSTED CERTIFICATE":
+			// OpenSSL appends trust settings after the certificate
+			var cert asn1.RawValue
+			if _, err = asn1.Unmarshal(block.Bytes, &cert); err == nil {
+				blockCerts, err = x509.ParseCertificates(cert.FullBytes)
+			}
+		case "PKCS7", "CMS":
+			blockCerts, err = decodePKCS7(block.Bytes)
+		case "PKCS12":
+			blockCerts, err = decodePKCS12(block.Bytes, passwords)
+		default:
+			// Keys, CSRs and so on
+			continue
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("PEM block %v (%v): %w", found, block.Type, err))
+			continue
+		}
+
+		certs = append(certs, blockCerts...)
+	}
+
+	// pem.Decode skips blocks that are malformed
+	if malformed := strings.Count(input, "-----BEGIN ") - found; malformed > 0 {
+		errs = append(errs, fmt.Errorf("%v PEM blocks are malformed", malformed))
+	}
+
+	if len(certs) == 0 && len(errs) == 0 {
+		errs = append(errs, errors.New("no certificates found in PEM input"))
+	}
+
+	return certs, errs
+}
+
+// derFormat Works out what a DER encoded structure is from its first field,
+// returns an empty string if it isn't DER
+func derFormat(b []byte) string {
+	var outer asn1.RawValue
+	if _, err := asn1.Unmarshal(b, &outer); err != nil || outer.Class != asn1.ClassUniversal || outer.Tag != asn1.TagSequence {
+		return ""
+	}
+
+	var first asn1.RawValue
+	if _, err := asn1.Unmarshal(outer.Bytes, &first); err != nil || first.Class != asn1.ClassUniversal {
+		return ""
+	}
+
+	switch first.Tag {
+	case asn1.TagSequence:
+		// The TBSCertificate
+		return "DER"
+	case asn1.TagOID:
+		// The content type
+		return "PKCS#7"
+	case asn1.TagInteger:
+		// The PFX version
+		return "PKCS#12"
+	default:
+		return ""
+	}
+}
+
+func isJavaKeystore(b []byte) bool {
+	if len(b) < 4 {
+		return false
+	}
+
+	magic := binary.BigEndian.Uint32(b)
+
+	return magic == jksMagic || magic == jceksMagic
+}
+
+// decodeBinaryCertificates Parses binary DER, PKCS#7, PKCS#12 or a Java
+// keystore. Errors are prefixed with the format
+func decodeBinaryCertificates(b []byte, passwords []string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	var err error
+
+	format := derFormat(b)
+
+	switch {
+	case isJavaKeystore(b):
+		format = "JKS"
+		certs, err = decodeJKS(b)
+	case format == "DER":
+		certs, err = x509.ParseCertificates(b)
+	case format == "PKCS#7":
+		certs, err = decodePKCS7(b)
+	case format == "PKCS#12":
+		certs, err = decodePKCS12(b, passwords)
+	default:
+		return nil, errors.New("input is not DER, PKCS#7, PKCS#12 or a Java keystore")
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", format, err)
+	}
+
+	return certs, nil
+}
+
+// decodePKCS7 Returns the certificates in a PKCS#7 SignedData, such as a .p7b
+// bundle
+func decodePKCS7(b []byte) ([]*x509.Certificate, error) {
+	var info pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(b, &info); err != nil {
+		return nil, err
+	}
+
+	if !info.ContentType.Equal(oidPKCS7SignedData) {
+		return nil, fmt.Errorf("content type %v is not SignedData", info.ContentType)
+	}
+
+	var sd pkcs7SignedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &sd); err != nil {
+		return nil, err
+	}
+
+	if len(sd.Certificates.Bytes) == 0 {
+		return nil, errors.New("no certificates in SignedData")
+	}
+
+	return x509.ParseCertificates(sd.Certificates.Bytes)
+}
+
+// decodePKCS12 Returns the certificates in a PKCS#12 file, trying an empty
+// password and then each of `passwords`. Both the legacy SHA-1 and 3DES/RC2
+// encryption and the PBES2/AES encryption used by OpenSSL 3 and recent
+// versions of Java are supported. Files without a private key must be Java
+// trust stores
+func decodePKCS12(b []byte, passwords []string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	var err error
+
+	for _, password := range append([]string{""}, passwords...) {
+		certs, err = decodePKCS12WithPassword(b, password)
+		if !errors.Is(err, pkcs12.ErrIncorrectPassword) {
+			break
+		}
+	}
+
+	if errors.Is(err, pkcs12.ErrIncorrectPassword) {
+		return nil, errors.New("none of the configured passwords are correct")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates in file")
+	}
+
+	return certs, nil
+}
+
+// decodePKCS12WithPassword Decodes a PKCS#12 file that contains a private key
+// and its chain, falling back to a trust store which only has certificates
+func decodePKCS12WithPassword(b []byte, password string) ([]*x509.Certificate, error) {
+	_, cert, chain, err := pkcs12.DecodeChain(b, password)
+	if err == nil {
+		return append([]*x509.Certificate{cert}, chain...), nil
+	}
+
+	if errors.Is(err, pkcs12.ErrIncorrectPassword) {
+		return nil, err
+	}
+
+	certs, trustStoreErr := pkcs12.DecodeTrustStore(b, password)
+	if trustStoreErr != nil {
+		// The error from DecodeChain is more useful as most files have a
+		// private key
+		return nil, err
+	}
+
+	return certs, nil
+}
+
+// decodeJKS Returns the certificates in a JKS keystore, both trusted
+// certificates and the chains of private keys. These aren't encrypted so no
+// password is needed. The integrity check at the end isn't verified
+func decodeJKS(b []byte) ([]*x509.Certificate, error) {
+	r := bytes.NewReader(b)
+
+	var header struct {
+		Magic   uint32
+		Version uint32
+		Count   uint32
+	}
+
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return nil, err
+	}
+
+	if header.Magic == jceksMagic {
+		return nil, errors.New("JCEKS keystores are not supported, convert to PKCS#12 or JKS")
+	}
+
+	if header.Version != 1 && header.Version != 2 {
+		return nil, fmt.Errorf("unsupported keystore version %v", header.Version)
+	}
+
+	readUint32 := func() (uint32, error) {
+		var v uint32
+		err := binary.Read(r, binary.BigEndian, &v)
+		return v, err
+	}
+
+	// readBytes Reads a length prefixed field, the length is checked so a
+	// corrupt file can't cause a huge allocation
+	readBytes := func(length int) ([]byte, error) {
+		if length > r.Len() {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		buf := make([]byte, length)
+		_, err := io.ReadFull(r, buf)
+		return buf, err
+	}
+
+	readUTF := func() (string, error) {
+		var length uint16
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+			return "", err
+		}
+
+		buf, err := readBytes(int(length))
+		return string(buf), err
+	}
+
+	readCert := func() (*x509.Certificate, error) {
+		if header.Version == 2 {
+			certType, err := readUTF()
+			if err != nil {
+				return nil, err
+			}
+
+			if certType != "X.509" {
+				return nil, fmt.Errorf("unsupported certificate type %v", certType)
+			}
+		}
+
+		length, err := readUint32()
+		if err != nil {
+			return nil, err
+		}
+
+		der, err := readBytes(int(length))
+		if err != nil {
+			return nil, err
+		}
+
+		return x509.ParseCertificate(der)
+	}
+
+	certs := make([]*x509.Certificate, 0)
+
+	for i := uint32(0); i < header.Count; i++ {
+		tag, err := readUint32()
+		if err != nil {
+			return nil, err
+		}
+
+		alias, err := readUTF()
+		if err != nil {
+			return nil, err
+		}
+
+		// Skip the creation timestamp
+		if _, err := readBytes(8); err != nil {
+			return nil, err
+		}
+
+		switch tag {
+		case 1:
+			// A private key followed by its chain
+			keyLength, err := readUint32()
+			if err != nil {
+				return nil, err
+			}
+
+			if _, err := readBytes(int(keyLength)); err != nil {
+				return nil, err
+			}
+
+			chainLength, err := readUint32()
+			if err != nil {
+				return nil, err
+			}
+
+			for j := uint32(0); j < chainLength; j++ {
+				cert, err := readCert()
+				if err != nil {
+					return nil, fmt.Errorf("entry %v: %w", alias, err)
+				}
+
+				certs = append(certs, cert)
+			}
+		case 2:
+			// A trusted certificate
+			cert, err := readCert()
+			if err != nil {
+				return nil, fmt.Errorf("entry %v: %w", alias, err)
+			}
+
+			certs = append(certs, cert)
+		default:
+			return nil, fmt.Errorf("entry %v has unsupported type %v", alias, tag)
+		}
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates in keystore")
+	}
+
+	return certs, nil
+}
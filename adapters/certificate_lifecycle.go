@@ -0,0 +1,146 @@
+package adapters
+
+import (
+	"crypto/dsa" // nolint:staticcheck // Only used to report the key size
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"math"
+	"time"
+
+	"github.com/overmindtech/sdp-go"
+)
+
+// DefaultCertificateExpiryWarning How long before a certificate expires that
+// its health becomes a warning
+const DefaultCertificateExpiryWarning = 30 * 24 * time.Hour
+
+// minRSAKeySize RSA keys smaller than this are weak, as per NIST SP 800-131A
+const minRSAKeySize = 2048
+
+// minECDSAKeySize ECDSA curves smaller than this are weak
+const minECDSAKeySize = 256
+
+// certificateLifecycle When a certificate is valid, relative to a point in
+// time
+type certificateLifecycle struct {
+	NotBefore time.Time
+	NotAfter  time.Time
+	Now       time.Time
+}
+
+// Expired Whether the certificate is past its notAfter
+func (l certificateLifecycle) Expired() bool {
+	return l.Now.After(l.NotAfter)
+}
+
+// NotYetValid Whether the certificate is before its notBefore
+func (l certificateLifecycle) NotYetValid() bool {
+	return l.Now.Before(l.NotBefore)
+}
+
+// DaysUntilExpiry Whole days until the certificate expires, negative once it
+// has expired
+func (l certificateLifecycle) DaysUntilExpiry() int {
+	return int(math.Floor(l.NotAfter.Sub(l.Now).Hours() / 24))
+}
+
+// Attributes Returns the lifecycle as item attributes, with RFC 3339
+// timestamps so that they can be sorted and compared
+func (l certificateLifecycle) Attributes() map[string]interface{} {
+	return map[string]interface{}{
+		"notBefore":          l.NotBefore.UTC().Format(time.RFC3339),
+		"notAfter":           l.NotAfter.UTC().Format(time.RFC3339),
+		"validityPeriodDays": int(l.NotAfter.Sub(l.NotBefore).Hours() / 24),
+		"daysUntilExpiry":    l.DaysUntilExpiry(),
+		"expired":            l.Expired(),
+		"notYetValid":        l.NotYetValid(),
+	}
+}
+
+// certificateKey Describes the public key of a certificate and how it was
+// signed
+type certificateKey struct {
+	// The size of the key in bits, zero if unknown
+	Bits int
+	// The curve of ECDSA keys e.g. "P-256"
+	Curve string
+	// Whether the key is too small to be considered secure
+	Weak bool
+	// Whether the certificate was signed using SHA-1
+	SHA1Signature bool
+	// Whether the certificate was signed using a broken hash, which includes
+	// SHA-1, MD5 and MD2
+	WeakSignature bool
+}
+
+// newCertificateKey Inspects the key and signature of a certificate
+func newCertificateKey(cert *x509.Certificate) certificateKey {
+	var k certificateKey
+
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		k.Bits = pub.N.BitLen()
+		k.Weak = k.Bits < minRSAKeySize
+	case *ecdsa.PublicKey:
+		k.Bits = pub.Curve.Params().BitSize
+		k.Curve = pub.Curve.Params().Name
+		k.Weak = k.Bits < minECDSAKeySize
+	case ed25519.PublicKey:
+		k.Bits = 256
+		k.Curve = "Ed25519"
+	case *dsa.PublicKey:
+		// DSA is no longer allowed for signing by FIPS 186-5
+		k.Bits = pub.P.BitLen()
+		k.Weak = true
+	}
+
+	switch cert.SignatureAlgorithm {
+	case x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1:
+		k.SHA1Signature = true
+		k.WeakSignature = true
+	case x509.MD2WithRSA, x509.MD5WithRSA:
+		k.WeakSignature = true
+	}
+
+	return k
+}
+
+// Attributes Returns the key details as item attributes
+func (k certificateKey) Attributes() map[string]interface{} {
+	attrs := map[string]interface{}{
+		"weakKey":       k.Weak,
+		"sha1Signature": k.SHA1Signature,
+		"weakSignature": k.WeakSignature,
+	}
+
+	if k.Bits > 0 {
+		attrs["publicKeySize"] = k.Bits
+	}
+
+	if k.Curve != "" {
+		attrs["publicKeyCurve"] = k.Curve
+	}
+
+	return attrs
+}
+
+// certificateHealth Returns an error if the certificate isn't currently
+// valid, and a warning if it expires within `expiryWarning` or uses a weak
+// key or signature. The signature of a self-signed certificate isn't checked
+// by clients so is ignored
+func certificateHealth(cert *x509.Certificate, l certificateLifecycle, k certificateKey, expiryWarning time.Duration) *sdp.Health {
+	switch {
+	case l.Expired() || l.NotYetValid():
+		return sdp.Health_HEALTH_ERROR.Enum()
+	case l.NotAfter.Sub(l.Now) < expiryWarning:
+		return sdp.Health_HEALTH_WARNING.Enum()
+	case k.Weak:
+		return sdp.Health_HEALTH_WARNING.Enum()
+	case k.WeakSignature && cert.Issuer.String() != cert.Subject.String():
+		return sdp.Health_HEALTH_WARNING.Enum()
+	default:
+		return nil
+	}
+}
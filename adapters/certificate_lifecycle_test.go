@@ -0,0 +1,200 @@
+package adapters
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/overmindtech/sdp-go"
+)
+
+func TestCertificateLifecycle(t *testing.T) {
+	t.Parallel()
+
+	notBefore := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		Name            string
+		Now             time.Time
+		DaysUntilExpiry int
+		Expired         bool
+		NotYetValid     bool
+	}{
+		{
+			Name:            "valid",
+			Now:             time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+			DaysUntilExpiry: 30,
+		},
+		{
+			Name:            "expired",
+			Now:             time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC),
+			DaysUntilExpiry: -1,
+			Expired:         true,
+		},
+		{
+			Name:            "not yet valid",
+			Now:             time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC),
+			DaysUntilExpiry: 92,
+			NotYetValid:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			attrs := certificateLifecycle{
+				NotBefore: notBefore,
+				NotAfter:  notAfter,
+				Now:       test.Now,
+			}.Attributes()
+
+			expected := map[string]interface{}{
+				"notBefore":          "2024-01-01T00:00:00Z",
+				"notAfter":           "2024-04-01T00:00:00Z",
+				"validityPeriodDays": 91,
+				"daysUntilExpiry":    test.DaysUntilExpiry,
+				"expired":            test.Expired,
+				"notYetValid":        test.NotYetValid,
+			}
+
+			for k, v := range expected {
+				if attrs[k] != v {
+					t.Errorf("expected %v to be %v, got %v", k, v, attrs[k])
+				}
+			}
+		})
+	}
+}
+
+func TestNewCertificateKey(t *testing.T) {
+	t.Parallel()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p224Key, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ed25519Key, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		Name     string
+		Cert     *x509.Certificate
+		Expected certificateKey
+	}{
+		{
+			Name: "weak RSA with SHA-1",
+			Cert: &x509.Certificate{
+				PublicKey:          &rsaKey.PublicKey,
+				SignatureAlgorithm: x509.SHA1WithRSA,
+			},
+			Expected: certificateKey{Bits: 1024, Weak: true, SHA1Signature: true, WeakSignature: true},
+		},
+		{
+			Name: "weak ECDSA",
+			Cert: &x509.Certificate{
+				PublicKey:          &p224Key.PublicKey,
+				SignatureAlgorithm: x509.ECDSAWithSHA256,
+			},
+			Expected: certificateKey{Bits: 224, Curve: "P-224", Weak: true},
+		},
+		{
+			Name: "ECDSA",
+			Cert: &x509.Certificate{
+				PublicKey:          &p384Key.PublicKey,
+				SignatureAlgorithm: x509.ECDSAWithSHA384,
+			},
+			Expected: certificateKey{Bits: 384, Curve: "P-384"},
+		},
+		{
+			Name: "Ed25519 with MD5",
+			Cert: &x509.Certificate{
+				PublicKey:          ed25519Key,
+				SignatureAlgorithm: x509.MD5WithRSA,
+			},
+			Expected: certificateKey{Bits: 256, Curve: "Ed25519", WeakSignature: true},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			if k := newCertificateKey(test.Cert); k != test.Expected {
+				t.Errorf("expected %+v, got %+v", test.Expected, k)
+			}
+		})
+	}
+}
+
+func TestCertificateHealth(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	cert := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "www.example.com"},
+		Issuer:       pkix.Name{CommonName: "Example CA"},
+	}
+
+	selfSigned := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Example Root"},
+		Issuer:       pkix.Name{CommonName: "Example Root"},
+	}
+
+	valid := certificateLifecycle{
+		NotBefore: now.Add(-24 * time.Hour),
+		NotAfter:  now.Add(90 * 24 * time.Hour),
+		Now:       now,
+	}
+
+	expiring := valid
+	expiring.NotAfter = now.Add(7 * 24 * time.Hour)
+
+	expired := valid
+	expired.NotAfter = now.Add(-time.Hour)
+
+	tests := []struct {
+		Name      string
+		Cert      *x509.Certificate
+		Lifecycle certificateLifecycle
+		Key       certificateKey
+		Expected  *sdp.Health
+	}{
+		{Name: "valid", Cert: cert, Lifecycle: valid},
+		{Name: "expiring", Cert: cert, Lifecycle: expiring, Expected: sdp.Health_HEALTH_WARNING.Enum()},
+		{Name: "expired", Cert: cert, Lifecycle: expired, Expected: sdp.Health_HEALTH_ERROR.Enum()},
+		{Name: "weak key", Cert: cert, Lifecycle: valid, Key: certificateKey{Weak: true}, Expected: sdp.Health_HEALTH_WARNING.Enum()},
+		{Name: "SHA-1 signature", Cert: cert, Lifecycle: valid, Key: certificateKey{SHA1Signature: true, WeakSignature: true}, Expected: sdp.Health_HEALTH_WARNING.Enum()},
+		{Name: "self-signed SHA-1 root", Cert: selfSigned, Lifecycle: valid, Key: certificateKey{SHA1Signature: true, WeakSignature: true}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			health := certificateHealth(test.Cert, test.Lifecycle, test.Key, DefaultCertificateExpiryWarning)
+
+			if (health == nil) != (test.Expected == nil) || (health != nil && *health != *test.Expected) {
+				t.Errorf("expected %v, got %v", test.Expected, health)
+			}
+		})
+	}
+}
@@ -2,7 +2,12 @@ package adapters
 
 import (
 	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
 	"fmt"
+	"net"
+	"strings"
 	"testing"
 
 	"github.com/overmindtech/discovery"
@@ -82,6 +87,84 @@ func TestCertificateGet(t *testing.T) {
 	}
 }
 
+func TestCertificateGetByFingerprint(t *testing.T) {
+	t.Parallel()
+
+	testChain := newTestChain(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "www.example.com"},
+		DNSNames:    []string{"www.example.com"},
+		IPAddresses: []net.IP{net.ParseIP("192.0.2.1")},
+	}, false)
+
+	leafFingerprint := certificateFingerprint(testChain.Leaf)
+	intermediateFingerprint := certificateFingerprint(testChain.Intermediate)
+
+	src := CertificateAdapter{}
+
+	t.Run("before searching", func(t *testing.T) {
+		_, err := src.Get(context.Background(), "global", leafFingerprint, false)
+
+		var qErr *sdp.QueryError
+		if !errors.As(err, &qErr) || qErr.GetErrorType() != sdp.QueryError_NOTFOUND {
+			t.Errorf("expected a NOTFOUND error, got %v", err)
+		}
+	})
+
+	items, err := src.Search(context.Background(), "global", testChain.PEM(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 2 {
+		t.Fatalf("expected 2 items, got %v", len(items))
+	}
+
+	if items[0].UniqueAttributeValue() != leafFingerprint {
+		t.Errorf("expected the unique attribute to be %v, got %v", leafFingerprint, items[0].UniqueAttributeValue())
+	}
+
+	assertLinks(t, items[0], []string{
+		"dns SEARCH www.example.com",
+		"ip GET 192.0.2.1",
+		"certificate GET " + intermediateFingerprint,
+	})
+
+	// The root isn't in the bundle so can't be linked to
+	for _, liq := range items[1].GetLinkedItemQueries() {
+		if liq.GetQuery().GetType() == "certificate" {
+			t.Errorf("expected no issuer link for the intermediate, got %v", liq.GetQuery().GetQuery())
+		}
+	}
+
+	t.Run("after searching", func(t *testing.T) {
+		queries := []string{
+			leafFingerprint,
+			strings.ToLower(strings.ReplaceAll(leafFingerprint, ":", "")),
+			"sha256 Fingerprint=" + leafFingerprint,
+		}
+
+		for _, query := range queries {
+			item, err := src.Get(context.Background(), "global", query, false)
+			if err != nil {
+				t.Fatalf("%v: %v", query, err)
+			}
+
+			if item.UniqueAttributeValue() != leafFingerprint {
+				t.Errorf("%v: expected %v, got %v", query, leafFingerprint, item.UniqueAttributeValue())
+			}
+		}
+	})
+
+	t.Run("invalid fingerprint", func(t *testing.T) {
+		_, err := src.Get(context.Background(), "global", "AB:CD", false)
+
+		var qErr *sdp.QueryError
+		if !errors.As(err, &qErr) || qErr.GetErrorType() != sdp.QueryError_OTHER {
+			t.Errorf("expected an OTHER error, got %v", err)
+		}
+	})
+}
+
 func TestCertificateList(t *testing.T) {
 	src := CertificateAdapter{}
 
@@ -177,7 +260,7 @@ func TestCertificateSearch(t *testing.T) {
 		},
 		{
 			Attribute: "notBefore",
-			Expected:  "2007-11-09 12:00:00 +0000 UTC",
+			Expected:  "2007-11-09T12:00:00Z",
 		},
 		{
 			Attribute: "publicKeyAlgorithm",
@@ -200,7 +283,7 @@ func TestCertificateSearch(t *testing.T) {
 		},
 		{
 			Attribute: "notAfter",
-			Expected:  "2021-11-10 00:00:00 +0000 UTC",
+			Expected:  "2021-11-10T00:00:00Z",
 		},
 		{
 			Attribute: "keyUsage",
@@ -210,9 +293,37 @@ func TestCertificateSearch(t *testing.T) {
 				"CRL Sign",
 			},
 		},
+		{
+			Attribute: "validityPeriodDays",
+			Expected:  float64(5114),
+		},
+		{
+			Attribute: "expired",
+			Expected:  true,
+		},
+		{
+			Attribute: "notYetValid",
+			Expected:  false,
+		},
+		{
+			Attribute: "publicKeySize",
+			Expected:  float64(2048),
+		},
+		{
+			Attribute: "weakKey",
+			Expected:  false,
+		},
+		{
+			Attribute: "sha1Signature",
+			Expected:  true,
+		},
 	}
 
 	for _, test := range tests {
 		test.Run(t, certs[0])
 	}
+
+	if certs[0].GetHealth() != sdp.Health_HEALTH_ERROR {
+		t.Errorf("expected the expired certificate to have an error health, got %v", certs[0].GetHealth())
+	}
 }
@@ -0,0 +1,155 @@
+package adapters
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// LoadRootCAs Loads a PEM encoded CA bundle to verify certificates against
+// instead of the system roots. If the path is empty nil is returned, meaning
+// that the system roots are used
+func LoadRootCAs(path string) (*x509.CertPool, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA bundle: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %v", path)
+	}
+
+	return pool, nil
+}
+
+// chainVerification The result of verifying a certificate chain
+type chainVerification struct {
+	// Whether the chain leads to a trusted root
+	Trusted bool
+	// Why the chain isn't trusted e.g. "certificate has expired"
+	Error string
+	// The length of the verified chain, including the leaf and the root
+	ChainLength int
+	// Whether the leaf is valid for the hostname, nil if no hostname was
+	// given
+	HostnameMatch *bool
+}
+
+// Attributes Returns the verification result as item attributes
+func (v chainVerification) Attributes() map[string]interface{} {
+	attrs := map[string]interface{}{
+		"trusted": v.Trusted,
+	}
+
+	if v.Error != "" {
+		attrs["verificationError"] = v.Error
+	}
+
+	if v.Trusted {
+		attrs["verifiedChainLength"] = v.ChainLength
+	}
+
+	if v.HostnameMatch != nil {
+		attrs["hostnameMatch"] = *v.HostnameMatch
+	}
+
+	return attrs
+}
+
+// verifyChain Verifies that the first certificate chains to a root in
+// `roots`, or the system roots if nil, using the other certificates as
+// intermediates. If a hostname is given the leaf is also checked against it.
+// If `serverAuth` is false any key usage is accepted, since certificates
+// that weren't presented by a server could be for anything
+func verifyChain(certs []*x509.Certificate, roots *x509.CertPool, hostname string, serverAuth bool) chainVerification {
+	var v chainVerification
+
+	if len(certs) == 0 {
+		v.Error = "no certificates"
+		return v
+	}
+
+	leaf := certs[0]
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   time.Now(),
+	}
+
+	if !serverAuth {
+		opts.KeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageAny}
+	}
+
+	chains, err := leaf.Verify(opts)
+	if err != nil {
+		v.Error = verificationReason(err)
+	} else {
+		v.Trusted = true
+
+		// Use the shortest chain, since that is what clients will prefer
+		for _, chain := range chains {
+			if v.ChainLength == 0 || len(chain) < v.ChainLength {
+				v.ChainLength = len(chain)
+			}
+		}
+	}
+
+	if hostname != "" {
+		match := leaf.VerifyHostname(hostname) == nil
+		v.HostnameMatch = &match
+	}
+
+	return v
+}
+
+// verificationReason Describes why verification failed, including which
+// certificate was the problem since it often isn't the leaf
+func verificationReason(err error) string {
+	var invalid x509.CertificateInvalidError
+	if errors.As(err, &invalid) && invalid.Cert != nil {
+		var reason string
+
+		switch invalid.Reason {
+		case x509.Expired:
+			reason = "certificate has expired or is not yet valid"
+		case x509.NotAuthorizedToSign:
+			reason = "certificate is not authorized to sign other certificates"
+		case x509.CANotAuthorizedForThisName:
+			reason = "issuer is not permitted to issue for this name"
+		case x509.TooManyIntermediates:
+			reason = "too many intermediates"
+		case x509.IncompatibleUsage:
+			reason = "certificate specifies an incompatible key usage"
+		case x509.NameMismatch:
+			reason = "issuer name does not match subject of the issuing certificate"
+		default:
+			return fmt.Sprintf("%v: %v", invalid.Cert.Subject.CommonName, err)
+		}
+
+		if invalid.Detail != "" {
+			reason = fmt.Sprintf("%v: %v", reason, invalid.Detail)
+		}
+
+		return fmt.Sprintf("%v: %v", invalid.Cert.Subject.CommonName, reason)
+	}
+
+	var unknown x509.UnknownAuthorityError
+	if errors.As(err, &unknown) && unknown.Cert != nil {
+		return fmt.Sprintf("%v: certificate signed by unknown authority", unknown.Cert.Subject.CommonName)
+	}
+
+	return err.Error()
+}
@@ -0,0 +1,273 @@
+package adapters
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/overmindtech/sdp-go"
+)
+
+// testChain A root, intermediate and leaf certificate
+type testChain struct {
+	Root            *x509.Certificate
+	Intermediate    *x509.Certificate
+	IntermediateKey *ecdsa.PrivateKey
+	Leaf            *x509.Certificate
+	LeafKey         *ecdsa.PrivateKey
+}
+
+// Pool Returns a pool containing the root
+func (c testChain) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(c.Root)
+	return pool
+}
+
+// PEM Returns the leaf and intermediate as a PEM bundle
+func (c testChain) PEM() string {
+	var b strings.Builder
+	for _, cert := range []*x509.Certificate{c.Leaf, c.Intermediate} {
+		b.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+	}
+	return b.String()
+}
+
+func newTestCert(t *testing.T, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parent == nil {
+		parent = template
+		parentKey = key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+// newTestChain Creates a chain for the leaf template. If expiredIntermediate
+// is true the intermediate expired an hour ago
+func newTestChain(t *testing.T, leaf *x509.Certificate, expiredIntermediate bool) testChain {
+	t.Helper()
+
+	now := time.Now()
+
+	root, rootKey := newTestCert(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Root"},
+		NotBefore:             now.Add(-24 * time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+
+	intermediateTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "Test Intermediate"},
+		NotBefore:             now.Add(-24 * time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	if expiredIntermediate {
+		intermediateTemplate.NotAfter = now.Add(-time.Hour)
+	}
+
+	intermediate, intermediateKey := newTestCert(t, intermediateTemplate, root, rootKey)
+
+	leaf.SerialNumber = big.NewInt(3)
+	leaf.NotBefore = now.Add(-time.Hour)
+	leaf.NotAfter = now.Add(time.Hour)
+	leaf.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+
+	leafCert, leafKey := newTestCert(t, leaf, intermediate, intermediateKey)
+
+	return testChain{
+		Root:            root,
+		Intermediate:    intermediate,
+		IntermediateKey: intermediateKey,
+		Leaf:            leafCert,
+		LeafKey:         leafKey,
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	t.Parallel()
+
+	chain := newTestChain(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "www.example.com"},
+		DNSNames: []string{"www.example.com"},
+	}, false)
+
+	certs := []*x509.Certificate{chain.Leaf, chain.Intermediate}
+
+	t.Run("trusted", func(t *testing.T) {
+		v := verifyChain(certs, chain.Pool(), "www.example.com", true)
+
+		if !v.Trusted || v.ChainLength != 3 || v.HostnameMatch == nil || !*v.HostnameMatch {
+			t.Errorf("expected a trusted chain of 3 matching the hostname, got %+v", v)
+		}
+	})
+
+	t.Run("hostname mismatch", func(t *testing.T) {
+		v := verifyChain(certs, chain.Pool(), "other.example.com", true)
+
+		if !v.Trusted || v.HostnameMatch == nil || *v.HostnameMatch {
+			t.Errorf("expected a trusted chain that doesn't match the hostname, got %+v", v)
+		}
+	})
+
+	t.Run("missing intermediate", func(t *testing.T) {
+		v := verifyChain(certs[:1], chain.Pool(), "", true)
+
+		if v.Trusted || v.Error != "www.example.com: certificate signed by unknown authority" {
+			t.Errorf("expected an unknown authority, got %+v", v)
+		}
+	})
+
+	t.Run("expired intermediate", func(t *testing.T) {
+		expired := newTestChain(t, &x509.Certificate{
+			Subject:  pkix.Name{CommonName: "www.example.com"},
+			DNSNames: []string{"www.example.com"},
+		}, true)
+
+		v := verifyChain([]*x509.Certificate{expired.Leaf, expired.Intermediate}, expired.Pool(), "", true)
+
+		if v.Trusted || !strings.HasPrefix(v.Error, "Test Intermediate: certificate has expired or is not yet valid") {
+			t.Errorf("expected the intermediate to have expired, got %+v", v)
+		}
+	})
+}
+
+func TestCertificateSearchVerification(t *testing.T) {
+	t.Parallel()
+
+	chain := newTestChain(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "www.example.com"},
+		DNSNames: []string{"www.example.com"},
+	}, false)
+
+	src := CertificateAdapter{RootCAs: chain.Pool()}
+
+	items, err := src.Search(context.Background(), "global", chain.PEM(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 2 {
+		t.Fatalf("expected 2 items, got %v", len(items))
+	}
+
+	expectedLengths := []float64{3, 2}
+	for i, item := range items {
+		if trusted, _ := item.GetAttributes().Get("trusted"); trusted != true {
+			t.Errorf("expected %v to be trusted, got %v", item.UniqueAttributeValue(), trusted)
+		}
+
+		if length, _ := item.GetAttributes().Get("verifiedChainLength"); length != expectedLengths[i] {
+			t.Errorf("expected %v to have a chain of %v, got %v", item.UniqueAttributeValue(), expectedLengths[i], length)
+		}
+	}
+
+	// Without the custom root the system roots are used, which won't
+	// include it
+	items, err = (&CertificateAdapter{}).Search(context.Background(), "global", chain.PEM(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if trusted, _ := items[0].GetAttributes().Get("trusted"); trusted != false {
+		t.Errorf("expected the leaf not to be trusted by the system roots, got %v", trusted)
+	}
+
+	if reason, _ := items[0].GetAttributes().Get("verificationError"); reason == nil {
+		t.Error("expected a verificationError")
+	}
+}
+
+func TestHTTPGetVerification(t *testing.T) {
+	t.Parallel()
+
+	chain := newTestChain(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "test server"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+	}, false)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{chain.Leaf.Raw, chain.Intermediate.Raw},
+			PrivateKey:  chain.LeafKey,
+		}},
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	tlsAttributes := func(t *testing.T, src *HTTPAdapter) (map[string]interface{}, *sdp.Item) {
+		item, err := src.Get(context.Background(), "global", server.URL, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tlsAttrs, err := item.GetAttributes().Get("tls")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return tlsAttrs.(map[string]interface{}), item
+	}
+
+	t.Run("trusted", func(t *testing.T) {
+		attrs, item := tlsAttributes(t, &HTTPAdapter{RootCAs: chain.Pool()})
+
+		if attrs["trusted"] != true || attrs["hostnameMatch"] != true || attrs["verifiedChainLength"] != float64(3) {
+			t.Errorf("expected a trusted chain of 3 matching the hostname, got %v", attrs)
+		}
+
+		if item.Health != nil {
+			t.Errorf("expected no health, got %v", item.GetHealth())
+		}
+	})
+
+	t.Run("untrusted", func(t *testing.T) {
+		attrs, item := tlsAttributes(t, &HTTPAdapter{})
+
+		if attrs["trusted"] != false || attrs["verificationError"] == nil {
+			t.Errorf("expected the chain not to be trusted, got %v", attrs)
+		}
+
+		if item.GetHealth() != sdp.Health_HEALTH_ERROR {
+			t.Errorf("expected an error health, got %v", item.GetHealth())
+		}
+	})
+}
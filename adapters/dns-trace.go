@@ -0,0 +1,725 @@
+package adapters
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/overmindtech/sdp-go"
+	"github.com/overmindtech/sdpcache"
+)
+
+// RootHints The IPv4 and IPv6 addresses of the root servers, used as the
+// starting point for iterative resolution. See
+// https://www.iana.org/domains/root/servers
+var RootHints = map[string][]string{
+	"a.root-servers.net": {"198.41.0.4", "2001:503:ba3e::2:30"},
+	"b.root-servers.net": {"170.247.170.2", "2801:1b8:10::b"},
+	"c.root-servers.net": {"192.33.4.12", "2001:500:2::c"},
+	"d.root-servers.net": {"199.7.91.13", "2001:500:2d::d"},
+	"e.root-servers.net": {"192.203.230.10", "2001:500:a8::e"},
+	"f.root-servers.net": {"192.5.5.241", "2001:500:2f::f"},
+	"g.root-servers.net": {"192.112.36.4", "2001:500:12::d0d"},
+	"h.root-servers.net": {"198.97.190.53", "2001:500:1::53"},
+	"i.root-servers.net": {"192.36.148.17", "2001:7fe::53"},
+	"j.root-servers.net": {"192.58.128.30", "2001:503:c27::2:30"},
+	"k.root-servers.net": {"193.0.14.129", "2001:7fd::1"},
+	"l.root-servers.net": {"199.7.83.42", "2001:500:9f::42"},
+	"m.root-servers.net": {"202.12.27.33", "2001:dc3::35"},
+}
+
+// The maximum number of delegations that will be followed before giving up
+const maxTraceDepth = 16
+
+// The maximum depth of nested resolutions when looking up the addresses of
+// name servers that were delegated to without glue
+const maxTraceNesting = 3
+
+// How long traces are cached for. Each step has its own TTLs so there isn't a
+// single TTL that applies to the whole trace
+const dnsTraceCacheDuration = 5 * time.Minute
+
+// DNSTraceAdapter Resolves names iteratively starting at the root, in the
+// same way as `dig +trace`. Rather than returning the answer this returns an
+// item for each step in the delegation so that it is possible to see which
+// servers were responsible for the answer, and where the delegation is broken
+type DNSTraceAdapter struct {
+	// Root servers to start from, keyed by name with the IP addresses as the
+	// value. Defaults to RootHints
+	RootServers map[string][]string
+
+	// The port to use when contacting name servers. Defaults to 53
+	Port string
+
+	client dns.Client
+
+	cache       *sdpcache.Cache // The sdpcache of this adapter
+	cacheInitMu sync.Mutex      // Mutex to ensure cache is only initialised once
+}
+
+func (d *DNSTraceAdapter) ensureCache() {
+	d.cacheInitMu.Lock()
+	defer d.cacheInitMu.Unlock()
+
+	if d.cache == nil {
+		d.cache = sdpcache.NewCache()
+	}
+}
+
+func (d *DNSTraceAdapter) Cache() *sdpcache.Cache {
+	d.ensureCache()
+	return d.cache
+}
+
+// Type is the type of items that this returns
+func (d *DNSTraceAdapter) Type() string {
+	return "dns-trace"
+}
+
+// Name Returns the name of the backend
+func (d *DNSTraceAdapter) Name() string {
+	return "stdlib-dns-trace"
+}
+
+// Weighting of duplicate adapters
+func (d *DNSTraceAdapter) Weight() int {
+	return 100
+}
+
+func (d *DNSTraceAdapter) Metadata() *sdp.AdapterMetadata {
+	return dnsTraceMetadata
+}
+
+var dnsTraceMetadata = Metadata.Register(&sdp.AdapterMetadata{
+	DescriptiveName: "DNS Delegation Trace",
+	Type:            "dns-trace",
+	SupportedQueryMethods: &sdp.AdapterSupportedQueryMethods{
+		Search:            true,
+		SearchDescription: "A DNS name to resolve iteratively from the root servers. Returns one item for each step in the delegation, including the name servers, glue and the server that answered",
+	},
+	PotentialLinks: []string{"dns", "dns-trace", "ip"},
+	Category:       sdp.AdapterCategory_ADAPTER_CATEGORY_NETWORK,
+})
+
+// List of scopes that this adapter is capable of find items for
+func (d *DNSTraceAdapter) Scopes() []string {
+	return []string{
+		"global",
+	}
+}
+
+func (d *DNSTraceAdapter) getRootServers() map[string][]string {
+	if len(d.RootServers) == 0 {
+		return RootHints
+	}
+	return d.RootServers
+}
+
+func (d *DNSTraceAdapter) getPort() string {
+	if d.Port == "" {
+		return "53"
+	}
+	return d.Port
+}
+
+// Get Trace steps can't be queried directly, but they can be returned if they
+// are cached from a previous search
+func (d *DNSTraceAdapter) Get(ctx context.Context, scope string, query string, ignoreCache bool) (*sdp.Item, error) {
+	if scope != "global" {
+		return nil, &sdp.QueryError{
+			ErrorType:   sdp.QueryError_NOSCOPE,
+			ErrorString: "DNS queries only supported in global scope",
+			Scope:       scope,
+		}
+	}
+
+	d.ensureCache()
+	hit, _, items, qErr := d.cache.Lookup(ctx, d.Name(), sdp.QueryMethod_GET, scope, d.Type(), query, ignoreCache)
+	if qErr != nil {
+		return nil, qErr
+	}
+	if hit && len(items) > 0 {
+		return items[0], nil
+	}
+
+	return nil, &sdp.QueryError{
+		ErrorType:   sdp.QueryError_NOTFOUND,
+		ErrorString: "dns-trace steps can't be queried directly, use the SEARCH method instead",
+		Scope:       scope,
+	}
+}
+
+// List is not supported
+func (d *DNSTraceAdapter) List(ctx context.Context, scope string, ignoreCache bool) ([]*sdp.Item, error) {
+	if scope != "global" {
+		return nil, &sdp.QueryError{
+			ErrorType:   sdp.QueryError_NOSCOPE,
+			ErrorString: "DNS queries only supported in global scope",
+			Scope:       scope,
+		}
+	}
+
+	return make([]*sdp.Item, 0), nil
+}
+
+// Search Traces the delegation for a name and returns an item for each step
+func (d *DNSTraceAdapter) Search(ctx context.Context, scope string, query string, ignoreCache bool) ([]*sdp.Item, error) {
+	if scope != "global" {
+		return nil, &sdp.QueryError{
+			ErrorType:   sdp.QueryError_NOSCOPE,
+			ErrorString: "DNS queries only supported in global scope",
+			Scope:       scope,
+		}
+	}
+
+	// Normalise the name so that every form of it is cached as the same
+	// trace
+	query, qErr := normalizeDomainQuery(query, scope)
+	if qErr != nil {
+		return nil, qErr
+	}
+
+	d.ensureCache()
+	hit, ck, cachedItems, qErr := d.cache.Lookup(ctx, d.Name(), sdp.QueryMethod_SEARCH, scope, d.Type(), query, ignoreCache)
+	if qErr != nil {
+		return nil, qErr
+	}
+	if hit {
+		return cachedItems, nil
+	}
+
+	steps, err := d.trace(ctx, dns.Fqdn(query), dns.TypeA, 0)
+	if err != nil {
+		err = &sdp.QueryError{
+			ErrorType:   sdp.QueryError_OTHER,
+			ErrorString: err.Error(),
+			Scope:       scope,
+		}
+		d.cache.StoreError(err, dnsTraceCacheDuration, ck)
+		return nil, err
+	}
+
+	items, err := traceStepsToItems(trimDnsSuffix(dns.Fqdn(query)), steps)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range items {
+		d.cache.StoreItem(item, dnsTraceCacheDuration, ck)
+	}
+
+	return items, nil
+}
+
+// traceServer A name server and the address that it can be reached on
+type traceServer struct {
+	Name string
+	IP   string
+}
+
+// traceStep The result of querying the servers for a single zone in the
+// delegation chain
+type traceStep struct {
+	Zone string
+	// The servers that the zone was delegated to, along with any glue
+	Servers []traceServer
+	// The addresses of each server, either from glue or resolved separately
+	Glue map[string][]string
+	// The server that answered the query
+	AnsweredBy traceServer
+	Rcode      int
+	// Whether the answer had the authoritative answer bit set
+	Authoritative bool
+	Answers       []dns.RR
+	Duration      time.Duration
+	// Servers that did not answer authoritatively for the zone
+	LameServers []string
+	// Name servers that appear only in the parent or only in the child
+	ParentOnlyNS []string
+	ChildOnlyNS  []string
+	// The error if none of the servers could be reached
+	Err error
+}
+
+// trace Walks the delegation chain for a name starting at the root servers.
+// The nesting parameter tracks how deep we are when resolving the addresses
+// of name servers that don't have glue
+func (d *DNSTraceAdapter) trace(ctx context.Context, qname string, qtype uint16, nesting int) ([]*traceStep, error) {
+	if nesting > maxTraceNesting {
+		return nil, fmt.Errorf("gave up resolving %v: too many nested lookups", qname)
+	}
+
+	servers := make([]traceServer, 0)
+	for name, ips := range d.getRootServers() {
+		for _, ip := range ips {
+			servers = append(servers, traceServer{Name: dns.Fqdn(name), IP: ip})
+		}
+	}
+	sortTraceServers(servers)
+
+	zone := "."
+	steps := make([]*traceStep, 0)
+
+	for depth := 0; depth < maxTraceDepth; depth++ {
+		step := &traceStep{
+			Zone:    zone,
+			Servers: servers,
+			Glue:    make(map[string][]string),
+		}
+		steps = append(steps, step)
+
+		for _, s := range servers {
+			if s.IP != "" {
+				step.Glue[s.Name] = append(step.Glue[s.Name], s.IP)
+			}
+		}
+
+		// The root servers come from the hints rather than a delegation so
+		// there is nothing to check. We also skip the checks when resolving
+		// name server addresses as they aren't reported
+		if depth > 0 && nesting == 0 {
+			d.checkDelegation(ctx, step)
+		}
+
+		start := time.Now()
+		r, answeredBy, err := d.exchangeAny(ctx, qname, qtype, servers)
+		step.Duration = time.Since(start)
+
+		if err != nil {
+			if depth == 0 {
+				return nil, err
+			}
+
+			step.Err = err
+			return steps, nil
+		}
+
+		step.AnsweredBy = answeredBy
+		step.Rcode = r.Rcode
+		step.Authoritative = r.Authoritative
+		step.Answers = r.Answer
+
+		child, nsNames := referral(r, zone)
+
+		if child == "" {
+			// This is the final answer (or an error such as NXDOMAIN)
+			return steps, nil
+		}
+
+		// Follow the delegation
+		next := d.delegationServers(ctx, nsNames, r.Extra, nesting)
+
+		if len(next) == 0 {
+			step.Err = fmt.Errorf("could not resolve any name servers for %v", child)
+			return steps, nil
+		}
+
+		zone = child
+		servers = next
+	}
+
+	return steps, fmt.Errorf("gave up resolving %v after %v delegations", qname, maxTraceDepth)
+}
+
+// referral Checks whether a response is a referral to a zone below the
+// current one, and if so returns the child zone and the names of its name
+// servers
+func referral(r *dns.Msg, zone string) (string, []string) {
+	if r.Rcode != dns.RcodeSuccess || len(r.Answer) > 0 || r.Authoritative {
+		return "", nil
+	}
+
+	var child string
+	names := make([]string, 0)
+
+	for _, rr := range r.Ns {
+		if ns, ok := rr.(*dns.NS); ok {
+			owner := dns.CanonicalName(ns.Hdr.Name)
+
+			// Ignore referrals that don't go any deeper as these would cause
+			// a loop
+			if !dns.IsSubDomain(zone, owner) || dns.CanonicalName(zone) == owner {
+				continue
+			}
+
+			child = owner
+			names = append(names, dns.CanonicalName(ns.Ns))
+		}
+	}
+
+	return child, names
+}
+
+// delegationServers Works out the addresses of the servers that a zone was
+// delegated to, using glue where possible and falling back to resolving them
+func (d *DNSTraceAdapter) delegationServers(ctx context.Context, nsNames []string, extra []dns.RR, nesting int) []traceServer {
+	glue := make(map[string][]string)
+
+	for _, rr := range extra {
+		switch glueRR := rr.(type) {
+		case *dns.A:
+			name := dns.CanonicalName(glueRR.Hdr.Name)
+			glue[name] = append(glue[name], glueRR.A.String())
+		case *dns.AAAA:
+			name := dns.CanonicalName(glueRR.Hdr.Name)
+			glue[name] = append(glue[name], glueRR.AAAA.String())
+		}
+	}
+
+	servers := make([]traceServer, 0)
+
+	for _, name := range nsNames {
+		ips, ok := glue[name]
+
+		if !ok {
+			// No glue, we need to resolve this name server ourselves
+			ips = d.resolveAddresses(ctx, name, nesting+1)
+		}
+
+		if len(ips) == 0 {
+			// Still include the server so that it shows up in the NS set
+			servers = append(servers, traceServer{Name: name})
+		}
+
+		for _, ip := range ips {
+			servers = append(servers, traceServer{Name: name, IP: ip})
+		}
+	}
+
+	sortTraceServers(servers)
+
+	return servers
+}
+
+// resolveAddresses Iteratively resolves the IPv4 addresses of a name, falling
+// back to its IPv6 addresses if it doesn't have any
+func (d *DNSTraceAdapter) resolveAddresses(ctx context.Context, name string, nesting int) []string {
+	ips := make([]string, 0)
+
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		steps, err := d.trace(ctx, name, qtype, nesting)
+		if err != nil || len(steps) == 0 {
+			continue
+		}
+
+		for _, rr := range steps[len(steps)-1].Answers {
+			switch addr := rr.(type) {
+			case *dns.A:
+				ips = append(ips, addr.A.String())
+			case *dns.AAAA:
+				ips = append(ips, addr.AAAA.String())
+			}
+		}
+
+		if len(ips) > 0 {
+			break
+		}
+	}
+
+	return ips
+}
+
+// exchangeAny Sends a non-recursive query to each server in turn until one of
+// them responds
+func (d *DNSTraceAdapter) exchangeAny(ctx context.Context, qname string, qtype uint16, servers []traceServer) (*dns.Msg, traceServer, error) {
+	var lastErr error
+
+	for _, s := range servers {
+		if s.IP == "" {
+			continue
+		}
+
+		r, err := d.exchange(ctx, qname, qtype, s.IP)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		return r, s, nil
+	}
+
+	if lastErr == nil {
+		lastErr = ErrNoServersAvailable
+	}
+
+	return nil, traceServer{}, lastErr
+}
+
+// exchange Sends a single non-recursive query, retrying over TCP if the
+// response was truncated
+func (d *DNSTraceAdapter) exchange(ctx context.Context, qname string, qtype uint16, ip string) (*dns.Msg, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	msg := dns.Msg{
+		Question: []dns.Question{
+			{
+				Name:   qname,
+				Qclass: dns.ClassINET,
+				Qtype:  qtype,
+			},
+		},
+		MsgHdr: dns.MsgHdr{
+			Opcode: dns.OpcodeQuery,
+			// We are the resolver, so we don't want the server to recurse
+			RecursionDesired: false,
+		},
+	}
+
+	server := net.JoinHostPort(ip, d.getPort())
+
+	r, _, err := d.client.ExchangeContext(ctx, &msg, server)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Truncated {
+		tcpClient := dns.Client{Net: "tcp"}
+		r, _, err = tcpClient.ExchangeContext(ctx, &msg, server)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// checkDelegation Queries every server that a zone was delegated to and
+// records servers that don't answer authoritatively (lame delegation) as well
+// as differences between the NS set in the parent and in the child
+func (d *DNSTraceAdapter) checkDelegation(ctx context.Context, step *traceStep) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	lame := make(map[string]bool)
+	childNS := make(map[string]bool)
+
+	for _, s := range step.Servers {
+		if s.IP == "" {
+			mu.Lock()
+			lame[s.Name] = true
+			mu.Unlock()
+			continue
+		}
+
+		wg.Add(1)
+		go func(s traceServer) {
+			defer wg.Done()
+
+			r, err := d.exchange(ctx, step.Zone, dns.TypeNS, s.IP)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil || r.Rcode != dns.RcodeSuccess || !r.Authoritative {
+				lame[s.Name] = true
+				return
+			}
+
+			for _, rr := range r.Answer {
+				if ns, ok := rr.(*dns.NS); ok {
+					childNS[dns.CanonicalName(ns.Ns)] = true
+				}
+			}
+		}(s)
+	}
+
+	wg.Wait()
+
+	parentNS := make(map[string]bool)
+	for _, s := range step.Servers {
+		parentNS[s.Name] = true
+	}
+
+	for name := range lame {
+		step.LameServers = append(step.LameServers, trimDnsSuffix(name))
+	}
+
+	// Only compare the NS sets if at least one server answered
+	if len(childNS) > 0 {
+		for name := range parentNS {
+			if !childNS[name] {
+				step.ParentOnlyNS = append(step.ParentOnlyNS, trimDnsSuffix(name))
+			}
+		}
+
+		for name := range childNS {
+			if !parentNS[name] {
+				step.ChildOnlyNS = append(step.ChildOnlyNS, trimDnsSuffix(name))
+			}
+		}
+	}
+
+	sort.Strings(step.LameServers)
+	sort.Strings(step.ParentOnlyNS)
+	sort.Strings(step.ChildOnlyNS)
+}
+
+// sortTraceServers Sorts servers by name, with the IPv4 addresses of each
+// server first since IPv6 connectivity is less likely to be available
+func sortTraceServers(servers []traceServer) {
+	sort.Slice(servers, func(i, j int) bool {
+		if servers[i].Name != servers[j].Name {
+			return servers[i].Name < servers[j].Name
+		}
+
+		iv6 := strings.Contains(servers[i].IP, ":")
+		jv6 := strings.Contains(servers[j].IP, ":")
+		if iv6 != jv6 {
+			return jv6
+		}
+
+		return servers[i].IP < servers[j].IP
+	})
+}
+
+// traceStepID Returns the unique ID for a step in a trace, this is the name
+// that was queried and the zone that the step is for e.g.
+// "www.example.com@com."
+func traceStepID(name string, zone string) string {
+	return fmt.Sprintf("%v@%v", name, zone)
+}
+
+// traceStepsToItems Converts the steps in a trace to items, each step is
+// linked to the next one in the chain
+func traceStepsToItems(name string, steps []*traceStep) ([]*sdp.Item, error) {
+	items := make([]*sdp.Item, 0, len(steps))
+
+	for i, step := range steps {
+		nameservers := make([]string, 0)
+		glue := make(map[string][]string)
+		seen := make(map[string]bool)
+		liq := make([]*sdp.LinkedItemQuery, 0)
+
+		for _, s := range step.Servers {
+			host := trimDnsSuffix(s.Name)
+
+			if !seen[host] {
+				seen[host] = true
+				nameservers = append(nameservers, host)
+				liq = append(liq, dnsTargetQuery(host))
+			}
+		}
+
+		for host, ips := range step.Glue {
+			glue[trimDnsSuffix(host)] = ips
+
+			for _, ip := range ips {
+				liq = append(liq, &sdp.LinkedItemQuery{
+					Query: &sdp.Query{
+						Type:   "ip",
+						Method: sdp.QueryMethod_GET,
+						Query:  ip,
+						Scope:  "global",
+					},
+					BlastPropagation: &sdp.BlastPropagation{
+						// Changing a name server's address affects the
+						// delegation
+						In:  true,
+						Out: false,
+					},
+				})
+			}
+		}
+
+		attrs := map[string]interface{}{
+			"id":          traceStepID(name, step.Zone),
+			"name":        name,
+			"zone":        step.Zone,
+			"depth":       i,
+			"nameservers": nameservers,
+			"glue":        glue,
+			"durationMs":  step.Duration.Milliseconds(),
+			"lame":        len(step.LameServers) > 0,
+			"inconsistent": len(step.ParentOnlyNS) > 0 ||
+				len(step.ChildOnlyNS) > 0,
+		}
+
+		if step.Err != nil {
+			attrs["error"] = step.Err.Error()
+		} else {
+			attrs["answeredBy"] = map[string]interface{}{
+				"name": trimDnsSuffix(step.AnsweredBy.Name),
+				"ip":   step.AnsweredBy.IP,
+			}
+			attrs["rcode"] = dns.RcodeToString[step.Rcode]
+			attrs["authoritative"] = step.Authoritative
+		}
+
+		if len(step.LameServers) > 0 {
+			attrs["lameServers"] = step.LameServers
+		}
+
+		if len(step.ParentOnlyNS) > 0 {
+			attrs["parentOnlyNameservers"] = step.ParentOnlyNS
+		}
+
+		if len(step.ChildOnlyNS) > 0 {
+			attrs["childOnlyNameservers"] = step.ChildOnlyNS
+		}
+
+		if len(step.Answers) > 0 {
+			answers := make([]string, 0, len(step.Answers))
+			for _, rr := range step.Answers {
+				answers = append(answers, rr.String())
+			}
+			attrs["answers"] = answers
+
+			// The final answer is the same thing that a recursive lookup would
+			// return
+			liq = append(liq, &sdp.LinkedItemQuery{
+				Query: &sdp.Query{
+					Type:   ItemType,
+					Method: sdp.QueryMethod_SEARCH,
+					Query:  name,
+					Scope:  "global",
+				},
+				BlastPropagation: &sdp.BlastPropagation{
+					In:  true,
+					Out: true,
+				},
+			})
+		}
+
+		attributes, err := sdp.ToAttributes(attrs)
+		if err != nil {
+			return nil, err
+		}
+
+		item := &sdp.Item{
+			Type:              "dns-trace",
+			UniqueAttribute:   "id",
+			Scope:             "global",
+			Attributes:        attributes,
+			LinkedItemQueries: liq,
+		}
+
+		// Link to the next step in the delegation
+		if i+1 < len(steps) {
+			item.LinkedItems = []*sdp.LinkedItem{
+				{
+					Item: &sdp.Reference{
+						Type:                 "dns-trace",
+						UniqueAttributeValue: traceStepID(name, steps[i+1].Zone),
+						Scope:                "global",
+					},
+					BlastPropagation: &sdp.BlastPropagation{
+						// A broken delegation at this level will break
+						// everything below it
+						In:  false,
+						Out: true,
+					},
+				},
+			}
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
@@ -0,0 +1,229 @@
+package adapters
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/overmindtech/discovery"
+)
+
+// referralHandler Returns a handler that answers every query with a referral
+// to the given zone. Glue is returned as an AAAA record if the IP is IPv6
+func referralHandler(t *testing.T, zone string, glue map[string]string) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+
+		for ns, ip := range glue {
+			m.Ns = append(m.Ns, mustRR(t, zone+" 3600 IN NS "+ns))
+
+			switch {
+			case ip == "":
+			case strings.Contains(ip, ":"):
+				m.Extra = append(m.Extra, mustRR(t, ns+" 3600 IN AAAA "+ip))
+			default:
+				m.Extra = append(m.Extra, mustRR(t, ns+" 3600 IN A "+ip))
+			}
+		}
+
+		_ = w.WriteMsg(m)
+	}
+}
+
+func mustRR(t *testing.T, s string) dns.RR {
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr
+}
+
+func TestDNSTraceSearch(t *testing.T) {
+	// Start the root server on a random port, then start the rest of the
+	// hierarchy on other loopback addresses using the same port
+	root := startTestDNSServer(t, referralHandler(t, "test.", map[string]string{
+		"ns.nic.test.": "127.0.0.2",
+	}))
+
+	_, port, err := net.SplitHostPort(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	startTestDNSServerOn(t, net.JoinHostPort("127.0.0.2", port), referralHandler(t, "example.test.", map[string]string{
+		"ns1.example.test.": "127.0.0.3",
+		// Nothing listens here so this server will be lame
+		"ns2.example.test.": "127.0.0.4",
+	}))
+
+	startTestDNSServerOn(t, net.JoinHostPort("127.0.0.3", port), dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Authoritative = true
+
+		switch r.Question[0].Qtype {
+		case dns.TypeNS:
+			m.Answer = append(m.Answer,
+				mustRR(t, "example.test. 3600 IN NS ns1.example.test."),
+				mustRR(t, "example.test. 3600 IN NS ns3.example.test."),
+			)
+		case dns.TypeA:
+			m.Answer = append(m.Answer, mustRR(t, "www.example.test. 300 IN A 192.0.2.1"))
+		}
+
+		_ = w.WriteMsg(m)
+	}))
+
+	src := DNSTraceAdapter{
+		RootServers: map[string][]string{
+			"root.test": {"127.0.0.1"},
+		},
+		Port: port,
+	}
+
+	items, err := src.Search(context.Background(), "global", "www.example.test", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	discovery.TestValidateItems(t, items)
+
+	if len(items) != 3 {
+		t.Fatalf("expected 3 steps, got %v", len(items))
+	}
+
+	expectedZones := []string{".", "test.", "example.test."}
+	for i, item := range items {
+		zone, _ := item.GetAttributes().Get("zone")
+		if zone != expectedZones[i] {
+			t.Errorf("expected step %v to be for %v, got %v", i, expectedZones[i], zone)
+		}
+	}
+
+	final := items[2]
+
+	t.Run("answer", func(t *testing.T) {
+		answeredBy, err := final.GetAttributes().Get("answeredBy")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ip := answeredBy.(map[string]interface{})["ip"]; ip != "127.0.0.3" {
+			t.Errorf("expected answer from 127.0.0.3, got %v", ip)
+		}
+
+		if authoritative, _ := final.GetAttributes().Get("authoritative"); authoritative != true {
+			t.Error("expected final answer to be authoritative")
+		}
+	})
+
+	t.Run("lame delegation", func(t *testing.T) {
+		lame, err := final.GetAttributes().Get("lameServers")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if l := lame.([]interface{}); len(l) != 1 || l[0] != "ns2.example.test" {
+			t.Errorf("expected ns2.example.test to be lame, got %v", l)
+		}
+	})
+
+	t.Run("inconsistent delegation", func(t *testing.T) {
+		if inconsistent, _ := final.GetAttributes().Get("inconsistent"); inconsistent != true {
+			t.Error("expected delegation to be inconsistent")
+		}
+
+		childOnly, err := final.GetAttributes().Get("childOnlyNameservers")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if c := childOnly.([]interface{}); len(c) != 1 || c[0] != "ns3.example.test" {
+			t.Errorf("expected ns3.example.test to only be in the child, got %v", c)
+		}
+	})
+
+	t.Run("steps are linked", func(t *testing.T) {
+		next := items[0].GetLinkedItems()[0].GetItem().GetUniqueAttributeValue()
+		if next != "www.example.test@test." {
+			t.Errorf("expected link to next step, got %v", next)
+		}
+	})
+
+	t.Run("query is normalised", func(t *testing.T) {
+		normalised, err := src.Search(context.Background(), "global", "WWW.Example.Test.", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(normalised) != len(items) {
+			t.Fatalf("expected %v steps, got %v", len(items), len(normalised))
+		}
+
+		// These come from the cache, which doesn't preserve the order
+		steps := make(map[string]bool)
+		for _, item := range items {
+			steps[item.UniqueAttributeValue()] = true
+		}
+
+		for _, item := range normalised {
+			if !steps[item.UniqueAttributeValue()] {
+				t.Errorf("unexpected step %v", item.UniqueAttributeValue())
+			}
+		}
+	})
+}
+
+func TestDNSTraceSearchIPv6(t *testing.T) {
+	// The root refers to a name server that only has IPv6 glue
+	root := startTestDNSServer(t, referralHandler(t, "v6.test.", map[string]string{
+		"ns.v6.test.": "::1",
+	}))
+
+	_, port, err := net.SplitHostPort(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	startTestDNSServerOn(t, net.JoinHostPort("::1", port), dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Authoritative = true
+
+		if r.Question[0].Qtype == dns.TypeA {
+			m.Answer = append(m.Answer, mustRR(t, "www.v6.test. 300 IN A 192.0.2.1"))
+		}
+
+		_ = w.WriteMsg(m)
+	}))
+
+	src := DNSTraceAdapter{
+		RootServers: map[string][]string{
+			"root.test": {"127.0.0.1"},
+		},
+		Port: port,
+	}
+
+	items, err := src.Search(context.Background(), "global", "www.v6.test", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	discovery.TestValidateItems(t, items)
+
+	if len(items) != 2 {
+		t.Fatalf("expected 2 steps, got %v", len(items))
+	}
+
+	answeredBy, err := items[1].GetAttributes().Get("answeredBy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ip := answeredBy.(map[string]interface{})["ip"]; ip != "::1" {
+		t.Errorf("expected answer from ::1, got %v", ip)
+	}
+}
@@ -2,9 +2,13 @@ package adapters
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"net"
+	"net/http"
+	"net/netip"
 	"sort"
 	"strings"
 	"sync"
@@ -21,20 +25,62 @@ import (
 // DNSAdapter struct on which all methods are registered
 type DNSAdapter struct {
 	// List of DNS server to use in order ot preference. They should be in the
-	// format "ip:port"
+	// format "ip:port" for plain DNS, "tls://host:port" for DNS-over-TLS (RFC
+	// 7858) or "https://host/dns-query" for DNS-over-HTTPS (RFC 8484)
 	Servers []string
 
 	// Whether to perform reverse lookups on IP addresses
 	ReverseLookup bool
 
+	// TLS config to use for DNS-over-TLS and DNS-over-HTTPS servers. If this
+	// is nil the system roots will be used
+	TLSConfig *tls.Config
+
+	// Whether to validate answers using DNSSEC. When enabled each item has a
+	// `dnssec` attribute with the validation status, and `dns-dnskey` and
+	// `dns-ds` items are returned for each zone in the chain of trust
+	ValidateDNSSEC bool
+
+	// DS records, in zone file format, to use as trust anchors when
+	// validating DNSSEC. Defaults to RootTrustAnchors
+	TrustAnchors []string
+
+	// The floor and ceiling for how long results are cached. Results are
+	// cached for the lowest TTL of their records, or for negative answers the
+	// SOA minimum. Defaults to DefaultDNSMinCacheDuration and
+	// DefaultDNSMaxCacheDuration
+	MinCacheDuration time.Duration
+	MaxCacheDuration time.Duration
+
+	// How long after expiry a result can be served if every server is
+	// failing (RFC 8767). Defaults to DefaultDNSMaxStaleDuration, a negative
+	// value disables serving stale results
+	MaxStaleDuration time.Duration
+
+	// Configures zone transfers, which allow List to return every record in
+	// the configured zones. If this is nil List returns nothing
+	ZoneTransfer *ZoneTransferConfig
+
 	client dns.Client
 
+	// Creates the client used for DNS-over-HTTPS, which sets the proxy. The
+	// TLS config above takes precedence over the factory's
+	ClientFactory *HTTPClientFactory
+
+	httpClient     *http.Client // Client used for DNS-over-HTTPS
+	httpClientOnce sync.Once
+
+	dotPools   map[string]*dotPool // Reused DNS-over-TLS connections by server
+	dotPoolsMu sync.Mutex
+
+	health serverHealth // Latency and failures of each server
+	stale  staleCache   // Previous results that can be served stale
+	zones  zoneStore    // Previous zone transfers, used for IXFR
+
 	cache       *sdpcache.Cache // The sdpcache of this adapter
 	cacheInitMu sync.Mutex      // Mutex to ensure cache is only initialised once
 }
 
-const dnsCacheDuration = 5 * time.Minute
-
 func (s *DNSAdapter) ensureCache() {
 	s.cacheInitMu.Lock()
 	defer s.cacheInitMu.Unlock()
@@ -83,6 +129,13 @@ func (d *DNSAdapter) GetServers() []string {
 	return d.Servers
 }
 
+func (d *DNSAdapter) GetTrustAnchors() []string {
+	if len(d.TrustAnchors) == 0 {
+		return RootTrustAnchors
+	}
+	return d.TrustAnchors
+}
+
 func (d *DNSAdapter) Metadata() *sdp.AdapterMetadata {
 	return dnsMetadata
 }
@@ -92,11 +145,13 @@ var dnsMetadata = Metadata.Register(&sdp.AdapterMetadata{
 	Type:            "dns",
 	SupportedQueryMethods: &sdp.AdapterSupportedQueryMethods{
 		Get:               true,
+		List:              true,
 		Search:            true,
 		GetDescription:    "A DNS A or AAAA entry to look up",
-		SearchDescription: "A DNS name (or IP for reverse DNS), this will perform a recursive search and return all results. It is recommended that you always use the SEARCH method",
+		ListDescription:   "Returns every record in the zones that are configured for zone transfers",
+		SearchDescription: "A DNS name (or IP for reverse DNS, or a CIDR such as 192.0.2.0/24 to return a `dns-ptr` item for every address in the range that has a PTR record), this will perform a recursive search and return all results including MX, NS, TXT, SOA, SRV, CAA, HTTPS and SVCB records as `dns-mx`, `dns-ns`, `dns-txt`, `dns-soa`, `dns-srv`, `dns-caa`, `dns-https` and `dns-svcb` items. It is recommended that you always use the SEARCH method. Prefix the name with `consistency:` to query every configured server in parallel and return a single `dns-consistency` item showing which servers agree. Prefix a zone with `axfr:` to return every record in the zone using a zone transfer from the configured primary",
 	},
-	PotentialLinks: []string{"dns", "ip", "rdap-domain"},
+	PotentialLinks: []string{"dns", "dns-dnskey", "dns-ds", "http", "ip", "rdap-domain"},
 	Category:       sdp.AdapterCategory_ADAPTER_CATEGORY_NETWORK,
 })
 
@@ -126,6 +181,12 @@ func (d *DNSAdapter) Get(ctx context.Context, scope string, query string, ignore
 		}
 	}
 
+	// Normalise the name so that every form of it is cached as the same item
+	query, qErr := normalizeDomainQuery(query, scope)
+	if qErr != nil {
+		return nil, qErr
+	}
+
 	d.ensureCache()
 	cacheHit, ck, cachedItems, qErr := d.cache.Lookup(ctx, d.Name(), sdp.QueryMethod_GET, scope, d.Type(), query, ignoreCache)
 	if qErr != nil {
@@ -142,20 +203,12 @@ func (d *DNSAdapter) Get(ctx context.Context, scope string, query string, ignore
 	// This won't work for CNAMEs since the linked query logic needs to be
 	// different and we're only querying for A and AAAA. Realistically people
 	// should be using Search() now anyway
-	items, err := d.MakeQuery(ctx, query)
-
+	items, duration, err := d.resolve(ctx, ck, query, addressQueryTypes, !ignoreCache)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(items) == 0 {
-		return nil, &sdp.QueryError{
-			ErrorType: sdp.QueryError_NOTFOUND,
-			Scope:     "global",
-		}
-	}
-
-	d.cache.StoreItem(items[0], dnsCacheDuration, ck)
+	d.cache.StoreItem(items[0], duration, ck)
 	return items[0], nil
 }
 
@@ -169,7 +222,11 @@ func (d *DNSAdapter) List(ctx context.Context, scope string, ignoreCache bool) (
 		}
 	}
 
-	return make([]*sdp.Item, 0), nil
+	if d.ZoneTransfer == nil || d.ZoneTransfer.Primary == "" || len(d.ZoneTransfer.Zones) == 0 {
+		return make([]*sdp.Item, 0), nil
+	}
+
+	return d.listZones(ctx, scope, ignoreCache)
 }
 
 type DNSRecord struct {
@@ -187,6 +244,33 @@ func (d *DNSAdapter) Search(ctx context.Context, scope string, query string, ign
 		}
 	}
 
+	if name, ok := strings.CutPrefix(query, consistencyQueryPrefix); ok {
+		name, qErr := normalizeDomainQuery(name, scope)
+		if qErr != nil {
+			return nil, qErr
+		}
+
+		return d.searchConsistency(ctx, scope, name, ignoreCache)
+	}
+
+	if zone, ok := strings.CutPrefix(query, transferQueryPrefix); ok {
+		zone, qErr := normalizeDomainQuery(zone, scope)
+		if qErr != nil {
+			return nil, qErr
+		}
+
+		return d.searchZone(ctx, scope, zone, ignoreCache)
+	}
+
+	if prefix, err := netip.ParsePrefix(query); err == nil {
+		if d.ReverseLookup {
+			// A CIDR sweeps the PTR records of every address in the range
+			return d.searchReverseRange(ctx, scope, prefix, ignoreCache)
+		}
+
+		return []*sdp.Item{}, nil
+	}
+
 	if net.ParseIP(query) != nil {
 		if d.ReverseLookup {
 			// If it's an IP then we want to run a reverse lookup
@@ -197,119 +281,441 @@ func (d *DNSAdapter) Search(ctx context.Context, scope string, query string, ign
 		}
 	}
 
-	ck := sdpcache.CacheKeyFromParts(d.Name(), sdp.QueryMethod_SEARCH, scope, d.Type(), query)
+	query, qErr := normalizeDomainQuery(query, scope)
+	if qErr != nil {
+		return nil, qErr
+	}
+
+	cacheHit, ck, cachedItems, qErr := d.lookupItems(ctx, sdp.QueryMethod_SEARCH, scope, query, ignoreCache)
+	if qErr != nil {
+		return nil, qErr
+	}
+	if cacheHit {
+		return cachedItems, nil
+	}
 
-	items, err := d.MakeQuery(ctx, query)
+	items, duration, err := d.resolve(ctx, ck, query, searchQueryTypes, !ignoreCache)
 	if err != nil {
-		d.cache.StoreError(err, dnsCacheDuration, ck)
 		return nil, err
 	}
 
-	for _, item := range items {
-		d.cache.StoreItem(item, dnsCacheDuration, ck)
-	}
+	d.storeItems(items, duration, ck)
 
 	return items, nil
 }
 
-// retryDNSQuery handles retrying DNS queries with backoff and server rotation
-func (d *DNSAdapter) retryDNSQuery(ctx context.Context, queryFn func(context.Context, string) ([]*sdp.Item, error)) ([]*sdp.Item, error) {
+// resolve Queries the servers and returns the items along with how long they
+// should be cached for. Negative answers are cached here based on the SOA
+// record. If every server fails then the last successful result is served
+// stale if allowed, see RFC 8767
+func (d *DNSAdapter) resolve(ctx context.Context, ck sdpcache.CacheKey, query string, qtypes []uint16, allowStale bool) ([]*sdp.Item, time.Duration, error) {
+	resp, err := d.query(ctx, query, qtypes)
+	if err != nil {
+		var qErr *sdp.QueryError
+		if errors.As(err, &qErr) && qErr.ErrorType == sdp.QueryError_NOTFOUND {
+			var ttl time.Duration
+			if resp != nil {
+				ttl = resp.TTL
+			}
+
+			d.cache.StoreError(err, d.cacheDuration(ttl), ck)
+			return nil, 0, err
+		}
+
+		if allowStale {
+			if items, age, ok := d.stale.Lookup(ck.String()); ok {
+				span := trace.SpanFromContext(ctx)
+				span.SetAttributes(
+					attribute.Bool("ovm.dns.stale", true),
+					attribute.Int64("ovm.dns.staleAgeMs", age.Milliseconds()),
+				)
+
+				return items, dnsStaleAnswerDuration, nil
+			}
+		}
+
+		// Cache the failure for the minimum time so that we don't keep
+		// hammering servers that are having issues
+		d.cache.StoreError(err, d.cacheDuration(0), ck)
+		return nil, 0, err
+	}
+
+	duration := d.cacheDuration(resp.TTL)
+	if len(resp.FailedTypes) > 0 {
+		// Only cache partial results for the minimum time so that the types
+		// that failed are queried again soon
+		duration = d.cacheDuration(0)
+	}
+
+	d.stale.Store(ck.String(), resp.Items, duration, d.staleDuration())
+
+	return resp.Items, duration, nil
+}
+
+// dnsResponse The items returned by a query, along with the TTL of the records
+// that they were created from
+type dnsResponse struct {
+	Items []*sdp.Item
+	TTL   time.Duration
+
+	// The raw answers and response code
+	Answers []dns.RR
+	Rcode   int
+
+	// The server that answered, so that follow up queries such as DNSSEC
+	// validation can be sent to the same place
+	Server string
+
+	// The record types that couldn't be queried, the answers of the other
+	// types are still returned
+	FailedTypes []string
+}
+
+// dnsQueryFunc Runs a query against a single server
+type dnsQueryFunc func(ctx context.Context, server string) (*dnsResponse, error)
+
+// newDNSBackOff Returns the backoff used when retrying DNS queries
+func newDNSBackOff(maxElapsed time.Duration) *backoff.ExponentialBackOff {
 	b := backoff.NewExponentialBackOff()
 	b.InitialInterval = 100 * time.Millisecond
 	b.MaxInterval = 500 * time.Millisecond
-	b.MaxElapsedTime = 30 * time.Second
+	b.MaxElapsedTime = maxElapsed
+
+	return b
+}
+
+// attemptDNSQuery Runs a single attempt of a query against a server. Errors
+// that are worth retrying are returned as-is, all others are wrapped in
+// backoff.Permanent. The outcome of each message is recorded against the
+// server's health by exchange
+func (d *DNSAdapter) attemptDNSQuery(ctx context.Context, server string, queryFn dnsQueryFunc) (*dnsResponse, error) {
+	resp, err := queryFn(ctx, server)
+	if err != nil {
+		var qErr *sdp.QueryError
+		if errors.As(err, &qErr) {
+			// The server responded, but with something like NXDOMAIN.
+			// This isn't the server's fault and asking another server
+			// won't change the answer
+			return resp, backoff.Permanent(err)
+		}
+
+		if isRetryableDNSError(err) {
+			return resp, err
+		}
+
+		return resp, backoff.Permanent(err)
+	}
+
+	return resp, nil
+}
+
+// retryDNSQuery handles retrying DNS queries with backoff. Servers are tried in
+// order of health, with failures demoting the server so that the next
+// attempt, and future queries, prefer healthier servers
+func (d *DNSAdapter) retryDNSQuery(ctx context.Context, queryFn dnsQueryFunc) (*dnsResponse, error) {
+	b := newDNSBackOff(30 * time.Second)
 
-	var items []*sdp.Item
+	var resp *dnsResponse
 	var i int
 	var server string
+	var attempts int
+	var resolutionFailures int
+
+	servers := d.health.Order(d.GetServers())
 
 	operation := func() error {
-		if i >= len(d.GetServers()) {
+		if i >= len(servers) {
+			// We have tried every server, re-check the health since things
+			// may have changed
+			servers = d.health.Order(d.GetServers())
 			i = 0
 		}
 
-		ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
-		defer cancel()
-
-		server = d.GetServers()[i]
+		server = servers[i]
+		attempts++
 
 		var err error
-		items, err = queryFn(ctx, server)
-		if err != nil {
-			i++ // Move to next server on error
-
-			if errors.Is(err, context.DeadlineExceeded) ||
-				strings.Contains(err.Error(), "timeout") ||
-				strings.Contains(err.Error(), "temporary failure") {
-				return err // Retry on timeout
+		resp, err = d.attemptDNSQuery(ctx, server, queryFn)
+
+		if errors.Is(err, errDNSResolutionFailure) {
+			resolutionFailures++
+			if resolutionFailures >= len(servers) {
+				// Every server failed to resolve the name, so it's the
+				// name that is broken and retrying won't help
+				return backoff.Permanent(err)
 			}
-			return backoff.Permanent(err)
 		}
 
-		return nil
+		var permanent *backoff.PermanentError
+		if err != nil && !errors.As(err, &permanent) {
+			i++ // Move to next server on error
+		}
+
+		return err
 	}
 
-	err := backoff.Retry(operation, b)
+	err := backoff.Retry(operation, backoff.WithContext(b, ctx))
 	span := trace.SpanFromContext(ctx)
 	span.SetAttributes(
 		attribute.String("ovm.dns.server", server),
+		attribute.Int("ovm.dns.attempts", attempts),
 	)
+	d.setHealthAttributes(span)
 	if err != nil {
-		return nil, err
+		// Negative answers still include the TTL that they can be cached for
+		return resp, err
 	}
 
-	return items, nil
+	return resp, nil
 }
 
-// Updated MakeQuery
+// dnsServerResult The result of running a query against a single server
+type dnsServerResult struct {
+	Server   string
+	Response *dnsResponse
+	Err      error
+	Attempts int
+}
+
+// fanOutDNSQuery Runs a query against every server in parallel rather than
+// failing over. Each server is retried independently, but for less time than
+// retryDNSQuery since a server that is down should be reported rather than
+// waited for. Results are returned in the order that the servers are
+// configured
+func (d *DNSAdapter) fanOutDNSQuery(ctx context.Context, queryFn dnsQueryFunc) []dnsServerResult {
+	servers := d.GetServers()
+	results := make([]dnsServerResult, len(servers))
+
+	var wg sync.WaitGroup
+
+	for i, server := range servers {
+		wg.Add(1)
+
+		go func(i int, server string) {
+			defer wg.Done()
+
+			result := dnsServerResult{
+				Server: server,
+			}
+
+			operation := func() error {
+				result.Attempts++
+
+				var err error
+				result.Response, err = d.attemptDNSQuery(ctx, server, queryFn)
+
+				if errors.Is(err, errDNSResolutionFailure) {
+					// The server couldn't resolve the name, which is
+					// worth reporting rather than retrying
+					return backoff.Permanent(err)
+				}
+
+				return err
+			}
+
+			result.Err = backoff.Retry(operation, backoff.WithContext(newDNSBackOff(5*time.Second), ctx))
+			results[i] = result
+		}(i, server)
+	}
+
+	wg.Wait()
+
+	var attempts int
+	for _, result := range results {
+		attempts += result.Attempts
+	}
+
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(
+		attribute.Bool("ovm.dns.fanOut", true),
+		attribute.Int("ovm.dns.attempts", attempts),
+	)
+	d.setHealthAttributes(span)
+
+	return results
+}
+
+// isRetryableDNSError Returns true if the error was caused by the server or
+// the network, rather than the query itself, and should therefore be retried
+// against another server
+func isRetryableDNSError(err error) bool {
+	var opErr *net.OpError
+
+	return errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, errDNSServerFailure) ||
+		errors.Is(err, errDNSResolutionFailure) ||
+		// Network errors such as connection refused. Note that this doesn't
+		// include TLS verification failures as these will never succeed
+		errors.As(err, &opErr) ||
+		strings.Contains(err.Error(), "timeout") ||
+		strings.Contains(err.Error(), "temporary failure")
+}
+
+// setHealthAttributes Adds the health of each server to the span. These are
+// stored as parallel arrays in the order that the servers are configured
+func (d *DNSAdapter) setHealthAttributes(span trace.Span) {
+	snapshots := d.health.Snapshot(d.GetServers())
+
+	servers := make([]string, 0, len(snapshots))
+	scores := make([]float64, 0, len(snapshots))
+	latencies := make([]int64, 0, len(snapshots))
+	successes := make([]int64, 0, len(snapshots))
+	failures := make([]int64, 0, len(snapshots))
+
+	for _, s := range snapshots {
+		servers = append(servers, s.Server)
+		scores = append(scores, s.Score)
+		latencies = append(latencies, s.Latency.Milliseconds())
+		successes = append(successes, int64(s.Successes))
+		failures = append(failures, int64(s.Failures))
+	}
+
+	span.SetAttributes(
+		attribute.StringSlice("ovm.dns.servers", servers),
+		attribute.Float64Slice("ovm.dns.serverScores", scores),
+		attribute.Int64Slice("ovm.dns.serverLatencyMs", latencies),
+		attribute.Int64Slice("ovm.dns.serverSuccesses", successes),
+		attribute.Int64Slice("ovm.dns.serverFailures", failures),
+	)
+}
+
+// MakeQuery Queries all supported record types for a name and returns an item
+// for each group of records that was found
 func (d *DNSAdapter) MakeQuery(ctx context.Context, query string) ([]*sdp.Item, error) {
-	return d.retryDNSQuery(ctx, func(ctx context.Context, server string) ([]*sdp.Item, error) {
-		return d.makeQueryImpl(ctx, query, server)
-	})
+	resp, err := d.query(ctx, query, searchQueryTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Items, nil
 }
 
-// Updated MakeReverseQuery
-func (d *DNSAdapter) MakeReverseQuery(ctx context.Context, query string) ([]*sdp.Item, error) {
-	return d.retryDNSQuery(ctx, func(ctx context.Context, server string) ([]*sdp.Item, error) {
-		return d.makeReverseQueryImpl(ctx, query, server)
-	})
+// MakeAddressQuery Queries only the A and AAAA records for a name
+func (d *DNSAdapter) MakeAddressQuery(ctx context.Context, query string) ([]*sdp.Item, error) {
+	resp, err := d.query(ctx, query, addressQueryTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Items, nil
 }
 
-func (d *DNSAdapter) makeReverseQueryImpl(ctx context.Context, query string, server string) ([]*sdp.Item, error) {
-	arpa, err := dns.ReverseAddr(query)
+// LookupTXT Returns the TXT records for a name, with the strings of each
+// record joined. Returns an empty slice if the name has no TXT records or
+// doesn't exist
+func (d *DNSAdapter) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	resp, err := d.retryDNSQuery(ctx, func(ctx context.Context, server string) (*dnsResponse, error) {
+		responses, _, err := d.exchangeTypes(ctx, name, server, []uint16{dns.TypeTXT})
+		if err != nil {
+			return nil, err
+		}
 
+		return &dnsResponse{
+			TTL:     responseTTL(responses),
+			Answers: responses[0].Answer,
+			Rcode:   responses[0].Rcode,
+		}, nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	// Create the query
-	msg := dns.Msg{
-		Question: []dns.Question{
-			{
-				Name:   arpa,
-				Qclass: dns.ClassINET,
-				Qtype:  dns.TypePTR,
-			},
-		},
-		MsgHdr: dns.MsgHdr{
-			Opcode:           dns.OpcodeQuery,
-			RecursionDesired: true,
-		},
+	txts := make([]string, 0)
+	for _, rr := range resp.Answers {
+		// This will skip any CNAMEs that were followed
+		if txt, ok := rr.(*dns.TXT); ok {
+			txts = append(txts, strings.Join(txt.Txt, ""))
+		}
+	}
+
+	return txts, nil
+}
+
+// query Queries the given record types for a name, retrying against other
+// servers if required. DNSSEC validation happens after the query has
+// succeeded so that the validation queries go to the server that answered
+func (d *DNSAdapter) query(ctx context.Context, query string, qtypes []uint16) (*dnsResponse, error) {
+	resp, err := d.retryDNSQuery(ctx, func(ctx context.Context, server string) (*dnsResponse, error) {
+		return d.makeQueryImpl(ctx, query, server, qtypes)
+	})
+	if err != nil {
+		return resp, err
+	}
+
+	if len(resp.FailedTypes) > 0 {
+		span := trace.SpanFromContext(ctx)
+		span.SetAttributes(attribute.StringSlice("ovm.dns.failedTypes", resp.FailedTypes))
+	}
+
+	if d.ValidateDNSSEC {
+		if err := d.validateDNSSEC(ctx, resp); err != nil {
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
+
+// validateDNSSEC Validates the answers in a response, sets the `dnssec`
+// attribute on each item and adds the items for the chain of trust
+func (d *DNSAdapter) validateDNSSEC(ctx context.Context, resp *dnsResponse) error {
+	anchors, err := parseTrustAnchors(d.GetTrustAnchors())
+	if err != nil {
+		return err
 	}
 
-	r, _, err := d.client.ExchangeContext(ctx, &msg, server)
+	// Use the same server for validation so that the answers and keys are
+	// consistent
+	validator := newDNSSECValidator(anchors, func(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+		return d.exchange(ctx, msg, resp.Server)
+	})
 
+	annotateDNSSEC(resp.Items, validator.ValidateAnswers(ctx, resp.Answers))
+
+	zoneItems, err := validator.ZoneItems()
 	if err != nil {
+		return err
+	}
+
+	resp.Items = append(resp.Items, zoneItems...)
+
+	return nil
+}
+
+// MakeReverseQuery Looks up the PTR records of an IP and returns the address
+// records of each name they point to. Only A and AAAA records are resolved,
+// each with its own retries, so that the PTR query isn't held up by them
+func (d *DNSAdapter) MakeReverseQuery(ctx context.Context, query string) ([]*sdp.Item, error) {
+	addr, err := netip.ParseAddr(query)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := d.retryDNSQuery(ctx, func(ctx context.Context, server string) (*dnsResponse, error) {
+		return d.queryPTR(ctx, addr.Unmap(), server)
+	})
+	if err != nil {
+		var qErr *sdp.QueryError
+		if errors.As(err, &qErr) && qErr.GetErrorType() == sdp.QueryError_NOTFOUND {
+			// No PTR records isn't an error, there's just nothing to return
+			return []*sdp.Item{}, nil
+		}
+
 		return nil, err
 	}
 
 	items := make([]*sdp.Item, 0)
 
-	for _, rr := range r.Answer {
+	for _, rr := range resp.Answers {
 		if ptr, ok := rr.(*dns.PTR); ok {
-			newItems, err := d.MakeQuery(ctx, ptr.Ptr)
-
+			newItems, err := d.MakeAddressQuery(ctx, ptr.Ptr)
 			if err != nil {
+				var qErr *sdp.QueryError
+				if errors.As(err, &qErr) && qErr.GetErrorType() == sdp.QueryError_NOTFOUND {
+					// The name doesn't resolve back to anything
+					continue
+				}
+
 				return nil, err
 			}
 
@@ -329,54 +735,168 @@ func trimDnsSuffix(name string) string {
 	return name
 }
 
-func (d *DNSAdapter) makeQueryImpl(ctx context.Context, query string, server string) ([]*sdp.Item, error) {
-	// Create the query
-	msg := dns.Msg{
-		Question: []dns.Question{
-			{
-				Name:   dns.Fqdn(query),
-				Qclass: dns.ClassINET,
-				Qtype:  dns.TypeA,
-			},
-		},
-		MsgHdr: dns.MsgHdr{
-			Opcode:           dns.OpcodeQuery,
-			RecursionDesired: true,
-		},
+// addressQueryTypes are the record types that are queried when looking up a
+// single name with Get()
+var addressQueryTypes = []uint16{
+	dns.TypeA,
+	dns.TypeAAAA,
+}
+
+// searchQueryTypes are the record types that are queried when searching for a
+// name. Each type that returns answers will be converted to its own item. A
+// type that fails doesn't stop the others from being returned, since some
+// resolvers don't answer newer types such as HTTPS and SVCB
+var searchQueryTypes = []uint16{
+	dns.TypeA,
+	dns.TypeAAAA,
+	dns.TypeMX,
+	dns.TypeNS,
+	dns.TypeTXT,
+	dns.TypeSOA,
+	dns.TypeSRV,
+	dns.TypeCAA,
+	dns.TypeHTTPS,
+	dns.TypeSVCB,
+}
+
+// dnsTypeFailure A record type that couldn't be queried, and why
+type dnsTypeFailure struct {
+	Type uint16
+	Err  error
+}
+
+// exchangeTypes Queries each of the record types for a name in parallel. The
+// responses of the types that succeeded are returned in the same order as
+// the types, along with the types that failed. An error is only returned if
+// every type failed
+func (d *DNSAdapter) exchangeTypes(ctx context.Context, query string, server string, qtypes []uint16) ([]*dns.Msg, []dnsTypeFailure, error) {
+	results := make([]*dns.Msg, len(qtypes))
+	errs := make([]error, len(qtypes))
+
+	var wg sync.WaitGroup
+
+	for i, qtype := range qtypes {
+		wg.Add(1)
+
+		go func(i int, qtype uint16) {
+			defer wg.Done()
+
+			// Create the query
+			msg := dns.Msg{
+				Question: []dns.Question{
+					{
+						Name:   dns.Fqdn(query),
+						Qclass: dns.ClassINET,
+						Qtype:  qtype,
+					},
+				},
+				MsgHdr: dns.MsgHdr{
+					Opcode:           dns.OpcodeQuery,
+					RecursionDesired: true,
+				},
+			}
+
+			if d.ValidateDNSSEC {
+				msg = *newDNSSECQuery(query, qtype)
+			}
+
+			results[i], errs[i] = d.exchange(ctx, &msg, server)
+		}(i, qtype)
 	}
 
-	r, _, err := d.client.ExchangeContext(ctx, &msg, server)
+	wg.Wait()
 
-	if err != nil {
-		return nil, err
+	responses := make([]*dns.Msg, 0, len(qtypes))
+	failures := make([]dnsTypeFailure, 0)
+
+	for i, qtype := range qtypes {
+		if errs[i] != nil {
+			failures = append(failures, dnsTypeFailure{
+				Type: qtype,
+				Err:  fmt.Errorf("%v: %w", dns.TypeToString[qtype], errs[i]),
+			})
+
+			continue
+		}
+
+		responses = append(responses, results[i])
 	}
 
-	// Also query for AAAA
-	msg.Question[0].Qtype = dns.TypeAAAA
-	r2, _, err := d.client.ExchangeContext(ctx, &msg, server)
+	if len(responses) == 0 {
+		return nil, failures, typeFailuresError(failures)
+	}
 
+	return responses, failures, nil
+}
+
+// typeFailuresError Joins the errors of the types that failed
+func typeFailuresError(failures []dnsTypeFailure) error {
+	errs := make([]error, 0, len(failures))
+	for _, f := range failures {
+		errs = append(errs, f.Err)
+	}
+
+	return errors.Join(errs...)
+}
+
+func (d *DNSAdapter) makeQueryImpl(ctx context.Context, query string, server string, qtypes []uint16) (*dnsResponse, error) {
+	responses, failures, err := d.exchangeTypes(ctx, query, server, qtypes)
 	if err != nil {
 		return nil, err
 	}
 
 	answers := make([]dns.RR, 0)
-	answers = append(answers, r.Answer...)
-	answers = append(answers, r2.Answer...)
+	for _, r := range responses {
+		answers = append(answers, r.Answer...)
+	}
+
+	ttl := responseTTL(responses)
 
 	if len(answers) == 0 {
-		// This means nothing was found
-		return nil, &sdp.QueryError{
+		if len(failures) > 0 {
+			// The types that failed might have had answers, so this isn't
+			// a negative answer and another server should be tried
+			return nil, typeFailuresError(failures)
+		}
+
+		// This means nothing was found. The TTL is still returned so that
+		// the negative answer can be cached
+		return &dnsResponse{TTL: ttl}, &sdp.QueryError{
 			ErrorType: sdp.QueryError_NOTFOUND,
 			Scope:     "global",
 		}
 	}
 
+	items, err := AnswersToItems(answers)
+	if err != nil {
+		return nil, err
+	}
+
+	failedTypes := make([]string, 0, len(failures))
+	for _, f := range failures {
+		failedTypes = append(failedTypes, dns.TypeToString[f.Type])
+	}
+
+	return &dnsResponse{
+		Items:       items,
+		TTL:         ttl,
+		Answers:     answers,
+		Server:      server,
+		FailedTypes: failedTypes,
+	}, nil
+}
+
+// AnswersToItems Groups a set of answers and converts each group to an item.
+// CNAME and address records are returned as `dns` items, all other record
+// types are returned as their own type e.g. `dns-mx`
+func AnswersToItems(answers []dns.RR) ([]*sdp.Item, error) {
 	ag := GroupAnswers(answers)
 
 	items := make([]*sdp.Item, 0)
 
 	var item *sdp.Item
 	var attrs *sdp.ItemAttributes
+	var err error
 
 	// Iterate over the groups and convert
 	for _, r := range ag.CNAME {
@@ -386,12 +906,12 @@ func (d *DNSAdapter) makeQueryImpl(ctx context.Context, query string, server str
 			name := trimDnsSuffix(cname.Hdr.Name)
 			target := trimDnsSuffix(cname.Target)
 
-			attrs, err = sdp.ToAttributes(map[string]interface{}{
+			attrs, err = sdp.ToAttributes(withUnicodeName(map[string]interface{}{
 				"name":   name,
 				"type":   "CNAME",
 				"ttl":    cname.Hdr.Ttl,
 				"target": target,
-			})
+			}))
 
 			if err != nil {
 				return nil, err
@@ -446,12 +966,62 @@ func (d *DNSAdapter) makeQueryImpl(ctx context.Context, query string, server str
 		items = append(items, item)
 	}
 
+	// Convert the remaining record types, each of which has its own converter
+	converters := []struct {
+		groups  map[string][]dns.RR
+		convert func(string, []dns.RR) (*sdp.Item, error)
+	}{
+		{ag.MX, MXToItem},
+		{ag.NS, NSToItem},
+		{ag.TXT, TXTToItem},
+		{ag.SRV, SRVToItem},
+		{ag.CAA, CAAToItem},
+		{ag.HTTPS, HTTPSToItem},
+		{ag.SVCB, SVCBToItem},
+		{ag.DNSKEY, DNSKEYToItem},
+		{ag.DS, DSToItem},
+		{ag.PTR, reverseNameToItem},
+	}
+
+	for _, c := range converters {
+		for name, rs := range c.groups {
+			item, err := c.convert(trimDnsSuffix(name), rs)
+
+			if err != nil {
+				return nil, err
+			}
+
+			items = append(items, item)
+		}
+	}
+
+	for name, r := range ag.SOA {
+		item, err := SOAToItem(trimDnsSuffix(name), r)
+
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, item)
+	}
+
 	return items, nil
 }
 
 type AnswerGroup struct {
 	CNAME   map[string]dns.RR
 	Address map[string][]dns.RR
+	MX      map[string][]dns.RR
+	NS      map[string][]dns.RR
+	TXT     map[string][]dns.RR
+	SOA     map[string]dns.RR
+	SRV     map[string][]dns.RR
+	CAA     map[string][]dns.RR
+	HTTPS   map[string][]dns.RR
+	SVCB    map[string][]dns.RR
+	DNSKEY  map[string][]dns.RR
+	DS      map[string][]dns.RR
+	PTR     map[string][]dns.RR
 }
 
 // GroupAnswers Groups the DNS answers so they they can be turned into
@@ -462,6 +1032,17 @@ func GroupAnswers(answers []dns.RR) *AnswerGroup {
 	ag := AnswerGroup{
 		CNAME:   make(map[string]dns.RR),
 		Address: make(map[string][]dns.RR),
+		MX:      make(map[string][]dns.RR),
+		NS:      make(map[string][]dns.RR),
+		TXT:     make(map[string][]dns.RR),
+		SOA:     make(map[string]dns.RR),
+		SRV:     make(map[string][]dns.RR),
+		CAA:     make(map[string][]dns.RR),
+		HTTPS:   make(map[string][]dns.RR),
+		SVCB:    make(map[string][]dns.RR),
+		DNSKEY:  make(map[string][]dns.RR),
+		DS:      make(map[string][]dns.RR),
+		PTR:     make(map[string][]dns.RR),
 	}
 
 	for _, answer := range answers {
@@ -479,6 +1060,29 @@ func GroupAnswers(answers []dns.RR) *AnswerGroup {
 				}
 
 				ag.Address[hdr.Name] = append(ag.Address[hdr.Name], answer)
+			case dns.TypeMX:
+				ag.MX[hdr.Name] = append(ag.MX[hdr.Name], answer)
+			case dns.TypeNS:
+				ag.NS[hdr.Name] = append(ag.NS[hdr.Name], answer)
+			case dns.TypeTXT:
+				ag.TXT[hdr.Name] = append(ag.TXT[hdr.Name], answer)
+			case dns.TypeSOA:
+				// There can only be one SOA per zone
+				ag.SOA[hdr.Name] = answer
+			case dns.TypeSRV:
+				ag.SRV[hdr.Name] = append(ag.SRV[hdr.Name], answer)
+			case dns.TypeCAA:
+				ag.CAA[hdr.Name] = append(ag.CAA[hdr.Name], answer)
+			case dns.TypeHTTPS:
+				ag.HTTPS[hdr.Name] = append(ag.HTTPS[hdr.Name], answer)
+			case dns.TypeSVCB:
+				ag.SVCB[hdr.Name] = append(ag.SVCB[hdr.Name], answer)
+			case dns.TypeDNSKEY:
+				ag.DNSKEY[hdr.Name] = append(ag.DNSKEY[hdr.Name], answer)
+			case dns.TypeDS:
+				ag.DS[hdr.Name] = append(ag.DS[hdr.Name], answer)
+			case dns.TypePTR:
+				ag.PTR[hdr.Name] = append(ag.PTR[hdr.Name], answer)
 			}
 		}
 	}
@@ -531,11 +1135,11 @@ func AToItem(name string, records []dns.RR) (*sdp.Item, error) {
 		return fmt.Sprint(i) < fmt.Sprint(j)
 	})
 
-	attrs, err := sdp.ToAttributes(map[string]interface{}{
+	attrs, err := sdp.ToAttributes(withUnicodeName(map[string]interface{}{
 		"name":    name,
 		"type":    "address",
 		"records": recordAttrs,
-	})
+	}))
 
 	if err != nil {
 		return nil, err
@@ -566,3 +1170,419 @@ func AToItem(name string, records []dns.RR) (*sdp.Item, error) {
 
 	return &item, nil
 }
+
+// recordItemType Returns the item type used for a given record type e.g.
+// `dns-mx` for MX records
+func recordItemType(rrtype uint16) string {
+	return ItemType + "-" + strings.ToLower(dns.TypeToString[rrtype])
+}
+
+// dnsTargetQuery Creates a linked item query for a DNS name that a record
+// points to such as an MX exchange or NS host. Changes to the target will
+// affect the record, but the record won't affect the target
+func dnsTargetQuery(target string) *sdp.LinkedItemQuery {
+	return &sdp.LinkedItemQuery{
+		Query: &sdp.Query{
+			Type:   ItemType,
+			Method: sdp.QueryMethod_SEARCH,
+			Query:  target,
+			Scope:  "global",
+		},
+		BlastPropagation: &sdp.BlastPropagation{
+			In:  true,
+			Out: false,
+		},
+	}
+}
+
+// recordsToItem Creates an item from a set of already converted records. This
+// is shared by all converters that return a group of records of the same type
+func recordsToItem(rrtype uint16, name string, recordAttrs []map[string]interface{}, liq []*sdp.LinkedItemQuery) (*sdp.Item, error) {
+	// Sort records to ensure they are consistent
+	sort.Slice(recordAttrs, func(i, j int) bool {
+		return fmt.Sprint(recordAttrs[i]) < fmt.Sprint(recordAttrs[j])
+	})
+
+	attrs, err := sdp.ToAttributes(withUnicodeName(map[string]interface{}{
+		"name":    name,
+		"type":    dns.TypeToString[rrtype],
+		"records": recordAttrs,
+	}))
+
+	if err != nil {
+		return nil, err
+	}
+
+	item := sdp.Item{
+		Type:              recordItemType(rrtype),
+		UniqueAttribute:   UniqueAttribute,
+		Scope:             "global",
+		Attributes:        attrs,
+		LinkedItemQueries: liq,
+	}
+
+	if name == "." {
+		// The root zone isn't registered anywhere
+		return &item, nil
+	}
+
+	item.LinkedItemQueries = append(item.LinkedItemQueries, &sdp.LinkedItemQuery{
+		Query: &sdp.Query{
+			Type:   "rdap-domain",
+			Method: sdp.QueryMethod_SEARCH,
+			Query:  name,
+			Scope:  "global",
+		},
+		BlastPropagation: &sdp.BlastPropagation{
+			// Changes to the domain will affect the DNS entry
+			In: true,
+			// Changes to the DNS entry won't affect the domain
+			Out: false,
+		},
+	})
+
+	return &item, nil
+}
+
+// MXToItem Converts a set of MX records to a `dns-mx` item, linking to each
+// mail exchange
+func MXToItem(name string, records []dns.RR) (*sdp.Item, error) {
+	recordAttrs := make([]map[string]interface{}, 0)
+	liq := make([]*sdp.LinkedItemQuery, 0)
+
+	for _, r := range records {
+		if mx, ok := r.(*dns.MX); ok {
+			exchange := trimDnsSuffix(mx.Mx)
+
+			recordAttrs = append(recordAttrs, map[string]interface{}{
+				"ttl":        mx.Hdr.Ttl,
+				"preference": mx.Preference,
+				"exchange":   exchange,
+			})
+
+			// A null MX (RFC 7505) has a target of "." and means that the
+			// domain doesn't accept mail
+			if exchange != "" {
+				liq = append(liq, dnsTargetQuery(exchange))
+			}
+		}
+	}
+
+	return recordsToItem(dns.TypeMX, name, recordAttrs, liq)
+}
+
+// NSToItem Converts a set of NS records to a `dns-ns` item, linking to each
+// name server
+func NSToItem(name string, records []dns.RR) (*sdp.Item, error) {
+	recordAttrs := make([]map[string]interface{}, 0)
+	liq := make([]*sdp.LinkedItemQuery, 0)
+
+	for _, r := range records {
+		if ns, ok := r.(*dns.NS); ok {
+			host := trimDnsSuffix(ns.Ns)
+
+			recordAttrs = append(recordAttrs, map[string]interface{}{
+				"ttl": ns.Hdr.Ttl,
+				"ns":  host,
+			})
+
+			liq = append(liq, dnsTargetQuery(host))
+		}
+	}
+
+	return recordsToItem(dns.TypeNS, name, recordAttrs, liq)
+}
+
+// TXTToItem Converts a set of TXT records to a `dns-txt` item. Records that
+// are split into many strings are joined back together as described in RFC
+// 7208 section 3.3
+func TXTToItem(name string, records []dns.RR) (*sdp.Item, error) {
+	recordAttrs := make([]map[string]interface{}, 0)
+
+	for _, r := range records {
+		if txt, ok := r.(*dns.TXT); ok {
+			recordAttrs = append(recordAttrs, map[string]interface{}{
+				"ttl":   txt.Hdr.Ttl,
+				"value": strings.Join(txt.Txt, ""),
+			})
+		}
+	}
+
+	return recordsToItem(dns.TypeTXT, name, recordAttrs, nil)
+}
+
+// SOAToItem Converts an SOA record to a `dns-soa` item, linking to the
+// primary name server
+func SOAToItem(name string, record dns.RR) (*sdp.Item, error) {
+	soa, ok := record.(*dns.SOA)
+
+	if !ok {
+		return nil, fmt.Errorf("expected SOA record, got %T", record)
+	}
+
+	primary := trimDnsSuffix(soa.Ns)
+
+	attrs, err := sdp.ToAttributes(withUnicodeName(map[string]interface{}{
+		"name":    name,
+		"type":    "SOA",
+		"ttl":     soa.Hdr.Ttl,
+		"ns":      primary,
+		"mbox":    trimDnsSuffix(soa.Mbox),
+		"serial":  soa.Serial,
+		"refresh": soa.Refresh,
+		"retry":   soa.Retry,
+		"expire":  soa.Expire,
+		"minttl":  soa.Minttl,
+	}))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &sdp.Item{
+		Type:            recordItemType(dns.TypeSOA),
+		UniqueAttribute: UniqueAttribute,
+		Scope:           "global",
+		Attributes:      attrs,
+		LinkedItemQueries: []*sdp.LinkedItemQuery{
+			dnsTargetQuery(primary),
+			{
+				Query: &sdp.Query{
+					Type:   "rdap-domain",
+					Method: sdp.QueryMethod_SEARCH,
+					Query:  name,
+					Scope:  "global",
+				},
+				BlastPropagation: &sdp.BlastPropagation{
+					In:  true,
+					Out: false,
+				},
+			},
+		},
+	}, nil
+}
+
+// SRVToItem Converts a set of SRV records to a `dns-srv` item, linking to each
+// target host
+func SRVToItem(name string, records []dns.RR) (*sdp.Item, error) {
+	recordAttrs := make([]map[string]interface{}, 0)
+	liq := make([]*sdp.LinkedItemQuery, 0)
+
+	for _, r := range records {
+		if srv, ok := r.(*dns.SRV); ok {
+			target := trimDnsSuffix(srv.Target)
+
+			recordAttrs = append(recordAttrs, map[string]interface{}{
+				"ttl":      srv.Hdr.Ttl,
+				"priority": srv.Priority,
+				"weight":   srv.Weight,
+				"port":     srv.Port,
+				"target":   target,
+			})
+
+			// A target of "." means that the service is not available
+			if target != "" {
+				liq = append(liq, dnsTargetQuery(target))
+			}
+		}
+	}
+
+	return recordsToItem(dns.TypeSRV, name, recordAttrs, liq)
+}
+
+// CAAToItem Converts a set of CAA records to a `dns-caa` item
+func CAAToItem(name string, records []dns.RR) (*sdp.Item, error) {
+	recordAttrs := make([]map[string]interface{}, 0)
+
+	for _, r := range records {
+		if caa, ok := r.(*dns.CAA); ok {
+			recordAttrs = append(recordAttrs, map[string]interface{}{
+				"ttl":   caa.Hdr.Ttl,
+				"flag":  caa.Flag,
+				"tag":   caa.Tag,
+				"value": caa.Value,
+			})
+		}
+	}
+
+	return recordsToItem(dns.TypeCAA, name, recordAttrs, nil)
+}
+
+// HTTPSToItem Converts a set of HTTPS records (RFC 9460) to a `dns-https`
+// item. Each record is linked to its target, the hinted IPs and the origin
+// that it describes
+func HTTPSToItem(name string, records []dns.RR) (*sdp.Item, error) {
+	recordAttrs := make([]map[string]interface{}, 0)
+	liq := make([]*sdp.LinkedItemQuery, 0)
+
+	for _, r := range records {
+		if https, ok := r.(*dns.HTTPS); ok {
+			attrs, links := svcbToAttributes(name, &https.SVCB)
+
+			recordAttrs = append(recordAttrs, attrs)
+			liq = append(liq, links...)
+		}
+	}
+
+	if len(recordAttrs) > 0 {
+		liq = append(liq, &sdp.LinkedItemQuery{
+			Query: &sdp.Query{
+				Type:   "http",
+				Method: sdp.QueryMethod_GET,
+				Query:  httpsOriginURL(name),
+				Scope:  "global",
+			},
+			BlastPropagation: &sdp.BlastPropagation{
+				// The record tells clients how to connect to the origin, so
+				// changing it changes how the origin is reached
+				In:  false,
+				Out: true,
+			},
+		})
+	}
+
+	return recordsToItem(dns.TypeHTTPS, name, recordAttrs, dedupeLinkedItemQueries(liq))
+}
+
+// SVCBToItem Converts a set of SVCB records (RFC 9460) to a `dns-svcb` item,
+// linking to each target and the hinted IPs
+func SVCBToItem(name string, records []dns.RR) (*sdp.Item, error) {
+	recordAttrs := make([]map[string]interface{}, 0)
+	liq := make([]*sdp.LinkedItemQuery, 0)
+
+	for _, r := range records {
+		if svcb, ok := r.(*dns.SVCB); ok {
+			attrs, links := svcbToAttributes(name, svcb)
+
+			recordAttrs = append(recordAttrs, attrs)
+			liq = append(liq, links...)
+		}
+	}
+
+	return recordsToItem(dns.TypeSVCB, name, recordAttrs, dedupeLinkedItemQueries(liq))
+}
+
+// svcbToAttributes Converts the parameters of an SVCB or HTTPS record to
+// attributes, and returns links to the target and hinted IPs
+func svcbToAttributes(name string, svcb *dns.SVCB) (map[string]interface{}, []*sdp.LinkedItemQuery) {
+	liq := make([]*sdp.LinkedItemQuery, 0)
+	target := trimDnsSuffix(svcb.Target)
+
+	attrs := map[string]interface{}{
+		"ttl":      svcb.Hdr.Ttl,
+		"priority": svcb.Priority,
+		"target":   target,
+	}
+
+	if svcb.Priority == 0 {
+		// AliasMode, which works like a CNAME that can be used at the apex
+		attrs["mode"] = "alias"
+	} else {
+		attrs["mode"] = "service"
+	}
+
+	// In ServiceMode a target of "." means the owner name itself, so there
+	// is nothing else to link to
+	if target != "" && target != name {
+		liq = append(liq, dnsTargetQuery(target))
+	}
+
+	for _, kv := range svcb.Value {
+		switch v := kv.(type) {
+		case *dns.SVCBAlpn:
+			attrs["alpn"] = v.Alpn
+		case *dns.SVCBNoDefaultAlpn:
+			attrs["noDefaultAlpn"] = true
+		case *dns.SVCBPort:
+			attrs["port"] = v.Port
+		case *dns.SVCBIPv4Hint:
+			attrs["ipv4hint"], liq = svcbHints(v.Hint, liq)
+		case *dns.SVCBIPv6Hint:
+			attrs["ipv6hint"], liq = svcbHints(v.Hint, liq)
+		case *dns.SVCBECHConfig:
+			attrs["ech"] = base64.StdEncoding.EncodeToString(v.ECH)
+		case *dns.SVCBMandatory:
+			mandatory := make([]string, 0, len(v.Code))
+			for _, key := range v.Code {
+				mandatory = append(mandatory, key.String())
+			}
+			attrs["mandatory"] = mandatory
+		case *dns.SVCBDoHPath:
+			attrs["dohpath"] = v.Template
+		default:
+			// Keys that aren't understood are kept in their presentation
+			// format so that they are still visible
+			params, _ := attrs["params"].(map[string]interface{})
+			if params == nil {
+				params = make(map[string]interface{})
+				attrs["params"] = params
+			}
+			params[kv.Key().String()] = kv.String()
+		}
+	}
+
+	return attrs, liq
+}
+
+// svcbHints Returns the hinted IPs as strings and links to each of them.
+// Clients may use the hints instead of looking up the target, so changes to
+// the IPs affect the record
+func svcbHints(hints []net.IP, liq []*sdp.LinkedItemQuery) ([]string, []*sdp.LinkedItemQuery) {
+	ips := make([]string, 0, len(hints))
+
+	for _, ip := range hints {
+		ips = append(ips, ip.String())
+
+		liq = append(liq, &sdp.LinkedItemQuery{
+			Query: &sdp.Query{
+				Type:   "ip",
+				Method: sdp.QueryMethod_GET,
+				Query:  ip.String(),
+				Scope:  "global",
+			},
+			BlastPropagation: &sdp.BlastPropagation{
+				In:  true,
+				Out: false,
+			},
+		})
+	}
+
+	return ips, liq
+}
+
+// httpsOriginURL Returns the URL of the origin that an HTTPS record is for.
+// Records for origins on other ports are published under a name like
+// "_8443._https.example.com" (RFC 9460 section 9.1)
+func httpsOriginURL(name string) string {
+	host := name
+	port := ""
+
+	if prefix, rest, ok := strings.Cut(name, "._https."); ok && strings.HasPrefix(prefix, "_") {
+		host = rest
+		port = strings.TrimPrefix(prefix, "_")
+	}
+
+	if port == "" || port == "443" {
+		return "https://" + host
+	}
+
+	return "https://" + net.JoinHostPort(host, port)
+}
+
+// dedupeLinkedItemQueries Removes duplicate queries, keeping the first
+func dedupeLinkedItemQueries(liq []*sdp.LinkedItemQuery) []*sdp.LinkedItemQuery {
+	seen := make(map[string]bool)
+	deduped := make([]*sdp.LinkedItemQuery, 0, len(liq))
+
+	for _, q := range liq {
+		key := q.GetQuery().GetType() + " " + q.GetQuery().GetMethod().String() + " " + q.GetQuery().GetQuery()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		deduped = append(deduped, q)
+	}
+
+	return deduped
+}
@@ -0,0 +1,193 @@
+package adapters
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/overmindtech/sdp-go"
+)
+
+const (
+	// DefaultDNSMinCacheDuration The shortest time that a DNS result will be
+	// cached for, this stops records with very low TTLs from being looked up
+	// on every query
+	DefaultDNSMinCacheDuration = 30 * time.Second
+	// DefaultDNSMaxCacheDuration The longest time that a DNS result will be
+	// cached for, regardless of the TTL
+	DefaultDNSMaxCacheDuration = 1 * time.Hour
+	// DefaultDNSMaxStaleDuration How long after expiry a result can be
+	// served if all upstream servers are failing. RFC 8767 recommends
+	// between 1 and 3 days
+	DefaultDNSMaxStaleDuration = 24 * time.Hour
+
+	// dnsStaleAnswerDuration How long a stale answer is cached for once it
+	// has been served. This is the 30 seconds recommended by RFC 8767
+	dnsStaleAnswerDuration = 30 * time.Second
+)
+
+// responseTTL Returns the TTL that a set of responses can be cached for. This
+// is the lowest TTL of any answer, or for responses with no answers the
+// negative caching TTL from the SOA record in the authority section as
+// described in RFC 2308. Returns zero if no TTL could be found
+func responseTTL(responses []*dns.Msg) time.Duration {
+	ttl := uint32(math.MaxUint32)
+
+	for _, r := range responses {
+		if len(r.Answer) > 0 {
+			for _, rr := range r.Answer {
+				ttl = min(ttl, rr.Header().Ttl)
+			}
+		} else if negative, ok := negativeTTL(r); ok {
+			ttl = min(ttl, negative)
+		}
+	}
+
+	if ttl == math.MaxUint32 {
+		return 0
+	}
+
+	return time.Duration(ttl) * time.Second
+}
+
+// negativeTTL Returns the TTL for a negative response, which is the lower of
+// the SOA record's TTL and its MINIMUM field
+func negativeTTL(r *dns.Msg) (uint32, bool) {
+	for _, rr := range r.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return min(soa.Hdr.Ttl, soa.Minttl), true
+		}
+	}
+
+	return 0, false
+}
+
+// cacheDuration Clamps a TTL to the configured floor and ceiling
+func (d *DNSAdapter) cacheDuration(ttl time.Duration) time.Duration {
+	floor := d.MinCacheDuration
+	if floor == 0 {
+		floor = DefaultDNSMinCacheDuration
+	}
+
+	ceiling := d.MaxCacheDuration
+	if ceiling == 0 {
+		ceiling = DefaultDNSMaxCacheDuration
+	}
+
+	return min(max(ttl, floor), max(ceiling, floor))
+}
+
+// staleDuration Returns how long after expiry results can be served stale,
+// zero means that stale results are never served
+func (d *DNSAdapter) staleDuration() time.Duration {
+	switch {
+	case d.MaxStaleDuration < 0:
+		return 0
+	case d.MaxStaleDuration == 0:
+		return DefaultDNSMaxStaleDuration
+	default:
+		return d.MaxStaleDuration
+	}
+}
+
+// staleSweepInterval How often expired results are removed from the stale
+// cache. Lookup removes expired results itself, this only stops results that
+// are never looked up again from building up
+const staleSweepInterval = time.Minute
+
+// staleEntry A result that can be served after it has expired
+type staleEntry struct {
+	Items   []*sdp.Item
+	Expiry  time.Time // When the result stopped being fresh
+	Removal time.Time // When the result can no longer be served stale
+}
+
+// staleCache Keeps a copy of the last successful result for each query so
+// that it can be served if upstream servers are failing (RFC 8767). The
+// sdpcache can't be used for this since it purges results once they expire.
+// The zero value is ready to use
+type staleCache struct {
+	mu        sync.Mutex
+	entries   map[string]staleEntry
+	lastSweep time.Time
+
+	// Used to override the current time in tests
+	now func() time.Time
+}
+
+func (s *staleCache) timeNow() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+
+	return time.Now()
+}
+
+// Store Saves a result that is fresh for the given duration and can then be
+// served stale for maxStale
+func (s *staleCache) Store(key string, items []*sdp.Item, fresh time.Duration, maxStale time.Duration) {
+	if maxStale <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.timeNow()
+
+	if s.entries == nil {
+		s.entries = make(map[string]staleEntry)
+	}
+
+	// Remove anything that can no longer be served so that this doesn't grow
+	// forever. This walks every entry so is only done once per interval
+	if now.Sub(s.lastSweep) >= staleSweepInterval {
+		for k, e := range s.entries {
+			if now.After(e.Removal) {
+				delete(s.entries, k)
+			}
+		}
+
+		s.lastSweep = now
+	}
+
+	s.entries[key] = staleEntry{
+		Items:   copyItems(items),
+		Expiry:  now.Add(fresh),
+		Removal: now.Add(fresh + maxStale),
+	}
+}
+
+// Lookup Returns a copy of the stored result and how long ago it expired, if
+// it can still be served
+func (s *staleCache) Lookup(key string) ([]*sdp.Item, time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return nil, 0, false
+	}
+
+	now := s.timeNow()
+
+	if now.After(e.Removal) {
+		delete(s.entries, key)
+		return nil, 0, false
+	}
+
+	return copyItems(e.Items), max(now.Sub(e.Expiry), 0), true
+}
+
+func copyItems(items []*sdp.Item) []*sdp.Item {
+	copied := make([]*sdp.Item, 0, len(items))
+
+	for _, item := range items {
+		c := &sdp.Item{}
+		item.Copy(c)
+		copied = append(copied, c)
+	}
+
+	return copied
+}
@@ -0,0 +1,349 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/overmindtech/sdp-go"
+)
+
+func TestResponseTTL(t *testing.T) {
+	t.Parallel()
+
+	answer := new(dns.Msg)
+	answer.Answer = mustRRs(t,
+		"example.com. 300 IN A 192.0.2.1",
+		"example.com. 60 IN A 192.0.2.2",
+	)
+
+	// The SOA minimum is lower than the TTL of the SOA itself
+	negative := new(dns.Msg)
+	negative.Ns = mustRRs(t, "example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 120")
+
+	tests := []struct {
+		Name      string
+		Responses []*dns.Msg
+		Expected  time.Duration
+	}{
+		{
+			Name:      "lowest answer TTL",
+			Responses: []*dns.Msg{answer},
+			Expected:  60 * time.Second,
+		},
+		{
+			Name:      "negative answer uses SOA minimum",
+			Responses: []*dns.Msg{negative},
+			Expected:  120 * time.Second,
+		},
+		{
+			Name:      "mixed answers",
+			Responses: []*dns.Msg{negative, answer},
+			Expected:  60 * time.Second,
+		},
+		{
+			Name:      "no TTL",
+			Responses: []*dns.Msg{new(dns.Msg)},
+			Expected:  0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			if ttl := responseTTL(test.Responses); ttl != test.Expected {
+				t.Errorf("expected %v, got %v", test.Expected, ttl)
+			}
+		})
+	}
+}
+
+func TestCacheDuration(t *testing.T) {
+	t.Parallel()
+
+	t.Run("defaults", func(t *testing.T) {
+		d := DNSAdapter{}
+
+		if got := d.cacheDuration(0); got != DefaultDNSMinCacheDuration {
+			t.Errorf("expected floor of %v, got %v", DefaultDNSMinCacheDuration, got)
+		}
+
+		if got := d.cacheDuration(5 * time.Minute); got != 5*time.Minute {
+			t.Errorf("expected TTL to be used, got %v", got)
+		}
+
+		if got := d.cacheDuration(48 * time.Hour); got != DefaultDNSMaxCacheDuration {
+			t.Errorf("expected ceiling of %v, got %v", DefaultDNSMaxCacheDuration, got)
+		}
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		d := DNSAdapter{
+			MinCacheDuration: time.Minute,
+			MaxCacheDuration: 10 * time.Minute,
+		}
+
+		if got := d.cacheDuration(time.Second); got != time.Minute {
+			t.Errorf("expected floor of 1m, got %v", got)
+		}
+
+		if got := d.cacheDuration(time.Hour); got != 10*time.Minute {
+			t.Errorf("expected ceiling of 10m, got %v", got)
+		}
+	})
+}
+
+func TestStaleCache(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	s := staleCache{
+		now: func() time.Time { return now },
+	}
+
+	item := &sdp.Item{
+		Type:            "dns",
+		UniqueAttribute: "name",
+		Scope:           "global",
+	}
+
+	s.Store("example.com", []*sdp.Item{item}, time.Minute, time.Hour)
+
+	now = now.Add(30 * time.Minute)
+
+	items, age, ok := s.Lookup("example.com")
+	if !ok || len(items) != 1 {
+		t.Fatalf("expected stale item, got %v", items)
+	}
+
+	if age != 29*time.Minute {
+		t.Errorf("expected age of 29m, got %v", age)
+	}
+
+	now = now.Add(time.Hour)
+
+	if _, _, ok := s.Lookup("example.com"); ok {
+		t.Error("expected item to have been removed after the max stale duration")
+	}
+
+	s.Store("disabled", []*sdp.Item{item}, time.Minute, 0)
+
+	if _, _, ok := s.Lookup("disabled"); ok {
+		t.Error("expected nothing to be stored when stale results are disabled")
+	}
+
+	t.Run("expired results are swept once per interval", func(t *testing.T) {
+		s := staleCache{
+			now: func() time.Time { return now },
+		}
+
+		s.Store("short", []*sdp.Item{item}, time.Second, time.Second)
+
+		// Expired, but a sweep has only just happened
+		now = now.Add(staleSweepInterval / 2)
+		s.Store("other", []*sdp.Item{item}, time.Hour, time.Hour)
+
+		if _, ok := s.entries["short"]; !ok {
+			t.Error("expected the expired result not to be swept until the interval has passed")
+		}
+
+		now = now.Add(staleSweepInterval)
+		s.Store("other", []*sdp.Item{item}, time.Hour, time.Hour)
+
+		if _, ok := s.entries["short"]; ok {
+			t.Error("expected the expired result to have been swept")
+		}
+	})
+}
+
+func TestDNSCaching(t *testing.T) {
+	t.Parallel()
+
+	// newCountingServer Returns a server that answers from the records until
+	// failing is set, after which it returns SERVFAIL
+	newCountingServer := func(t *testing.T, records ...string) (string, *atomic.Int32, *atomic.Bool) {
+		var queries atomic.Int32
+		var failing atomic.Bool
+
+		answer := testZone(t, records...)
+		soa := mustRR(t, "example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 120")
+
+		server := startTestDNSServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			queries.Add(1)
+
+			m := answer(r)
+
+			if failing.Load() {
+				m.Answer = nil
+				m.Rcode = dns.RcodeServerFailure
+			} else if len(m.Answer) == 0 {
+				m.Rcode = dns.RcodeNameError
+				m.Ns = []dns.RR{soa}
+			}
+
+			_ = w.WriteMsg(m)
+		}))
+
+		return server, &queries, &failing
+	}
+
+	t.Run("answers are cached", func(t *testing.T) {
+		server, queries, _ := newCountingServer(t, "www.example.com. 600 IN A 192.0.2.1")
+
+		src := DNSAdapter{
+			Servers: []string{server},
+		}
+
+		for range 2 {
+			items, err := src.Search(context.Background(), "global", "www.example.com", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(items) != 1 {
+				t.Fatalf("expected 1 item, got %v", len(items))
+			}
+		}
+
+		if n := queries.Load(); n != int32(len(searchQueryTypes)) {
+			t.Errorf("expected the second search to be served from cache, got %v queries", n)
+		}
+	})
+
+	t.Run("get after search", func(t *testing.T) {
+		server, queries, _ := newCountingServer(t,
+			"example.com. 600 IN A 192.0.2.1",
+			"example.com. 600 IN MX 10 mx.example.com.",
+			`example.com. 600 IN TXT "v=spf1 -all"`,
+		)
+
+		src := DNSAdapter{
+			Servers: []string{server},
+		}
+
+		items, err := src.Search(context.Background(), "global", "example.com", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(items) != 3 {
+			t.Fatalf("expected 3 items, got %v", len(items))
+		}
+
+		searchQueries := queries.Load()
+
+		// Every item has the same name, so they mustn't be returned for a
+		// GET of a different type
+		item, err := src.Get(context.Background(), "global", "example.com", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if item.GetType() != "dns" {
+			t.Errorf("expected a dns item, got %v", item.GetType())
+		}
+
+		mx, err := (&DNSRecordAdapter{DNS: &src, RecordType: dns.TypeMX}).Get(context.Background(), "global", "example.com", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if mx.GetType() != "dns-mx" {
+			t.Errorf("expected a dns-mx item, got %v", mx.GetType())
+		}
+
+		if n := queries.Load(); n != searchQueries {
+			t.Errorf("expected the gets to be served from cache, got %v extra queries", n-searchQueries)
+		}
+
+		items, err = src.Search(context.Background(), "global", "example.com", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(items) != 3 {
+			t.Errorf("expected the search to still be cached with 3 items, got %v", len(items))
+		}
+
+		if n := queries.Load(); n != searchQueries {
+			t.Errorf("expected the second search to be served from cache, got %v extra queries", n-searchQueries)
+		}
+	})
+
+	t.Run("negative answers are cached", func(t *testing.T) {
+		server, queries, _ := newCountingServer(t)
+
+		src := DNSAdapter{
+			Servers: []string{server},
+		}
+
+		for range 2 {
+			_, err := src.Search(context.Background(), "global", "missing.example.com", false)
+
+			var qErr *sdp.QueryError
+			if !errors.As(err, &qErr) || qErr.GetErrorType() != sdp.QueryError_NOTFOUND {
+				t.Fatalf("expected NOTFOUND, got %v", err)
+			}
+		}
+
+		if n := queries.Load(); n != int32(len(searchQueryTypes)) {
+			t.Errorf("expected the second search to be served from cache, got %v queries", n)
+		}
+	})
+
+	t.Run("stale answers are served when servers fail", func(t *testing.T) {
+		server, _, failing := newCountingServer(t, "www.example.com. 0 IN A 192.0.2.1")
+
+		src := DNSAdapter{
+			Servers:          []string{server},
+			MinCacheDuration: time.Nanosecond,
+		}
+
+		_, err := src.Search(context.Background(), "global", "www.example.com", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		failing.Store(true)
+		src.Cache().Purge(time.Now())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+
+		items, err := src.Search(ctx, "global", "www.example.com", false)
+		if err != nil {
+			t.Fatalf("expected stale answer, got %v", err)
+		}
+
+		if len(items) != 1 {
+			t.Fatalf("expected 1 stale item, got %v", len(items))
+		}
+	})
+
+	t.Run("stale answers can be disabled", func(t *testing.T) {
+		server, _, failing := newCountingServer(t, "www.example.com. 0 IN A 192.0.2.1")
+
+		src := DNSAdapter{
+			Servers:          []string{server},
+			MinCacheDuration: time.Nanosecond,
+			MaxStaleDuration: -1,
+		}
+
+		_, err := src.Search(context.Background(), "global", "www.example.com", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		failing.Store(true)
+		src.Cache().Purge(time.Now())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+
+		if _, err = src.Search(ctx, "global", "www.example.com", false); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
@@ -0,0 +1,347 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+	"github.com/overmindtech/sdp-go"
+)
+
+// consistencyQueryPrefix Search queries with this prefix query every server
+// in parallel and compare the answers, e.g. "consistency:www.example.com"
+const consistencyQueryPrefix = "consistency:"
+
+// ConsistencyItemType The type of item returned by consistency checks
+const ConsistencyItemType = "dns-consistency"
+
+// dnsAnswerSet A unique set of answers and the servers that returned it
+type dnsAnswerSet struct {
+	Rcode   string
+	Answers []string
+	Servers []string
+}
+
+// key Returns a string that is the same for identical answer sets
+func (a *dnsAnswerSet) key() string {
+	return a.Rcode + "\n" + strings.Join(a.Answers, "\n")
+}
+
+// searchConsistency Runs a consistency check for a name and caches the result
+func (d *DNSAdapter) searchConsistency(ctx context.Context, scope string, name string, ignoreCache bool) ([]*sdp.Item, error) {
+	cacheHit, ck, cachedItems, qErr := d.lookupItems(ctx, sdp.QueryMethod_SEARCH, scope, consistencyQueryPrefix+name, ignoreCache)
+	if qErr != nil {
+		return nil, qErr
+	}
+	if cacheHit {
+		return cachedItems, nil
+	}
+
+	item, err := d.CheckConsistency(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	// These are used to debug changes, so only cache for the minimum time
+	d.storeItems([]*sdp.Item{item}, d.cacheDuration(0), ck)
+
+	return []*sdp.Item{item}, nil
+}
+
+// DNSConsistencyAdapter Gets the consistency of a name across every
+// configured DNS server. This shares the servers and cache of the DNS
+// adapter, so it returns the same items as a `consistency:` search
+type DNSConsistencyAdapter struct {
+	DNS *DNSAdapter
+}
+
+// Type is the type of items that this returns
+func (d *DNSConsistencyAdapter) Type() string {
+	return ConsistencyItemType
+}
+
+// Name Returns the name of the backend
+func (d *DNSConsistencyAdapter) Name() string {
+	return "stdlib-dns-consistency"
+}
+
+// Weighting of duplicate adapters
+func (d *DNSConsistencyAdapter) Weight() int {
+	return 100
+}
+
+func (d *DNSConsistencyAdapter) Metadata() *sdp.AdapterMetadata {
+	return dnsConsistencyMetadata
+}
+
+var dnsConsistencyMetadata = Metadata.Register(&sdp.AdapterMetadata{
+	DescriptiveName: "DNS Consistency Check",
+	Type:            ConsistencyItemType,
+	SupportedQueryMethods: &sdp.AdapterSupportedQueryMethods{
+		Get:            true,
+		GetDescription: "A DNS name to query against every configured server, comparing the answers for each record type to show which servers agree",
+	},
+	PotentialLinks: []string{"dns"},
+	Category:       sdp.AdapterCategory_ADAPTER_CATEGORY_NETWORK,
+})
+
+// List of scopes that this adapter is capable of find items for
+func (d *DNSConsistencyAdapter) Scopes() []string {
+	return []string{
+		"global",
+	}
+}
+
+// Get Runs a consistency check for a name
+func (d *DNSConsistencyAdapter) Get(ctx context.Context, scope string, query string, ignoreCache bool) (*sdp.Item, error) {
+	if scope != "global" {
+		return nil, &sdp.QueryError{
+			ErrorType:   sdp.QueryError_NOSCOPE,
+			ErrorString: "DNS queries only supported in global scope",
+			Scope:       scope,
+		}
+	}
+
+	name, qErr := normalizeDomainQuery(query, scope)
+	if qErr != nil {
+		return nil, qErr
+	}
+
+	d.DNS.ensureCache()
+	cacheHit, ck, cachedItems, qErr := d.DNS.cache.Lookup(ctx, d.DNS.Name(), sdp.QueryMethod_GET, scope, d.Type(), name, ignoreCache)
+	if qErr != nil {
+		return nil, qErr
+	}
+	if cacheHit && len(cachedItems) > 0 {
+		return cachedItems[0], nil
+	}
+
+	item, err := d.DNS.CheckConsistency(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	d.DNS.cache.StoreItem(item, d.DNS.cacheDuration(0), ck)
+
+	return item, nil
+}
+
+// List Returns nothing, consistency can only be checked for a name
+func (d *DNSConsistencyAdapter) List(ctx context.Context, scope string, ignoreCache bool) ([]*sdp.Item, error) {
+	if scope != "global" {
+		return nil, &sdp.QueryError{
+			ErrorType:   sdp.QueryError_NOSCOPE,
+			ErrorString: "DNS queries only supported in global scope",
+			Scope:       scope,
+		}
+	}
+
+	return make([]*sdp.Item, 0), nil
+}
+
+// CheckConsistency Queries a name against every configured server in parallel
+// and returns a `dns-consistency` item showing which servers agreed and which
+// disagreed. This is useful for debugging split-brain DNS, where private and
+// public resolvers return different answers. Each record type is compared
+// separately, so a type that fails on one server doesn't stop the others
+// being compared. Failures are reported in the item, an error is only
+// returned if every server fails
+func (d *DNSAdapter) CheckConsistency(ctx context.Context, name string) (*sdp.Item, error) {
+	var mu sync.Mutex
+	responses := make(map[string][]*dns.Msg)
+	typeFailures := make(map[string][]dnsTypeFailure)
+
+	results := d.fanOutDNSQuery(ctx, func(ctx context.Context, server string) (*dnsResponse, error) {
+		r, failures, err := d.exchangeTypes(ctx, name, server, searchQueryTypes)
+		if err != nil {
+			return nil, err
+		}
+
+		mu.Lock()
+		responses[server] = r
+		typeFailures[server] = failures
+		mu.Unlock()
+
+		return &dnsResponse{TTL: responseTTL(r)}, nil
+	})
+
+	answered := make([]string, 0, len(results))
+	failed := make([]map[string]interface{}, 0)
+	var errs []error
+
+	for _, result := range results {
+		if result.Err != nil {
+			failed = append(failed, map[string]interface{}{
+				"server": result.Server,
+				"error":  result.Err.Error(),
+			})
+			errs = append(errs, result.Err)
+
+			continue
+		}
+
+		answered = append(answered, result.Server)
+
+		for _, f := range typeFailures[result.Server] {
+			failed = append(failed, map[string]interface{}{
+				"server": result.Server,
+				"type":   dns.TypeToString[f.Type],
+				"error":  f.Err.Error(),
+			})
+		}
+	}
+
+	if len(answered) == 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	consistent := true
+	disagreedServers := make(map[string]bool)
+	types := make([]map[string]interface{}, 0)
+
+	for _, qtype := range searchQueryTypes {
+		sets := make([]*dnsAnswerSet, 0)
+		var hasAnswers bool
+
+		for _, server := range answered {
+			r := responseForType(responses[server], qtype)
+			if r == nil {
+				// This type failed on this server
+				continue
+			}
+
+			set := &dnsAnswerSet{
+				Rcode:   dns.RcodeToString[r.Rcode],
+				Answers: answerStrings(r.Answer),
+				Servers: []string{server},
+			}
+			hasAnswers = hasAnswers || len(set.Answers) > 0
+
+			var found bool
+			for _, existing := range sets {
+				if existing.key() == set.key() {
+					existing.Servers = append(existing.Servers, server)
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				sets = append(sets, set)
+			}
+		}
+
+		if len(sets) == 0 || (len(sets) == 1 && !hasAnswers) {
+			// Types that nobody has records for aren't worth reporting
+			continue
+		}
+
+		// The answer returned by the most servers is treated as the one
+		// that everyone should agree on. Ties are broken by the configured
+		// order of the servers since the stable sort keeps the order that
+		// they were found
+		sort.SliceStable(sets, func(i, j int) bool {
+			return len(sets[i].Servers) > len(sets[j].Servers)
+		})
+
+		answerSets := make([]map[string]interface{}, 0, len(sets))
+
+		for i, set := range sets {
+			if i > 0 {
+				for _, server := range set.Servers {
+					disagreedServers[server] = true
+				}
+			}
+
+			answerSets = append(answerSets, map[string]interface{}{
+				"rcode":   set.Rcode,
+				"answers": set.Answers,
+				"servers": set.Servers,
+			})
+		}
+
+		consistent = consistent && len(sets) == 1
+
+		types = append(types, map[string]interface{}{
+			"type":       dns.TypeToString[qtype],
+			"consistent": len(sets) == 1,
+			"answerSets": answerSets,
+		})
+	}
+
+	agreed := make([]string, 0)
+	disagreed := make([]string, 0)
+
+	for _, server := range answered {
+		if disagreedServers[server] {
+			disagreed = append(disagreed, server)
+		} else {
+			agreed = append(agreed, server)
+		}
+	}
+
+	attrs, err := sdp.ToAttributes(map[string]interface{}{
+		"name":       trimDnsSuffix(name),
+		"consistent": consistent,
+		"agreed":     agreed,
+		"disagreed":  disagreed,
+		"failed":     failed,
+		"types":      types,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &sdp.Item{
+		Type:            ConsistencyItemType,
+		UniqueAttribute: UniqueAttribute,
+		Scope:           "global",
+		Attributes:      attrs,
+		LinkedItemQueries: []*sdp.LinkedItemQuery{
+			dnsTargetQuery(trimDnsSuffix(name)),
+		},
+	}, nil
+}
+
+// responseForType Returns the response to the query for a record type, or nil
+// if there isn't one
+func responseForType(responses []*dns.Msg, qtype uint16) *dns.Msg {
+	for _, r := range responses {
+		if len(r.Question) > 0 && r.Question[0].Qtype == qtype {
+			return r
+		}
+	}
+
+	return nil
+}
+
+// answerStrings Converts answers to sorted strings that can be compared
+// between servers. TTLs are removed since they count down independently on
+// each server, and signatures are removed since they are covered by the
+// records that they sign
+func answerStrings(answers []dns.RR) []string {
+	seen := make(map[string]bool)
+	strs := make([]string, 0, len(answers))
+
+	for _, rr := range answers {
+		if rr.Header().Rrtype == dns.TypeRRSIG {
+			continue
+		}
+
+		hdr := rr.Header()
+		rdata := strings.TrimPrefix(rr.String(), hdr.String())
+		s := trimDnsSuffix(hdr.Name) + " " + dns.TypeToString[hdr.Rrtype] + " " + rdata
+
+		if !seen[s] {
+			seen[s] = true
+			strs = append(strs, s)
+		}
+	}
+
+	sort.Strings(strs)
+
+	return strs
+}
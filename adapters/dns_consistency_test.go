@@ -0,0 +1,173 @@
+package adapters
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/overmindtech/discovery"
+)
+
+func TestCheckConsistency(t *testing.T) {
+	t.Parallel()
+
+	public1 := newTestDNSServer(t, "app.example.com. 300 IN A 192.0.2.1")
+	public2 := newTestDNSServer(t, "app.example.com. 60 IN A 192.0.2.1")
+	private := newTestDNSServer(t, "app.example.com. 300 IN A 10.0.0.1")
+	broken := startTestDNSServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeServerFailure)
+		_ = w.WriteMsg(m)
+	}))
+
+	t.Run("split brain", func(t *testing.T) {
+		src := DNSAdapter{
+			Servers: []string{private, public1, public2, broken},
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		items, err := src.Search(ctx, "global", "consistency:app.example.com", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(items) != 1 {
+			t.Fatalf("expected 1 item, got %v", len(items))
+		}
+
+		item := items[0]
+		discovery.TestValidateItem(t, item)
+
+		if item.GetType() != ConsistencyItemType {
+			t.Errorf("expected type %v, got %v", ConsistencyItemType, item.GetType())
+		}
+
+		if consistent, _ := item.GetAttributes().Get("consistent"); consistent != false {
+			t.Errorf("expected consistent to be false, got %v", consistent)
+		}
+
+		// The public servers are in the majority, TTLs are ignored
+		agreed, _ := item.GetAttributes().Get("agreed")
+		if !reflect.DeepEqual(agreed, []interface{}{public1, public2}) {
+			t.Errorf("expected public servers to agree, got %v", agreed)
+		}
+
+		disagreed, _ := item.GetAttributes().Get("disagreed")
+		if !reflect.DeepEqual(disagreed, []interface{}{private}) {
+			t.Errorf("expected private server to disagree, got %v", disagreed)
+		}
+
+		// Only the A records differ, the other types have no records
+		typesAttr, _ := item.GetAttributes().Get("types")
+		types := typesAttr.([]interface{})
+		if len(types) != 1 || types[0].(map[string]interface{})["type"] != "A" {
+			t.Fatalf("expected only A records to be compared, got %v", types)
+		}
+
+		sets := types[0].(map[string]interface{})["answerSets"].([]interface{})
+		if len(sets) != 2 {
+			t.Fatalf("expected 2 answer sets, got %v", sets)
+		}
+
+		answers := sets[1].(map[string]interface{})["answers"]
+		if !reflect.DeepEqual(answers, []interface{}{"app.example.com A 10.0.0.1"}) {
+			t.Errorf("expected private answer, got %v", answers)
+		}
+
+		failed, _ := item.GetAttributes().Get("failed")
+		if f := failed.([]interface{}); len(f) != 1 || f[0].(map[string]interface{})["server"] != broken {
+			t.Errorf("expected broken server to have failed, got %v", failed)
+		}
+	})
+
+	t.Run("consistent", func(t *testing.T) {
+		src := DNSAdapter{
+			Servers: []string{public1, public2},
+		}
+
+		item, err := src.CheckConsistency(context.Background(), "app.example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if consistent, _ := item.GetAttributes().Get("consistent"); consistent != true {
+			t.Errorf("expected consistent to be true, got %v", consistent)
+		}
+	})
+
+	t.Run("failures are per type", func(t *testing.T) {
+		answer := testZone(t,
+			"app.example.com. 300 IN A 192.0.2.1",
+			"app.example.com. 300 IN MX 10 mx.example.com.",
+		)
+
+		// Refuses MX queries, but should still be compared for the others
+		partial := startTestDNSServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			m := answer(r)
+			if r.Question[0].Qtype == dns.TypeMX {
+				m.Answer = nil
+				m.Rcode = dns.RcodeRefused
+			}
+
+			_ = w.WriteMsg(m)
+		}))
+
+		src := DNSAdapter{
+			Servers: []string{partial, public1},
+		}
+
+		item, err := src.CheckConsistency(context.Background(), "app.example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if consistent, _ := item.GetAttributes().Get("consistent"); consistent != true {
+			t.Errorf("expected consistent to be true, got %v", consistent)
+		}
+
+		agreed, _ := item.GetAttributes().Get("agreed")
+		if !reflect.DeepEqual(agreed, []interface{}{partial, public1}) {
+			t.Errorf("expected both servers to agree, got %v", agreed)
+		}
+
+		failed, _ := item.GetAttributes().Get("failed")
+		f := failed.([]interface{})
+		if len(f) != 1 || f[0].(map[string]interface{})["server"] != partial || f[0].(map[string]interface{})["type"] != "MX" {
+			t.Errorf("expected MX to have failed on the partial server, got %v", failed)
+		}
+	})
+
+	t.Run("adapter", func(t *testing.T) {
+		src := DNSAdapter{
+			Servers: []string{public1, public2},
+		}
+
+		item, err := (&DNSConsistencyAdapter{DNS: &src}).Get(context.Background(), "global", "App.Example.com.", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		discovery.TestValidateItem(t, item)
+
+		if name, _ := item.GetAttributes().Get("name"); name != "app.example.com" {
+			t.Errorf("expected the name to be normalised, got %v", name)
+		}
+	})
+
+	t.Run("all servers failing", func(t *testing.T) {
+		src := DNSAdapter{
+			Servers: []string{broken},
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+
+		if _, err := src.CheckConsistency(ctx, "app.example.com"); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
@@ -0,0 +1,229 @@
+package adapters
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The weight given to the latest latency measurement when calculating the
+// moving average
+const healthLatencyWeight = 0.3
+
+// The score penalty, in milliseconds, that is added for each recent failure
+const healthFailurePenalty = 1000.0
+
+// How long it takes for the penalty from a failure to halve. This allows
+// servers that have been demoted to recover over time
+const healthRecoveryHalfLife = 30 * time.Second
+
+// A server is demoted while its decayed count of recent failures is above
+// this, so a single failure demotes a server for one half life
+const healthDemoteFailures = 0.5
+
+// A server is demoted if its latency is this many times that of the fastest
+// server, and above healthOutlierMinLatency. Smaller differences don't
+// override the configured order since the servers may not be equivalent,
+// for example a private resolver that knows about internal zones
+const healthOutlierFactor = 10
+
+// The latency below which a server is never treated as an outlier
+const healthOutlierMinLatency = 500 * time.Millisecond
+
+// serverStats Health information about a single DNS server
+type serverStats struct {
+	Successes int
+	Failures  int
+	// A decaying count of recent failures, this is decayed based on the time
+	// since LastFailure when it is read
+	RecentFailures float64
+	LastFailure    time.Time
+	// Exponentially weighted moving average of response latency
+	Latency time.Duration
+}
+
+// serverHealth Tracks the latency and failures of DNS servers so that healthy
+// servers can be preferred. The zero value is ready to use
+type serverHealth struct {
+	mu    sync.Mutex
+	stats map[string]*serverStats
+
+	// Used to override the current time in tests
+	now func() time.Time
+}
+
+func (h *serverHealth) getNow() time.Time {
+	if h.now != nil {
+		return h.now()
+	}
+	return time.Now()
+}
+
+// get Returns the stats for a server, creating them if required. The caller
+// must hold the lock
+func (h *serverHealth) get(server string) *serverStats {
+	if h.stats == nil {
+		h.stats = make(map[string]*serverStats)
+	}
+
+	s, ok := h.stats[server]
+	if !ok {
+		s = &serverStats{}
+		h.stats[server] = s
+	}
+
+	return s
+}
+
+// RecordSuccess Records a successful response from a server
+func (h *serverHealth) RecordSuccess(server string, latency time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.get(server)
+	s.Successes++
+
+	if s.Latency == 0 {
+		s.Latency = latency
+	} else {
+		s.Latency = time.Duration(healthLatencyWeight*float64(latency) + (1-healthLatencyWeight)*float64(s.Latency))
+	}
+}
+
+// RecordFailure Records a failed request to a server
+func (h *serverHealth) RecordFailure(server string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.getNow()
+	s := h.get(server)
+
+	s.Failures++
+	s.RecentFailures = s.decayedFailures(now) + 1
+	s.LastFailure = now
+}
+
+// decayedFailures Returns the number of recent failures, decayed based on how
+// long ago the last failure was
+func (s *serverStats) decayedFailures(now time.Time) float64 {
+	if s.RecentFailures == 0 {
+		return 0
+	}
+
+	elapsed := now.Sub(s.LastFailure)
+
+	return s.RecentFailures * math.Pow(0.5, float64(elapsed)/float64(healthRecoveryHalfLife))
+}
+
+// score Returns the score of a server, lower is better. This is the average
+// latency in milliseconds plus a penalty for recent failures. Servers that
+// have never responded use `unmeasured` as their latency
+func (s *serverStats) score(now time.Time, unmeasured time.Duration) float64 {
+	latency := s.Latency
+	if latency == 0 {
+		latency = unmeasured
+	}
+
+	return float64(latency)/float64(time.Millisecond) + s.decayedFailures(now)*healthFailurePenalty
+}
+
+// scores Returns the score of each server. Servers that have never responded
+// are given the median latency of the others, so that a new server doesn't
+// jump ahead of a preferred one just because it hasn't been measured yet. The
+// caller must hold the lock
+func (h *serverHealth) scores(servers []string) map[string]float64 {
+	now := h.getNow()
+
+	latencies := make([]time.Duration, 0, len(servers))
+	for _, server := range servers {
+		if l := h.get(server).Latency; l > 0 {
+			latencies = append(latencies, l)
+		}
+	}
+
+	var median time.Duration
+	if n := len(latencies); n > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+		if n%2 == 1 {
+			median = latencies[n/2]
+		} else {
+			median = (latencies[n/2-1] + latencies[n/2]) / 2
+		}
+	}
+
+	scores := make(map[string]float64, len(servers))
+	for _, server := range servers {
+		scores[server] = h.get(server).score(now, median)
+	}
+
+	return scores
+}
+
+// Order Returns the servers in the order that they should be tried. Healthy
+// servers keep the order they were passed in, so that the configured
+// preference is respected. Servers that have failed recently, or are much
+// slower than the others, are demoted to the end and sorted by score
+func (h *serverHealth) Order(servers []string) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.getNow()
+	scores := h.scores(servers)
+
+	var fastest time.Duration
+	for _, server := range servers {
+		if l := h.get(server).Latency; l > 0 && (fastest == 0 || l < fastest) {
+			fastest = l
+		}
+	}
+
+	ordered := make([]string, 0, len(servers))
+	demoted := make([]string, 0)
+
+	for _, server := range servers {
+		s := h.get(server)
+
+		outlier := s.Latency > healthOutlierMinLatency && s.Latency > healthOutlierFactor*fastest
+		if s.decayedFailures(now) > healthDemoteFailures || outlier {
+			demoted = append(demoted, server)
+		} else {
+			ordered = append(ordered, server)
+		}
+	}
+
+	sort.SliceStable(demoted, func(i, j int) bool {
+		return scores[demoted[i]] < scores[demoted[j]]
+	})
+
+	return append(ordered, demoted...)
+}
+
+// serverSnapshot A copy of the stats of a server, along with its score
+type serverSnapshot struct {
+	Server string
+	serverStats
+	Score float64
+}
+
+// Snapshot Returns a copy of the current stats of the given servers
+func (h *serverHealth) Snapshot(servers []string) []serverSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	scores := h.scores(servers)
+	snapshots := make([]serverSnapshot, 0, len(servers))
+
+	for _, server := range servers {
+		s := h.get(server)
+
+		snapshots = append(snapshots, serverSnapshot{
+			Server:      server,
+			serverStats: *s,
+			Score:       scores[server],
+		})
+	}
+
+	return snapshots
+}
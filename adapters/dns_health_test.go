@@ -0,0 +1,171 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestServerHealth(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	h := serverHealth{
+		now: func() time.Time { return now },
+	}
+
+	servers := []string{"a", "b", "c"}
+
+	t.Run("unknown servers keep configured order", func(t *testing.T) {
+		ordered := h.Order(servers)
+
+		for i := range servers {
+			if ordered[i] != servers[i] {
+				t.Fatalf("expected %v, got %v", servers, ordered)
+			}
+		}
+	})
+
+	t.Run("failures demote a server", func(t *testing.T) {
+		h.RecordFailure("a")
+		h.RecordSuccess("b", 10*time.Millisecond)
+		h.RecordSuccess("c", 50*time.Millisecond)
+
+		ordered := h.Order(servers)
+
+		if ordered[0] != "b" || ordered[1] != "c" || ordered[2] != "a" {
+			t.Errorf("expected [b c a], got %v", ordered)
+		}
+	})
+
+	t.Run("demoted servers recover over time", func(t *testing.T) {
+		now = now.Add(10 * healthRecoveryHalfLife)
+
+		ordered := h.Order(servers)
+
+		if ordered[0] != "a" || ordered[1] != "b" || ordered[2] != "c" {
+			t.Errorf("expected a to have recovered to [a b c], got %v", ordered)
+		}
+	})
+
+	t.Run("snapshot", func(t *testing.T) {
+		snapshots := h.Snapshot(servers)
+
+		if snapshots[0].Failures != 1 {
+			t.Errorf("expected 1 failure for a, got %v", snapshots[0].Failures)
+		}
+
+		if snapshots[1].Successes != 1 || snapshots[1].Latency != 10*time.Millisecond {
+			t.Errorf("unexpected stats for b: %+v", snapshots[1])
+		}
+	})
+}
+
+func TestServerHealthUnmeasured(t *testing.T) {
+	t.Parallel()
+
+	var h serverHealth
+
+	servers := []string{"primary", "fallback"}
+
+	// Once the primary has been measured the fallback, which never has,
+	// shouldn't jump ahead of it
+	h.RecordSuccess("primary", 20*time.Millisecond)
+
+	if ordered := h.Order(servers); ordered[0] != "primary" {
+		t.Errorf("expected the primary to stay first, got %v", ordered)
+	}
+
+	// A faster server doesn't jump ahead of a preferred one, since it
+	// may not know about the same zones
+	h.RecordSuccess("slower", 200*time.Millisecond)
+	h.RecordSuccess("fast", 10*time.Millisecond)
+
+	ordered := h.Order([]string{"slower", "new", "fast"})
+	if ordered[0] != "slower" || ordered[1] != "new" || ordered[2] != "fast" {
+		t.Errorf("expected [slower new fast], got %v", ordered)
+	}
+
+	// Unless it is an extreme outlier
+	h.RecordSuccess("outlier", 2*time.Second)
+
+	ordered = h.Order([]string{"outlier", "new", "fast"})
+	if ordered[0] != "new" || ordered[1] != "fast" || ordered[2] != "outlier" {
+		t.Errorf("expected [new fast outlier], got %v", ordered)
+	}
+}
+
+func TestRetryDemotesFailedServer(t *testing.T) {
+	t.Parallel()
+
+	// Grab a port with nothing listening on it so that queries are refused
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := pc.LocalAddr().String()
+	pc.Close()
+
+	healthy := newTestDNSServer(t, "example.test. 300 IN A 192.0.2.1")
+
+	src := DNSAdapter{
+		Servers: []string{dead, healthy},
+	}
+
+	_, err = src.Get(context.Background(), "global", "example.test", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ordered := src.health.Order(src.GetServers()); ordered[0] != healthy {
+		t.Errorf("expected healthy server to be preferred, got %v", ordered)
+	}
+}
+
+func TestServerFailureIsNotPenalised(t *testing.T) {
+	t.Parallel()
+
+	var queries atomic.Int32
+	servfail := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		queries.Add(1)
+
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeServerFailure)
+		_ = w.WriteMsg(m)
+	})
+
+	src := DNSAdapter{
+		Servers: []string{
+			startTestDNSServer(t, servfail),
+			startTestDNSServer(t, servfail),
+		},
+	}
+
+	start := time.Now()
+
+	_, err := src.query(context.Background(), "broken.example.test", addressQueryTypes)
+	if !errors.Is(err, errDNSResolutionFailure) {
+		t.Fatalf("expected a resolution failure, got %v", err)
+	}
+
+	// Each server should be asked once, rather than retrying until the
+	// backoff gives up
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected SERVFAIL from every server to fail quickly, took %v", elapsed)
+	}
+
+	if n := queries.Load(); n != int32(2*len(addressQueryTypes)) {
+		t.Errorf("expected each server to be queried once, got %v queries", n)
+	}
+
+	for _, s := range src.health.Snapshot(src.GetServers()) {
+		if s.Failures != 0 {
+			t.Errorf("expected SERVFAIL not to count against %v, got %v failures", s.Server, s.Failures)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package adapters
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/overmindtech/sdp-go"
+	"github.com/overmindtech/sdpcache"
+)
+
+// dnsRecordTypes The record types that are returned as their own item type
+// e.g. `dns-mx`, rather than as `dns` items
+var dnsRecordTypes = []uint16{
+	dns.TypeMX,
+	dns.TypeNS,
+	dns.TypeTXT,
+	dns.TypeSOA,
+	dns.TypeSRV,
+	dns.TypeCAA,
+	dns.TypeHTTPS,
+	dns.TypeSVCB,
+	dns.TypeDNSKEY,
+	dns.TypeDS,
+	dns.TypePTR,
+}
+
+// dnsItemTypes Returns every item type that the DNS adapter can return
+func dnsItemTypes() []string {
+	types := []string{ItemType, ConsistencyItemType}
+	for _, rrtype := range dnsRecordTypes {
+		types = append(types, recordItemType(rrtype))
+	}
+
+	return types
+}
+
+// typeCacheKey Returns a copy of a cache key for a different item type. Each
+// item is cached under its own type since they all use `name` as their unique
+// attribute, and a GET that finds more than one item is treated as a miss
+func typeCacheKey(ck sdpcache.CacheKey, typ string) sdpcache.CacheKey {
+	ck.SST.Type = typ
+	return ck
+}
+
+// storeItems Caches items under the key for their own type
+func (d *DNSAdapter) storeItems(items []*sdp.Item, duration time.Duration, ck sdpcache.CacheKey) {
+	for _, item := range items {
+		d.cache.StoreItem(item, duration, typeCacheKey(ck, item.GetType()))
+	}
+}
+
+// lookupItems Looks up a query in the cache, returning the items of every type
+// that were stored for it by storeItems. Errors are only ever stored under the
+// `dns` type
+func (d *DNSAdapter) lookupItems(ctx context.Context, method sdp.QueryMethod, scope string, query string, ignoreCache bool) (bool, sdpcache.CacheKey, []*sdp.Item, *sdp.QueryError) {
+	d.ensureCache()
+	cacheHit, ck, cachedItems, qErr := d.cache.Lookup(ctx, d.Name(), method, scope, d.Type(), query, ignoreCache)
+	if qErr != nil || ignoreCache {
+		return cacheHit, ck, cachedItems, qErr
+	}
+
+	for _, typ := range dnsItemTypes() {
+		if typ == d.Type() {
+			continue
+		}
+
+		items, err := d.cache.Search(typeCacheKey(ck, typ))
+		if err != nil {
+			continue
+		}
+
+		cachedItems = append(cachedItems, items...)
+		cacheHit = true
+	}
+
+	return cacheHit, ck, cachedItems, nil
+}
+
+// DNSRecordAdapter Gets the records of a single type for a name, such as the
+// MX records of a domain. These share the cache and servers of the DNS
+// adapter, so items returned by a DNS search can be fetched without querying
+// again
+type DNSRecordAdapter struct {
+	DNS        *DNSAdapter
+	RecordType uint16
+}
+
+// NewDNSRecordAdapters Returns an adapter for each record type that the DNS
+// adapter returns as its own item type
+func NewDNSRecordAdapters(d *DNSAdapter) []*DNSRecordAdapter {
+	adapters := make([]*DNSRecordAdapter, 0, len(dnsRecordTypes))
+	for _, rrtype := range dnsRecordTypes {
+		adapters = append(adapters, &DNSRecordAdapter{
+			DNS:        d,
+			RecordType: rrtype,
+		})
+	}
+
+	return adapters
+}
+
+// Type is the type of items that this returns
+func (d *DNSRecordAdapter) Type() string {
+	return recordItemType(d.RecordType)
+}
+
+// Name Returns the name of the backend
+func (d *DNSRecordAdapter) Name() string {
+	return "stdlib-" + d.Type()
+}
+
+// Weighting of duplicate adapters
+func (d *DNSRecordAdapter) Weight() int {
+	return 100
+}
+
+func (d *DNSRecordAdapter) Metadata() *sdp.AdapterMetadata {
+	return dnsRecordMetadata[d.RecordType]
+}
+
+// dnsRecordLinks The types that each record type can link to, other than
+// `rdap-domain` which they all link to
+var dnsRecordLinks = map[uint16][]string{
+	dns.TypeMX:    {"dns"},
+	dns.TypeNS:    {"dns"},
+	dns.TypeSOA:   {"dns"},
+	dns.TypeSRV:   {"dns"},
+	dns.TypeHTTPS: {"dns", "ip"},
+	dns.TypeSVCB:  {"dns", "ip"},
+	dns.TypePTR:   {"dns", "ip"},
+}
+
+var dnsRecordMetadata = func() map[uint16]*sdp.AdapterMetadata {
+	metadata := make(map[uint16]*sdp.AdapterMetadata)
+
+	for _, rrtype := range dnsRecordTypes {
+		name := dns.TypeToString[rrtype]
+		getDescription := fmt.Sprintf("A DNS name to get the %v records of e.g. \"example.com\"", name)
+		links := append([]string{}, dnsRecordLinks[rrtype]...)
+
+		if rrtype == dns.TypePTR {
+			getDescription = "An IP address, or a reverse DNS name such as \"4.3.2.1.in-addr.arpa\", to get the PTR records of"
+		} else {
+			links = append(links, "rdap-domain")
+		}
+
+		metadata[rrtype] = Metadata.Register(&sdp.AdapterMetadata{
+			DescriptiveName: fmt.Sprintf("DNS %v Records", name),
+			Type:            recordItemType(rrtype),
+			SupportedQueryMethods: &sdp.AdapterSupportedQueryMethods{
+				Get:            true,
+				GetDescription: getDescription,
+			},
+			PotentialLinks: links,
+			Category:       sdp.AdapterCategory_ADAPTER_CATEGORY_NETWORK,
+		})
+	}
+
+	return metadata
+}()
+
+// List of scopes that this adapter is capable of find items for
+func (d *DNSRecordAdapter) Scopes() []string {
+	return []string{
+		"global",
+	}
+}
+
+// Get Returns the records of this type for a name
+func (d *DNSRecordAdapter) Get(ctx context.Context, scope string, query string, ignoreCache bool) (*sdp.Item, error) {
+	if scope != "global" {
+		return nil, &sdp.QueryError{
+			ErrorType:   sdp.QueryError_NOSCOPE,
+			ErrorString: "DNS queries only supported in global scope",
+			Scope:       scope,
+		}
+	}
+
+	if d.RecordType == dns.TypePTR {
+		// PTR records are stored under the reverse name, so accept an IP
+		// as well
+		if addr, err := netip.ParseAddr(query); err == nil {
+			arpa, err := dns.ReverseAddr(addr.String())
+			if err != nil {
+				return nil, err
+			}
+
+			query = arpa
+		}
+	}
+
+	// Normalise the name so that it matches the items returned by a search
+	query, qErr := normalizeDomainQuery(query, scope)
+	if qErr != nil {
+		return nil, qErr
+	}
+
+	d.DNS.ensureCache()
+	cacheHit, ck, cachedItems, qErr := d.DNS.cache.Lookup(ctx, d.DNS.Name(), sdp.QueryMethod_GET, scope, d.Type(), query, ignoreCache)
+	if qErr != nil {
+		return nil, qErr
+	}
+	if cacheHit && len(cachedItems) > 0 {
+		return cachedItems[0], nil
+	}
+
+	items, duration, err := d.DNS.resolve(ctx, ck, query, []uint16{d.RecordType}, !ignoreCache)
+	if err != nil {
+		return nil, err
+	}
+
+	// If the name is a CNAME the records will be for the target, so prefer
+	// an exact match but fall back to the records that the alias points to
+	var found *sdp.Item
+	for _, item := range items {
+		if item.GetType() != d.Type() {
+			continue
+		}
+
+		if found == nil || strings.EqualFold(item.UniqueAttributeValue(), query) {
+			found = item
+		}
+	}
+
+	if found == nil {
+		qErr := &sdp.QueryError{
+			ErrorType:   sdp.QueryError_NOTFOUND,
+			ErrorString: fmt.Sprintf("no %v records found for %v", dns.TypeToString[d.RecordType], query),
+			Scope:       scope,
+		}
+
+		d.DNS.cache.StoreError(qErr, duration, ck)
+		return nil, qErr
+	}
+
+	d.DNS.cache.StoreItem(found, duration, ck)
+
+	return found, nil
+}
+
+// List Returns nothing, records can only be fetched by name
+func (d *DNSRecordAdapter) List(ctx context.Context, scope string, ignoreCache bool) ([]*sdp.Item, error) {
+	if scope != "global" {
+		return nil, &sdp.QueryError{
+			ErrorType:   sdp.QueryError_NOSCOPE,
+			ErrorString: "DNS queries only supported in global scope",
+			Scope:       scope,
+		}
+	}
+
+	return make([]*sdp.Item, 0), nil
+}
@@ -0,0 +1,368 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/overmindtech/sdp-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// PTRItemType The type of item returned for each address in a reverse sweep
+const PTRItemType = "dns-ptr"
+
+// maxReverseSweepAddresses The largest range that can be swept, a /20 for
+// IPv4 or a /116 for IPv6. This stops a typo from querying millions of
+// addresses
+const maxReverseSweepAddresses = 4096
+
+// reverseSweepConcurrency How many PTR queries are run at the same time
+const reverseSweepConcurrency = 16
+
+// reverseSweepTimeout The longest that a sweep can take. Addresses that
+// haven't been queried by then are treated as failures
+const reverseSweepTimeout = 60 * time.Second
+
+// searchReverseRange Sweeps the PTR records of every address in a range and
+// caches the result
+func (d *DNSAdapter) searchReverseRange(ctx context.Context, scope string, prefix netip.Prefix, ignoreCache bool) ([]*sdp.Item, error) {
+	query := prefix.Masked().String()
+
+	cacheHit, ck, cachedItems, qErr := d.lookupItems(ctx, sdp.QueryMethod_SEARCH, scope, query, ignoreCache)
+	if qErr != nil {
+		return nil, qErr
+	}
+	if cacheHit {
+		return cachedItems, nil
+	}
+
+	items, ttl, err := d.SweepReverse(ctx, prefix)
+	if err != nil {
+		var qErr *sdp.QueryError
+		if !errors.As(err, &qErr) {
+			qErr = &sdp.QueryError{
+				ErrorType:   sdp.QueryError_OTHER,
+				ErrorString: err.Error(),
+				Scope:       scope,
+			}
+		}
+
+		// NOTFOUND is only returned when every address was queried, other
+		// errors mean that the result is incomplete so nothing is cached
+		if qErr.GetErrorType() == sdp.QueryError_NOTFOUND {
+			d.cache.StoreError(qErr, d.cacheDuration(ttl), ck)
+		}
+
+		return items, qErr
+	}
+
+	d.storeItems(items, d.cacheDuration(ttl), ck)
+
+	return items, nil
+}
+
+// SweepReverse Looks up the PTR records of every address in a range, such as
+// a /24 or a /120, and returns a `dns-ptr` item for each address that has
+// them. The names that the records point to are linked rather than resolved.
+// The returned duration is the lowest TTL of the answers. If every address
+// was queried and none have PTR records a NOTFOUND error is returned. If some
+// addresses couldn't be queried the items that were found are returned along
+// with an error saying which addresses failed
+func (d *DNSAdapter) SweepReverse(ctx context.Context, prefix netip.Prefix) ([]*sdp.Item, time.Duration, error) {
+	prefix = prefix.Masked()
+
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	if hostBits > 62 || 1<<hostBits > maxReverseSweepAddresses {
+		return nil, 0, fmt.Errorf("%v is too large to sweep, the maximum is %v addresses", prefix, maxReverseSweepAddresses)
+	}
+
+	type result struct {
+		addr netip.Addr
+		resp *dnsResponse
+		err  error
+	}
+
+	results := make([]result, 0, 1<<hostBits)
+	for addr := prefix.Addr(); addr.IsValid() && prefix.Contains(addr); addr = addr.Next() {
+		results = append(results, result{addr: addr})
+	}
+
+	sweepCtx, cancel := context.WithTimeout(ctx, reverseSweepTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, reverseSweepConcurrency)
+
+	for i := range results {
+		select {
+		case sem <- struct{}{}:
+		case <-sweepCtx.Done():
+			results[i].err = sweepCtx.Err()
+			continue
+		}
+
+		wg.Add(1)
+
+		go func(r *result) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			// Each address only gets a single attempt against the
+			// healthiest server. Retrying with backoff would make a sweep
+			// of a range with unresponsive servers take far too long, and
+			// an address that fails is reported rather than hidden
+			server := d.health.Order(d.GetServers())[0]
+
+			r.resp, r.err = d.attemptDNSQuery(sweepCtx, server, func(ctx context.Context, server string) (*dnsResponse, error) {
+				return d.queryPTR(ctx, r.addr, server)
+			})
+		}(&results[i])
+	}
+
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return nil, 0, ctx.Err()
+	}
+
+	items := make([]*sdp.Item, 0)
+	errs := make([]error, 0)
+	var ttl time.Duration
+	var failed int
+
+	for _, r := range results {
+		if r.resp != nil && (ttl == 0 || (r.resp.TTL > 0 && r.resp.TTL < ttl)) {
+			ttl = r.resp.TTL
+		}
+
+		var qErr *sdp.QueryError
+		if errors.As(r.err, &qErr) && qErr.GetErrorType() == sdp.QueryError_NOTFOUND {
+			continue
+		}
+
+		if r.err != nil {
+			failed++
+			errs = append(errs, fmt.Errorf("%v: %w", r.addr, r.err))
+			continue
+		}
+
+		item, err := PTRToItem(r.addr, r.resp.Answers)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		items = append(items, item)
+	}
+
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(
+		attribute.Int("ovm.dns.sweepAddresses", len(results)),
+		attribute.Int("ovm.dns.sweepFound", len(items)),
+		attribute.Int("ovm.dns.sweepFailed", failed),
+	)
+
+	if failed == len(results) {
+		// Every query failed so the result would be meaningless
+		return nil, 0, errors.Join(errs...)
+	}
+
+	if failed > 0 {
+		return items, ttl, fmt.Errorf("%v of %v addresses could not be queried:\n%w", failed, len(results), errors.Join(errs...))
+	}
+
+	if len(items) == 0 {
+		return nil, ttl, &sdp.QueryError{
+			ErrorType:   sdp.QueryError_NOTFOUND,
+			ErrorString: fmt.Sprintf("no PTR records found in %v", prefix),
+			Scope:       "global",
+		}
+	}
+
+	return items, ttl, nil
+}
+
+// queryPTR Looks up the PTR records for a single address
+func (d *DNSAdapter) queryPTR(ctx context.Context, addr netip.Addr, server string) (*dnsResponse, error) {
+	arpa, err := dns.ReverseAddr(addr.String())
+	if err != nil {
+		return nil, err
+	}
+
+	msg := dns.Msg{
+		Question: []dns.Question{
+			{
+				Name:   arpa,
+				Qclass: dns.ClassINET,
+				Qtype:  dns.TypePTR,
+			},
+		},
+		MsgHdr: dns.MsgHdr{
+			Opcode:           dns.OpcodeQuery,
+			RecursionDesired: true,
+		},
+	}
+
+	r, err := d.exchange(ctx, &msg, server)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := responseTTL([]*dns.Msg{r})
+
+	answers := make([]dns.RR, 0)
+	for _, rr := range r.Answer {
+		if _, ok := rr.(*dns.PTR); ok {
+			answers = append(answers, rr)
+		}
+	}
+
+	if len(answers) == 0 {
+		return &dnsResponse{TTL: ttl}, &sdp.QueryError{
+			ErrorType: sdp.QueryError_NOTFOUND,
+			Scope:     "global",
+		}
+	}
+
+	return &dnsResponse{
+		Answers: answers,
+		TTL:     ttl,
+	}, nil
+}
+
+// PTRToItem Converts the PTR records of an address to a `dns-ptr` item,
+// linking to the IP and to each name that it points to
+func PTRToItem(addr netip.Addr, records []dns.RR) (*sdp.Item, error) {
+	arpa, err := dns.ReverseAddr(addr.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return ptrRecordsToItem(trimDnsSuffix(arpa), addr, records)
+}
+
+// reverseNameToItem Converts the PTR records of a reverse name such as
+// `4.3.2.1.in-addr.arpa` to a `dns-ptr` item. The IP is only linked if the
+// name is a complete reverse name for an address
+func reverseNameToItem(name string, records []dns.RR) (*sdp.Item, error) {
+	addr, _ := arpaToAddr(name)
+
+	return ptrRecordsToItem(name, addr, records)
+}
+
+// arpaToAddr Returns the address that a reverse name such as
+// `4.3.2.1.in-addr.arpa` refers to, the opposite of dns.ReverseAddr
+func arpaToAddr(name string) (netip.Addr, bool) {
+	name = strings.ToLower(trimDnsSuffix(name))
+
+	if v4, ok := strings.CutSuffix(name, ".in-addr.arpa"); ok {
+		labels := strings.Split(v4, ".")
+		if len(labels) != 4 {
+			return netip.Addr{}, false
+		}
+
+		slices.Reverse(labels)
+		addr, err := netip.ParseAddr(strings.Join(labels, "."))
+
+		return addr, err == nil && addr.Is4()
+	}
+
+	if v6, ok := strings.CutSuffix(name, ".ip6.arpa"); ok {
+		labels := strings.Split(v6, ".")
+		if len(labels) != 32 {
+			return netip.Addr{}, false
+		}
+
+		var b strings.Builder
+		for i := len(labels) - 1; i >= 0; i-- {
+			if len(labels[i]) != 1 {
+				return netip.Addr{}, false
+			}
+
+			b.WriteString(labels[i])
+			if i > 0 && i%4 == 0 {
+				b.WriteByte(':')
+			}
+		}
+
+		addr, err := netip.ParseAddr(b.String())
+
+		return addr, err == nil && addr.Is6()
+	}
+
+	return netip.Addr{}, false
+}
+
+// ptrRecordsToItem Creates a `dns-ptr` item for a reverse name, linking to the
+// address if it is valid and to each name that the records point to
+func ptrRecordsToItem(name string, addr netip.Addr, records []dns.RR) (*sdp.Item, error) {
+	recordAttrs := make([]map[string]interface{}, 0)
+	liq := make([]*sdp.LinkedItemQuery, 0)
+
+	if addr.IsValid() {
+		liq = append(liq, &sdp.LinkedItemQuery{
+			Query: &sdp.Query{
+				Type:   "ip",
+				Method: sdp.QueryMethod_GET,
+				Query:  addr.String(),
+				Scope:  "global",
+			},
+			BlastPropagation: &sdp.BlastPropagation{
+				// Whoever controls the IP controls its PTR records
+				In:  true,
+				Out: false,
+			},
+		})
+	}
+
+	for _, r := range records {
+		if ptr, ok := r.(*dns.PTR); ok {
+			target := trimDnsSuffix(ptr.Ptr)
+
+			recordAttrs = append(recordAttrs, map[string]interface{}{
+				"ttl": ptr.Hdr.Ttl,
+				"ptr": target,
+			})
+
+			if target != "" {
+				liq = append(liq, dnsTargetQuery(target))
+			}
+		}
+	}
+
+	// Sort records to ensure they are consistent
+	sort.Slice(recordAttrs, func(i, j int) bool {
+		return fmt.Sprint(recordAttrs[i]) < fmt.Sprint(recordAttrs[j])
+	})
+
+	attrMap := map[string]interface{}{
+		"name":    name,
+		"type":    "PTR",
+		"records": recordAttrs,
+	}
+
+	if addr.IsValid() {
+		attrMap["ip"] = addr.String()
+	}
+
+	attrs, err := sdp.ToAttributes(attrMap)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sdp.Item{
+		Type:              PTRItemType,
+		UniqueAttribute:   UniqueAttribute,
+		Scope:             "global",
+		Attributes:        attrs,
+		LinkedItemQueries: dedupeLinkedItemQueries(liq),
+	}, nil
+}
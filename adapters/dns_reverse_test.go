@@ -0,0 +1,214 @@
+package adapters
+
+import (
+	"context"
+	"net/netip"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/overmindtech/discovery"
+	"github.com/overmindtech/sdp-go"
+)
+
+func TestSweepReverse(t *testing.T) {
+	t.Parallel()
+
+	answer := testZone(t,
+		"1.2.0.192.in-addr.arpa. 300 IN PTR host1.example.com.",
+		"10.2.0.192.in-addr.arpa. 60 IN PTR host10.example.com.",
+		"10.2.0.192.in-addr.arpa. 60 IN PTR alias10.example.com.",
+		"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa. 300 IN PTR v6.example.com.",
+	)
+
+	var queries atomic.Int32
+	server := startTestDNSServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		queries.Add(1)
+		_ = w.WriteMsg(answer(r))
+	}))
+
+	src := DNSAdapter{
+		Servers:       []string{server},
+		ReverseLookup: true,
+	}
+
+	t.Run("IPv4", func(t *testing.T) {
+		items, err := src.Search(context.Background(), "global", "192.0.2.0/24", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		discovery.TestValidateItems(t, items)
+
+		names := make([]string, 0)
+		for _, item := range items {
+			if item.GetType() != PTRItemType {
+				t.Errorf("expected a %v item, got %v", PTRItemType, item.GetType())
+			}
+
+			names = append(names, item.UniqueAttributeValue())
+		}
+		sort.Strings(names)
+
+		expected := []string{"1.2.0.192.in-addr.arpa", "10.2.0.192.in-addr.arpa"}
+		if len(names) != 2 || names[0] != expected[0] || names[1] != expected[1] {
+			t.Errorf("expected %v, got %v", expected, names)
+		}
+
+		// Only PTR queries are made, the names aren't resolved
+		if n := queries.Load(); n != 256 {
+			t.Errorf("expected 256 queries, got %v", n)
+		}
+
+		for _, item := range items {
+			if ip, _ := item.GetAttributes().Get("ip"); ip == "192.0.2.10" {
+				assertLinks(t, item, []string{
+					"ip GET 192.0.2.10",
+					"dns SEARCH host10.example.com",
+					"dns SEARCH alias10.example.com",
+				})
+			}
+		}
+	})
+
+	t.Run("IPv6", func(t *testing.T) {
+		items, err := src.Search(context.Background(), "global", "2001:db8::/120", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(items) != 1 {
+			t.Fatalf("expected 1 item, got %v", len(items))
+		}
+
+		assertLinks(t, items[0], []string{
+			"ip GET 2001:db8::1",
+			"dns SEARCH v6.example.com",
+		})
+	})
+
+	t.Run("nothing found", func(t *testing.T) {
+		_, err := src.Search(context.Background(), "global", "198.51.100.0/28", false)
+
+		if qErr, ok := err.(*sdp.QueryError); !ok || qErr.GetErrorType() != sdp.QueryError_NOTFOUND {
+			t.Errorf("expected NOTFOUND, got %v", err)
+		}
+	})
+
+	t.Run("partial failure", func(t *testing.T) {
+		var failures atomic.Int32
+		failing := startTestDNSServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			if r.Question[0].Name == "5.2.0.192.in-addr.arpa." {
+				failures.Add(1)
+
+				m := new(dns.Msg)
+				m.SetRcode(r, dns.RcodeServerFailure)
+				_ = w.WriteMsg(m)
+				return
+			}
+
+			_ = w.WriteMsg(answer(r))
+		}))
+
+		src := DNSAdapter{
+			Servers:       []string{failing},
+			ReverseLookup: true,
+		}
+
+		for i := 0; i < 2; i++ {
+			items, err := src.Search(context.Background(), "global", "192.0.2.0/29", false)
+
+			if qErr, ok := err.(*sdp.QueryError); !ok || qErr.GetErrorType() != sdp.QueryError_OTHER {
+				t.Errorf("expected an OTHER error, got %v", err)
+			}
+
+			if len(items) != 1 || items[0].UniqueAttributeValue() != "1.2.0.192.in-addr.arpa" {
+				t.Errorf("expected the item for 192.0.2.1, got %v", items)
+			}
+		}
+
+		// Each sweep makes a single attempt, and the incomplete result isn't
+		// cached
+		if n := failures.Load(); n != 2 {
+			t.Errorf("expected 2 queries for the failing address, got %v", n)
+		}
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		_, _, err := src.SweepReverse(context.Background(), netip.MustParsePrefix("10.0.0.0/8"))
+		if err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		items, err := (&DNSAdapter{Servers: []string{server}}).Search(context.Background(), "global", "192.0.2.0/24", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(items) != 0 {
+			t.Errorf("expected no items, got %v", len(items))
+		}
+	})
+}
+
+func TestMakeReverseQuery(t *testing.T) {
+	t.Parallel()
+
+	answer := testZone(t,
+		"1.2.0.192.in-addr.arpa. 300 IN PTR host1.example.com.",
+		"1.2.0.192.in-addr.arpa. 300 IN PTR nowhere.example.com.",
+		"host1.example.com. 300 IN A 192.0.2.1",
+		"host1.example.com. 300 IN MX 10 mail.example.com.",
+		"host1.example.com. 300 IN TXT \"v=spf1 -all\"",
+	)
+
+	var mu sync.Mutex
+	qtypes := make(map[uint16]bool)
+
+	server := startTestDNSServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		mu.Lock()
+		qtypes[r.Question[0].Qtype] = true
+		mu.Unlock()
+
+		_ = w.WriteMsg(answer(r))
+	}))
+
+	src := DNSAdapter{
+		Servers:       []string{server},
+		ReverseLookup: true,
+	}
+
+	items, err := src.Search(context.Background(), "global", "192.0.2.1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 1 || items[0].GetType() != "dns" {
+		t.Fatalf("expected a single dns item, got %v", items)
+	}
+
+	assertLinks(t, items[0], []string{"ip GET 192.0.2.1"})
+
+	mu.Lock()
+	for qtype := range qtypes {
+		if qtype != dns.TypePTR && qtype != dns.TypeA && qtype != dns.TypeAAAA {
+			t.Errorf("expected only PTR, A and AAAA queries, got %v", dns.TypeToString[qtype])
+		}
+	}
+	mu.Unlock()
+
+	t.Run("no PTR records", func(t *testing.T) {
+		items, err := src.Search(context.Background(), "global", "192.0.2.2", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(items) != 0 {
+			t.Errorf("expected no items, got %v", items)
+		}
+	})
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"errors"
 	"net"
+	"reflect"
 	"testing"
 
+	"github.com/miekg/dns"
 	"github.com/overmindtech/discovery"
 	"github.com/overmindtech/sdp-go"
 )
@@ -166,3 +168,366 @@ func TestDnsGet(t *testing.T) {
 		t.Log(item)
 	})
 }
+
+// newTestDNSServer Starts an in-process DNS server that answers from the
+// supplied zone data and returns its address. Records should be in zone file
+// format e.g. "example.com. 300 IN A 192.0.2.1"
+func newTestDNSServer(t *testing.T, records ...string) string {
+	t.Helper()
+
+	answer := testZone(t, records...)
+
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		_ = w.WriteMsg(answer(r))
+	})
+
+	return startTestDNSServer(t, handler)
+}
+
+// testZone Parses the supplied records and returns a function that answers
+// queries from them
+func testZone(t *testing.T, records ...string) func(*dns.Msg) *dns.Msg {
+	t.Helper()
+
+	zone := make(map[dns.Question][]dns.RR)
+
+	for _, record := range records {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		q := dns.Question{
+			Name:   dns.CanonicalName(rr.Header().Name),
+			Qtype:  rr.Header().Rrtype,
+			Qclass: dns.ClassINET,
+		}
+		zone[q] = append(zone[q], rr)
+	}
+
+	return func(r *dns.Msg) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetReply(r)
+
+		q := r.Question[0]
+		q.Name = dns.CanonicalName(q.Name)
+		m.Answer = zone[q]
+
+		return m
+	}
+}
+
+// startTestDNSServer Runs the handler on a random local UDP port and returns
+// the address in "ip:port" format
+func startTestDNSServer(t *testing.T, handler dns.Handler) string {
+	t.Helper()
+
+	return startTestDNSServerOn(t, "127.0.0.1:0", handler)
+}
+
+// startTestDNSServerOn Runs the handler on a specific local UDP address
+func startTestDNSServerOn(t *testing.T, addr string, handler dns.Handler) string {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	server := &dns.Server{
+		PacketConn:        pc,
+		Handler:           handler,
+		NotifyStartedFunc: func() { close(started) },
+	}
+
+	go func() {
+		_ = server.ActivateAndServe()
+	}()
+
+	t.Cleanup(func() {
+		_ = server.Shutdown()
+	})
+
+	<-started
+
+	return pc.LocalAddr().String()
+}
+
+func TestSearchRecordTypes(t *testing.T) {
+	t.Parallel()
+
+	server := newTestDNSServer(t,
+		"example.test. 300 IN A 192.0.2.1",
+		"example.test. 300 IN MX 10 mx1.example.test.",
+		"example.test. 300 IN MX 20 mx2.example.test.",
+		"example.test. 3600 IN NS ns1.example.test.",
+		`example.test. 300 IN TXT "v=spf1 " "-all"`,
+		"example.test. 3600 IN SOA ns1.example.test. hostmaster.example.test. 2024010101 7200 3600 1209600 300",
+		"example.test. 300 IN CAA 0 issue \"letsencrypt.org\"",
+		"_sip._tcp.example.test. 300 IN SRV 10 60 5060 sip.example.test.",
+		`cdn.example.test. 300 IN HTTPS 1 . alpn="h3,h2" ipv4hint="192.0.2.10,192.0.2.11" ipv6hint="2001:db8::10" ech="AEX+DQBBpQAgACDX/+O4wB7PNG5b+VuPNOXiuqb7sHX81RW9tG7gLiuXIQAEAAEAAQASY2xvdWRmbGFyZS1lY2guY29tAAA="`,
+		"cdn.example.test. 300 IN HTTPS 2 edge.cdn.example.net. port=8443 alpn=h2",
+		"_8443._https.api.example.test. 300 IN HTTPS 0 api.cdn.example.net.",
+		`_dns.resolver.example.test. 300 IN SVCB 1 dns.example.net. alpn=dot,doq key65500="custom"`,
+	)
+
+	s := DNSAdapter{
+		Servers: []string{server},
+	}
+
+	items, err := s.Search(context.Background(), "global", "example.test", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	discovery.TestValidateItems(t, items)
+
+	byType := make(map[string]*sdp.Item)
+	for _, item := range items {
+		byType[item.GetType()] = item
+	}
+
+	for _, typ := range []string{"dns", "dns-mx", "dns-ns", "dns-txt", "dns-soa", "dns-caa"} {
+		if _, ok := byType[typ]; !ok {
+			t.Errorf("expected a %v item, got none", typ)
+		}
+	}
+
+	t.Run("MX links to exchanges", func(t *testing.T) {
+		var found int
+		for _, q := range byType["dns-mx"].GetLinkedItemQueries() {
+			if q.GetQuery().GetType() == "dns" && (q.GetQuery().GetQuery() == "mx1.example.test" || q.GetQuery().GetQuery() == "mx2.example.test") {
+				found++
+			}
+		}
+
+		if found != 2 {
+			t.Errorf("expected 2 links to mail exchanges, got %v", found)
+		}
+	})
+
+	t.Run("TXT strings are joined", func(t *testing.T) {
+		records, err := byType["dns-txt"].GetAttributes().Get("records")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		value := records.([]interface{})[0].(map[string]interface{})["value"]
+		if value != "v=spf1 -all" {
+			t.Errorf("expected joined TXT value, got %v", value)
+		}
+	})
+
+	t.Run("SOA links to primary", func(t *testing.T) {
+		q := byType["dns-soa"].GetLinkedItemQueries()[0].GetQuery()
+		if q.GetQuery() != "ns1.example.test" {
+			t.Errorf("expected link to ns1.example.test, got %v", q.GetQuery())
+		}
+	})
+
+	t.Run("SRV", func(t *testing.T) {
+		items, err := s.Search(context.Background(), "global", "_sip._tcp.example.test", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(items) != 1 || items[0].GetType() != "dns-srv" {
+			t.Fatalf("expected a single dns-srv item, got %v", items)
+		}
+
+		if q := items[0].GetLinkedItemQueries()[0].GetQuery(); q.GetQuery() != "sip.example.test" {
+			t.Errorf("expected link to sip.example.test, got %v", q.GetQuery())
+		}
+	})
+	t.Run("HTTPS", func(t *testing.T) {
+		items, err := s.Search(context.Background(), "global", "cdn.example.test", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(items) != 1 || items[0].GetType() != "dns-https" {
+			t.Fatalf("expected a single dns-https item, got %v", items)
+		}
+
+		records, err := items[0].GetAttributes().Get("records")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		byPriority := make(map[float64]map[string]interface{})
+		for _, r := range records.([]interface{}) {
+			record := r.(map[string]interface{})
+			byPriority[record["priority"].(float64)] = record
+		}
+
+		service := byPriority[1]
+		if service["mode"] != "service" || service["target"] != "" {
+			t.Errorf("unexpected mode or target %v", service)
+		}
+
+		if !reflect.DeepEqual(service["alpn"], []interface{}{"h3", "h2"}) {
+			t.Errorf("unexpected alpn %v", service["alpn"])
+		}
+
+		if !reflect.DeepEqual(service["ipv4hint"], []interface{}{"192.0.2.10", "192.0.2.11"}) {
+			t.Errorf("unexpected ipv4hint %v", service["ipv4hint"])
+		}
+
+		if _, ok := service["ech"]; !ok {
+			t.Error("expected ech config")
+		}
+
+		if alt := byPriority[2]; alt["port"] != float64(8443) {
+			t.Errorf("expected port 8443, got %v", alt["port"])
+		}
+
+		assertLinks(t, items[0], []string{
+			"ip GET 192.0.2.10",
+			"ip GET 192.0.2.11",
+			"ip GET 2001:db8::10",
+			"dns SEARCH edge.cdn.example.net",
+			"http GET https://cdn.example.test",
+		})
+	})
+
+	t.Run("HTTPS on another port", func(t *testing.T) {
+		items, err := s.Search(context.Background(), "global", "_8443._https.api.example.test", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(items) != 1 {
+			t.Fatalf("expected a single item, got %v", items)
+		}
+
+		if mode, _ := items[0].GetAttributes().Get("records"); mode.([]interface{})[0].(map[string]interface{})["mode"] != "alias" {
+			t.Errorf("expected alias mode, got %v", mode)
+		}
+
+		assertLinks(t, items[0], []string{
+			"dns SEARCH api.cdn.example.net",
+			"http GET https://api.example.test:8443",
+		})
+	})
+
+	t.Run("SVCB", func(t *testing.T) {
+		items, err := s.Search(context.Background(), "global", "_dns.resolver.example.test", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(items) != 1 || items[0].GetType() != "dns-svcb" {
+			t.Fatalf("expected a single dns-svcb item, got %v", items)
+		}
+
+		params, err := items[0].GetAttributes().Get("records")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		record := params.([]interface{})[0].(map[string]interface{})
+		if !reflect.DeepEqual(record["params"], map[string]interface{}{"key65500": "custom"}) {
+			t.Errorf("expected unknown keys to be kept, got %v", record["params"])
+		}
+
+		for _, liq := range items[0].GetLinkedItemQueries() {
+			if liq.GetQuery().GetType() == "http" {
+				t.Errorf("unexpected http link %v", liq.GetQuery().GetQuery())
+			}
+		}
+	})
+}
+
+func TestSearchPartialFailure(t *testing.T) {
+	t.Parallel()
+
+	answer := testZone(t,
+		"example.test. 300 IN A 192.0.2.1",
+		"example.test. 300 IN MX 10 mx.example.test.",
+		`example.test. 300 IN TXT "v=spf1 -all"`,
+	)
+
+	server := startTestDNSServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := answer(r)
+
+		// Simulate a server that can't answer some types
+		switch r.Question[0].Qtype {
+		case dns.TypeMX, dns.TypeHTTPS:
+			m.Answer = nil
+			m.Rcode = dns.RcodeRefused
+		}
+
+		_ = w.WriteMsg(m)
+	}))
+
+	s := DNSAdapter{
+		Servers: []string{server},
+	}
+
+	resp, err := s.query(context.Background(), "example.test", searchQueryTypes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	types := make(map[string]bool)
+	for _, item := range resp.Items {
+		types[item.GetType()] = true
+	}
+
+	if !types["dns"] || !types["dns-txt"] {
+		t.Errorf("expected the types that succeeded to be returned, got %v", types)
+	}
+
+	if types["dns-mx"] {
+		t.Error("expected no dns-mx item")
+	}
+
+	if !reflect.DeepEqual(resp.FailedTypes, []string{"MX", "HTTPS"}) {
+		t.Errorf("expected MX and HTTPS to have failed, got %v", resp.FailedTypes)
+	}
+
+	t.Run("every type failing is an error", func(t *testing.T) {
+		responses, failures, err := s.exchangeTypes(context.Background(), "example.test", server, []uint16{dns.TypeMX, dns.TypeHTTPS})
+		if !errors.Is(err, errDNSServerFailure) {
+			t.Errorf("expected a server failure, got %v", err)
+		}
+
+		if len(responses) != 0 || len(failures) != 2 {
+			t.Errorf("expected 2 failures and no responses, got %v and %v", len(failures), len(responses))
+		}
+	})
+}
+
+func TestSearchHTTPSUnsupported(t *testing.T) {
+	t.Parallel()
+
+	answer := testZone(t,
+		"example.test. 300 IN A 192.0.2.1",
+		"example.test. 300 IN MX 10 mx.example.test.",
+	)
+
+	// Some older resolvers and middleboxes drop queries for types they
+	// don't understand rather than answering them
+	server := startTestDNSServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		switch r.Question[0].Qtype {
+		case dns.TypeHTTPS, dns.TypeSVCB:
+			return
+		}
+
+		_ = w.WriteMsg(answer(r))
+	}))
+
+	s := DNSAdapter{
+		Servers: []string{server},
+	}
+
+	items, err := s.Search(context.Background(), "global", "example.test", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 2 {
+		t.Errorf("expected the A and MX records to be returned, got %v items", len(items))
+	}
+}
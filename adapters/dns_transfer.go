@@ -0,0 +1,407 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/overmindtech/sdp-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// transferQueryPrefix Search queries with this prefix enumerate a zone using a
+// zone transfer from the configured primary, e.g. "axfr:corp.example.com"
+const transferQueryPrefix = "axfr:"
+
+// dnsTransferTimeout How long to wait for each message of a zone transfer
+const dnsTransferTimeout = 10 * time.Second
+
+// ZoneTransferConfig Configures zone transfers from a primary server. These
+// are usually only allowed for internal zones
+type ZoneTransferConfig struct {
+	// The primary server to transfer zones from e.g. "10.0.0.2:53"
+	Primary string
+
+	// The zones that are returned by List. Any zone can be transferred using
+	// Search
+	Zones []string
+
+	// The name, algorithm and base64 encoded secret of the TSIG key used to
+	// authenticate transfers. If the name is empty transfers are not signed.
+	// The algorithm defaults to hmac-sha256
+	TSIGName      string
+	TSIGAlgorithm string
+	TSIGSecret    string
+}
+
+// tsigAlgorithm Returns the TSIG algorithm in the format expected by miekg/dns
+func (c *ZoneTransferConfig) tsigAlgorithm() string {
+	if c.TSIGAlgorithm == "" {
+		return dns.HmacSHA256
+	}
+
+	return dns.Fqdn(strings.ToLower(c.TSIGAlgorithm))
+}
+
+// transferredZone The records from the last transfer of a zone, used to
+// request an incremental transfer (IXFR) next time
+type transferredZone struct {
+	Serial  uint32
+	Records []dns.RR
+}
+
+// zoneStore Stores the last transfer of each zone
+type zoneStore struct {
+	mu    sync.Mutex
+	zones map[string]transferredZone
+}
+
+func (z *zoneStore) Get(zone string) (transferredZone, bool) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	t, ok := z.zones[zone]
+	return t, ok
+}
+
+func (z *zoneStore) Store(zone string, t transferredZone) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	if z.zones == nil {
+		z.zones = make(map[string]transferredZone)
+	}
+
+	z.zones[zone] = t
+}
+
+// listZones Transfers every configured zone and returns their records as
+// items
+func (d *DNSAdapter) listZones(ctx context.Context, scope string, ignoreCache bool) ([]*sdp.Item, error) {
+	cacheHit, ck, cachedItems, qErr := d.lookupItems(ctx, sdp.QueryMethod_LIST, scope, "", ignoreCache)
+	if qErr != nil {
+		return nil, qErr
+	}
+	if cacheHit {
+		return cachedItems, nil
+	}
+
+	records := make([]dns.RR, 0)
+	var refresh time.Duration
+
+	for _, zone := range d.ZoneTransfer.Zones {
+		rrs, err := d.TransferZone(ctx, zone)
+		if err != nil {
+			qErr := &sdp.QueryError{
+				ErrorType:   sdp.QueryError_OTHER,
+				ErrorString: err.Error(),
+				Scope:       scope,
+			}
+
+			d.cache.StoreError(qErr, d.cacheDuration(0), ck)
+			return nil, qErr
+		}
+
+		records = append(records, rrs...)
+		refresh = minRefresh(refresh, rrs)
+	}
+
+	items, err := AnswersToItems(records)
+	if err != nil {
+		return nil, err
+	}
+
+	d.storeItems(items, d.cacheDuration(refresh), ck)
+
+	return items, nil
+}
+
+// searchZone Transfers a single zone and returns its records as items
+func (d *DNSAdapter) searchZone(ctx context.Context, scope string, zone string, ignoreCache bool) ([]*sdp.Item, error) {
+	if d.ZoneTransfer == nil || d.ZoneTransfer.Primary == "" {
+		return nil, &sdp.QueryError{
+			ErrorType:   sdp.QueryError_OTHER,
+			ErrorString: "zone transfers are not configured",
+			Scope:       scope,
+		}
+	}
+
+	cacheHit, ck, cachedItems, qErr := d.lookupItems(ctx, sdp.QueryMethod_SEARCH, scope, transferQueryPrefix+zone, ignoreCache)
+	if qErr != nil {
+		return nil, qErr
+	}
+	if cacheHit {
+		return cachedItems, nil
+	}
+
+	records, err := d.TransferZone(ctx, zone)
+	if err != nil {
+		qErr := &sdp.QueryError{
+			ErrorType:   sdp.QueryError_OTHER,
+			ErrorString: err.Error(),
+			Scope:       scope,
+		}
+
+		d.cache.StoreError(qErr, d.cacheDuration(0), ck)
+		return nil, qErr
+	}
+
+	items, err := AnswersToItems(records)
+	if err != nil {
+		return nil, err
+	}
+
+	// Secondaries check for changes every refresh interval, so this is how
+	// stale the zone is expected to be anyway
+	d.storeItems(items, d.cacheDuration(minRefresh(0, records)), ck)
+
+	return items, nil
+}
+
+// minRefresh Returns the lower of the current refresh interval and the refresh
+// interval of the SOA in the records. A current value of zero is ignored
+func minRefresh(current time.Duration, records []dns.RR) time.Duration {
+	for _, rr := range records {
+		if soa, ok := rr.(*dns.SOA); ok {
+			refresh := time.Duration(soa.Refresh) * time.Second
+			if current == 0 || refresh < current {
+				current = refresh
+			}
+		}
+	}
+
+	return current
+}
+
+// TransferZone Returns every record in a zone using a zone transfer from the
+// configured primary. If the zone has been transferred before an incremental
+// transfer (IXFR) is requested, falling back to a full transfer (AXFR) if the
+// primary doesn't support it
+func (d *DNSAdapter) TransferZone(ctx context.Context, zone string) ([]dns.RR, error) {
+	if d.ZoneTransfer == nil || d.ZoneTransfer.Primary == "" {
+		return nil, errors.New("zone transfers are not configured")
+	}
+
+	zone = dns.Fqdn(strings.ToLower(zone))
+	span := trace.SpanFromContext(ctx)
+
+	if previous, ok := d.zones.Get(zone); ok {
+		records, err := d.ixfr(ctx, zone, previous)
+		if err == nil {
+			span.SetAttributes(attribute.String("ovm.dns.transfer", "ixfr"))
+			return records, nil
+		}
+
+		span.SetAttributes(attribute.String("ovm.dns.ixfrError", err.Error()))
+	}
+
+	span.SetAttributes(attribute.String("ovm.dns.transfer", "axfr"))
+
+	msg := new(dns.Msg)
+	msg.SetAxfr(zone)
+
+	rrs, err := d.transfer(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	records, serial, err := axfrRecords(rrs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transfer of %v: %w", zone, err)
+	}
+
+	d.zones.Store(zone, transferredZone{
+		Serial:  serial,
+		Records: records,
+	})
+
+	return records, nil
+}
+
+// ixfr Requests the changes to a zone since the previous transfer and applies
+// them
+func (d *DNSAdapter) ixfr(ctx context.Context, zone string, previous transferredZone) ([]dns.RR, error) {
+	msg := new(dns.Msg)
+	msg.SetIxfr(zone, previous.Serial, ".", ".")
+
+	rrs, err := d.transfer(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	records, serial, err := applyIXFR(previous, rrs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid incremental transfer of %v: %w", zone, err)
+	}
+
+	d.zones.Store(zone, transferredZone{
+		Serial:  serial,
+		Records: records,
+	})
+
+	return records, nil
+}
+
+// transfer Sends a transfer request to the primary and returns every record
+// in the response
+func (d *DNSAdapter) transfer(ctx context.Context, msg *dns.Msg) ([]dns.RR, error) {
+	config := d.ZoneTransfer
+
+	conn, err := (&net.Dialer{Timeout: dnsTransferTimeout}).DialContext(ctx, "tcp", config.Primary)
+	if err != nil {
+		return nil, err
+	}
+
+	// The transfer can't be cancelled directly, so close the connection
+	// instead. The transfer closes the connection once it's done
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	t := &dns.Transfer{
+		Conn:        &dns.Conn{Conn: conn},
+		ReadTimeout: dnsTransferTimeout,
+	}
+
+	if config.TSIGName != "" {
+		name := dns.Fqdn(strings.ToLower(config.TSIGName))
+
+		t.TsigSecret = map[string]string{name: config.TSIGSecret}
+		msg.SetTsig(name, config.tsigAlgorithm(), 300, time.Now().Unix())
+	}
+
+	envelopes, err := t.In(msg, config.Primary)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	rrs := make([]dns.RR, 0)
+	for env := range envelopes {
+		if env.Error != nil {
+			err = env.Error
+			continue
+		}
+
+		rrs = append(rrs, env.RR...)
+	}
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return rrs, nil
+}
+
+// axfrRecords Returns the records of a full zone transfer, which starts and
+// ends with the SOA, along with the serial of the zone
+func axfrRecords(rrs []dns.RR) ([]dns.RR, uint32, error) {
+	if len(rrs) < 2 {
+		return nil, 0, errors.New("transfer is too short")
+	}
+
+	soa, ok := rrs[0].(*dns.SOA)
+	if !ok {
+		return nil, 0, errors.New("transfer doesn't start with an SOA")
+	}
+
+	if last, ok := rrs[len(rrs)-1].(*dns.SOA); !ok || last.Serial != soa.Serial {
+		return nil, 0, errors.New("transfer doesn't end with the SOA")
+	}
+
+	return rrs[:len(rrs)-1], soa.Serial, nil
+}
+
+// applyIXFR Applies the response to an incremental transfer to the previous
+// records of the zone (RFC 1995). The response can also be a full transfer, or
+// a single SOA if the zone hasn't changed
+func applyIXFR(previous transferredZone, rrs []dns.RR) ([]dns.RR, uint32, error) {
+	if len(rrs) == 0 {
+		return nil, 0, errors.New("transfer is empty")
+	}
+
+	soa, ok := rrs[0].(*dns.SOA)
+	if !ok {
+		return nil, 0, errors.New("transfer doesn't start with an SOA")
+	}
+
+	if len(rrs) == 1 {
+		if soa.Serial != previous.Serial {
+			return nil, 0, fmt.Errorf("zone has changed from serial %v to %v but no changes were sent", previous.Serial, soa.Serial)
+		}
+
+		return previous.Records, previous.Serial, nil
+	}
+
+	if _, ok := rrs[1].(*dns.SOA); !ok {
+		// The server has sent the full zone instead
+		return axfrRecords(rrs)
+	}
+
+	// The zone is indexed by the record without its TTL so that changes to
+	// TTLs delete the old record
+	key := func(rr dns.RR) string {
+		c := dns.Copy(rr)
+		c.Header().Ttl = 0
+		return strings.ToLower(c.String())
+	}
+
+	records := make(map[string]dns.RR, len(previous.Records))
+	order := make([]string, 0, len(previous.Records))
+	for _, rr := range previous.Records {
+		k := key(rr)
+		records[k] = rr
+		order = append(order, k)
+	}
+
+	// Each change is the old SOA followed by the deleted records, then the
+	// new SOA followed by the added records. The response ends with the new
+	// SOA
+	adding := true
+	for _, rr := range rrs[1 : len(rrs)-1] {
+		if _, ok := rr.(*dns.SOA); ok {
+			adding = !adding
+			continue
+		}
+
+		k := key(rr)
+		if adding {
+			if _, ok := records[k]; !ok {
+				order = append(order, k)
+			}
+			records[k] = rr
+		} else {
+			delete(records, k)
+		}
+	}
+
+	if last, ok := rrs[len(rrs)-1].(*dns.SOA); !ok || last.Serial != soa.Serial {
+		return nil, 0, errors.New("transfer doesn't end with the SOA")
+	}
+
+	// The SOA of the zone is replaced with the new one
+	result := []dns.RR{soa}
+	for _, k := range order {
+		if rr, ok := records[k]; ok {
+			if _, isSOA := rr.(*dns.SOA); isSOA {
+				continue
+			}
+
+			result = append(result, rr)
+			delete(records, k)
+		}
+	}
+
+	return result, soa.Serial, nil
+}
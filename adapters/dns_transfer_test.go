@@ -0,0 +1,302 @@
+package adapters
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/overmindtech/discovery"
+	"github.com/overmindtech/sdp-go"
+)
+
+const testTSIGName = "transfer.example.com."
+const testTSIGSecret = "c2VjcmV0LXNlY3JldC1zZWNyZXQtc2VjcmV0LXNlY3JldA=="
+
+// testPrimary A primary server that serves a single zone over AXFR and IXFR
+type testPrimary struct {
+	t *testing.T
+
+	mu      sync.Mutex
+	serial  uint32
+	records map[uint32][]string    // Full zone at each serial, excluding the SOA
+	changes map[uint32][2][]string // Records deleted and added to reach each serial
+	qtypes  []uint16               // Transfer types that have been requested
+}
+
+func (p *testPrimary) soa(serial uint32) dns.RR {
+	rr := mustRR(p.t, "example.com. 300 IN SOA ns1.example.com. admin.example.com. 1 600 60 3600 60")
+	rr.(*dns.SOA).Serial = serial
+	return rr
+}
+
+func (p *testPrimary) rrs(records []string) []dns.RR {
+	rrs := make([]dns.RR, 0, len(records))
+	for _, r := range records {
+		rrs = append(rrs, mustRR(p.t, r))
+	}
+	return rrs
+}
+
+func (p *testPrimary) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if r.IsTsig() == nil || w.TsigStatus() != nil {
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeRefused)
+		_ = w.WriteMsg(m)
+		return
+	}
+
+	qtype := r.Question[0].Qtype
+	p.qtypes = append(p.qtypes, qtype)
+
+	current := p.soa(p.serial)
+	answer := []dns.RR{current}
+
+	switch qtype {
+	case dns.TypeAXFR:
+		answer = append(answer, p.rrs(p.records[p.serial])...)
+		answer = append(answer, current)
+	case dns.TypeIXFR:
+		from := r.Ns[0].(*dns.SOA).Serial
+		if from != p.serial {
+			for serial := from + 1; serial <= p.serial; serial++ {
+				change := p.changes[serial]
+				answer = append(answer, p.soa(serial-1))
+				answer = append(answer, p.rrs(change[0])...)
+				answer = append(answer, p.soa(serial))
+				answer = append(answer, p.rrs(change[1])...)
+			}
+			answer = append(answer, current)
+		}
+	}
+
+	ch := make(chan *dns.Envelope, 1)
+	ch <- &dns.Envelope{RR: answer}
+	close(ch)
+
+	_ = new(dns.Transfer).Out(w, r, ch)
+}
+
+// startTestTransferServer Runs the handler on a local TCP address, requiring
+// the test TSIG key
+func startTestTransferServer(t *testing.T, handler dns.Handler) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	server := &dns.Server{
+		Listener:          l,
+		Handler:           handler,
+		TsigSecret:        map[string]string{testTSIGName: testTSIGSecret},
+		NotifyStartedFunc: func() { close(started) },
+	}
+
+	go func() {
+		_ = server.ActivateAndServe()
+	}()
+
+	t.Cleanup(func() {
+		_ = server.Shutdown()
+	})
+
+	<-started
+
+	return l.Addr().String()
+}
+
+func TestZoneTransfer(t *testing.T) {
+	t.Parallel()
+
+	primary := &testPrimary{
+		t:      t,
+		serial: 1,
+		records: map[uint32][]string{
+			1: {
+				"example.com. 300 IN NS ns1.example.com.",
+				"example.com. 300 IN MX 10 mail.example.com.",
+				"example.com. 300 IN TXT \"v=spf1 -all\"",
+				"www.example.com. 300 IN A 192.0.2.1",
+				"www.example.com. 300 IN A 192.0.2.2",
+				"api.example.com. 300 IN CNAME www.example.com.",
+			},
+		},
+		changes: map[uint32][2][]string{
+			2: {
+				{"www.example.com. 300 IN A 192.0.2.2"},
+				{"www.example.com. 300 IN A 192.0.2.3", "db.example.com. 300 IN AAAA 2001:db8::1"},
+			},
+		},
+	}
+
+	addr := startTestTransferServer(t, primary)
+
+	newAdapter := func() *DNSAdapter {
+		return &DNSAdapter{
+			ZoneTransfer: &ZoneTransferConfig{
+				Primary:    addr,
+				Zones:      []string{"example.com"},
+				TSIGName:   testTSIGName,
+				TSIGSecret: testTSIGSecret,
+			},
+		}
+	}
+
+	t.Run("Search", func(t *testing.T) {
+		items, err := newAdapter().Search(context.Background(), "global", "axfr:example.com", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		found := make([]string, 0)
+		for _, item := range items {
+			discovery.TestValidateItem(t, item)
+			found = append(found, item.GetType()+" "+item.UniqueAttributeValue())
+		}
+		sort.Strings(found)
+
+		// The address records for www are grouped into a single item
+		expected := []string{
+			"dns api.example.com",
+			"dns www.example.com",
+			"dns-mx example.com",
+			"dns-ns example.com",
+			"dns-soa example.com",
+			"dns-txt example.com",
+		}
+
+		if !reflect.DeepEqual(found, expected) {
+			t.Errorf("expected %v, got %v", expected, found)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		items, err := newAdapter().List(context.Background(), "global", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(items) != 6 {
+			t.Errorf("expected 6 items, got %v", len(items))
+		}
+
+		items, err = (&DNSAdapter{}).List(context.Background(), "global", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(items) != 0 {
+			t.Errorf("expected no items without zone transfers, got %v", len(items))
+		}
+	})
+
+	t.Run("wrong TSIG key", func(t *testing.T) {
+		src := newAdapter()
+		src.ZoneTransfer.TSIGName = "other.example.com."
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		_, err := src.Search(ctx, "global", "axfr:example.com", false)
+		if err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("IXFR", func(t *testing.T) {
+		src := newAdapter()
+
+		if _, err := src.TransferZone(context.Background(), "example.com"); err != nil {
+			t.Fatal(err)
+		}
+
+		primary.mu.Lock()
+		primary.serial = 2
+		primary.qtypes = nil
+		primary.mu.Unlock()
+
+		records, err := src.TransferZone(context.Background(), "example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		primary.mu.Lock()
+		qtypes := primary.qtypes
+		primary.serial = 1
+		primary.mu.Unlock()
+
+		if !reflect.DeepEqual(qtypes, []uint16{dns.TypeIXFR}) {
+			t.Errorf("expected a single IXFR, got %v", qtypes)
+		}
+
+		found := make([]string, 0)
+		for _, rr := range records {
+			switch r := rr.(type) {
+			case *dns.SOA:
+				if r.Serial != 2 {
+					t.Errorf("expected serial 2, got %v", r.Serial)
+				}
+			case *dns.A:
+				found = append(found, r.A.String())
+			case *dns.AAAA:
+				found = append(found, r.AAAA.String())
+			}
+		}
+		sort.Strings(found)
+
+		if expected := []string{"192.0.2.1", "192.0.2.3", "2001:db8::1"}; !reflect.DeepEqual(found, expected) {
+			t.Errorf("expected %v, got %v", expected, found)
+		}
+	})
+
+	t.Run("not configured", func(t *testing.T) {
+		_, err := (&DNSAdapter{}).Search(context.Background(), "global", "axfr:example.com", false)
+		if err == nil {
+			t.Error("expected an error")
+		}
+
+		if qErr, ok := err.(*sdp.QueryError); !ok || qErr.GetErrorType() != sdp.QueryError_OTHER {
+			t.Errorf("expected an OTHER error, got %v", err)
+		}
+	})
+}
+
+func TestApplyIXFR(t *testing.T) {
+	t.Parallel()
+
+	soa := mustRR(t, "example.com. 300 IN SOA ns1.example.com. admin.example.com. 5 600 60 3600 60")
+	previous := transferredZone{
+		Serial: 5,
+		Records: []dns.RR{
+			soa,
+			mustRR(t, "www.example.com. 300 IN A 192.0.2.1"),
+		},
+	}
+
+	// A single SOA means that the zone hasn't changed
+	records, serial, err := applyIXFR(previous, []dns.RR{soa})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if serial != 5 || len(records) != 2 {
+		t.Errorf("expected the zone to be unchanged, got serial %v and %v", serial, records)
+	}
+
+	newer := dns.Copy(soa)
+	newer.(*dns.SOA).Serial = 6
+
+	if _, _, err := applyIXFR(previous, []dns.RR{newer}); err == nil {
+		t.Error("expected a changed serial without changes to fail")
+	}
+}
@@ -0,0 +1,302 @@
+package adapters
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// The media type for DNS wire format messages, as defined in RFC 8484
+const dnsMessageContentType = "application/dns-message"
+
+// The maximum size of a DNS message, used to limit how much of a DoH response
+// we are willing to read
+const maxDNSMessageSize = 65535
+
+// errDNSServerFailure is returned when a server responded, but not with a
+// valid DNS message or with REFUSED. Queries that fail with this error will
+// be retried against the next server
+var errDNSServerFailure = errors.New("dns server failure")
+
+// errDNSResolutionFailure is returned when a server responded with SERVFAIL.
+// This usually means that the name itself is broken, for example a lame
+// delegation or a DNSSEC failure, rather than the server. Queries that fail
+// with this error are tried against each server once, and the server isn't
+// counted as unhealthy
+var errDNSResolutionFailure = errors.New("dns resolution failure")
+
+// dnsQueryTimeout How long to wait for the response to a single message
+const dnsQueryTimeout = 3 * time.Second
+
+// exchange Sends a DNS message to a server using the transport that matches
+// the format of the server:
+//
+//   - "ip:port": Plain DNS over UDP, falling back to TCP for large responses
+//   - "tls://host:port": DNS-over-TLS (RFC 7858), port defaults to 853
+//   - "https://host/path": DNS-over-HTTPS (RFC 8484)
+//
+// Each message has its own timeout, and its outcome and latency are recorded
+// against the server's health
+func (d *DNSAdapter) exchange(ctx context.Context, msg *dns.Msg, server string) (*dns.Msg, error) {
+	queryCtx, cancel := context.WithTimeout(ctx, dnsQueryTimeout)
+	defer cancel()
+
+	start := time.Now()
+
+	r, err := d.exchangeTransport(queryCtx, msg, server)
+	if err != nil {
+		// If the caller gave up that says nothing about the server
+		if ctx.Err() == nil && isRetryableDNSError(err) {
+			d.health.RecordFailure(server)
+		}
+
+		return nil, err
+	}
+
+	if r.Rcode == dns.RcodeRefused {
+		// The server won't answer for us, not that the name doesn't exist,
+		// so this shouldn't be treated as a negative answer
+		d.health.RecordFailure(server)
+		return nil, fmt.Errorf("%w: %v returned %v", errDNSServerFailure, server, dns.RcodeToString[r.Rcode])
+	}
+
+	d.health.RecordSuccess(server, time.Since(start))
+
+	if r.Rcode == dns.RcodeServerFailure {
+		// The server answered, but couldn't resolve the name
+		return nil, fmt.Errorf("%w: %v returned %v for %v", errDNSResolutionFailure, server, dns.RcodeToString[r.Rcode], msg.Question[0].Name)
+	}
+
+	return r, nil
+}
+
+// exchangeTransport Sends the message using the transport for the server
+func (d *DNSAdapter) exchangeTransport(ctx context.Context, msg *dns.Msg, server string) (*dns.Msg, error) {
+	switch {
+	case strings.HasPrefix(server, "https://"):
+		return d.exchangeHTTPS(ctx, msg, server)
+	case strings.HasPrefix(server, "tls://"):
+		return d.exchangeTLS(ctx, msg, strings.TrimPrefix(server, "tls://"))
+	default:
+		r, _, err := d.client.ExchangeContext(ctx, msg, server)
+		if err != nil {
+			return nil, err
+		}
+
+		if r.Truncated {
+			// The response didn't fit in a UDP packet, retry over TCP
+			tcpClient := dns.Client{Net: "tcp"}
+			r, _, err = tcpClient.ExchangeContext(ctx, msg, server)
+		}
+
+		return r, err
+	}
+}
+
+// dotMaxIdleConns How many idle connections are kept for each DNS-over-TLS
+// server. Queries for different record types run in parallel, so more than
+// one is needed
+const dotMaxIdleConns = 4
+
+// dotIdleTimeout How long a DNS-over-TLS connection can be idle before it is
+// closed rather than reused. Servers close idle connections themselves
+// (RFC 7766), so there is no point keeping them for long
+const dotIdleTimeout = 10 * time.Second
+
+// dotConn An idle DNS-over-TLS connection
+type dotConn struct {
+	conn     *dns.Conn
+	lastUsed time.Time
+}
+
+// dotPool Keeps the connections to a DNS-over-TLS server open between queries
+// so that each query doesn't need a new TCP and TLS handshake
+type dotPool struct {
+	client  dns.Client
+	address string
+
+	mu   sync.Mutex
+	idle []dotConn
+}
+
+// get Returns an idle connection if there is one, otherwise a new one.
+// Returns true if the connection has been used before
+func (p *dotPool) get(ctx context.Context) (*dns.Conn, bool, error) {
+	p.mu.Lock()
+	for len(p.idle) > 0 {
+		c := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+
+		if time.Since(c.lastUsed) < dotIdleTimeout {
+			p.mu.Unlock()
+			return c.conn, true, nil
+		}
+
+		c.conn.Close()
+	}
+	p.mu.Unlock()
+
+	conn, err := p.client.DialContext(ctx, p.address)
+
+	return conn, false, err
+}
+
+// put Returns a connection to the pool once its response has been read
+func (p *dotPool) put(conn *dns.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.idle) >= dotMaxIdleConns {
+		conn.Close()
+		return
+	}
+
+	p.idle = append(p.idle, dotConn{
+		conn:     conn,
+		lastUsed: time.Now(),
+	})
+}
+
+// getDoTPool Returns the connection pool for a DNS-over-TLS server, creating
+// it if required
+func (d *DNSAdapter) getDoTPool(address string) *dotPool {
+	d.dotPoolsMu.Lock()
+	defer d.dotPoolsMu.Unlock()
+
+	if pool, ok := d.dotPools[address]; ok {
+		return pool
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		// No port specified, use the default
+		host = address
+		port = "853"
+	}
+
+	var tlsConfig *tls.Config
+	if d.TLSConfig != nil {
+		tlsConfig = d.TLSConfig.Clone()
+	} else {
+		tlsConfig = d.ClientFactory.TLSConfig()
+	}
+
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = host
+	}
+
+	pool := &dotPool{
+		client: dns.Client{
+			Net:       "tcp-tls",
+			TLSConfig: tlsConfig,
+		},
+		address: net.JoinHostPort(host, port),
+	}
+
+	if d.dotPools == nil {
+		d.dotPools = make(map[string]*dotPool)
+	}
+	d.dotPools[address] = pool
+
+	return pool
+}
+
+// exchangeTLS Sends a DNS message over TLS, reusing an open connection to the
+// server if there is one
+func (d *DNSAdapter) exchangeTLS(ctx context.Context, msg *dns.Msg, address string) (*dns.Msg, error) {
+	pool := d.getDoTPool(address)
+
+	for {
+		conn, reused, err := pool.get(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		r, _, err := pool.client.ExchangeWithConnContext(ctx, msg, conn)
+		if err != nil {
+			conn.Close()
+
+			if reused && ctx.Err() == nil {
+				// The server may have closed the connection while it was
+				// idle, try again with another one
+				continue
+			}
+
+			return nil, err
+		}
+
+		pool.put(conn)
+
+		return r, nil
+	}
+}
+
+// exchangeHTTPS Sends a DNS message to a DNS-over-HTTPS server using the POST
+// method
+func (d *DNSAdapter) exchangeHTTPS(ctx context.Context, msg *dns.Msg, url string) (*dns.Msg, error) {
+	d.httpClientOnce.Do(func() {
+		tr := d.ClientFactory.Transport()
+		if d.TLSConfig != nil {
+			tr.TLSClientConfig = d.TLSConfig
+		}
+
+		d.httpClient = &http.Client{
+			Transport: otelhttp.NewTransport(tr),
+		}
+	})
+
+	// RFC 8484 recommends an ID of 0 so that responses are cache friendly. We
+	// copy the message so that the caller's message isn't modified
+	query := msg.Copy()
+	query.Id = 0
+
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", dnsMessageContentType)
+	req.Header.Set("Accept", dnsMessageContentType)
+
+	res, err := d.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: DNS-over-HTTPS server %v returned %v", errDNSServerFailure, url, res.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxDNSMessageSize))
+	if err != nil {
+		return nil, err
+	}
+
+	r := new(dns.Msg)
+	err = r.Unpack(body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Restore the ID so that the response matches the original query
+	r.Id = msg.Id
+
+	return r, nil
+}
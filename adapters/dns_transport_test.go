@@ -0,0 +1,225 @@
+package adapters
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// newTestDoHServer Starts an in-process DNS-over-HTTPS server. The returned
+// TLS config trusts the server's certificate
+func newTestDoHServer(t *testing.T, answer func(*dns.Msg) *dns.Msg) (string, *tls.Config) {
+	t.Helper()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != dnsMessageContentType {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		query := new(dns.Msg)
+		if err = query.Unpack(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		packed, err := answer(query).Pack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", dnsMessageContentType)
+		_, _ = w.Write(packed)
+	}))
+	t.Cleanup(server.Close)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+
+	return server.URL + "/dns-query", &tls.Config{RootCAs: pool} // nolint:gosec // Only used in tests
+}
+
+// newTestDoTServer Starts an in-process DNS-over-TLS server using the same
+// certificate as the supplied test HTTPS server config
+func newTestDoTServer(t *testing.T, answer func(*dns.Msg) *dns.Msg) (string, *tls.Config) {
+	t.Helper()
+
+	return startTestDoTServer(t, answer, new(atomic.Int32))
+}
+
+// countingListener Counts the connections that have been accepted
+type countingListener struct {
+	net.Listener
+	accepted *atomic.Int32
+}
+
+func (l countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted.Add(1)
+	}
+
+	return conn, err
+}
+
+// startTestDoTServer Starts an in-process DNS-over-TLS server that counts the
+// connections it accepts
+func startTestDoTServer(t *testing.T, answer func(*dns.Msg) *dns.Msg, accepted *atomic.Int32) (string, *tls.Config) {
+	t.Helper()
+
+	// Borrow the certificate that httptest generates since it is valid for
+	// 127.0.0.1
+	https := httptest.NewUnstartedServer(http.NotFoundHandler())
+	https.StartTLS()
+	https.Close()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", https.TLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	server := &dns.Server{
+		Listener: countingListener{Listener: listener, accepted: accepted},
+		Net:      "tcp-tls",
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			_ = w.WriteMsg(answer(r))
+		}),
+		NotifyStartedFunc: func() { close(started) },
+	}
+
+	go func() {
+		_ = server.ActivateAndServe()
+	}()
+	t.Cleanup(func() {
+		_ = server.Shutdown()
+	})
+
+	<-started
+
+	pool := x509.NewCertPool()
+	pool.AddCert(https.Certificate())
+
+	return "tls://" + listener.Addr().String(), &tls.Config{RootCAs: pool} // nolint:gosec // Only used in tests
+}
+
+func TestDNSTransports(t *testing.T) {
+	t.Parallel()
+
+	answer := testZone(t,
+		"example.test. 300 IN A 192.0.2.1",
+		"example.test. 300 IN AAAA 2001:db8::1",
+	)
+
+	t.Run("DNS-over-HTTPS", func(t *testing.T) {
+		url, tlsConfig := newTestDoHServer(t, answer)
+
+		src := DNSAdapter{
+			Servers:   []string{url},
+			TLSConfig: tlsConfig,
+		}
+
+		item, err := src.Get(context.Background(), "global", "example.test", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(item.GetLinkedItemQueries()) != 3 {
+			t.Errorf("expected 3 linked item queries, got %v", len(item.GetLinkedItemQueries()))
+		}
+	})
+
+	t.Run("DNS-over-TLS", func(t *testing.T) {
+		server, tlsConfig := newTestDoTServer(t, answer)
+
+		src := DNSAdapter{
+			Servers:   []string{server},
+			TLSConfig: tlsConfig,
+		}
+
+		item, err := src.Get(context.Background(), "global", "example.test", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(item.GetLinkedItemQueries()) != 3 {
+			t.Errorf("expected 3 linked item queries, got %v", len(item.GetLinkedItemQueries()))
+		}
+	})
+
+	t.Run("DNS-over-TLS connections are reused", func(t *testing.T) {
+		var accepted atomic.Int32
+		server, tlsConfig := startTestDoTServer(t, answer, &accepted)
+
+		src := DNSAdapter{
+			Servers:   []string{server},
+			TLSConfig: tlsConfig,
+		}
+
+		for range 5 {
+			_, err := src.Get(context.Background(), "global", "example.test", true)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// The A and AAAA queries run in parallel so each need a connection,
+		// after that they should be reused
+		if n := accepted.Load(); n > 2 {
+			t.Errorf("expected connections to be reused, got %v connections for 10 queries", n)
+		}
+	})
+
+	t.Run("Untrusted certificate", func(t *testing.T) {
+		url, _ := newTestDoHServer(t, answer)
+
+		src := DNSAdapter{
+			Servers: []string{url},
+		}
+
+		_, err := src.Get(context.Background(), "global", "example.test", false)
+		if err == nil {
+			t.Error("expected error for untrusted certificate")
+		}
+	})
+
+	t.Run("Mixed transports fail over", func(t *testing.T) {
+		url, tlsConfig := newTestDoHServer(t, answer)
+
+		// A socket that never responds, this will time out and we should
+		// move on to the next server
+		blackhole, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer blackhole.Close()
+
+		src := DNSAdapter{
+			Servers: []string{
+				blackhole.LocalAddr().String(),
+				url,
+			},
+			TLSConfig: tlsConfig,
+		}
+
+		_, err = src.Get(context.Background(), "global", "example.test", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,775 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/overmindtech/sdp-go"
+)
+
+// The validation status of a DNS answer, as described in RFC 4035 section 4.3
+type dnssecStatus string
+
+const (
+	// The answer has a chain of trust back to a trust anchor
+	dnssecSecure dnssecStatus = "secure"
+	// The answer is in a zone that has been proven to be unsigned
+	dnssecInsecure dnssecStatus = "insecure"
+	// The answer should be signed, but the signatures don't validate
+	dnssecBogus dnssecStatus = "bogus"
+	// We weren't able to determine the status, usually because a query failed
+	dnssecIndeterminate dnssecStatus = "indeterminate"
+)
+
+// dnssecSeverity Used to combine the status of many RRsets, the status with
+// the highest severity wins
+var dnssecSeverity = map[dnssecStatus]int{
+	dnssecSecure:        0,
+	dnssecInsecure:      1,
+	dnssecIndeterminate: 2,
+	dnssecBogus:         3,
+}
+
+// RootTrustAnchors The DS records for the root zone's key signing keys. See
+// https://data.iana.org/root-anchors/root-anchors.xml
+var RootTrustAnchors = []string{
+	". 172800 IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+	". 172800 IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+}
+
+// dnssecResult The result of validating a single RRset
+type dnssecResult struct {
+	Status dnssecStatus
+	Reason string
+	// The zone that signed the RRset
+	Signer string
+}
+
+// zoneTrust The validation state of a zone's keys
+type zoneTrust struct {
+	Zone   string
+	Parent string
+	Status dnssecStatus
+	Reason string
+	// The validated keys for this zone, only set if the zone is secure
+	Keys   []*dns.DNSKEY
+	DNSKEY []dns.RR
+	DS     []dns.RR
+}
+
+// dnssecValidator Validates answers by building a chain of trust from the
+// signing zone back to the root trust anchors. Keys are memoised so a single
+// validator should be used for all answers in a query
+type dnssecValidator struct {
+	exchange func(ctx context.Context, msg *dns.Msg) (*dns.Msg, error)
+	anchors  []*dns.DS
+	now      time.Time
+	zones    map[string]*zoneTrust
+	// The order that zones were evaluated in, used to return consistent items
+	order []string
+}
+
+// parseTrustAnchors Parses DS records in zone file format
+func parseTrustAnchors(anchors []string) ([]*dns.DS, error) {
+	parsed := make([]*dns.DS, 0, len(anchors))
+
+	for _, anchor := range anchors {
+		rr, err := dns.NewRR(anchor)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trust anchor %q: %w", anchor, err)
+		}
+
+		ds, ok := rr.(*dns.DS)
+		if !ok {
+			return nil, fmt.Errorf("trust anchor %q is not a DS record", anchor)
+		}
+
+		parsed = append(parsed, ds)
+	}
+
+	return parsed, nil
+}
+
+func newDNSSECValidator(anchors []*dns.DS, exchange func(ctx context.Context, msg *dns.Msg) (*dns.Msg, error)) *dnssecValidator {
+	return &dnssecValidator{
+		exchange: exchange,
+		anchors:  anchors,
+		now:      time.Now(),
+		zones:    make(map[string]*zoneTrust),
+	}
+}
+
+// newDNSSECQuery Creates a query with the DO bit set so that signatures are
+// returned. The CD bit is also set so that validating resolvers return bogus
+// data rather than SERVFAIL, allowing us to report on it
+func newDNSSECQuery(name string, qtype uint16) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), qtype)
+	msg.RecursionDesired = true
+	msg.CheckingDisabled = true
+	msg.SetEdns0(4096, true)
+
+	return msg
+}
+
+func (v *dnssecValidator) query(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+	return v.exchange(ctx, newDNSSECQuery(name, qtype))
+}
+
+// rrsetFrom Extracts the records of a given name and type, along with the
+// signatures that cover them
+func rrsetFrom(rrs []dns.RR, name string, rrtype uint16) ([]dns.RR, []*dns.RRSIG) {
+	name = dns.CanonicalName(name)
+
+	rrset := make([]dns.RR, 0)
+	sigs := make([]*dns.RRSIG, 0)
+
+	for _, rr := range rrs {
+		if dns.CanonicalName(rr.Header().Name) != name {
+			continue
+		}
+
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			if sig.TypeCovered == rrtype {
+				sigs = append(sigs, sig)
+			}
+		} else if rr.Header().Rrtype == rrtype {
+			rrset = append(rrset, rr)
+		}
+	}
+
+	return rrset, sigs
+}
+
+// verify Checks that at least one of the signatures over the RRset was made
+// by one of the keys and is currently valid
+func (v *dnssecValidator) verify(rrset []dns.RR, sigs []*dns.RRSIG, keys []*dns.DNSKEY) error {
+	if len(sigs) == 0 {
+		return errors.New("no signatures")
+	}
+
+	err := errors.New("no matching key for signature")
+
+	for _, sig := range sigs {
+		for _, key := range keys {
+			if key.KeyTag() != sig.KeyTag || key.Algorithm != sig.Algorithm {
+				continue
+			}
+
+			if err = sig.Verify(key, rrset); err != nil {
+				continue
+			}
+
+			if !sig.ValidityPeriod(v.now) {
+				err = fmt.Errorf("signature by key %v is outside of its validity period", sig.KeyTag)
+				continue
+			}
+
+			return nil
+		}
+	}
+
+	return err
+}
+
+// Trust Returns the validation state of a zone's keys, evaluating it if
+// required
+func (v *dnssecValidator) Trust(ctx context.Context, zone string) *zoneTrust {
+	zone = dns.CanonicalName(zone)
+
+	if t, ok := v.zones[zone]; ok {
+		return t
+	}
+
+	// Store this before evaluating so that loops in the chain terminate
+	t := &zoneTrust{
+		Zone:   zone,
+		Status: dnssecIndeterminate,
+		Reason: "loop in chain of trust",
+	}
+	v.zones[zone] = t
+
+	v.evaluate(ctx, t)
+	v.order = append(v.order, zone)
+
+	return t
+}
+
+func (v *dnssecValidator) evaluate(ctx context.Context, t *zoneTrust) {
+	var ds []*dns.DS
+
+	if t.Zone == "." {
+		ds = v.anchors
+	} else {
+		r, err := v.query(ctx, t.Zone, dns.TypeDS)
+		if err != nil {
+			t.Status, t.Reason = dnssecIndeterminate, fmt.Sprintf("querying DS failed: %v", err)
+			return
+		}
+
+		dsSet, dsSigs := rrsetFrom(r.Answer, t.Zone, dns.TypeDS)
+
+		if len(dsSet) == 0 {
+			v.evaluateNoDS(ctx, t, r)
+			return
+		}
+
+		if len(dsSigs) == 0 {
+			// There are DS records, but they aren't signed. This means that
+			// the parent must be insecure, otherwise this is bogus
+			v.inheritParent(ctx, t, dnsParent(t.Zone), dnssecBogus, "DS records are not signed")
+			return
+		}
+
+		parent := v.Trust(ctx, dsSigs[0].SignerName)
+		t.Parent = parent.Zone
+
+		if parent.Status != dnssecSecure {
+			t.Status, t.Reason = parent.Status, fmt.Sprintf("parent zone %v is %v", parent.Zone, parent.Status)
+			return
+		}
+
+		if err = v.verify(dsSet, dsSigs, parent.Keys); err != nil {
+			t.Status, t.Reason = dnssecBogus, fmt.Sprintf("DS signature invalid: %v", err)
+			return
+		}
+
+		t.DS = dsSet
+
+		for _, rr := range dsSet {
+			if d, ok := rr.(*dns.DS); ok {
+				ds = append(ds, d)
+			}
+		}
+	}
+
+	r, err := v.query(ctx, t.Zone, dns.TypeDNSKEY)
+	if err != nil {
+		t.Status, t.Reason = dnssecIndeterminate, fmt.Sprintf("querying DNSKEY failed: %v", err)
+		return
+	}
+
+	keySet, keySigs := rrsetFrom(r.Answer, t.Zone, dns.TypeDNSKEY)
+	t.DNSKEY = keySet
+
+	keys := make([]*dns.DNSKEY, 0, len(keySet))
+	for _, rr := range keySet {
+		if key, ok := rr.(*dns.DNSKEY); ok {
+			keys = append(keys, key)
+		}
+	}
+
+	if len(keys) == 0 {
+		t.Status, t.Reason = dnssecBogus, "zone has DS records but no DNSKEY records"
+		return
+	}
+
+	// Find the keys that are authorised by the DS records
+	trusted := make([]*dns.DNSKEY, 0)
+	for _, key := range keys {
+		for _, d := range ds {
+			if key.KeyTag() != d.KeyTag || key.Algorithm != d.Algorithm {
+				continue
+			}
+
+			if computed := key.ToDS(d.DigestType); computed != nil && strings.EqualFold(computed.Digest, d.Digest) {
+				trusted = append(trusted, key)
+				break
+			}
+		}
+	}
+
+	if len(trusted) == 0 {
+		t.Status, t.Reason = dnssecBogus, "no DNSKEY matches the DS records"
+		return
+	}
+
+	if err = v.verify(keySet, keySigs, trusted); err != nil {
+		t.Status, t.Reason = dnssecBogus, fmt.Sprintf("DNSKEY signature invalid: %v", err)
+		return
+	}
+
+	t.Status, t.Reason = dnssecSecure, ""
+	t.Keys = keys
+}
+
+// evaluateNoDS Works out whether a zone without DS records is provably
+// insecure. This requires the parent to be secure and to have returned signed
+// NSEC or NSEC3 records showing that there is no DS record
+func (v *dnssecValidator) evaluateNoDS(ctx context.Context, t *zoneTrust, r *dns.Msg) {
+	var parentName string
+
+	for _, rr := range r.Ns {
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			parentName = sig.SignerName
+			break
+		}
+
+		if soa, ok := rr.(*dns.SOA); ok {
+			parentName = soa.Hdr.Name
+		}
+	}
+
+	if parentName == "" {
+		parentName = dnsParent(t.Zone)
+	}
+
+	parent := v.Trust(ctx, parentName)
+	t.Parent = parent.Zone
+
+	if parent.Status != dnssecSecure {
+		t.Status, t.Reason = parent.Status, fmt.Sprintf("parent zone %v is %v", parent.Zone, parent.Status)
+		return
+	}
+
+	nsec3s := make([]*dns.NSEC3, 0)
+	var hasDenial bool
+
+	for _, rr := range r.Ns {
+		switch denial := rr.(type) {
+		case *dns.RRSIG:
+			hasDenial = true
+		case *dns.NSEC:
+			hasDenial = true
+
+			if dns.CanonicalName(denial.Hdr.Name) != t.Zone || hasType(denial.TypeBitMap, dns.TypeDS) || hasType(denial.TypeBitMap, dns.TypeSOA) {
+				continue
+			}
+
+			set, sigs := rrsetFrom(r.Ns, denial.Hdr.Name, dns.TypeNSEC)
+			if err := v.verify(set, sigs, parent.Keys); err != nil {
+				t.Status, t.Reason = dnssecBogus, fmt.Sprintf("NSEC signature invalid: %v", err)
+				return
+			}
+
+			t.Status, t.Reason = dnssecInsecure, "no DS records, proven by NSEC"
+			return
+		case *dns.NSEC3:
+			hasDenial = true
+			nsec3s = append(nsec3s, denial)
+		}
+	}
+
+	if len(nsec3s) > 0 {
+		t.Status, t.Reason = v.evaluateNSEC3NoDS(t.Zone, r.Ns, nsec3s, parent.Keys)
+		return
+	}
+
+	if !hasDenial {
+		// Nothing was returned that could prove or disprove anything, which
+		// happens when something between us and the zone strips DNSSEC
+		t.Status, t.Reason = dnssecIndeterminate, "no NSEC, NSEC3 or RRSIG records were returned with the DS response"
+		return
+	}
+
+	t.Status, t.Reason = dnssecBogus, "absence of DS records was not proven"
+}
+
+// evaluateNSEC3NoDS Checks that NSEC3 records prove that a zone has no DS
+// records, as described in RFC 5155 section 8.6. Either an NSEC3 matches the
+// zone and shows that there is no DS record, or there is a closest encloser
+// proof where the NSEC3 covering the next closer name has the opt-out flag.
+// Opt-out is used by most large TLDs for their unsigned delegations
+func (v *dnssecValidator) evaluateNSEC3NoDS(zone string, section []dns.RR, nsec3s []*dns.NSEC3, keys []*dns.DNSKEY) (dnssecStatus, string) {
+	verify := func(nsec3s ...*dns.NSEC3) error {
+		for _, n := range nsec3s {
+			set, sigs := rrsetFrom(section, n.Hdr.Name, dns.TypeNSEC3)
+			if err := v.verify(set, sigs, keys); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	for _, n := range nsec3s {
+		if !n.Match(zone) {
+			continue
+		}
+
+		if hasType(n.TypeBitMap, dns.TypeDS) || hasType(n.TypeBitMap, dns.TypeSOA) {
+			return dnssecBogus, "NSEC3 matching the zone does not prove the absence of DS records"
+		}
+
+		if err := verify(n); err != nil {
+			return dnssecBogus, fmt.Sprintf("NSEC3 signature invalid: %v", err)
+		}
+
+		return dnssecInsecure, "no DS records, proven by NSEC3"
+	}
+
+	// Find the closest encloser, the longest ancestor with a matching NSEC3.
+	// The next closer name is the name one label below it
+	labels := dns.SplitDomainName(zone)
+
+	for i := 1; i <= len(labels); i++ {
+		encloser := dns.Fqdn(strings.Join(labels[i:], "."))
+		nextCloser := dns.Fqdn(strings.Join(labels[i-1:], "."))
+
+		var matching, covering *dns.NSEC3
+		for _, n := range nsec3s {
+			if matching == nil && n.Match(encloser) {
+				matching = n
+			}
+
+			if covering == nil && n.Cover(nextCloser) {
+				covering = n
+			}
+		}
+
+		if matching == nil {
+			continue
+		}
+
+		if covering == nil {
+			return dnssecBogus, fmt.Sprintf("no NSEC3 covers the next closer name %v", nextCloser)
+		}
+
+		if covering.Flags&1 == 0 {
+			// Without opt-out a covering NSEC3 means the delegation
+			// doesn't exist, not that it is unsigned
+			return dnssecBogus, fmt.Sprintf("NSEC3 covering %v does not have the opt-out flag set", nextCloser)
+		}
+
+		if err := verify(matching, covering); err != nil {
+			return dnssecBogus, fmt.Sprintf("NSEC3 signature invalid: %v", err)
+		}
+
+		return dnssecInsecure, "no DS records, proven by an opt-out NSEC3"
+	}
+
+	return dnssecBogus, "absence of DS records was not proven by NSEC3"
+}
+
+// inheritParent Sets the status of a zone based on its parent. If the parent
+// is insecure then so is the child, if the parent is secure then the child
+// gets the supplied status
+func (v *dnssecValidator) inheritParent(ctx context.Context, t *zoneTrust, parentName string, status dnssecStatus, reason string) {
+	parent := v.Trust(ctx, v.FindZone(ctx, parentName))
+	t.Parent = parent.Zone
+
+	if parent.Status == dnssecSecure {
+		t.Status, t.Reason = status, reason
+	} else {
+		t.Status, t.Reason = parent.Status, fmt.Sprintf("parent zone %v is %v", parent.Zone, parent.Status)
+	}
+}
+
+// FindZone Finds the apex of the zone that contains a name by looking for the
+// SOA record
+func (v *dnssecValidator) FindZone(ctx context.Context, name string) string {
+	name = dns.CanonicalName(name)
+
+	if name == "." {
+		return name
+	}
+
+	r, err := v.query(ctx, name, dns.TypeSOA)
+	if err == nil {
+		for _, rr := range append(r.Answer, r.Ns...) {
+			if soa, ok := rr.(*dns.SOA); ok {
+				return dns.CanonicalName(soa.Hdr.Name)
+			}
+		}
+	}
+
+	// Fall back to assuming that the parent is the zone
+	return dnsParent(name)
+}
+
+// Validate Returns the validation status of an RRset
+func (v *dnssecValidator) Validate(ctx context.Context, name string, rrset []dns.RR, sigs []*dns.RRSIG) dnssecResult {
+	if len(sigs) == 0 {
+		t := v.Trust(ctx, v.FindZone(ctx, name))
+
+		switch t.Status {
+		case dnssecSecure:
+			return dnssecResult{Status: dnssecBogus, Reason: "answer is not signed", Signer: t.Zone}
+		case dnssecInsecure:
+			return dnssecResult{Status: dnssecInsecure, Reason: t.Reason, Signer: t.Zone}
+		default:
+			return dnssecResult{Status: t.Status, Reason: fmt.Sprintf("zone %v is %v: %v", t.Zone, t.Status, t.Reason), Signer: t.Zone}
+		}
+	}
+
+	t := v.Trust(ctx, sigs[0].SignerName)
+
+	if t.Status != dnssecSecure {
+		return dnssecResult{Status: t.Status, Reason: fmt.Sprintf("zone %v is %v: %v", t.Zone, t.Status, t.Reason), Signer: t.Zone}
+	}
+
+	if err := v.verify(rrset, sigs, t.Keys); err != nil {
+		return dnssecResult{Status: dnssecBogus, Reason: err.Error(), Signer: t.Zone}
+	}
+
+	return dnssecResult{Status: dnssecSecure, Signer: t.Zone}
+}
+
+// rrsetKey Identifies an RRset within a response
+type rrsetKey struct {
+	Name string
+	Type uint16
+}
+
+// ValidateAnswers Validates every RRset in a set of answers
+func (v *dnssecValidator) ValidateAnswers(ctx context.Context, answers []dns.RR) map[rrsetKey]dnssecResult {
+	results := make(map[rrsetKey]dnssecResult)
+
+	for _, rr := range answers {
+		if rr.Header().Rrtype == dns.TypeRRSIG {
+			continue
+		}
+
+		key := rrsetKey{
+			Name: dns.CanonicalName(rr.Header().Name),
+			Type: rr.Header().Rrtype,
+		}
+
+		if _, ok := results[key]; ok {
+			continue
+		}
+
+		rrset, sigs := rrsetFrom(answers, key.Name, key.Type)
+		results[key] = v.Validate(ctx, key.Name, dedupeRRs(rrset), dedupeSigs(sigs))
+	}
+
+	return results
+}
+
+// dedupeRRs Removes duplicate records, these can happen when the same CNAME
+// is returned for several queries
+func dedupeRRs(rrs []dns.RR) []dns.RR {
+	seen := make(map[string]bool)
+	deduped := make([]dns.RR, 0, len(rrs))
+
+	for _, rr := range rrs {
+		if s := rr.String(); !seen[s] {
+			seen[s] = true
+			deduped = append(deduped, rr)
+		}
+	}
+
+	return deduped
+}
+
+func dedupeSigs(sigs []*dns.RRSIG) []*dns.RRSIG {
+	seen := make(map[string]bool)
+	deduped := make([]*dns.RRSIG, 0, len(sigs))
+
+	for _, sig := range sigs {
+		if s := sig.String(); !seen[s] {
+			seen[s] = true
+			deduped = append(deduped, sig)
+		}
+	}
+
+	return deduped
+}
+
+// ZoneItems Returns `dns-dnskey` and `dns-ds` items for each zone that was
+// evaluated
+func (v *dnssecValidator) ZoneItems() ([]*sdp.Item, error) {
+	items := make([]*sdp.Item, 0)
+
+	for _, zone := range v.order {
+		t := v.zones[zone]
+
+		if len(t.DNSKEY) > 0 {
+			item, err := DNSKEYToItem(zoneItemName(t.Zone), t.DNSKEY)
+			if err != nil {
+				return nil, err
+			}
+
+			setDNSSECAttribute(item, dnssecResult{Status: t.Status, Reason: t.Reason, Signer: zoneItemName(t.Zone)})
+
+			if len(t.DS) > 0 {
+				// The DS records are what authorise these keys
+				item.LinkedItems = append(item.LinkedItems, zoneKeyLink(dns.TypeDS, t.Zone))
+			}
+
+			items = append(items, item)
+		}
+
+		if len(t.DS) > 0 {
+			item, err := DSToItem(zoneItemName(t.Zone), t.DS)
+			if err != nil {
+				return nil, err
+			}
+
+			setDNSSECAttribute(item, dnssecResult{Status: t.Status, Reason: t.Reason, Signer: zoneItemName(t.Parent)})
+
+			item.LinkedItems = append(item.LinkedItems, zoneKeyLink(dns.TypeDNSKEY, t.Zone))
+
+			if t.Parent != "" {
+				// The DS records are signed by the parent, so rolling the
+				// parent's keys will affect them
+				item.LinkedItems = append(item.LinkedItems, zoneKeyLink(dns.TypeDNSKEY, t.Parent))
+			}
+
+			items = append(items, item)
+		}
+	}
+
+	return items, nil
+}
+
+// zoneItemName Returns the name used for items that represent a zone. This is
+// the zone without the trailing dot, except for the root
+func zoneItemName(zone string) string {
+	if name := trimDnsSuffix(zone); name != "" {
+		return name
+	}
+
+	return "."
+}
+
+// zoneKeyLink Creates a link to the DNSKEY or DS item for a zone
+func zoneKeyLink(rrtype uint16, zone string) *sdp.LinkedItem {
+	return &sdp.LinkedItem{
+		Item: &sdp.Reference{
+			Type:                 recordItemType(rrtype),
+			UniqueAttributeValue: zoneItemName(zone),
+			Scope:                "global",
+		},
+		BlastPropagation: &sdp.BlastPropagation{
+			// Changing keys will affect everything they sign
+			In:  true,
+			Out: false,
+		},
+	}
+}
+
+// setDNSSECAttribute Adds the result of validation to an item
+func setDNSSECAttribute(item *sdp.Item, result dnssecResult) {
+	dnssec := map[string]interface{}{
+		"status": string(result.Status),
+	}
+
+	if result.Reason != "" {
+		dnssec["reason"] = result.Reason
+	}
+
+	if result.Signer != "" {
+		dnssec["signer"] = result.Signer
+	}
+
+	_ = item.GetAttributes().Set("dnssec", dnssec)
+}
+
+// annotateDNSSEC Sets the combined validation status of the RRsets that make
+// up each item, and links the item to the keys of the zone that signed it
+func annotateDNSSEC(items []*sdp.Item, results map[rrsetKey]dnssecResult) {
+	for _, item := range items {
+		name, err := item.GetAttributes().Get("name")
+		if err != nil {
+			continue
+		}
+
+		typ, err := item.GetAttributes().Get("type")
+		if err != nil {
+			continue
+		}
+
+		var rrtypes []uint16
+		if typ == "address" {
+			rrtypes = []uint16{dns.TypeA, dns.TypeAAAA}
+		} else if t, ok := dns.StringToType[fmt.Sprint(typ)]; ok {
+			rrtypes = []uint16{t}
+		}
+
+		var combined *dnssecResult
+
+		for _, rrtype := range rrtypes {
+			result, ok := results[rrsetKey{Name: dns.CanonicalName(fmt.Sprint(name)), Type: rrtype}]
+			if !ok {
+				continue
+			}
+
+			if combined == nil || dnssecSeverity[result.Status] > dnssecSeverity[combined.Status] {
+				combined = &result
+			}
+		}
+
+		if combined == nil {
+			continue
+		}
+
+		setDNSSECAttribute(item, *combined)
+
+		if combined.Status == dnssecSecure {
+			item.LinkedItems = append(item.LinkedItems, zoneKeyLink(dns.TypeDNSKEY, combined.Signer))
+		}
+	}
+}
+
+// hasType Checks whether an NSEC or NSEC3 type bitmap includes a type
+func hasType(bitmap []uint16, rrtype uint16) bool {
+	for _, t := range bitmap {
+		if t == rrtype {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dnsParent Returns the parent of a name by removing the first label
+func dnsParent(name string) string {
+	name = dns.CanonicalName(name)
+
+	if name == "." {
+		return name
+	}
+
+	labels := dns.SplitDomainName(name)
+	if len(labels) <= 1 {
+		return "."
+	}
+
+	return dns.Fqdn(strings.Join(labels[1:], "."))
+}
+
+// DNSKEYToItem Converts a set of DNSKEY records to a `dns-dnskey` item
+func DNSKEYToItem(name string, records []dns.RR) (*sdp.Item, error) {
+	recordAttrs := make([]map[string]interface{}, 0)
+
+	for _, r := range records {
+		if key, ok := r.(*dns.DNSKEY); ok {
+			recordAttrs = append(recordAttrs, map[string]interface{}{
+				"ttl":       key.Hdr.Ttl,
+				"flags":     key.Flags,
+				"protocol":  key.Protocol,
+				"algorithm": dns.AlgorithmToString[key.Algorithm],
+				"keyTag":    key.KeyTag(),
+				// Key signing keys have the secure entry point flag set
+				"sep":       key.Flags&dns.SEP == dns.SEP,
+				"publicKey": key.PublicKey,
+			})
+		}
+	}
+
+	return recordsToItem(dns.TypeDNSKEY, name, recordAttrs, nil)
+}
+
+// DSToItem Converts a set of DS records to a `dns-ds` item
+func DSToItem(name string, records []dns.RR) (*sdp.Item, error) {
+	recordAttrs := make([]map[string]interface{}, 0)
+
+	for _, r := range records {
+		if ds, ok := r.(*dns.DS); ok {
+			recordAttrs = append(recordAttrs, map[string]interface{}{
+				"ttl":        ds.Hdr.Ttl,
+				"keyTag":     ds.KeyTag,
+				"algorithm":  dns.AlgorithmToString[ds.Algorithm],
+				"digestType": dns.HashToString[ds.DigestType],
+				"digest":     strings.ToUpper(ds.Digest),
+			})
+		}
+	}
+
+	return recordsToItem(dns.TypeDS, name, recordAttrs, nil)
+}
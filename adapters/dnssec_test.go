@@ -0,0 +1,369 @@
+package adapters
+
+import (
+	"context"
+	"crypto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/overmindtech/discovery"
+	"github.com/overmindtech/sdp-go"
+)
+
+// testSigner A zone and the key that it is signed with
+type testSigner struct {
+	zone string
+	key  *dns.DNSKEY
+	priv crypto.Signer
+}
+
+func newTestSigner(t *testing.T, zone string) *testSigner {
+	t.Helper()
+
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: zone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     dns.ZONE | dns.SEP,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+
+	priv, err := key.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testSigner{
+		zone: zone,
+		key:  key,
+		priv: priv.(crypto.Signer),
+	}
+}
+
+func (s *testSigner) sign(t *testing.T, rrset []dns.RR) *dns.RRSIG {
+	t.Helper()
+
+	hdr := rrset[0].Header()
+	sig := &dns.RRSIG{
+		Hdr:         dns.RR_Header{Name: hdr.Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: hdr.Ttl},
+		TypeCovered: hdr.Rrtype,
+		Algorithm:   s.key.Algorithm,
+		Labels:      uint8(dns.CountLabel(hdr.Name)),
+		OrigTtl:     hdr.Ttl,
+		Expiration:  uint32(time.Now().Add(time.Hour).Unix()),
+		Inception:   uint32(time.Now().Add(-time.Hour).Unix()),
+		KeyTag:      s.key.KeyTag(),
+		SignerName:  s.zone,
+	}
+
+	if err := sig.Sign(s.priv, rrset); err != nil {
+		t.Fatal(err)
+	}
+
+	return sig
+}
+
+func (s *testSigner) ds() *dns.DS {
+	return s.key.ToDS(dns.SHA256)
+}
+
+// testSignedZone Answers queries from a set of signed RRsets
+type testSignedZone struct {
+	answers map[rrsetKey][]dns.RR
+	denials map[rrsetKey][]dns.RR
+	soas    map[string]dns.RR
+}
+
+func (z *testSignedZone) add(t *testing.T, signer *testSigner, rrset ...dns.RR) {
+	t.Helper()
+
+	hdr := rrset[0].Header()
+	key := rrsetKey{Name: dns.CanonicalName(hdr.Name), Type: hdr.Rrtype}
+
+	z.answers[key] = append(z.answers[key], rrset...)
+
+	if signer != nil {
+		z.answers[key] = append(z.answers[key], signer.sign(t, rrset))
+	}
+
+	if soa, ok := rrset[0].(*dns.SOA); ok {
+		z.soas[key.Name] = soa
+	}
+}
+
+func (z *testSignedZone) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+
+	q := r.Question[0]
+	key := rrsetKey{Name: dns.CanonicalName(q.Name), Type: q.Qtype}
+	m.Answer = z.answers[key]
+
+	if len(m.Answer) == 0 {
+		m.Ns = append(m.Ns, z.denials[key]...)
+
+		// Add the SOA of the closest enclosing zone, DS records are served
+		// by the parent
+		start := key.Name
+		if q.Qtype == dns.TypeDS {
+			start = dnsParent(start)
+		}
+
+		for name := start; ; name = dnsParent(name) {
+			if soa, ok := z.soas[name]; ok {
+				m.Ns = append(m.Ns, soa)
+				break
+			}
+
+			if name == "." {
+				break
+			}
+		}
+	}
+
+	_ = w.WriteMsg(m)
+}
+
+func mustRRs(t *testing.T, records ...string) []dns.RR {
+	rrs := make([]dns.RR, 0, len(records))
+	for _, r := range records {
+		rrs = append(rrs, mustRR(t, r))
+	}
+	return rrs
+}
+
+func soaFor(t *testing.T, zone string) dns.RR {
+	// Avoid a double dot for the root
+	suffix := strings.TrimPrefix(zone, ".")
+
+	return mustRR(t, zone+" 3600 IN SOA ns1."+suffix+" hostmaster."+suffix+" 1 7200 3600 1209600 300")
+}
+
+// newTestSignedHierarchy Creates a signed root, "test." and "example.test."
+// as well as unsigned zones under "test." whose delegations are proven in
+// different ways, and returns a server for them along
+// with the trust anchor for the root
+func newTestSignedHierarchy(t *testing.T) (string, string) {
+	t.Helper()
+
+	root := newTestSigner(t, ".")
+	tld := newTestSigner(t, "test.")
+	example := newTestSigner(t, "example.test.")
+
+	z := &testSignedZone{
+		answers: make(map[rrsetKey][]dns.RR),
+		denials: make(map[rrsetKey][]dns.RR),
+		soas:    make(map[string]dns.RR),
+	}
+
+	z.add(t, root, root.key)
+	z.add(t, root, soaFor(t, "."))
+	z.add(t, root, tld.ds())
+
+	z.add(t, tld, tld.key)
+	z.add(t, tld, soaFor(t, "test."))
+	z.add(t, tld, example.ds())
+
+	z.add(t, example, example.key)
+	z.add(t, example, soaFor(t, "example.test."))
+	z.add(t, example, mustRRs(t, "www.example.test. 300 IN A 192.0.2.1")...)
+
+	// A record whose signature doesn't match the data
+	bogus := mustRRs(t, "bogus.example.test. 300 IN A 192.0.2.2")
+	sig := example.sign(t, mustRRs(t, "bogus.example.test. 300 IN A 192.0.2.99"))
+	z.answers[rrsetKey{Name: "bogus.example.test.", Type: dns.TypeA}] = append(bogus, sig)
+
+	// An unsigned delegation, proven by NSEC in the parent
+	nsec := &dns.NSEC{
+		Hdr:        dns.RR_Header{Name: "insecure.test.", Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: 300},
+		NextDomain: "zzz.test.",
+		TypeBitMap: []uint16{dns.TypeNS, dns.TypeRRSIG, dns.TypeNSEC},
+	}
+	z.denials[rrsetKey{Name: "insecure.test.", Type: dns.TypeDS}] = []dns.RR{nsec, tld.sign(t, []dns.RR{nsec})}
+	z.add(t, nil, soaFor(t, "insecure.test."))
+	z.add(t, nil, mustRRs(t, "www.insecure.test. 300 IN A 192.0.2.3")...)
+
+	// The apex of test., the closest encloser for NSEC3 proofs
+	apex := testNSEC3(t, "test.", "VVVVVVVVVVVVVVVVVVVVVVVVVVVVVVVV", false, dns.TypeSOA, dns.TypeNS, dns.TypeDNSKEY)
+
+	// Unsigned delegations proven by an NSEC3 matching the name, an opt-out
+	// NSEC3 covering it, an NSEC3 without opt-out and nothing at all
+	exact := testNSEC3(t, "nsec3.test.", "VVVVVVVVVVVVVVVVVVVVVVVVVVVVVVVV", false, dns.TypeNS)
+	optOut := testNSEC3(t, "", "VVVVVVVVVVVVVVVVVVVVVVVVVVVVVVVV", true)
+	noOptOut := testNSEC3(t, "", "VVVVVVVVVVVVVVVVVVVVVVVVVVVVVVVV", false)
+
+	for zone, denial := range map[string][]dns.RR{
+		"nsec3.test.":     {exact, tld.sign(t, []dns.RR{exact})},
+		"optout.test.":    {apex, tld.sign(t, []dns.RR{apex}), optOut, tld.sign(t, []dns.RR{optOut})},
+		"notoptout.test.": {apex, tld.sign(t, []dns.RR{apex}), noOptOut, tld.sign(t, []dns.RR{noOptOut})},
+		"noproof.test.":   nil,
+	} {
+		z.denials[rrsetKey{Name: zone, Type: dns.TypeDS}] = denial
+		z.add(t, nil, soaFor(t, zone))
+		z.add(t, nil, mustRRs(t, "www."+zone+" 300 IN A 192.0.2.4")...)
+	}
+
+	return startTestDNSServer(t, z), root.ds().String()
+}
+
+// testNSEC3 Creates an NSEC3 record in "test." for `name`, with no salt or
+// extra iterations. An empty name uses the lowest possible hash, so that with
+// a high `next` the record covers every name
+func testNSEC3(t *testing.T, name string, next string, optOut bool, types ...uint16) *dns.NSEC3 {
+	t.Helper()
+
+	hash := "00000000000000000000000000000000"
+	if name != "" {
+		hash = dns.HashName(name, dns.SHA1, 0, "")
+	}
+
+	var flags uint8
+	if optOut {
+		flags = 1
+	}
+
+	return &dns.NSEC3{
+		Hdr:        dns.RR_Header{Name: strings.ToLower(hash) + ".test.", Rrtype: dns.TypeNSEC3, Class: dns.ClassINET, Ttl: 300},
+		Hash:       dns.SHA1,
+		Flags:      flags,
+		HashLength: 20,
+		NextDomain: next,
+		TypeBitMap: types,
+	}
+}
+
+func dnssecStatusOf(t *testing.T, item *sdp.Item) string {
+	t.Helper()
+
+	dnssec, err := item.GetAttributes().Get("dnssec")
+	if err != nil {
+		t.Fatalf("expected %v to have a dnssec attribute", item.GloballyUniqueName())
+	}
+
+	return dnssec.(map[string]interface{})["status"].(string)
+}
+
+func TestDNSSECValidation(t *testing.T) {
+	t.Parallel()
+
+	server, anchor := newTestSignedHierarchy(t)
+
+	src := DNSAdapter{
+		Servers:        []string{server},
+		ValidateDNSSEC: true,
+		TrustAnchors:   []string{anchor},
+	}
+
+	t.Run("secure", func(t *testing.T) {
+		items, err := src.Search(context.Background(), "global", "www.example.test", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		discovery.TestValidateItems(t, items)
+
+		found := make(map[string]bool)
+		for _, item := range items {
+			found[item.GloballyUniqueName()] = true
+		}
+
+		for _, name := range []string{
+			"global.dns.www.example.test",
+			"global.dns-dnskey..",
+			"global.dns-dnskey.test",
+			"global.dns-dnskey.example.test",
+			"global.dns-ds.test",
+			"global.dns-ds.example.test",
+		} {
+			if !found[name] {
+				t.Errorf("expected to find %v, got %v", name, found)
+			}
+		}
+
+		if status := dnssecStatusOf(t, items[0]); status != "secure" {
+			t.Errorf("expected secure, got %v", status)
+		}
+
+		if ref := items[0].GetLinkedItems()[0].GetItem(); ref.GetType() != "dns-dnskey" || ref.GetUniqueAttributeValue() != "example.test" {
+			t.Errorf("expected link to example.test keys, got %v", ref)
+		}
+	})
+
+	t.Run("bogus", func(t *testing.T) {
+		items, err := src.Search(context.Background(), "global", "bogus.example.test", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if status := dnssecStatusOf(t, items[0]); status != "bogus" {
+			t.Errorf("expected bogus, got %v", status)
+		}
+	})
+
+	for _, test := range []struct {
+		Name     string
+		Query    string
+		Expected string
+	}{
+		{Name: "insecure", Query: "www.insecure.test", Expected: "insecure"},
+		{Name: "NSEC3 exact match", Query: "www.nsec3.test", Expected: "insecure"},
+		{Name: "NSEC3 opt-out", Query: "www.optout.test", Expected: "insecure"},
+		{Name: "NSEC3 without opt-out", Query: "www.notoptout.test", Expected: "bogus"},
+		{Name: "no proof", Query: "www.noproof.test", Expected: "indeterminate"},
+	} {
+		t.Run(test.Name, func(t *testing.T) {
+			items, err := src.Search(context.Background(), "global", test.Query, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if status := dnssecStatusOf(t, items[0]); status != test.Expected {
+				t.Errorf("expected %v, got %v", test.Expected, status)
+			}
+		})
+	}
+
+	t.Run("wrong trust anchor", func(t *testing.T) {
+		other := newTestSigner(t, ".")
+
+		src := DNSAdapter{
+			Servers:        []string{server},
+			ValidateDNSSEC: true,
+			TrustAnchors:   []string{other.ds().String()},
+		}
+
+		items, err := src.Search(context.Background(), "global", "www.example.test", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if status := dnssecStatusOf(t, items[0]); status != "bogus" {
+			t.Errorf("expected bogus, got %v", status)
+		}
+	})
+
+	t.Run("missing keys", func(t *testing.T) {
+		// A server that answers but never returns any keys
+		plain := newTestDNSServer(t,
+			"www.example.test. 300 IN A 192.0.2.1",
+			"www.example.test. 300 IN RRSIG A 13 3 300 20300101000000 20000101000000 12345 example.test. AAAA",
+		)
+
+		src := DNSAdapter{
+			Servers:        []string{plain},
+			ValidateDNSSEC: true,
+			TrustAnchors:   []string{anchor},
+		}
+
+		items, err := src.Search(context.Background(), "global", "www.example.test", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The root has no keys, so it can't be matched to the anchor
+		if status := dnssecStatusOf(t, items[0]); status != "bogus" {
+			t.Errorf("expected bogus, got %v", status)
+		}
+	})
+}
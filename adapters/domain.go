@@ -0,0 +1,263 @@
+package adapters
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/overmindtech/sdp-go"
+	"golang.org/x/net/publicsuffix"
+)
+
+// DomainParts The parts of a domain name according to the Public Suffix List
+type DomainParts struct {
+	// The normalised name e.g. "www.example.co.uk"
+	Name string
+	// The public suffix e.g. "co.uk"
+	PublicSuffix string
+	// Whether the suffix is managed by ICANN, rather than being a private
+	// suffix such as "blogspot.com"
+	ICANN bool
+	// Whether the suffix is a private suffix. If neither this or ICANN is
+	// true then the suffix isn't in the list
+	Private bool
+	// The registrable domain, one label more than the public suffix e.g.
+	// "example.co.uk". This is empty if the name is itself a public suffix
+	RegistrableDomain string
+	// The domain registered with ICANN. This is the same as the registrable
+	// domain unless the suffix is private e.g. "blogspot.com" for
+	// "foo.blogspot.com"
+	ICANNRegistrableDomain string
+}
+
+// SplitDomain Splits a domain name into its public suffix and registrable
+// domain using the embedded Public Suffix List
+func SplitDomain(name string) (*DomainParts, error) {
+	name, err := NormalizeDomain(name)
+	if err != nil {
+		return nil, err
+	}
+
+	suffix, icann := publicsuffix.PublicSuffix(name)
+
+	parts := &DomainParts{
+		Name:         name,
+		PublicSuffix: suffix,
+		ICANN:        icann,
+		// Names that aren't in the list use the default rule, which is a
+		// single label that isn't managed by ICANN. Every private suffix
+		// has more than one label
+		Private: !icann && strings.Contains(suffix, "."),
+	}
+
+	if name == suffix {
+		return parts, fmt.Errorf("%v is a public suffix", name)
+	}
+
+	parts.RegistrableDomain = registrablePart(name, suffix)
+	parts.ICANNRegistrableDomain = parts.RegistrableDomain
+
+	if parts.Private {
+		// Find the ICANN suffix that the private suffix is under
+		icannSuffix := suffix
+		for {
+			_, rest, ok := strings.Cut(icannSuffix, ".")
+			if !ok {
+				break
+			}
+
+			icannSuffix = rest
+			if s, icann := publicsuffix.PublicSuffix(icannSuffix); icann && s == icannSuffix {
+				break
+			}
+		}
+
+		parts.ICANNRegistrableDomain = registrablePart(name, icannSuffix)
+	}
+
+	return parts, nil
+}
+
+// registrablePart Returns the suffix plus the label before it
+func registrablePart(name string, suffix string) string {
+	rest := strings.TrimSuffix(name, "."+suffix)
+	if i := strings.LastIndex(rest, "."); i >= 0 {
+		rest = rest[i+1:]
+	}
+
+	return rest + "." + suffix
+}
+
+// rdapCandidates Returns the domains that could have an RDAP record, in the
+// order that they should be queried
+func (p *DomainParts) rdapCandidates() []string {
+	candidates := []string{p.RegistrableDomain}
+
+	if p.ICANNRegistrableDomain != p.RegistrableDomain {
+		candidates = append(candidates, p.ICANNRegistrableDomain)
+	}
+
+	return candidates
+}
+
+// DomainAdapter Returns information about a domain name from the Public
+// Suffix List, such as the registrable domain that it belongs to. This
+// doesn't make any network requests
+type DomainAdapter struct{}
+
+// Type The type of items that this adapter is capable of finding
+func (s *DomainAdapter) Type() string {
+	return "domain"
+}
+
+// Descriptive name for the adapter, used in logging and metadata
+func (s *DomainAdapter) Name() string {
+	return "stdlib-domain"
+}
+
+// Weighting of duplicate adapters
+func (s *DomainAdapter) Weight() int {
+	return 100
+}
+
+// Metadata Returns metadata about the adapter
+func (s *DomainAdapter) Metadata() *sdp.AdapterMetadata {
+	return domainMetadata
+}
+
+var domainMetadata = Metadata.Register(&sdp.AdapterMetadata{
+	DescriptiveName: "Domain",
+	Type:            "domain",
+	SupportedQueryMethods: &sdp.AdapterSupportedQueryMethods{
+		Get:            true,
+		GetDescription: "A domain name e.g. \"www.example.co.uk\". Returns the registrable domain and public suffix that it belongs to",
+	},
+	PotentialLinks: []string{"domain", "dns", "rdap-domain"},
+	Category:       sdp.AdapterCategory_ADAPTER_CATEGORY_NETWORK,
+})
+
+// List of scopes that this adapter is capable of find items for
+func (s *DomainAdapter) Scopes() []string {
+	return []string{
+		"global",
+	}
+}
+
+// Get Returns a domain item for the name
+func (s *DomainAdapter) Get(ctx context.Context, scope string, query string, ignoreCache bool) (*sdp.Item, error) {
+	if scope != "global" {
+		return nil, &sdp.QueryError{
+			ErrorType:   sdp.QueryError_NOSCOPE,
+			ErrorString: "domain is only supported in the 'global' scope",
+			Scope:       scope,
+		}
+	}
+
+	if net.ParseIP(query) != nil {
+		return nil, &sdp.QueryError{
+			ErrorType:   sdp.QueryError_OTHER,
+			ErrorString: fmt.Sprintf("%v is an IP address, not a domain", query),
+			Scope:       scope,
+		}
+	}
+
+	parts, err := SplitDomain(query)
+	if parts == nil {
+		return nil, &sdp.QueryError{
+			ErrorType:   sdp.QueryError_OTHER,
+			ErrorString: err.Error(),
+			Scope:       scope,
+		}
+	}
+
+	// Public suffixes are still returned, but they don't have a registrable
+	// domain
+	attributes := map[string]interface{}{
+		"name":           parts.Name,
+		"publicSuffix":   parts.PublicSuffix,
+		"icann":          parts.ICANN,
+		"private":        parts.Private,
+		"isPublicSuffix": err != nil,
+	}
+
+	if parts.RegistrableDomain != "" {
+		attributes["registrableDomain"] = parts.RegistrableDomain
+		attributes["isRegistrableDomain"] = parts.Name == parts.RegistrableDomain
+
+		if parts.ICANNRegistrableDomain != parts.RegistrableDomain {
+			attributes["icannRegistrableDomain"] = parts.ICANNRegistrableDomain
+		}
+	}
+
+	attrs, err := sdp.ToAttributes(withUnicodeName(attributes))
+	if err != nil {
+		return nil, &sdp.QueryError{
+			ErrorType:   sdp.QueryError_OTHER,
+			ErrorString: err.Error(),
+			Scope:       scope,
+		}
+	}
+
+	item := &sdp.Item{
+		Type:            "domain",
+		UniqueAttribute: "name",
+		Scope:           "global",
+		Attributes:      attrs,
+		LinkedItemQueries: []*sdp.LinkedItemQuery{
+			{
+				Query: &sdp.Query{
+					Type:   "dns",
+					Method: sdp.QueryMethod_SEARCH,
+					Query:  parts.Name,
+					Scope:  "global",
+				},
+				BlastPropagation: &sdp.BlastPropagation{
+					// The DNS records are what the domain resolves to
+					In:  true,
+					Out: false,
+				},
+			},
+		},
+	}
+
+	if parts.RegistrableDomain != "" {
+		item.LinkedItemQueries = append(item.LinkedItemQueries, &sdp.LinkedItemQuery{
+			Query: &sdp.Query{
+				Type:   "rdap-domain",
+				Method: sdp.QueryMethod_SEARCH,
+				Query:  parts.Name,
+				Scope:  "global",
+			},
+			BlastPropagation: &sdp.BlastPropagation{
+				// Changes to the registration, such as it expiring, affect
+				// the domain
+				In:  true,
+				Out: false,
+			},
+		})
+
+		if parts.Name != parts.RegistrableDomain {
+			// Subdomains are affected by changes to their parent
+			item.LinkedItemQueries = append(item.LinkedItemQueries, &sdp.LinkedItemQuery{
+				Query: &sdp.Query{
+					Type:   "domain",
+					Method: sdp.QueryMethod_GET,
+					Query:  parts.RegistrableDomain,
+					Scope:  "global",
+				},
+				BlastPropagation: &sdp.BlastPropagation{
+					In:  true,
+					Out: false,
+				},
+			})
+		}
+	}
+
+	return item, nil
+}
+
+// List is not supported
+func (s *DomainAdapter) List(ctx context.Context, scope string, ignoreCache bool) ([]*sdp.Item, error) {
+	return make([]*sdp.Item, 0), nil
+}
@@ -0,0 +1,140 @@
+package adapters
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/overmindtech/discovery"
+)
+
+func TestSplitDomain(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Input             string
+		RegistrableDomain string
+		PublicSuffix      string
+		ICANN             bool
+		Private           bool
+		Candidates        []string
+		Invalid           bool
+	}{
+		{
+			Input:             "www.google.com",
+			RegistrableDomain: "google.com",
+			PublicSuffix:      "com",
+			ICANN:             true,
+			Candidates:        []string{"google.com"},
+		},
+		{
+			Input:             "www.example.co.uk.",
+			RegistrableDomain: "example.co.uk",
+			PublicSuffix:      "co.uk",
+			ICANN:             true,
+			Candidates:        []string{"example.co.uk"},
+		},
+		{
+			Input:             "a.b.foo.blogspot.com",
+			RegistrableDomain: "foo.blogspot.com",
+			PublicSuffix:      "blogspot.com",
+			Private:           true,
+			Candidates:        []string{"foo.blogspot.com", "blogspot.com"},
+		},
+		{
+			Input:             "Bücher.example.de",
+			RegistrableDomain: "example.de",
+			PublicSuffix:      "de",
+			ICANN:             true,
+			Candidates:        []string{"example.de"},
+		},
+		{
+			Input:             "host.internal",
+			RegistrableDomain: "host.internal",
+			PublicSuffix:      "internal",
+			Candidates:        []string{"host.internal"},
+		},
+		{Input: "co.uk", Invalid: true},
+		{Input: "com", Invalid: true},
+		{Input: "", Invalid: true},
+	}
+
+	for _, test := range tests {
+		parts, err := SplitDomain(test.Input)
+
+		if test.Invalid {
+			if err == nil {
+				t.Errorf("expected %q to be invalid, got %+v", test.Input, parts)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("unexpected error for %q: %v", test.Input, err)
+			continue
+		}
+
+		if parts.RegistrableDomain != test.RegistrableDomain {
+			t.Errorf("expected %q to have registrable domain %q, got %q", test.Input, test.RegistrableDomain, parts.RegistrableDomain)
+		}
+
+		if parts.PublicSuffix != test.PublicSuffix {
+			t.Errorf("expected %q to have suffix %q, got %q", test.Input, test.PublicSuffix, parts.PublicSuffix)
+		}
+
+		if parts.ICANN != test.ICANN || parts.Private != test.Private {
+			t.Errorf("expected %q to have icann=%v private=%v, got icann=%v private=%v", test.Input, test.ICANN, test.Private, parts.ICANN, parts.Private)
+		}
+
+		if candidates := parts.rdapCandidates(); !reflect.DeepEqual(candidates, test.Candidates) {
+			t.Errorf("expected %q to have RDAP candidates %v, got %v", test.Input, test.Candidates, candidates)
+		}
+	}
+}
+
+func TestDomainGet(t *testing.T) {
+	t.Parallel()
+
+	src := DomainAdapter{}
+
+	t.Run("subdomain", func(t *testing.T) {
+		item, err := src.Get(context.Background(), "global", "www.example.co.uk", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		discovery.TestValidateItem(t, item)
+
+		if rd, _ := item.GetAttributes().Get("registrableDomain"); rd != "example.co.uk" {
+			t.Errorf("expected registrableDomain to be example.co.uk, got %v", rd)
+		}
+
+		assertLinks(t, item, []string{
+			"dns SEARCH www.example.co.uk",
+			"rdap-domain SEARCH www.example.co.uk",
+			"domain GET example.co.uk",
+		})
+	})
+
+	t.Run("public suffix", func(t *testing.T) {
+		item, err := src.Get(context.Background(), "global", "co.uk", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ps, _ := item.GetAttributes().Get("isPublicSuffix"); ps != true {
+			t.Errorf("expected isPublicSuffix to be true, got %v", ps)
+		}
+
+		assertLinks(t, item, []string{
+			"dns SEARCH co.uk",
+		})
+	})
+
+	t.Run("IP address", func(t *testing.T) {
+		_, err := src.Get(context.Background(), "global", "192.0.2.1", false)
+		if err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
@@ -0,0 +1,678 @@
+package adapters
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/overmindtech/sdp-go"
+	"github.com/overmindtech/sdpcache"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+const emailDomainCacheDuration = 5 * time.Minute
+
+// The maximum size of an MTA-STS policy file, see RFC 8461 section 3.3
+const maxMTASTSPolicySize = 64 * 1024
+
+// DefaultDKIMSelectors Commonly used DKIM selectors. There is no way to list
+// the selectors that a domain uses, so these are checked instead
+var DefaultDKIMSelectors = []string{
+	"default",
+	"dkim",
+	"google",
+	"k1",
+	"k2",
+	"mail",
+	"s1",
+	"s2",
+	"selector1",
+	"selector2",
+}
+
+// EmailDomainAdapter Returns the email security posture of a domain, based on
+// its SPF, DMARC, DKIM, MTA-STS, TLS-RPT and BIMI records
+type EmailDomainAdapter struct {
+	// The DNS adapter to use for lookups. Sharing this with the `dns` adapter
+	// means that the health of servers is shared. Defaults to a DNSAdapter
+	// using the default servers
+	DNS *DNSAdapter
+
+	// The client used to fetch MTA-STS policies. Defaults to a client that
+	// doesn't follow redirects, as required by RFC 8461
+	HTTPClient *http.Client
+
+	// Creates the default HTTPClient, which sets the proxy and root CAs
+	ClientFactory *HTTPClientFactory
+
+	// DKIM selectors to check. Defaults to DefaultDKIMSelectors
+	DKIMSelectors []string
+
+	defaultsOnce sync.Once
+
+	cache       *sdpcache.Cache // The sdpcache of this adapter
+	cacheInitMu sync.Mutex      // Mutex to ensure cache is only initialised once
+}
+
+func (s *EmailDomainAdapter) ensureCache() {
+	s.cacheInitMu.Lock()
+	defer s.cacheInitMu.Unlock()
+
+	if s.cache == nil {
+		s.cache = sdpcache.NewCache()
+	}
+}
+
+func (s *EmailDomainAdapter) Cache() *sdpcache.Cache {
+	s.ensureCache()
+	return s.cache
+}
+
+func (s *EmailDomainAdapter) ensureDefaults() {
+	s.defaultsOnce.Do(func() {
+		if s.DNS == nil {
+			s.DNS = &DNSAdapter{}
+		}
+
+		if s.HTTPClient == nil {
+			s.HTTPClient = &http.Client{
+				Transport: otelhttp.NewTransport(s.ClientFactory.Transport()),
+				Timeout:   10 * time.Second,
+				CheckRedirect: func(req *http.Request, via []*http.Request) error {
+					return http.ErrUseLastResponse
+				},
+			}
+		}
+	})
+}
+
+func (s *EmailDomainAdapter) getDKIMSelectors() []string {
+	if len(s.DKIMSelectors) == 0 {
+		return DefaultDKIMSelectors
+	}
+	return s.DKIMSelectors
+}
+
+// Type The type of items that this adapter is capable of finding
+func (s *EmailDomainAdapter) Type() string {
+	return "email-domain"
+}
+
+// Descriptive name for the adapter, used in logging and metadata
+func (s *EmailDomainAdapter) Name() string {
+	return "stdlib-email-domain"
+}
+
+// Weighting of duplicate adapters
+func (s *EmailDomainAdapter) Weight() int {
+	return 100
+}
+
+// Metadata Returns metadata about the adapter
+func (s *EmailDomainAdapter) Metadata() *sdp.AdapterMetadata {
+	return emailDomainMetadata
+}
+
+var emailDomainMetadata = Metadata.Register(&sdp.AdapterMetadata{
+	DescriptiveName: "Email Domain",
+	Type:            "email-domain",
+	SupportedQueryMethods: &sdp.AdapterSupportedQueryMethods{
+		Get:            true,
+		GetDescription: "A domain to check the email security records of, including SPF, DMARC, DKIM, MTA-STS, TLS-RPT and BIMI",
+	},
+	PotentialLinks: []string{"dns", "http", "ip", "spf"},
+	Category:       sdp.AdapterCategory_ADAPTER_CATEGORY_SECURITY,
+})
+
+// List of scopes that this adapter is capable of find items for
+func (s *EmailDomainAdapter) Scopes() []string {
+	return []string{
+		"global",
+	}
+}
+
+// Get Fetches and parses the email security records for a domain
+func (s *EmailDomainAdapter) Get(ctx context.Context, scope string, query string, ignoreCache bool) (*sdp.Item, error) {
+	if scope != "global" {
+		return nil, &sdp.QueryError{
+			ErrorType:   sdp.QueryError_NOSCOPE,
+			ErrorString: "email-domain is only supported in the 'global' scope",
+			Scope:       scope,
+		}
+	}
+
+	domain, qErr := normalizeDomainQuery(query, scope)
+	if qErr != nil {
+		return nil, qErr
+	}
+
+	if net.ParseIP(domain) != nil {
+		return nil, &sdp.QueryError{
+			ErrorType:   sdp.QueryError_OTHER,
+			ErrorString: fmt.Sprintf("%v is not a valid domain", query),
+			Scope:       scope,
+		}
+	}
+
+	s.ensureCache()
+	cacheHit, ck, cachedItems, qErr := s.cache.Lookup(ctx, s.Name(), sdp.QueryMethod_GET, scope, s.Type(), domain, ignoreCache)
+	if qErr != nil {
+		return nil, qErr
+	}
+	if cacheHit && len(cachedItems) > 0 {
+		return cachedItems[0], nil
+	}
+
+	s.ensureDefaults()
+
+	item, err := s.emailDomainItem(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	s.cache.StoreItem(item, emailDomainCacheDuration, ck)
+
+	return item, nil
+}
+
+// List is not supported
+func (s *EmailDomainAdapter) List(ctx context.Context, scope string, ignoreCache bool) ([]*sdp.Item, error) {
+	return make([]*sdp.Item, 0), nil
+}
+
+// linkedQueries Collects linked item queries, ignoring duplicates
+type linkedQueries struct {
+	queries []*sdp.LinkedItemQuery
+	seen    map[string]bool
+}
+
+func (l *linkedQueries) add(typ string, method sdp.QueryMethod, query string, in bool, out bool) {
+	key := typ + " " + method.String() + " " + query
+	if l.seen == nil {
+		l.seen = make(map[string]bool)
+	}
+
+	if l.seen[key] {
+		return
+	}
+	l.seen[key] = true
+
+	l.queries = append(l.queries, &sdp.LinkedItemQuery{
+		Query: &sdp.Query{
+			Type:   typ,
+			Method: method,
+			Query:  query,
+			Scope:  "global",
+		},
+		BlastPropagation: &sdp.BlastPropagation{
+			In:  in,
+			Out: out,
+		},
+	})
+}
+
+// addName Links to a DNS name, changes to which will affect mail for the
+// domain
+func (l *linkedQueries) addName(name string) {
+	name = trimDnsSuffix(name)
+
+	if name == "" || strings.ContainsAny(name, "%*") {
+		// Macros and wildcards can't be resolved
+		return
+	}
+
+	l.add("dns", sdp.QueryMethod_SEARCH, name, true, false)
+}
+
+// addURL Links to an HTTPS URL that is used to fetch part of the policy
+func (l *linkedQueries) addURL(url string) {
+	if strings.HasPrefix(url, "https://") {
+		l.add("http", sdp.QueryMethod_GET, url, true, false)
+	}
+}
+
+// emailDomainItem Fetches every record and converts them to an item
+func (s *EmailDomainAdapter) emailDomainItem(ctx context.Context, domain string) (*sdp.Item, error) {
+	links := &linkedQueries{}
+
+	// The domain itself, changing its records changes everything below
+	links.addName(domain)
+
+	attributes := map[string]interface{}{
+		"domain": domain,
+	}
+
+	if spf := s.spfAttributes(ctx, domain, links); spf != nil {
+		attributes["spf"] = spf
+	}
+
+	if dmarc := s.dmarcAttributes(ctx, domain, links); dmarc != nil {
+		attributes["dmarc"] = dmarc
+	}
+
+	if dkim := s.dkimAttributes(ctx, domain, links); len(dkim) > 0 {
+		attributes["dkim"] = dkim
+	}
+
+	if mtaSTS := s.mtaSTSAttributes(ctx, domain, links); mtaSTS != nil {
+		attributes["mtaSts"] = mtaSTS
+	}
+
+	if tlsRPT := s.tlsRPTAttributes(ctx, domain, links); tlsRPT != nil {
+		attributes["tlsRpt"] = tlsRPT
+	}
+
+	if bimi := s.bimiAttributes(ctx, domain, links); bimi != nil {
+		attributes["bimi"] = bimi
+	}
+
+	attrs, err := sdp.ToAttributes(attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sdp.Item{
+		Type:              "email-domain",
+		UniqueAttribute:   "domain",
+		Scope:             "global",
+		Attributes:        attrs,
+		LinkedItemQueries: links.queries,
+	}, nil
+}
+
+// spfAttributes Expands the SPF record for a domain
+func (s *EmailDomainAdapter) spfAttributes(ctx context.Context, domain string, links *linkedQueries) map[string]interface{} {
+	tree, err := ExpandSPF(ctx, s.DNS.LookupTXT, domain)
+	if errors.Is(err, errNoSPFRecord) {
+		return nil
+	}
+	if err != nil {
+		return map[string]interface{}{
+			"error": err.Error(),
+		}
+	}
+
+	mechanisms := make([]string, 0, len(tree.Record.Mechanisms))
+	for _, m := range tree.Record.Mechanisms {
+		mechanisms = append(mechanisms, m.String())
+	}
+
+	includes := make([]string, 0)
+	var linkTree func(t *SPFTree)
+	linkTree = func(t *SPFTree) {
+		for _, m := range t.Record.Mechanisms {
+			switch m.Type {
+			case "include", "a", "mx", "exists":
+				name, _, _ := strings.Cut(m.Value, "/")
+				links.addName(name)
+			case "ip4", "ip6":
+				// Only single addresses can be linked to the ip adapter
+				ip, prefix, _ := strings.Cut(m.Value, "/")
+				if prefix == "" || prefix == "32" || prefix == "128" {
+					// Anyone with this IP can send mail as the domain
+					links.add("ip", sdp.QueryMethod_GET, ip, true, false)
+				}
+			}
+		}
+
+		for _, child := range t.children() {
+			includes = append(includes, child.Domain)
+			links.addName(child.Domain)
+			linkTree(child)
+		}
+	}
+	linkTree(tree)
+
+	// The spf item has the full include tree
+	links.add("spf", sdp.QueryMethod_GET, domain, true, false)
+
+	spf := map[string]interface{}{
+		"record":              tree.Record.Raw,
+		"mechanisms":          mechanisms,
+		"all":                 tree.Record.All(),
+		"includes":            includes,
+		"lookups":             tree.Lookups,
+		"lookupLimitExceeded": tree.OverLimit(),
+	}
+
+	if tree.Record.Redirect != "" {
+		spf["redirect"] = tree.Record.Redirect
+	}
+
+	if errs := tree.AllErrors(); len(errs) > 0 {
+		spf["errors"] = errs
+	}
+
+	return spf
+}
+
+// dmarcAttributes Parses the DMARC record for a domain, see RFC 7489. If the
+// domain doesn't have a record then the record of its organizational domain
+// is used, along with its subdomain policy (section 6.6.3)
+func (s *EmailDomainAdapter) dmarcAttributes(ctx context.Context, domain string, links *linkedQueries) map[string]interface{} {
+	policyDomain := domain
+	name := "_dmarc." + policyDomain
+
+	record, tags, err := s.lookupTagRecord(ctx, name, "DMARC1")
+	if err != nil {
+		return map[string]interface{}{"error": err.Error()}
+	}
+
+	if record == "" {
+		parts, err := SplitDomain(domain)
+		if err != nil || parts.RegistrableDomain == "" || parts.RegistrableDomain == domain {
+			return nil
+		}
+
+		policyDomain = parts.RegistrableDomain
+		name = "_dmarc." + policyDomain
+
+		record, tags, err = s.lookupTagRecord(ctx, name, "DMARC1")
+		if err != nil {
+			return map[string]interface{}{"error": err.Error()}
+		}
+		if record == "" {
+			return nil
+		}
+	}
+
+	links.addName(name)
+
+	// Defaults are from RFC 7489 section 6.3
+	subdomainPolicy := tagOrDefault(tags, "sp", tags["p"])
+
+	policy := tags["p"]
+	if policyDomain != domain {
+		policy = subdomainPolicy
+	}
+
+	dmarc := map[string]interface{}{
+		"record": record,
+		// The domain that the record was found on, either the domain itself
+		// or its organizational domain
+		"policyDomain":    policyDomain,
+		"policy":          policy,
+		"subdomainPolicy": subdomainPolicy,
+		"percentage":      tagOrDefault(tags, "pct", "100"),
+		"adkim":           tagOrDefault(tags, "adkim", "r"),
+		"aspf":            tagOrDefault(tags, "aspf", "r"),
+	}
+
+	if rua, ok := tags["rua"]; ok {
+		dmarc["rua"] = splitTagList(rua)
+	}
+
+	if ruf, ok := tags["ruf"]; ok {
+		dmarc["ruf"] = splitTagList(ruf)
+	}
+
+	if fo, ok := tags["fo"]; ok {
+		dmarc["failureOptions"] = fo
+	}
+
+	return dmarc
+}
+
+// dkimAttributes Checks each of the configured DKIM selectors, see RFC 6376
+func (s *EmailDomainAdapter) dkimAttributes(ctx context.Context, domain string, links *linkedQueries) []map[string]interface{} {
+	keys := make([]map[string]interface{}, 0)
+
+	for _, selector := range s.getDKIMSelectors() {
+		name := selector + "._domainkey." + domain
+
+		txts, err := s.DNS.LookupTXT(ctx, name)
+		if err != nil || len(txts) == 0 {
+			continue
+		}
+
+		// The version tag is optional for DKIM, so use the first record
+		// that has a public key
+		for _, txt := range txts {
+			tags := parseTagList(txt)
+
+			p, ok := tags["p"]
+			if !ok {
+				continue
+			}
+
+			links.addName(name)
+
+			keys = append(keys, map[string]interface{}{
+				"selector": selector,
+				"record":   txt,
+				"keyType":  tagOrDefault(tags, "k", "rsa"),
+				// An empty public key means that the key has been revoked
+				"revoked": p == "",
+			})
+
+			break
+		}
+	}
+
+	return keys
+}
+
+// mtaSTSAttributes Checks the MTA-STS record and fetches the policy, see RFC
+// 8461
+func (s *EmailDomainAdapter) mtaSTSAttributes(ctx context.Context, domain string, links *linkedQueries) map[string]interface{} {
+	name := "_mta-sts." + domain
+
+	record, tags, err := s.lookupTagRecord(ctx, name, "STSv1")
+	if err != nil {
+		return map[string]interface{}{"error": err.Error()}
+	}
+	if record == "" {
+		return nil
+	}
+
+	links.addName(name)
+
+	mtaSTS := map[string]interface{}{
+		"record": record,
+		"id":     tags["id"],
+	}
+
+	policyHost := "mta-sts." + domain
+	policyURL := "https://" + policyHost + "/.well-known/mta-sts.txt"
+
+	mtaSTS["policyUrl"] = policyURL
+	links.addName(policyHost)
+	links.addURL(policyURL)
+
+	policy, err := s.fetchMTASTSPolicy(ctx, policyURL)
+	if err != nil {
+		mtaSTS["policyError"] = err.Error()
+		return mtaSTS
+	}
+
+	mtaSTS["policy"] = policy
+
+	if mxs, ok := policy["mx"].([]string); ok {
+		for _, mx := range mxs {
+			links.addName(mx)
+		}
+	}
+
+	return mtaSTS
+}
+
+// fetchMTASTSPolicy Fetches and parses an MTA-STS policy file
+func (s *EmailDomainAdapter) fetchMTASTSPolicy(ctx context.Context, url string) (map[string]interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("policy returned %v", res.Status)
+	}
+
+	policy := map[string]interface{}{}
+	mxs := make([]string, 0)
+
+	scanner := bufio.NewScanner(io.LimitReader(res.Body, maxMTASTSPolicySize))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "version":
+			policy["version"] = value
+		case "mode":
+			policy["mode"] = value
+		case "max_age":
+			if maxAge, err := strconv.Atoi(value); err == nil {
+				policy["maxAge"] = maxAge
+			}
+		case "mx":
+			mxs = append(mxs, value)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if policy["version"] != "STSv1" {
+		return nil, fmt.Errorf("policy has unsupported version %q", policy["version"])
+	}
+
+	policy["mx"] = mxs
+
+	return policy, nil
+}
+
+// tlsRPTAttributes Parses the SMTP TLS reporting record, see RFC 8460
+func (s *EmailDomainAdapter) tlsRPTAttributes(ctx context.Context, domain string, links *linkedQueries) map[string]interface{} {
+	name := "_smtp._tls." + domain
+
+	record, tags, err := s.lookupTagRecord(ctx, name, "TLSRPTv1")
+	if err != nil {
+		return map[string]interface{}{"error": err.Error()}
+	}
+	if record == "" {
+		return nil
+	}
+
+	links.addName(name)
+
+	rua := splitTagList(tags["rua"])
+	for _, uri := range rua {
+		links.addURL(uri)
+	}
+
+	return map[string]interface{}{
+		"record": record,
+		"rua":    rua,
+	}
+}
+
+// bimiAttributes Parses the default BIMI record
+func (s *EmailDomainAdapter) bimiAttributes(ctx context.Context, domain string, links *linkedQueries) map[string]interface{} {
+	name := "default._bimi." + domain
+
+	record, tags, err := s.lookupTagRecord(ctx, name, "BIMI1")
+	if err != nil {
+		return map[string]interface{}{"error": err.Error()}
+	}
+	if record == "" {
+		return nil
+	}
+
+	links.addName(name)
+
+	bimi := map[string]interface{}{
+		"record": record,
+	}
+
+	if location := tags["l"]; location != "" {
+		bimi["location"] = location
+		links.addURL(location)
+	}
+
+	if authority := tags["a"]; authority != "" {
+		bimi["authority"] = authority
+		links.addURL(authority)
+	}
+
+	return bimi
+}
+
+// lookupTagRecord Finds the TXT record with the given version tag and parses
+// its tags. Returns an empty record if there isn't one
+func (s *EmailDomainAdapter) lookupTagRecord(ctx context.Context, name string, version string) (string, map[string]string, error) {
+	txts, err := s.DNS.LookupTXT(ctx, name)
+	if err != nil {
+		return "", nil, err
+	}
+
+	for _, txt := range txts {
+		tags := parseTagList(txt)
+
+		if strings.EqualFold(tags["v"], version) {
+			return txt, tags, nil
+		}
+	}
+
+	return "", nil, nil
+}
+
+// parseTagList Parses a record in the "tag=value; tag=value" format used by
+// DKIM, DMARC, MTA-STS, TLS-RPT and BIMI
+func parseTagList(record string) map[string]string {
+	tags := make(map[string]string)
+
+	for _, part := range strings.Split(record, ";") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+
+		key = strings.ToLower(strings.TrimSpace(key))
+		if _, exists := tags[key]; !exists {
+			tags[key] = strings.TrimSpace(value)
+		}
+	}
+
+	return tags
+}
+
+// splitTagList Splits a comma separated tag value such as a list of URIs
+func splitTagList(value string) []string {
+	values := make([]string, 0)
+
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+
+	return values
+}
+
+func tagOrDefault(tags map[string]string, tag string, def string) string {
+	if value, ok := tags[tag]; ok && value != "" {
+		return value
+	}
+
+	return def
+}
@@ -0,0 +1,270 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/overmindtech/discovery"
+	"github.com/overmindtech/sdp-go"
+)
+
+// newTestMTASTSClient Returns a client that sends every request to the
+// server. The test certificate is valid for *.example.com
+func newTestMTASTSClient(server *httptest.Server) *http.Client {
+	client := server.Client()
+
+	transport := client.Transport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+	}
+
+	client.Transport = transport
+
+	return client
+}
+
+func TestEmailDomainGet(t *testing.T) {
+	t.Parallel()
+
+	dnsServer := newTestDNSServer(t,
+		`example.com. 300 IN TXT "v=spf1 ip4:192.0.2.1 include:_spf.example.net -all"`,
+		`_spf.example.net. 300 IN TXT "v=spf1 ip4:198.51.100.0/24 a mx ~all"`,
+		`_dmarc.example.com. 300 IN TXT "v=DMARC1; p=reject; rua=mailto:dmarc@example.com, mailto:other@example.net"`,
+		`selector1._domainkey.example.com. 300 IN TXT "v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="`,
+		`selector2._domainkey.example.com. 300 IN TXT "v=DKIM1; p="`,
+		`_mta-sts.example.com. 300 IN TXT "v=STSv1; id=20240101T000000"`,
+		`_smtp._tls.example.com. 300 IN TXT "v=TLSRPTv1; rua=mailto:tls@example.com,https://reports.example.com/tls"`,
+		`default._bimi.example.com. 300 IN TXT "v=BIMI1; l=https://example.com/logo.svg; a="`,
+	)
+
+	policyServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "mta-sts.example.com" || r.URL.Path != "/.well-known/mta-sts.txt" {
+			http.NotFound(w, r)
+			return
+		}
+
+		fmt.Fprint(w, "version: STSv1\r\nmode: enforce\r\nmx: mail.example.com\r\nmx: *.mail.example.net\r\nmax_age: 604800\r\n")
+	}))
+	t.Cleanup(policyServer.Close)
+
+	src := EmailDomainAdapter{
+		DNS: &DNSAdapter{
+			Servers: []string{dnsServer},
+		},
+		HTTPClient: newTestMTASTSClient(policyServer),
+	}
+
+	item, err := src.Get(context.Background(), "global", "example.com", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	discovery.TestValidateItem(t, item)
+
+	get := func(path string) interface{} {
+		t.Helper()
+
+		value, err := item.GetAttributes().Get(path)
+		if err != nil {
+			t.Fatalf("missing attribute %v", path)
+		}
+
+		return value
+	}
+
+	t.Run("SPF", func(t *testing.T) {
+		spf := get("spf").(map[string]interface{})
+
+		// include, then a and mx in the included record
+		if spf["lookups"] != float64(3) {
+			t.Errorf("expected 3 lookups, got %v", spf["lookups"])
+		}
+
+		if spf["all"] != "-" {
+			t.Errorf("expected -all, got %v", spf["all"])
+		}
+
+		if !reflect.DeepEqual(spf["includes"], []interface{}{"_spf.example.net"}) {
+			t.Errorf("unexpected includes %v", spf["includes"])
+		}
+	})
+
+	t.Run("DMARC", func(t *testing.T) {
+		dmarc := get("dmarc").(map[string]interface{})
+
+		if dmarc["policy"] != "reject" || dmarc["subdomainPolicy"] != "reject" {
+			t.Errorf("unexpected policies %v", dmarc)
+		}
+
+		if !reflect.DeepEqual(dmarc["rua"], []interface{}{"mailto:dmarc@example.com", "mailto:other@example.net"}) {
+			t.Errorf("unexpected rua %v", dmarc["rua"])
+		}
+
+		if dmarc["policyDomain"] != "example.com" {
+			t.Errorf("expected the policy to come from example.com, got %v", dmarc["policyDomain"])
+		}
+	})
+
+	t.Run("DKIM", func(t *testing.T) {
+		dkim := get("dkim").([]interface{})
+		if len(dkim) != 2 {
+			t.Fatalf("expected 2 selectors, got %v", dkim)
+		}
+
+		first := dkim[0].(map[string]interface{})
+		if first["selector"] != "selector1" || first["keyType"] != "ed25519" || first["revoked"] != false {
+			t.Errorf("unexpected key %v", first)
+		}
+
+		if second := dkim[1].(map[string]interface{}); second["revoked"] != true {
+			t.Errorf("expected selector2 to be revoked, got %v", second)
+		}
+	})
+
+	t.Run("MTA-STS", func(t *testing.T) {
+		mtaSTS := get("mtaSts").(map[string]interface{})
+
+		if _, ok := mtaSTS["policyError"]; ok {
+			t.Fatalf("unexpected policy error %v", mtaSTS["policyError"])
+		}
+
+		policy := mtaSTS["policy"].(map[string]interface{})
+		if policy["mode"] != "enforce" || policy["maxAge"] != float64(604800) {
+			t.Errorf("unexpected policy %v", policy)
+		}
+
+		if !reflect.DeepEqual(policy["mx"], []interface{}{"mail.example.com", "*.mail.example.net"}) {
+			t.Errorf("unexpected mx %v", policy["mx"])
+		}
+	})
+
+	t.Run("TLS-RPT and BIMI", func(t *testing.T) {
+		tlsRPT := get("tlsRpt").(map[string]interface{})
+		if len(tlsRPT["rua"].([]interface{})) != 2 {
+			t.Errorf("unexpected rua %v", tlsRPT["rua"])
+		}
+
+		bimi := get("bimi").(map[string]interface{})
+		if bimi["location"] != "https://example.com/logo.svg" {
+			t.Errorf("unexpected location %v", bimi["location"])
+		}
+
+		if _, ok := bimi["authority"]; ok {
+			t.Errorf("expected empty authority to be omitted, got %v", bimi["authority"])
+		}
+	})
+
+	t.Run("Links", func(t *testing.T) {
+		expected := map[string]bool{
+			"dns SEARCH example.com":                                       false,
+			"dns SEARCH _spf.example.net":                                  false,
+			"ip GET 192.0.2.1":                                             false,
+			"dns SEARCH mail.example.com":                                  false,
+			"http GET https://mta-sts.example.com/.well-known/mta-sts.txt": false,
+			"http GET https://reports.example.com/tls":                     false,
+			"http GET https://example.com/logo.svg":                        false,
+		}
+
+		for _, liq := range item.GetLinkedItemQueries() {
+			q := liq.GetQuery()
+			key := fmt.Sprintf("%v %v %v", q.GetType(), q.GetMethod(), q.GetQuery())
+
+			if _, ok := expected[key]; ok {
+				expected[key] = true
+			}
+
+			if q.GetQuery() == "198.51.100.0/24" || q.GetQuery() == "*.mail.example.net" {
+				t.Errorf("unexpected link %v", key)
+			}
+		}
+
+		for key, found := range expected {
+			if !found {
+				t.Errorf("missing link %v", key)
+			}
+		}
+	})
+}
+
+func TestEmailDomainGetNoRecords(t *testing.T) {
+	t.Parallel()
+
+	src := EmailDomainAdapter{
+		DNS: &DNSAdapter{
+			Servers: []string{newTestDNSServer(t)},
+		},
+	}
+
+	item, err := src.Get(context.Background(), "global", "example.com", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := item.GetAttributes().Get("spf"); err == nil {
+		t.Error("expected no spf attribute")
+	}
+
+	_, err = src.Get(context.Background(), "global", "192.0.2.1", false)
+
+	var qErr *sdp.QueryError
+	if !errors.As(err, &qErr) {
+		t.Errorf("expected a QueryError, got %v", err)
+	}
+}
+
+func TestEmailDomainDMARCOrganizationalDomain(t *testing.T) {
+	t.Parallel()
+
+	src := EmailDomainAdapter{
+		DNS: &DNSAdapter{
+			Servers: []string{newTestDNSServer(t,
+				`_dmarc.example.co.uk. 300 IN TXT "v=DMARC1; p=reject; sp=quarantine"`,
+				`_dmarc.own.example.co.uk. 300 IN TXT "v=DMARC1; p=none"`,
+			)},
+		},
+	}
+
+	tests := []struct {
+		Domain       string
+		PolicyDomain string
+		Policy       string
+	}{
+		// Subdomains without a record use the subdomain policy of the
+		// organizational domain
+		{Domain: "mail.example.co.uk", PolicyDomain: "example.co.uk", Policy: "quarantine"},
+		{Domain: "a.b.example.co.uk", PolicyDomain: "example.co.uk", Policy: "quarantine"},
+		// A record on the subdomain takes precedence
+		{Domain: "own.example.co.uk", PolicyDomain: "own.example.co.uk", Policy: "none"},
+		{Domain: "example.co.uk", PolicyDomain: "example.co.uk", Policy: "reject"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Domain, func(t *testing.T) {
+			item, err := src.Get(context.Background(), "global", test.Domain, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dmarc, err := item.GetAttributes().Get("dmarc")
+			if err != nil {
+				t.Fatal("missing dmarc attribute")
+			}
+
+			attrs := dmarc.(map[string]interface{})
+
+			if attrs["policyDomain"] != test.PolicyDomain {
+				t.Errorf("expected policy domain %v, got %v", test.PolicyDomain, attrs["policyDomain"])
+			}
+
+			if attrs["policy"] != test.Policy {
+				t.Errorf("expected policy %v, got %v", test.Policy, attrs["policy"])
+			}
+		})
+	}
+}
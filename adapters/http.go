@@ -2,8 +2,7 @@ package adapters
 
 import (
 	"context"
-	"crypto/tls"
-	"encoding/pem"
+	"crypto/x509"
 	"fmt"
 	"net"
 	"net/http"
@@ -14,17 +13,38 @@ import (
 
 	"github.com/overmindtech/sdp-go"
 	"github.com/overmindtech/sdpcache"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const USER_AGENT_VERSION = "0.1"
 
 type HTTPAdapter struct {
+	// Send a `GET` request rather than `HEAD` and fingerprint the body. Only
+	// the first MaxBodySize bytes of the body are read
+	FullGet bool
+	// The maximum number of bytes of the body to read in GET mode, defaults
+	// to DefaultHTTPMaxBodySize
+	MaxBodySize int64
+	// The maximum number of redirects to follow when searching, defaults to
+	// DefaultHTTPMaxRedirects
+	MaxRedirects int
+	// The CAs to verify certificates against. Defaults to the roots of the
+	// client factory
+	RootCAs *x509.CertPool
+	// Creates the transport used for requests, which sets the proxy and
+	// client certificates. If nil the environment's proxy is used
+	ClientFactory *HTTPClientFactory
+
 	cache       *sdpcache.Cache // The sdpcache of this adapter
 	cacheInitMu sync.Mutex      // Mutex to ensure cache is only initialised once
 }
 
 const httpCacheDuration = 5 * time.Minute
 
+// DefaultHTTPMaxRedirects How many redirects are followed by Search. This is
+// the same as browsers and the Go HTTP client
+const DefaultHTTPMaxRedirects = 10
+
 func (s *HTTPAdapter) ensureCache() {
 	s.cacheInitMu.Lock()
 	defer s.cacheInitMu.Unlock()
@@ -39,6 +59,30 @@ func (s *HTTPAdapter) Cache() *sdpcache.Cache {
 	return s.cache
 }
 
+func (s *HTTPAdapter) maxBodySize() int64 {
+	if s.MaxBodySize > 0 {
+		return s.MaxBodySize
+	}
+
+	return DefaultHTTPMaxBodySize
+}
+
+func (s *HTTPAdapter) rootCAs() *x509.CertPool {
+	if s.RootCAs != nil {
+		return s.RootCAs
+	}
+
+	return s.ClientFactory.RootCAs()
+}
+
+func (s *HTTPAdapter) maxRedirects() int {
+	if s.MaxRedirects > 0 {
+		return s.MaxRedirects
+	}
+
+	return DefaultHTTPMaxRedirects
+}
+
 // Type The type of items that this adapter is capable of finding
 func (s *HTTPAdapter) Type() string {
 	return "http"
@@ -58,11 +102,13 @@ var httpMetadata = Metadata.Register(&sdp.AdapterMetadata{
 	DescriptiveName: "HTTP Endpoint",
 	Type:            "http",
 	SupportedQueryMethods: &sdp.AdapterSupportedQueryMethods{
-		Get:            true,
-		GetDescription: "A HTTP endpoint to run a `HEAD` request against",
+		Get:               true,
+		GetDescription:    "A HTTP endpoint to run a `HEAD` request against. If GET mode is enabled a `GET` request is sent instead and the body is fingerprinted",
+		Search:            true,
+		SearchDescription: "A HTTP endpoint to follow the redirects of. Returns an item for each hop in the chain, starting with the endpoint itself",
 	},
 	Category:       sdp.AdapterCategory_ADAPTER_CATEGORY_NETWORK,
-	PotentialLinks: []string{"ip", "dns", "certificate", "http"},
+	PotentialLinks: []string{"ip", "dns", "certificate", "http", "tls"},
 })
 
 // List of scopes that this adapter is capable of find items for. If the
@@ -103,12 +149,10 @@ func (s *HTTPAdapter) Get(ctx context.Context, scope string, query string, ignor
 
 	// Create a client that skips TLS verification since we will want to get the
 	// details of the TLS connection rather than stop if it's not trusted. Since
-	// we are only running a HEAD request this is unlikely to be a problem
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true, // nolint:gosec // This is fine for a HEAD request
-		},
-	}
+	// we only read the headers and a bounded part of the body this is unlikely
+	// to be a problem
+	tr := s.ClientFactory.Transport()
+	tr.TLSClientConfig.InsecureSkipVerify = true // nolint:gosec // Nothing sensitive is sent
 	client := &http.Client{
 		Transport: tr,
 		// Don't follow redirects, just return the status code directly
@@ -117,7 +161,16 @@ func (s *HTTPAdapter) Get(ctx context.Context, scope string, query string, ignor
 		},
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "HEAD", query, http.NoBody)
+	method := http.MethodHead
+	if s.FullGet {
+		method = http.MethodGet
+	}
+
+	// Record how long each phase of the request takes so that it's clear
+	// where the time went if an endpoint is slow
+	traceCtx, timings := withHTTPTrace(ctx)
+
+	req, err := http.NewRequestWithContext(traceCtx, method, query, http.NoBody)
 	if err != nil {
 		err = &sdp.QueryError{
 			ErrorType:   sdp.QueryError_OTHER,
@@ -131,9 +184,17 @@ func (s *HTTPAdapter) Get(ctx context.Context, scope string, query string, ignor
 	req.Header.Add("User-Agent", fmt.Sprintf("Overmind/%v (%v/%v)", USER_AGENT_VERSION, runtime.GOOS, runtime.GOARCH))
 	req.Header.Add("Accept", "*/*")
 
+	if tr.Proxy != nil {
+		if proxy, err := tr.Proxy(req); err == nil && proxy != nil {
+			timings.Proxied = true
+		}
+	}
+
 	var res *http.Response
 
+	start := time.Now()
 	res, err = client.Do(req)
+	duration := time.Since(start)
 
 	if err != nil {
 		err = &sdp.QueryError{
@@ -147,6 +208,7 @@ func (s *HTTPAdapter) Get(ctx context.Context, scope string, query string, ignor
 
 	// Clean up connections once we're done
 	defer client.CloseIdleConnections()
+	defer res.Body.Close()
 
 	// Convert headers from map[string][]string to map[string]string. This means
 	// that headers that were returned many times will end up with their values
@@ -156,16 +218,48 @@ func (s *HTTPAdapter) Get(ctx context.Context, scope string, query string, ignor
 		headersMap[header] = strings.Join(values, ", ")
 	}
 
-	// Convert the attributes from a golang map, to the structure required for
-	// the SDP protocol
-	attributes, err := sdp.ToAttributes(map[string]interface{}{
+	attrMap := map[string]interface{}{
 		"name":             query,
+		"method":           method,
 		"status":           res.StatusCode,
+		"durationMs":       duration.Milliseconds(),
 		"statusString":     res.Status,
 		"proto":            res.Proto,
 		"headers":          headersMap,
 		"transferEncoding": res.Request.TransferEncoding,
-	})
+	}
+
+	redirect := res.StatusCode >= 300 && res.StatusCode < 400
+	security := analyseSecurity(res.Header, req.URL.Scheme == "https", redirect)
+
+	attrMap["security"] = security.Headers
+	attrMap["cookies"] = security.Cookies
+	attrMap["securityIssues"] = security.Issues
+
+	for k, v := range timings.Attributes(trace.SpanFromContext(ctx)) {
+		attrMap[k] = v
+	}
+
+	if s.FullGet {
+		fingerprint, err := fingerprintResponse(ctx, client, res, s.maxBodySize())
+		if err != nil {
+			err = &sdp.QueryError{
+				ErrorType:   sdp.QueryError_OTHER,
+				ErrorString: err.Error(),
+				Scope:       scope,
+			}
+			s.cache.StoreError(err, httpCacheDuration, ck)
+			return nil, err
+		}
+
+		for k, v := range fingerprint.Attributes() {
+			attrMap[k] = v
+		}
+	}
+
+	// Convert the attributes from a golang map, to the structure required for
+	// the SDP protocol
+	attributes, err := sdp.ToAttributes(attrMap)
 
 	if err != nil {
 		err = &sdp.QueryError{
@@ -184,32 +278,38 @@ func (s *HTTPAdapter) Get(ctx context.Context, scope string, query string, ignor
 		Scope:           "global",
 	}
 
-	if ip := net.ParseIP(req.URL.Hostname()); ip != nil {
-		// If the host is an IP, add a linked item to that IP address
+	ip := net.ParseIP(req.URL.Hostname())
+	if ip == nil {
+		// If the host is not an ip, try to resolve via DNS
 		item.LinkedItemQueries = append(item.LinkedItemQueries, &sdp.LinkedItemQuery{
 			Query: &sdp.Query{
-				Type:   "ip",
-				Method: sdp.QueryMethod_GET,
-				Query:  ip.String(),
+				Type:   "dns",
+				Method: sdp.QueryMethod_SEARCH,
+				Query:  req.URL.Hostname(),
 				Scope:  "global",
 			},
 			BlastPropagation: &sdp.BlastPropagation{
-				// IPs always linked
+				// DNS always linked
 				In:  true,
 				Out: true,
 			},
 		})
-	} else {
-		// If the host is not an ip, try to resolve via DNS
+
+		// The name may resolve to many addresses, so also link to the one
+		// that was actually connected to
+		ip = timings.RemoteIP()
+	}
+
+	if ip != nil {
 		item.LinkedItemQueries = append(item.LinkedItemQueries, &sdp.LinkedItemQuery{
 			Query: &sdp.Query{
-				Type:   "dns",
-				Method: sdp.QueryMethod_SEARCH,
-				Query:  req.URL.Hostname(),
+				Type:   "ip",
+				Method: sdp.QueryMethod_GET,
+				Query:  ip.String(),
 				Scope:  "global",
 			},
 			BlastPropagation: &sdp.BlastPropagation{
-				// DNS always linked
+				// IPs always linked
 				In:  true,
 				Out: true,
 			},
@@ -217,65 +317,70 @@ func (s *HTTPAdapter) Get(ctx context.Context, scope string, query string, ignor
 	}
 
 	if tlsState := res.TLS; tlsState != nil {
-		var version string
-
-		// Extract TLS version as a string
-		switch tlsState.Version {
-		case tls.VersionTLS10:
-			version = "TLSv1.0"
-		case tls.VersionTLS11:
-			version = "TLSv1.1"
-		case tls.VersionTLS12:
-			version = "TLSv1.2"
-		case tls.VersionTLS13:
-			version = "TLSv1.3"
-		default:
-			version = "unknown"
-		}
-
-		attributes.Set("tls", map[string]interface{}{
-			"version":     version,
+		tlsAttrs := map[string]interface{}{
+			"version":     tlsVersionName(tlsState.Version),
 			"certificate": CertToName(tlsState.PeerCertificates[0]),
 			"serverName":  tlsState.ServerName,
-		})
+		}
 
-		if len(tlsState.PeerCertificates) > 0 {
-			// Create a PEM bundle and then linked item request
-			var certs []string
+		// Verification was skipped when connecting so that untrusted
+		// endpoints can still be discovered, so do it now
+		verification := verifyChain(tlsState.PeerCertificates, s.rootCAs(), req.URL.Hostname(), true)
+		for k, v := range verification.Attributes() {
+			tlsAttrs[k] = v
+		}
+
+		if !verification.Trusted || (verification.HostnameMatch != nil && !*verification.HostnameMatch) {
+			// Clients will refuse to connect
+			item.Health = sdp.Health_HEALTH_ERROR.Enum()
+		}
 
-			for _, cert := range tlsState.PeerCertificates {
-				block := pem.Block{
-					Type:  "CERTIFICATE",
-					Bytes: cert.Raw,
-				}
+		attributes.Set("tls", tlsAttrs)
 
-				certs = append(certs, string(pem.EncodeToMemory(&block)))
-			}
+		if len(tlsState.PeerCertificates) > 0 {
+			item.LinkedItemQueries = append(item.LinkedItemQueries, certificateBundleLink(tlsState.PeerCertificates, scope))
+		}
 
-			item.LinkedItemQueries = append(item.LinkedItemQueries, &sdp.LinkedItemQuery{
-				Query: &sdp.Query{
-					Type:   "certificate",
-					Method: sdp.QueryMethod_SEARCH,
-					Query:  strings.Join(certs, "\n"),
-					Scope:  scope,
-				},
-				BlastPropagation: &sdp.BlastPropagation{
-					// Changing the cert will affect the HTTP endpoint
-					In: true,
-					// The HTTP endpoint won't affect the cert
-					Out: false,
-				},
-			})
+		// The TLS endpoint has the details of what else the server accepts
+		port := req.URL.Port()
+		if port == "" {
+			port = "443"
 		}
+
+		item.LinkedItemQueries = append(item.LinkedItemQueries, &sdp.LinkedItemQuery{
+			Query: &sdp.Query{
+				Type:   "tls",
+				Method: sdp.QueryMethod_GET,
+				Query:  net.JoinHostPort(req.URL.Hostname(), port),
+				Scope:  scope,
+			},
+			BlastPropagation: &sdp.BlastPropagation{
+				// Changing the TLS config will affect the HTTP endpoint
+				In: true,
+				// The HTTP endpoint won't affect the TLS config
+				Out: false,
+			},
+		})
 	}
-	// Detect redirect and add a linked item for the redirect target
-	if res.StatusCode >= 300 && res.StatusCode < 400 {
-		if loc := res.Header.Get("Location"); loc != "" {
+	// Detect redirect and add a linked item for the redirect target. Relative
+	// locations are resolved against the URL that was requested
+	if redirect {
+		if loc, err := res.Location(); err == nil {
+			attributes.Set("redirectLocation", loc.String())
+
+			// Redirecting from HTTPS to HTTP means that anything sent to the
+			// target can be intercepted
+			downgrade := req.URL.Scheme == "https" && loc.Scheme == "http"
+			attributes.Set("redirectDowngrade", downgrade)
+			if downgrade && item.Health == nil {
+				item.Health = sdp.Health_HEALTH_WARNING.Enum()
+			}
+
 			item.LinkedItemQueries = append(item.LinkedItemQueries, &sdp.LinkedItemQuery{
 				Query: &sdp.Query{
 					Type:   "http",
 					Method: sdp.QueryMethod_GET,
-					Query:  loc,
+					Query:  loc.String(),
 					Scope:  scope,
 				},
 				BlastPropagation: &sdp.BlastPropagation{
@@ -292,6 +397,63 @@ func (s *HTTPAdapter) Get(ctx context.Context, scope string, query string, ignor
 	return &item, nil
 }
 
+// Search Follows the redirects of an endpoint, up to MaxRedirects, and returns
+// an item for each hop in order. Each hop is found using Get so it is cached
+// separately. The returned items are copies with `redirectHop` set, and the
+// last one has `redirectLoop` or `redirectLimitReached` set if the chain
+// didn't end with a response that wasn't a redirect. If a hop after the first
+// can't be requested the chain so far is returned with `redirectError` set
+// on the last hop
+func (s *HTTPAdapter) Search(ctx context.Context, scope string, query string, ignoreCache bool) ([]*sdp.Item, error) {
+	items := make([]*sdp.Item, 0)
+	visited := make(map[string]bool)
+	next := query
+
+	for {
+		item, err := s.Get(ctx, scope, next, ignoreCache)
+		if err == nil && item == nil {
+			err = fmt.Errorf("no item found for %v", next)
+		}
+
+		if err != nil {
+			if len(items) == 0 {
+				return nil, err
+			}
+
+			last := items[len(items)-1]
+			last.GetAttributes().Set("redirectError", err.Error())
+			last.Health = sdp.Health_HEALTH_ERROR.Enum()
+
+			return items, nil
+		}
+
+		hop := &sdp.Item{}
+		item.Copy(hop)
+		hop.GetAttributes().Set("redirectHop", len(items))
+
+		items = append(items, hop)
+		visited[next] = true
+
+		loc, _ := hop.GetAttributes().Get("redirectLocation")
+		next, _ = loc.(string)
+
+		switch {
+		case next == "":
+			return items, nil
+		case visited[next]:
+			hop.GetAttributes().Set("redirectLoop", true)
+			hop.Health = sdp.Health_HEALTH_ERROR.Enum()
+
+			return items, nil
+		case len(items) > s.maxRedirects():
+			hop.GetAttributes().Set("redirectLimitReached", true)
+			hop.Health = sdp.Health_HEALTH_ERROR.Enum()
+
+			return items, nil
+		}
+	}
+}
+
 // List is not implemented for HTTP as this would require scanning infinitely many
 // endpoints or something, doesn't really make sense
 func (s *HTTPAdapter) List(ctx context.Context, scope string, ignoreCache bool) ([]*sdp.Item, error) {
@@ -0,0 +1,182 @@
+package adapters
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestPEM(t *testing.T, name string, blockType string, der []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+
+	err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestHTTPClientFactoryProxy(t *testing.T) {
+	t.Parallel()
+
+	proxied := make(chan *http.Request, 1)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied <- r
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer proxy.Close()
+
+	f, err := NewHTTPClientFactory(HTTPClientOptions{
+		ProxyURL: "http://user:secret@" + proxy.Listener.Addr().String(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := HTTPAdapter{ClientFactory: f}
+
+	item, err := src.Get(context.Background(), "global", "http://www.example.invalid/path", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status, _ := item.GetAttributes().Get("status"); status != float64(http.StatusTeapot) {
+		t.Errorf("expected the response from the proxy, got %v", status)
+	}
+
+	r := <-proxied
+
+	if r.URL.String() != "http://www.example.invalid/path" {
+		t.Errorf("expected the proxy to receive the full URL, got %v", r.URL)
+	}
+
+	if auth := r.Header.Get("Proxy-Authorization"); auth != "Basic dXNlcjpzZWNyZXQ=" {
+		t.Errorf("expected proxy credentials, got %q", auth)
+	}
+
+	// The connection was to the proxy so nothing about it describes the
+	// server
+	assertLinks(t, item, []string{"dns SEARCH www.example.invalid"})
+
+	if addr, err := item.GetAttributes().Get("remoteAddress"); err == nil {
+		t.Errorf("expected no remoteAddress, got %v", addr)
+	}
+
+	timings, _ := item.GetAttributes().Get("timings")
+	if _, ok := timings.(map[string]interface{})["connectMs"]; ok {
+		t.Errorf("expected no connect timing through a proxy, got %v", timings)
+	}
+
+	t.Run("invalid scheme", func(t *testing.T) {
+		_, err := NewHTTPClientFactory(HTTPClientOptions{ProxyURL: "ftp://proxy.example.com"})
+		if err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
+
+func TestHTTPClientFactoryMutualTLS(t *testing.T) {
+	t.Parallel()
+
+	serverChain := newTestChain(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "server"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+	}, false)
+
+	clientChain := newTestChain(t, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "client"},
+	}, false)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{serverChain.Leaf.Raw, serverChain.Intermediate.Raw},
+			PrivateKey:  serverChain.LeafKey,
+		}},
+		ClientAuth: tls.RequireAnyClientCert,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return x509.UnknownAuthorityError{}
+			}
+			return nil
+		},
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	keyDER, err := x509.MarshalECPrivateKey(clientChain.LeafKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := HTTPClientOptions{
+		ExtraCAs:          writeTestPEM(t, "ca.pem", "CERTIFICATE", serverChain.Root.Raw),
+		ClientCertificate: writeTestPEM(t, "client.pem", "CERTIFICATE", clientChain.Leaf.Raw),
+		ClientKey:         writeTestPEM(t, "client-key.pem", "EC PRIVATE KEY", keyDER),
+	}
+
+	f, err := NewHTTPClientFactory(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The extra CA is trusted so this verifies the server
+	res, err := f.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	// The HTTP adapter uses the same roots to verify
+	item, err := (&HTTPAdapter{ClientFactory: f}).Get(context.Background(), "global", server.URL, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tlsAttrs, _ := item.GetAttributes().Get("tls")
+	if trusted := tlsAttrs.(map[string]interface{})["trusted"]; trusted != true {
+		t.Errorf("expected the server to be trusted, got %v", trusted)
+	}
+
+	// Without a client certificate the handshake fails
+	opts.ClientCertificate = ""
+	opts.ClientKey = ""
+
+	f, err = NewHTTPClientFactory(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res, err := f.Client().Get(server.URL); err == nil {
+		res.Body.Close()
+		t.Error("expected the request to fail without a client certificate")
+	}
+}
+
+func TestNilHTTPClientFactory(t *testing.T) {
+	t.Parallel()
+
+	var f *HTTPClientFactory
+
+	if f.RootCAs() != nil {
+		t.Error("expected the system roots")
+	}
+
+	tr := f.Transport()
+	if tr.Proxy == nil || tr.TLSClientConfig == nil {
+		t.Errorf("expected a proxy and TLS config, got %+v", tr)
+	}
+}
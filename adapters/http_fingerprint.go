@@ -0,0 +1,343 @@
+package adapters
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"runtime"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// DefaultHTTPMaxBodySize How much of a response body is read in GET mode. The
+// fingerprint is calculated from this much of the body
+const DefaultHTTPMaxBodySize = 1 << 20 // 1 MiB
+
+// httpFingerprint Details of a response body that help to tell apart endpoints
+// that return the same headers
+type httpFingerprint struct {
+	// The content type from the headers, or sniffed from the body if the
+	// server didn't send one
+	ContentType string
+	// The number of bytes that were read, which is at most the maximum body
+	// size
+	BodySize int
+	// Whether the body was larger than the maximum body size
+	BodyTruncated bool
+	// Hex encoded SHA-256 of the bytes that were read
+	BodySHA256 string
+	// The contents of the <title> element, for HTML responses
+	Title string
+	// The URL of the favicon and the hex encoded SHA-256 of its contents. The
+	// URL is empty if no favicon was found
+	FaviconURL    string
+	FaviconSHA256 string
+	// Technologies detected from the headers, cookies and HTML e.g.
+	// "nginx/1.25.3" or "WordPress 6.4"
+	Technologies []string
+}
+
+// Attributes Returns the fingerprint as item attributes
+func (f *httpFingerprint) Attributes() map[string]interface{} {
+	attrs := map[string]interface{}{
+		"bodySize":      f.BodySize,
+		"bodyTruncated": f.BodyTruncated,
+		"bodySha256":    f.BodySHA256,
+		"technologies":  f.Technologies,
+	}
+
+	if f.ContentType != "" {
+		attrs["contentType"] = f.ContentType
+	}
+
+	if f.Title != "" {
+		attrs["title"] = f.Title
+	}
+
+	if f.FaviconURL != "" {
+		attrs["faviconUrl"] = f.FaviconURL
+		attrs["faviconSha256"] = f.FaviconSHA256
+	}
+
+	return attrs
+}
+
+// readBody Reads up to `max` bytes of a body, returning whether there was more
+func readBody(body io.Reader, max int64) ([]byte, bool, error) {
+	b, err := io.ReadAll(io.LimitReader(body, max+1))
+	if err != nil {
+		return nil, false, err
+	}
+
+	if int64(len(b)) > max {
+		return b[:max], true, nil
+	}
+
+	return b, false, nil
+}
+
+// fingerprintResponse Reads a bounded part of the body of a response and
+// calculates its fingerprint. If the response is HTML the favicon is also
+// requested using the same client
+func fingerprintResponse(ctx context.Context, client *http.Client, res *http.Response, maxBodySize int64) (*httpFingerprint, error) {
+	body, truncated, err := readBody(res.Body, maxBodySize)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+
+	sum := sha256.Sum256(body)
+
+	f := &httpFingerprint{
+		ContentType:   res.Header.Get("Content-Type"),
+		BodySize:      len(body),
+		BodyTruncated: truncated,
+		BodySHA256:    hex.EncodeToString(sum[:]),
+	}
+
+	if f.ContentType == "" && len(body) > 0 {
+		f.ContentType = http.DetectContentType(body)
+	}
+
+	technologies := headerTechnologies(res.Header)
+
+	if mediaType, _, _ := mime.ParseMediaType(f.ContentType); mediaType == "text/html" {
+		page := parseHTML(body)
+
+		f.Title = page.Title
+		technologies = append(technologies, page.Generators...)
+
+		// Browsers fall back to /favicon.ico if the page doesn't link to an
+		// icon
+		icon := "/favicon.ico"
+		if page.Icon != "" {
+			icon = page.Icon
+		}
+
+		if iconURL, err := res.Request.URL.Parse(icon); err == nil {
+			if hash, ok := fetchFavicon(ctx, client, iconURL, maxBodySize); ok {
+				f.FaviconURL = iconURL.String()
+				f.FaviconSHA256 = hash
+			}
+		}
+	}
+
+	f.Technologies = dedupeSorted(technologies)
+
+	return f, nil
+}
+
+// fetchFavicon Requests a favicon and returns the hex encoded SHA-256 of its
+// contents. Returns false if the favicon couldn't be fetched
+func fetchFavicon(ctx context.Context, client *http.Client, u *url.URL, maxBodySize int64) (string, bool) {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", false
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), http.NoBody)
+	if err != nil {
+		return "", false
+	}
+
+	req.Header.Add("User-Agent", fmt.Sprintf("Overmind/%v (%v/%v)", USER_AGENT_VERSION, runtime.GOOS, runtime.GOARCH))
+	req.Header.Add("Accept", "image/*")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return "", false
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", false
+	}
+
+	body, _, err := readBody(res.Body, maxBodySize)
+	if err != nil || len(body) == 0 {
+		return "", false
+	}
+
+	sum := sha256.Sum256(body)
+
+	return hex.EncodeToString(sum[:]), true
+}
+
+// htmlPage The parts of an HTML page that are used in the fingerprint
+type htmlPage struct {
+	Title      string
+	Icon       string
+	Generators []string
+}
+
+// parseHTML Extracts the title, icon link and generators from an HTML page.
+// The body may be truncated so parsing is best effort
+func parseHTML(body []byte) htmlPage {
+	var page htmlPage
+	var inTitle, titleDone bool
+
+	z := html.NewTokenizer(bytes.NewReader(body))
+
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return page
+		case html.TextToken:
+			if inTitle && !titleDone {
+				page.Title += string(z.Text())
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == "title" && inTitle {
+				inTitle = false
+				titleDone = true
+				page.Title = strings.Join(strings.Fields(page.Title), " ")
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+
+			attrs := make(map[string]string)
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				attrs[string(key)] = string(val)
+			}
+
+			switch string(name) {
+			case "title":
+				inTitle = !titleDone
+			case "link":
+				if page.Icon == "" && attrs["href"] != "" {
+					for _, rel := range strings.Fields(strings.ToLower(attrs["rel"])) {
+						if rel == "icon" {
+							page.Icon = attrs["href"]
+						}
+					}
+				}
+			case "meta":
+				if strings.EqualFold(attrs["name"], "generator") && strings.TrimSpace(attrs["content"]) != "" {
+					page.Generators = append(page.Generators, strings.TrimSpace(attrs["content"]))
+				}
+			}
+		}
+	}
+}
+
+// technologyHeaders Headers whose presence identifies a technology, even
+// though their value doesn't
+var technologyHeaders = map[string]string{
+	"Cf-Ray":              "Cloudflare",
+	"X-Amz-Cf-Id":         "Amazon CloudFront",
+	"X-Azure-Ref":         "Azure Front Door",
+	"X-Drupal-Cache":      "Drupal",
+	"X-Github-Request-Id": "GitHub Pages",
+	"X-Served-By":         "Fastly",
+	"X-Vercel-Id":         "Vercel",
+}
+
+// technologyCookies Session cookies that are set by default by common
+// frameworks
+var technologyCookies = map[string]string{
+	"ASP.NET_SessionId": "ASP.NET",
+	"JSESSIONID":        "Java",
+	"PHPSESSID":         "PHP",
+	"laravel_session":   "Laravel",
+}
+
+// headerTechnologies Detects technologies from response headers and cookies
+func headerTechnologies(headers http.Header) []string {
+	technologies := make([]string, 0)
+
+	technologies = append(technologies, serverProducts(headers.Get("Server"))...)
+
+	for _, poweredBy := range headers.Values("X-Powered-By") {
+		for _, p := range strings.Split(poweredBy, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				technologies = append(technologies, p)
+			}
+		}
+	}
+
+	if v := headers.Get("X-AspNet-Version"); v != "" {
+		technologies = append(technologies, "ASP.NET/"+v)
+	}
+
+	if v := headers.Get("X-Generator"); v != "" {
+		technologies = append(technologies, v)
+	}
+
+	for header, technology := range technologyHeaders {
+		if headers.Get(header) != "" {
+			technologies = append(technologies, technology)
+		}
+	}
+
+	for _, cookie := range (&http.Response{Header: headers}).Cookies() {
+		if technology, ok := technologyCookies[cookie.Name]; ok {
+			technologies = append(technologies, technology)
+		}
+	}
+
+	return technologies
+}
+
+// serverProducts Splits a `Server` header into its products, ignoring
+// comments. "Apache/2.4.57 (Debian) OpenSSL/3.0.11" returns "Apache/2.4.57"
+// and "OpenSSL/3.0.11"
+func serverProducts(server string) []string {
+	products := make([]string, 0)
+
+	var depth int
+	var current strings.Builder
+
+	flush := func() {
+		if current.Len() > 0 {
+			products = append(products, current.String())
+			current.Reset()
+		}
+	}
+
+	for _, r := range server {
+		switch {
+		case r == '(':
+			flush()
+			depth++
+		case r == ')':
+			if depth > 0 {
+				depth--
+			}
+		case depth > 0:
+			// Inside a comment
+		case r == ' ' || r == '\t':
+			flush()
+		default:
+			current.WriteRune(r)
+		}
+	}
+
+	flush()
+
+	return products
+}
+
+// dedupeSorted Returns the unique values sorted
+func dedupeSorted(values []string) []string {
+	seen := make(map[string]bool)
+	unique := make([]string, 0)
+
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+
+	sort.Strings(unique)
+
+	return unique
+}
@@ -0,0 +1,394 @@
+package adapters
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// hstsMinMaxAge The shortest HSTS max-age that isn't considered weak, 180 days
+// is the minimum that security scanners accept
+const hstsMinMaxAge = 180 * 24 * 60 * 60
+
+// referrerPolicies The valid values for Referrer-Policy
+var referrerPolicies = map[string]bool{
+	"no-referrer":                     true,
+	"no-referrer-when-downgrade":      true,
+	"origin":                          true,
+	"origin-when-cross-origin":        true,
+	"same-origin":                     true,
+	"strict-origin":                   true,
+	"strict-origin-when-cross-origin": true,
+	"unsafe-url":                      true,
+}
+
+// httpSecurity The parsed security headers and cookies of a response, and the
+// protections that are missing or weak
+type httpSecurity struct {
+	Headers map[string]interface{}
+	Cookies []map[string]interface{}
+	Issues  []string
+}
+
+// analyseSecurity Parses the security headers and cookies of a response.
+// Headers that only apply to documents aren't reported as missing for
+// redirects, and HSTS and the Secure cookie flag are only expected over HTTPS
+func analyseSecurity(headers http.Header, https bool, redirect bool) *httpSecurity {
+	s := &httpSecurity{
+		Headers: make(map[string]interface{}),
+		Cookies: make([]map[string]interface{}, 0),
+		Issues:  make([]string, 0),
+	}
+
+	s.analyseHSTS(headers, https)
+	s.analyseCSP(headers, redirect)
+	s.analyseFraming(headers, redirect)
+
+	if v := headers.Get("X-Content-Type-Options"); v != "" {
+		s.Headers["xContentTypeOptions"] = v
+	}
+	if !strings.EqualFold(strings.TrimSpace(headers.Get("X-Content-Type-Options")), "nosniff") && !redirect {
+		s.Issues = append(s.Issues, "missing X-Content-Type-Options: nosniff")
+	}
+
+	s.analyseReferrerPolicy(headers, redirect)
+
+	if policy := parsePermissionsPolicy(strings.Join(headers.Values("Permissions-Policy"), ",")); len(policy) > 0 {
+		s.Headers["permissionsPolicy"] = policy
+	} else if !redirect {
+		s.Issues = append(s.Issues, "missing Permissions-Policy")
+	}
+
+	s.analyseCORS(headers)
+	s.analyseCookies(headers, https)
+
+	return s
+}
+
+// analyseHSTS Parses Strict-Transport-Security (RFC 6797)
+func (s *httpSecurity) analyseHSTS(headers http.Header, https bool) {
+	value := headers.Get("Strict-Transport-Security")
+	if value == "" {
+		if https {
+			s.Issues = append(s.Issues, "missing Strict-Transport-Security")
+		}
+		return
+	}
+
+	hsts := map[string]interface{}{
+		"includeSubDomains": false,
+		"preload":           false,
+	}
+
+	maxAge := -1
+	for _, directive := range strings.Split(value, ";") {
+		name, val, _ := strings.Cut(strings.TrimSpace(directive), "=")
+
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "max-age":
+			if n, err := strconv.Atoi(strings.Trim(strings.TrimSpace(val), `"`)); err == nil {
+				maxAge = n
+				hsts["maxAge"] = n
+			}
+		case "includesubdomains":
+			hsts["includeSubDomains"] = true
+		case "preload":
+			hsts["preload"] = true
+		}
+	}
+
+	s.Headers["hsts"] = hsts
+
+	if !https {
+		// Browsers ignore HSTS that isn't sent over HTTPS
+		return
+	}
+
+	switch {
+	case maxAge < 0:
+		s.Issues = append(s.Issues, "weak Strict-Transport-Security: no max-age")
+	case maxAge == 0:
+		s.Issues = append(s.Issues, "weak Strict-Transport-Security: max-age=0 disables HSTS")
+	case maxAge < hstsMinMaxAge:
+		s.Issues = append(s.Issues, fmt.Sprintf("weak Strict-Transport-Security: max-age %v is less than 180 days", maxAge))
+	}
+}
+
+// analyseCSP Parses Content-Security-Policy. A response can have more than
+// one policy, all of which are enforced, so each is returned separately
+func (s *httpSecurity) analyseCSP(headers http.Header, redirect bool) {
+	policies := make([]map[string][]string, 0)
+
+	for _, value := range headers.Values("Content-Security-Policy") {
+		for _, policy := range strings.Split(value, ",") {
+			if parsed := parseCSP(policy); len(parsed) > 0 {
+				policies = append(policies, parsed)
+			}
+		}
+	}
+
+	if len(policies) == 0 {
+		if !redirect {
+			s.Issues = append(s.Issues, "missing Content-Security-Policy")
+		}
+		return
+	}
+
+	s.Headers["csp"] = policies
+
+	// Since every policy is enforced, scripts are only weakly protected if
+	// every policy allows them
+	inline, eval, wildcard := true, true, true
+	for _, policy := range policies {
+		sources, restricted := policy["script-src"]
+		if !restricted {
+			sources, restricted = policy["default-src"]
+		}
+
+		if !restricted {
+			continue
+		}
+
+		inline = inline && cspAllowsInline(sources)
+		eval = eval && cspHas(sources, "'unsafe-eval'")
+		wildcard = wildcard && (cspHas(sources, "*") || cspHas(sources, "http:") || cspHas(sources, "https:"))
+	}
+
+	if inline {
+		s.Issues = append(s.Issues, "weak Content-Security-Policy: allows inline scripts")
+	}
+	if eval {
+		s.Issues = append(s.Issues, "weak Content-Security-Policy: allows eval")
+	}
+	if wildcard {
+		s.Issues = append(s.Issues, "weak Content-Security-Policy: allows scripts from any host")
+	}
+}
+
+// parseCSP Parses a single policy into its directives. Directive names are
+// case insensitive and only the first occurrence of each is used
+func parseCSP(policy string) map[string][]string {
+	directives := make(map[string][]string)
+
+	for _, directive := range strings.Split(policy, ";") {
+		fields := strings.Fields(directive)
+		if len(fields) == 0 {
+			continue
+		}
+
+		name := strings.ToLower(fields[0])
+		if _, exists := directives[name]; !exists {
+			directives[name] = fields[1:]
+		}
+	}
+
+	return directives
+}
+
+// cspAllowsInline 'unsafe-inline' is ignored by browsers if there is also a
+// nonce, hash or 'strict-dynamic'
+func cspAllowsInline(sources []string) bool {
+	if !cspHas(sources, "'unsafe-inline'") {
+		return false
+	}
+
+	for _, source := range sources {
+		lower := strings.ToLower(source)
+		if strings.HasPrefix(lower, "'nonce-") || strings.HasPrefix(lower, "'sha") || lower == "'strict-dynamic'" {
+			return false
+		}
+	}
+
+	return true
+}
+
+func cspHas(sources []string, source string) bool {
+	for _, s := range sources {
+		if strings.EqualFold(s, source) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// analyseFraming Checks for clickjacking protection, which can come from
+// X-Frame-Options or the CSP frame-ancestors directive
+func (s *httpSecurity) analyseFraming(headers http.Header, redirect bool) {
+	xfo := strings.ToUpper(strings.TrimSpace(headers.Get("X-Frame-Options")))
+	if xfo != "" {
+		s.Headers["xFrameOptions"] = xfo
+	}
+
+	if policies, ok := s.Headers["csp"].([]map[string][]string); ok {
+		for _, policy := range policies {
+			if _, ok := policy["frame-ancestors"]; ok {
+				return
+			}
+		}
+	}
+
+	switch {
+	case xfo == "DENY" || xfo == "SAMEORIGIN":
+	case xfo == "":
+		if !redirect {
+			s.Issues = append(s.Issues, "missing X-Frame-Options")
+		}
+	default:
+		// ALLOW-FROM is not supported by modern browsers
+		s.Issues = append(s.Issues, fmt.Sprintf("weak X-Frame-Options: %v is not supported by browsers", xfo))
+	}
+}
+
+// analyseReferrerPolicy The header can list several policies for
+// compatibility, browsers use the last one that they recognise
+func (s *httpSecurity) analyseReferrerPolicy(headers http.Header, redirect bool) {
+	var policy string
+
+	for _, value := range headers.Values("Referrer-Policy") {
+		for _, p := range strings.Split(value, ",") {
+			if p = strings.ToLower(strings.TrimSpace(p)); referrerPolicies[p] {
+				policy = p
+			}
+		}
+	}
+
+	switch policy {
+	case "":
+		if !redirect {
+			s.Issues = append(s.Issues, "missing Referrer-Policy")
+		}
+	case "unsafe-url":
+		s.Headers["referrerPolicy"] = policy
+		s.Issues = append(s.Issues, "weak Referrer-Policy: unsafe-url sends the full URL to every origin")
+	default:
+		s.Headers["referrerPolicy"] = policy
+	}
+}
+
+// parsePermissionsPolicy Parses a Permissions-Policy structured header e.g.
+// `camera=(), geolocation=(self "https://example.com")` into the allowlist of
+// each feature
+func parsePermissionsPolicy(value string) map[string][]string {
+	policy := make(map[string][]string)
+
+	for _, member := range strings.Split(value, ",") {
+		feature, allowlist, ok := strings.Cut(strings.TrimSpace(member), "=")
+		if !ok || feature == "" {
+			continue
+		}
+
+		// Remove parameters, then the brackets around the list
+		allowlist, _, _ = strings.Cut(allowlist, ";")
+		allowlist = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(allowlist), "("), ")")
+
+		origins := make([]string, 0)
+		for _, origin := range strings.Fields(allowlist) {
+			origins = append(origins, strings.Trim(origin, `"`))
+		}
+
+		policy[strings.ToLower(feature)] = origins
+	}
+
+	return policy
+}
+
+// analyseCORS Parses the CORS response headers
+func (s *httpSecurity) analyseCORS(headers http.Header) {
+	origin := strings.TrimSpace(headers.Get("Access-Control-Allow-Origin"))
+	if origin == "" {
+		return
+	}
+
+	credentials := strings.EqualFold(strings.TrimSpace(headers.Get("Access-Control-Allow-Credentials")), "true")
+
+	cors := map[string]interface{}{
+		"allowOrigin":      origin,
+		"allowCredentials": credentials,
+		"allowMethods":     splitHeaderList(headers.Values("Access-Control-Allow-Methods")),
+		"allowHeaders":     splitHeaderList(headers.Values("Access-Control-Allow-Headers")),
+		"exposeHeaders":    splitHeaderList(headers.Values("Access-Control-Expose-Headers")),
+	}
+
+	if maxAge, err := strconv.Atoi(strings.TrimSpace(headers.Get("Access-Control-Max-Age"))); err == nil {
+		cors["maxAge"] = maxAge
+	}
+
+	s.Headers["cors"] = cors
+
+	switch {
+	case origin == "null":
+		s.Issues = append(s.Issues, "weak CORS: allows the null origin")
+	case origin == "*" && credentials:
+		s.Issues = append(s.Issues, "weak CORS: allows credentials from any origin")
+	}
+}
+
+func splitHeaderList(values []string) []string {
+	items := make([]string, 0)
+
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+
+	return items
+}
+
+// analyseCookies Breaks down each Set-Cookie header. The values of cookies
+// aren't included since they are often secrets
+func (s *httpSecurity) analyseCookies(headers http.Header, https bool) {
+	for _, cookie := range (&http.Response{Header: headers}).Cookies() {
+		var sameSite string
+		switch cookie.SameSite {
+		case http.SameSiteLaxMode:
+			sameSite = "Lax"
+		case http.SameSiteStrictMode:
+			sameSite = "Strict"
+		case http.SameSiteNoneMode:
+			sameSite = "None"
+		}
+
+		c := map[string]interface{}{
+			"name":     cookie.Name,
+			"secure":   cookie.Secure,
+			"httpOnly": cookie.HttpOnly,
+		}
+
+		if sameSite != "" {
+			c["sameSite"] = sameSite
+		}
+		if cookie.Path != "" {
+			c["path"] = cookie.Path
+		}
+		if cookie.Domain != "" {
+			c["domain"] = cookie.Domain
+		}
+		if cookie.RawExpires != "" {
+			c["expires"] = cookie.RawExpires
+		}
+		if cookie.MaxAge != 0 {
+			c["maxAge"] = cookie.MaxAge
+		}
+
+		s.Cookies = append(s.Cookies, c)
+
+		if https && !cookie.Secure {
+			s.Issues = append(s.Issues, fmt.Sprintf("cookie %v: missing Secure", cookie.Name))
+		}
+		if !cookie.HttpOnly {
+			s.Issues = append(s.Issues, fmt.Sprintf("cookie %v: missing HttpOnly", cookie.Name))
+		}
+		switch {
+		case sameSite == "":
+			s.Issues = append(s.Issues, fmt.Sprintf("cookie %v: missing SameSite", cookie.Name))
+		case sameSite == "None" && !cookie.Secure:
+			// Browsers reject these
+			s.Issues = append(s.Issues, fmt.Sprintf("cookie %v: SameSite=None without Secure", cookie.Name))
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package adapters
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestAnalyseSecurity(t *testing.T) {
+	t.Parallel()
+
+	t.Run("well configured", func(t *testing.T) {
+		headers := http.Header{}
+		headers.Set("Strict-Transport-Security", `max-age="31536000"; includeSubDomains; preload`)
+		headers.Set("Content-Security-Policy", "default-src 'self'; script-src 'self' 'nonce-abc' 'unsafe-inline'; frame-ancestors 'none'")
+		headers.Set("X-Content-Type-Options", "nosniff")
+		headers.Set("Referrer-Policy", "no-referrer, strict-origin-when-cross-origin")
+		headers.Set("Permissions-Policy", `camera=(), geolocation=(self "https://maps.example.com")`)
+		headers.Add("Set-Cookie", "session=secret; Path=/; Secure; HttpOnly; SameSite=Strict")
+
+		s := analyseSecurity(headers, true, false)
+
+		if len(s.Issues) != 0 {
+			t.Errorf("expected no issues, got %v", s.Issues)
+		}
+
+		expectedHSTS := map[string]interface{}{"maxAge": 31536000, "includeSubDomains": true, "preload": true}
+		if !reflect.DeepEqual(s.Headers["hsts"], expectedHSTS) {
+			t.Errorf("expected hsts %v, got %v", expectedHSTS, s.Headers["hsts"])
+		}
+
+		expectedCSP := []map[string][]string{{
+			"default-src":     {"'self'"},
+			"script-src":      {"'self'", "'nonce-abc'", "'unsafe-inline'"},
+			"frame-ancestors": {"'none'"},
+		}}
+		if !reflect.DeepEqual(s.Headers["csp"], expectedCSP) {
+			t.Errorf("expected csp %v, got %v", expectedCSP, s.Headers["csp"])
+		}
+
+		if s.Headers["referrerPolicy"] != "strict-origin-when-cross-origin" {
+			t.Errorf("expected the last referrer policy, got %v", s.Headers["referrerPolicy"])
+		}
+
+		expectedPP := map[string][]string{"camera": {}, "geolocation": {"self", "https://maps.example.com"}}
+		if !reflect.DeepEqual(s.Headers["permissionsPolicy"], expectedPP) {
+			t.Errorf("expected permissionsPolicy %v, got %v", expectedPP, s.Headers["permissionsPolicy"])
+		}
+
+		expectedCookies := []map[string]interface{}{{
+			"name":     "session",
+			"secure":   true,
+			"httpOnly": true,
+			"sameSite": "Strict",
+			"path":     "/",
+		}}
+		if !reflect.DeepEqual(s.Cookies, expectedCookies) {
+			t.Errorf("expected cookies %v, got %v", expectedCookies, s.Cookies)
+		}
+	})
+
+	t.Run("missing and weak", func(t *testing.T) {
+		headers := http.Header{}
+		headers.Set("Strict-Transport-Security", "max-age=3600")
+		headers.Set("Content-Security-Policy", "default-src * 'unsafe-inline' 'unsafe-eval'")
+		headers.Set("X-Frame-Options", "allow-from https://example.com")
+		headers.Set("Referrer-Policy", "unsafe-url")
+		headers.Set("Access-Control-Allow-Origin", "*")
+		headers.Set("Access-Control-Allow-Credentials", "true")
+		headers.Set("Access-Control-Allow-Methods", "GET, POST")
+		headers.Add("Set-Cookie", "tracking=1; SameSite=None")
+
+		s := analyseSecurity(headers, true, false)
+
+		expected := []string{
+			"weak Strict-Transport-Security: max-age 3600 is less than 180 days",
+			"weak Content-Security-Policy: allows inline scripts",
+			"weak Content-Security-Policy: allows eval",
+			"weak Content-Security-Policy: allows scripts from any host",
+			"weak X-Frame-Options: ALLOW-FROM HTTPS://EXAMPLE.COM is not supported by browsers",
+			"missing X-Content-Type-Options: nosniff",
+			"weak Referrer-Policy: unsafe-url sends the full URL to every origin",
+			"missing Permissions-Policy",
+			"weak CORS: allows credentials from any origin",
+			"cookie tracking: missing Secure",
+			"cookie tracking: missing HttpOnly",
+			"cookie tracking: SameSite=None without Secure",
+		}
+
+		if !reflect.DeepEqual(s.Issues, expected) {
+			t.Errorf("expected issues:\n%v\ngot:\n%v", expected, s.Issues)
+		}
+
+		cors, _ := s.Headers["cors"].(map[string]interface{})
+		if !reflect.DeepEqual(cors["allowMethods"], []string{"GET", "POST"}) {
+			t.Errorf("expected allowMethods [GET POST], got %v", cors["allowMethods"])
+		}
+	})
+
+	t.Run("plain HTTP redirect", func(t *testing.T) {
+		s := analyseSecurity(http.Header{}, false, true)
+
+		// Document headers don't matter for redirects and HSTS only applies
+		// to HTTPS
+		if len(s.Issues) != 0 {
+			t.Errorf("expected no issues, got %v", s.Issues)
+		}
+	})
+}
+
+func TestHTTPGetSecurity(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Header().Add("Set-Cookie", "a=1; Secure; HttpOnly; SameSite=Lax")
+		w.Header().Add("Set-Cookie", "b=2; Secure; HttpOnly; SameSite=Lax")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	src := HTTPAdapter{}
+
+	item, err := src.Get(context.Background(), "global", server.URL, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cookies, _ := item.GetAttributes().Get("cookies")
+	if c, ok := cookies.([]interface{}); !ok || len(c) != 2 {
+		t.Errorf("expected 2 cookies, got %v", cookies)
+	}
+
+	security, _ := item.GetAttributes().Get("security")
+	if xfo := security.(map[string]interface{})["xFrameOptions"]; xfo != "DENY" {
+		t.Errorf("expected xFrameOptions to be DENY, got %v", xfo)
+	}
+
+	issues, _ := item.GetAttributes().Get("securityIssues")
+	if !reflect.DeepEqual(issues, []interface{}{
+		"missing Strict-Transport-Security",
+		"missing Content-Security-Policy",
+		"missing X-Content-Type-Options: nosniff",
+		"missing Referrer-Policy",
+		"missing Permissions-Policy",
+	}) {
+		t.Errorf("unexpected securityIssues %v", issues)
+	}
+}
@@ -2,14 +2,23 @@ package adapters
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"regexp"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/overmindtech/discovery"
+	"github.com/overmindtech/sdp-go"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 const TestHTTPTimeout = 3 * time.Second
@@ -256,3 +265,361 @@ func TestHTTPGet(t *testing.T) {
 		discovery.TestValidateItem(t, item)
 	})
 }
+
+func TestHTTPGetFingerprint(t *testing.T) {
+	t.Parallel()
+
+	favicon := []byte("not really an icon")
+
+	sm := http.NewServeMux()
+	sm.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "nginx/1.25.3 (Ubuntu)")
+		w.Header().Set("X-Powered-By", "PHP/8.2.1")
+		http.SetCookie(w, &http.Cookie{Name: "PHPSESSID", Value: "abc"})
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+		_, _ = w.Write([]byte(`<html><head>
+			<title>
+				Example   Site
+			</title>
+			<meta name="generator" content="WordPress 6.4">
+			<link rel="shortcut icon" href="/static/icon.png">
+			</head><body>hello</body></html>`))
+	}))
+	sm.Handle("/static/icon.png", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(favicon)
+	}))
+	sm.Handle("/large", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(strings.Repeat("a", 100)))
+	}))
+
+	server := httptest.NewServer(sm)
+	defer server.Close()
+
+	t.Run("HTML page", func(t *testing.T) {
+		src := HTTPAdapter{FullGet: true}
+
+		item, err := src.Get(context.Background(), "global", server.URL+"/", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		discovery.TestValidateItem(t, item)
+
+		faviconHash := sha256.Sum256(favicon)
+
+		expected := map[string]interface{}{
+			"method":        "GET",
+			"contentType":   "text/html; charset=utf-8",
+			"title":         "Example Site",
+			"bodyTruncated": false,
+			"faviconUrl":    server.URL + "/static/icon.png",
+			"faviconSha256": hex.EncodeToString(faviconHash[:]),
+			"technologies":  []interface{}{"PHP", "PHP/8.2.1", "WordPress 6.4", "nginx/1.25.3"},
+		}
+
+		for attr, value := range expected {
+			actual, err := item.GetAttributes().Get(attr)
+			if err != nil {
+				t.Errorf("expected %v attribute: %v", attr, err)
+			} else if !reflect.DeepEqual(actual, value) {
+				t.Errorf("expected %v to be %v, got %v", attr, value, actual)
+			}
+		}
+
+		if hash, _ := item.GetAttributes().Get("bodySha256"); len(fmt.Sprint(hash)) != 64 {
+			t.Errorf("expected a SHA-256 body hash, got %v", hash)
+		}
+	})
+
+	t.Run("truncated body", func(t *testing.T) {
+		src := HTTPAdapter{FullGet: true, MaxBodySize: 10}
+
+		item, err := src.Get(context.Background(), "global", server.URL+"/large", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expectedHash := sha256.Sum256([]byte(strings.Repeat("a", 10)))
+
+		if size, _ := item.GetAttributes().Get("bodySize"); size != float64(10) {
+			t.Errorf("expected bodySize to be 10, got %v", size)
+		}
+
+		if truncated, _ := item.GetAttributes().Get("bodyTruncated"); truncated != true {
+			t.Errorf("expected bodyTruncated to be true, got %v", truncated)
+		}
+
+		if hash, _ := item.GetAttributes().Get("bodySha256"); hash != hex.EncodeToString(expectedHash[:]) {
+			t.Errorf("expected bodySha256 to be the hash of the first 10 bytes, got %v", hash)
+		}
+
+		if _, err := item.GetAttributes().Get("faviconUrl"); err == nil {
+			t.Error("expected no favicon for a non-HTML response")
+		}
+	})
+
+	t.Run("HEAD mode", func(t *testing.T) {
+		src := HTTPAdapter{}
+
+		item, err := src.Get(context.Background(), "global", server.URL+"/", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if method, _ := item.GetAttributes().Get("method"); method != "HEAD" {
+			t.Errorf("expected method to be HEAD, got %v", method)
+		}
+
+		if _, err := item.GetAttributes().Get("bodySha256"); err == nil {
+			t.Error("expected no body fingerprint in HEAD mode")
+		}
+	})
+}
+
+func TestServerProducts(t *testing.T) {
+	t.Parallel()
+
+	products := serverProducts("Apache/2.4.57 (Debian) OpenSSL/3.0.11 (comment (nested)) mod_wsgi/4.9")
+	expected := []string{"Apache/2.4.57", "OpenSSL/3.0.11", "mod_wsgi/4.9"}
+
+	if !reflect.DeepEqual(products, expected) {
+		t.Errorf("expected %v, got %v", expected, products)
+	}
+}
+
+func TestHTTPSearchRedirects(t *testing.T) {
+	t.Parallel()
+
+	sm := http.NewServeMux()
+	sm.Handle("/start", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "next")
+		w.WriteHeader(http.StatusMovedPermanently)
+	}))
+	sm.Handle("/next", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "/done?a=b")
+		w.WriteHeader(http.StatusFound)
+	}))
+	sm.Handle("/done", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	sm.Handle("/loop-a", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "/loop-b")
+		w.WriteHeader(http.StatusFound)
+	}))
+	sm.Handle("/loop-b", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "/loop-a")
+		w.WriteHeader(http.StatusFound)
+	}))
+	sm.Handle("/count/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", r.URL.Path+"x")
+		w.WriteHeader(http.StatusFound)
+	}))
+
+	server := httptest.NewServer(sm)
+	defer server.Close()
+
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", server.URL+"/done")
+		w.WriteHeader(http.StatusFound)
+	}))
+	defer tlsServer.Close()
+
+	hopNames := func(items []*sdp.Item) []string {
+		names := make([]string, 0)
+		for _, item := range items {
+			names = append(names, item.UniqueAttributeValue())
+		}
+		return names
+	}
+
+	t.Run("relative redirects", func(t *testing.T) {
+		src := HTTPAdapter{}
+
+		items, err := src.Search(context.Background(), "global", server.URL+"/start", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		discovery.TestValidateItems(t, items)
+
+		expected := []string{server.URL + "/start", server.URL + "/next", server.URL + "/done?a=b"}
+		if names := hopNames(items); !reflect.DeepEqual(names, expected) {
+			t.Fatalf("expected %v, got %v", expected, names)
+		}
+
+		assertLinks(t, items[0], []string{
+			"ip GET 127.0.0.1",
+			"http GET " + server.URL + "/next",
+		})
+
+		for i, item := range items {
+			if hop, _ := item.GetAttributes().Get("redirectHop"); hop != float64(i) {
+				t.Errorf("expected redirectHop to be %v, got %v", i, hop)
+			}
+
+			if _, err := item.GetAttributes().Get("durationMs"); err != nil {
+				t.Errorf("expected hop %v to have durationMs", i)
+			}
+		}
+
+		// The hops are cached individually so a Get doesn't make a request
+		item, err := src.Get(context.Background(), "global", server.URL+"/next", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := item.GetAttributes().Get("redirectHop"); err == nil {
+			t.Error("expected redirectHop to only be set on search results")
+		}
+	})
+
+	t.Run("loop", func(t *testing.T) {
+		src := HTTPAdapter{}
+
+		items, err := src.Search(context.Background(), "global", server.URL+"/loop-a", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(items) != 2 {
+			t.Fatalf("expected 2 hops, got %v", hopNames(items))
+		}
+
+		if loop, _ := items[1].GetAttributes().Get("redirectLoop"); loop != true {
+			t.Errorf("expected redirectLoop on the last hop, got %v", loop)
+		}
+
+		if items[1].GetHealth() != sdp.Health_HEALTH_ERROR {
+			t.Errorf("expected the last hop to be unhealthy, got %v", items[1].GetHealth())
+		}
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		src := HTTPAdapter{MaxRedirects: 3}
+
+		items, err := src.Search(context.Background(), "global", server.URL+"/count/", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(items) != 4 {
+			t.Fatalf("expected 4 hops, got %v", hopNames(items))
+		}
+
+		if limit, _ := items[3].GetAttributes().Get("redirectLimitReached"); limit != true {
+			t.Errorf("expected redirectLimitReached on the last hop, got %v", limit)
+		}
+	})
+
+	t.Run("downgrade", func(t *testing.T) {
+		roots := x509.NewCertPool()
+		roots.AddCert(tlsServer.Certificate())
+
+		src := HTTPAdapter{RootCAs: roots}
+
+		items, err := src.Search(context.Background(), "global", tlsServer.URL+"/", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(items) != 2 {
+			t.Fatalf("expected 2 hops, got %v", hopNames(items))
+		}
+
+		if downgrade, _ := items[0].GetAttributes().Get("redirectDowngrade"); downgrade != true {
+			t.Errorf("expected redirectDowngrade on the first hop, got %v", downgrade)
+		}
+
+		if items[0].GetHealth() != sdp.Health_HEALTH_WARNING {
+			t.Errorf("expected the first hop to have a warning, got %v", items[0].GetHealth())
+		}
+
+		if _, err := items[1].GetAttributes().Get("redirectDowngrade"); err == nil {
+			t.Error("expected redirectDowngrade to only be set on redirects")
+		}
+	})
+}
+
+func TestHTTPGetTimings(t *testing.T) {
+	t.Parallel()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	tlsServer := httptest.NewTLSServer(handler)
+	defer tlsServer.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	ctx, span := tp.Tracer("test").Start(context.Background(), "test")
+
+	src := HTTPAdapter{}
+
+	// Use a name rather than the IP so that DNS is used
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	item, err := src.Get(ctx, "global", "http://localhost:"+port+"/", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	span.End()
+
+	timings, err := item.GetAttributes().Get("timings")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, phase := range []string{"dnsMs", "connectMs", "waitMs", "firstByteMs"} {
+		if _, ok := timings.(map[string]interface{})[phase]; !ok {
+			t.Errorf("expected timings to include %v, got %v", phase, timings)
+		}
+	}
+
+	if addr, _ := item.GetAttributes().Get("remoteAddress"); addr != server.Listener.Addr().String() {
+		t.Errorf("expected remoteAddress to be %v, got %v", server.Listener.Addr(), addr)
+	}
+
+	if reused, _ := item.GetAttributes().Get("connectionReused"); reused != false {
+		t.Errorf("expected connectionReused to be false, got %v", reused)
+	}
+
+	assertLinks(t, item, []string{
+		"dns SEARCH localhost",
+		"ip GET 127.0.0.1",
+	})
+
+	events := make(map[string]bool)
+	for _, s := range recorder.Ended() {
+		for _, e := range s.Events() {
+			events[e.Name] = true
+		}
+	}
+
+	for _, event := range []string{"http.dnsStart", "http.connectDone", "http.gotConn", "http.gotFirstResponseByte"} {
+		if !events[event] {
+			t.Errorf("expected a %v span event, got %v", event, events)
+		}
+	}
+
+	t.Run("TLS", func(t *testing.T) {
+		item, err := src.Get(context.Background(), "global", tlsServer.URL, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		timings, _ := item.GetAttributes().Get("timings")
+		if _, ok := timings.(map[string]interface{})["tlsMs"]; !ok {
+			t.Errorf("expected timings to include tlsMs, got %v", timings)
+		}
+
+		if _, ok := timings.(map[string]interface{})["dnsMs"]; ok {
+			t.Errorf("expected no DNS timing for an IP, got %v", timings)
+		}
+	})
+}
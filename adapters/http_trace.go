@@ -0,0 +1,174 @@
+package adapters
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// httpTimings Records when each phase of a request happened using
+// httptrace. Phases that didn't happen, such as DNS for an IP or connecting
+// when a connection was reused, are left as zero
+type httpTimings struct {
+	mu sync.Mutex
+
+	Start        time.Time
+	DNSStart     time.Time
+	DNSDone      time.Time
+	ConnectStart time.Time
+	ConnectDone  time.Time
+	TLSStart     time.Time
+	TLSDone      time.Time
+	GotConn      time.Time
+	WroteRequest time.Time
+	FirstByte    time.Time
+
+	// The address that was actually connected to
+	RemoteAddr net.Addr
+	// Whether an existing connection was used
+	Reused bool
+	// Whether the request went through a proxy. If it did the connection is
+	// to the proxy, so the address and the DNS and connect timings aren't
+	// the server's and aren't reported
+	Proxied bool
+}
+
+// withHTTPTrace Returns a context that records the timings of the request it
+// is used for, and adds each phase as an event on the current span
+func withHTTPTrace(ctx context.Context) (context.Context, *httpTimings) {
+	t := &httpTimings{
+		Start: time.Now(),
+	}
+
+	span := trace.SpanFromContext(ctx)
+
+	// record Sets a time and adds a span event, httptrace hooks can be called
+	// concurrently when dialing more than one address
+	record := func(field *time.Time, event string, attrs ...attribute.KeyValue) {
+		now := time.Now()
+
+		t.mu.Lock()
+		if field.IsZero() {
+			*field = now
+		}
+		t.mu.Unlock()
+
+		span.AddEvent(event, trace.WithTimestamp(now), trace.WithAttributes(attrs...))
+	}
+
+	ct := &httptrace.ClientTrace{
+		DNSStart: func(info httptrace.DNSStartInfo) {
+			record(&t.DNSStart, "http.dnsStart", attribute.String("ovm.http.host", info.Host))
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			addrs := make([]string, 0, len(info.Addrs))
+			for _, a := range info.Addrs {
+				addrs = append(addrs, a.String())
+			}
+
+			record(&t.DNSDone, "http.dnsDone", attribute.StringSlice("ovm.http.addrs", addrs))
+		},
+		ConnectStart: func(network, addr string) {
+			record(&t.ConnectStart, "http.connectStart", attribute.String("ovm.http.addr", addr))
+		},
+		ConnectDone: func(network, addr string, err error) {
+			if err != nil {
+				span.AddEvent("http.connectFailed", trace.WithAttributes(
+					attribute.String("ovm.http.addr", addr),
+					attribute.String("ovm.http.error", err.Error()),
+				))
+				return
+			}
+
+			record(&t.ConnectDone, "http.connectDone", attribute.String("ovm.http.addr", addr))
+		},
+		TLSHandshakeStart: func() {
+			record(&t.TLSStart, "http.tlsHandshakeStart")
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			record(&t.TLSDone, "http.tlsHandshakeDone")
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.RemoteAddr = info.Conn.RemoteAddr()
+			t.Reused = info.Reused
+			t.mu.Unlock()
+
+			record(&t.GotConn, "http.gotConn",
+				attribute.String("ovm.http.remoteAddress", info.Conn.RemoteAddr().String()),
+				attribute.Bool("ovm.http.reused", info.Reused),
+			)
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			record(&t.WroteRequest, "http.wroteRequest")
+		},
+		GotFirstResponseByte: func() {
+			record(&t.FirstByte, "http.gotFirstResponseByte")
+		},
+	}
+
+	return httptrace.WithClientTrace(ctx, ct), t
+}
+
+// RemoteIP Returns the IP that was connected to, or nil if the connection
+// wasn't made or was to a proxy
+func (t *httpTimings) RemoteIP() net.IP {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.Proxied {
+		return nil
+	}
+
+	if tcp, ok := t.RemoteAddr.(*net.TCPAddr); ok {
+		return tcp.IP
+	}
+
+	return nil
+}
+
+// Attributes Returns the duration of each phase that happened in
+// milliseconds, and the address that was connected to. The durations are
+// also set on the span
+func (t *httpTimings) Attributes(span trace.Span) map[string]interface{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	timings := make(map[string]interface{})
+
+	phase := func(name string, start time.Time, end time.Time) {
+		if start.IsZero() || end.IsZero() {
+			return
+		}
+
+		ms := end.Sub(start).Milliseconds()
+		timings[name] = ms
+		span.SetAttributes(attribute.Int64("ovm.http."+name, ms))
+	}
+
+	if !t.Proxied {
+		phase("dnsMs", t.DNSStart, t.DNSDone)
+		phase("connectMs", t.ConnectStart, t.ConnectDone)
+	}
+	phase("tlsMs", t.TLSStart, t.TLSDone)
+	// The time the server took to respond after the request was sent
+	phase("waitMs", t.WroteRequest, t.FirstByte)
+	phase("firstByteMs", t.Start, t.FirstByte)
+
+	attrs := map[string]interface{}{
+		"timings":          timings,
+		"connectionReused": t.Reused,
+	}
+
+	if t.RemoteAddr != nil && !t.Proxied {
+		attrs["remoteAddress"] = t.RemoteAddr.String()
+	}
+
+	return attrs
+}
@@ -0,0 +1,86 @@
+package adapters
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/overmindtech/sdp-go"
+	"golang.org/x/net/idna"
+)
+
+// idnaProfile Converts between U-labels and A-labels (RFC 5891) using the
+// UTS #46 mapping that browsers use, so that case and width differences map
+// to the same name. Underscores and wildcards are allowed since they are
+// common in DNS even though they aren't valid hostnames
+var idnaProfile = idna.New(
+	idna.MapForLookup(),
+	idna.Transitional(false),
+	idna.StrictDomainName(false),
+	idna.VerifyDNSLength(true),
+	idna.BidiRule(),
+)
+
+// NormalizeDomain Converts a domain name to the form that is used in queries
+// and items: A-labels, lower case and without a trailing dot. This means that
+// "Bücher.example." and "xn--bcher-kva.example" both return
+// "xn--bcher-kva.example"
+func NormalizeDomain(name string) (string, error) {
+	name = strings.TrimSuffix(name, ".")
+
+	if name == "" {
+		return "", fmt.Errorf("domain name is empty")
+	}
+
+	if strings.IndexFunc(name, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+		return "", fmt.Errorf("%q is not a valid domain name: contains whitespace", name)
+	}
+
+	ascii, err := idnaProfile.ToASCII(name)
+	if err != nil {
+		return "", fmt.Errorf("%q is not a valid domain name: %w", name, err)
+	}
+
+	return ascii, nil
+}
+
+// UnicodeDomain Returns the Unicode form of a normalised domain name, for
+// display. If the name can't be converted it is returned unchanged
+func UnicodeDomain(name string) string {
+	unicodeName, err := idnaProfile.ToUnicode(name)
+	if err != nil {
+		return name
+	}
+
+	return unicodeName
+}
+
+// normalizeDomainQuery Normalises a domain name from a query, returning a
+// QueryError if it isn't valid
+func normalizeDomainQuery(query string, scope string) (string, *sdp.QueryError) {
+	name, err := NormalizeDomain(query)
+	if err != nil {
+		return "", &sdp.QueryError{
+			ErrorType:   sdp.QueryError_OTHER,
+			ErrorString: err.Error(),
+			Scope:       scope,
+		}
+	}
+
+	return name, nil
+}
+
+// withUnicodeName Adds a `unicodeName` attribute if the `name` attribute
+// contains A-labels, so that internationalised names are readable
+func withUnicodeName(attrs map[string]interface{}) map[string]interface{} {
+	name, ok := attrs["name"].(string)
+	if !ok || !strings.Contains(name, "xn--") {
+		return attrs
+	}
+
+	if unicodeName := UnicodeDomain(name); unicodeName != name {
+		attrs["unicodeName"] = unicodeName
+	}
+
+	return attrs
+}
@@ -0,0 +1,140 @@
+package adapters
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/overmindtech/sdp-go"
+)
+
+func TestNormalizeDomain(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Input    string
+		Expected string
+		Invalid  bool
+	}{
+		{Input: "example.com", Expected: "example.com"},
+		{Input: "Example.COM.", Expected: "example.com"},
+		{Input: "Bücher.example", Expected: "xn--bcher-kva.example"},
+		{Input: "xn--bcher-kva.example", Expected: "xn--bcher-kva.example"},
+		{Input: "faß.de", Expected: "xn--fa-hia.de"},
+		{Input: "ｅｘａｍｐｌｅ.com", Expected: "example.com"},
+		{Input: "_dmarc.example.com", Expected: "_dmarc.example.com"},
+		{Input: "*.example.com", Expected: "*.example.com"},
+		{Input: "", Invalid: true},
+		{Input: "a..example.com", Invalid: true},
+		{Input: "ex ample.com", Invalid: true},
+		{Input: "xn--zz.example", Invalid: true},
+		{Input: "-leading.example", Invalid: true},
+	}
+
+	for _, test := range tests {
+		actual, err := NormalizeDomain(test.Input)
+
+		if test.Invalid {
+			if err == nil {
+				t.Errorf("expected %q to be invalid, got %q", test.Input, actual)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("unexpected error for %q: %v", test.Input, err)
+		} else if actual != test.Expected {
+			t.Errorf("expected %q to be %q, got %q", test.Input, test.Expected, actual)
+		}
+	}
+
+	if u := UnicodeDomain("xn--bcher-kva.example"); u != "bücher.example" {
+		t.Errorf("expected bücher.example, got %v", u)
+	}
+}
+
+func TestDNSInternationalisedNames(t *testing.T) {
+	t.Parallel()
+
+	src := DNSAdapter{
+		Servers: []string{newTestDNSServer(t,
+			"xn--bcher-kva.example. 300 IN A 192.0.2.1",
+		)},
+	}
+
+	unicodeItem, err := src.Get(context.Background(), "global", "Bücher.example", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	asciiItem, err := src.Get(context.Background(), "global", "xn--bcher-kva.example", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if unicodeItem.UniqueAttributeValue() != "xn--bcher-kva.example" || asciiItem.UniqueAttributeValue() != unicodeItem.UniqueAttributeValue() {
+		t.Errorf("expected both forms to return the same item, got %v and %v", unicodeItem.UniqueAttributeValue(), asciiItem.UniqueAttributeValue())
+	}
+
+	if name, _ := unicodeItem.GetAttributes().Get("unicodeName"); name != "bücher.example" {
+		t.Errorf("expected unicodeName to be bücher.example, got %v", name)
+	}
+
+	_, err = src.Search(context.Background(), "global", "-invalid.example", false)
+
+	var qErr *sdp.QueryError
+	if !errors.As(err, &qErr) || qErr.GetErrorType() != sdp.QueryError_OTHER {
+		t.Errorf("expected an OTHER QueryError, got %v", err)
+	}
+}
+
+func TestCertificateSANLinks(t *testing.T) {
+	t.Parallel()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "xn--bcher-kva.example"},
+		DNSNames:     []string{"xn--bcher-kva.example", "*.xn--bcher-kva.example", "WWW.example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := CertificateAdapter{}
+	items, err := src.Search(context.Background(), "global", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names, _ := items[0].GetAttributes().Get("unicodeDnsNames")
+	if !reflect.DeepEqual(names, []interface{}{"bücher.example", "*.bücher.example", "www.example.com"}) {
+		t.Errorf("unexpected unicodeDnsNames %v", names)
+	}
+
+	links := make([]string, 0)
+	for _, liq := range items[0].GetLinkedItemQueries() {
+		links = append(links, liq.GetQuery().GetQuery())
+	}
+
+	if !reflect.DeepEqual(links, []string{"xn--bcher-kva.example", "www.example.com"}) {
+		t.Errorf("unexpected links %v", links)
+	}
+}
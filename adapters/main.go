@@ -2,18 +2,19 @@ package adapters
 
 import (
 	"errors"
+	"net/http"
 	"net/url"
 	"reflect"
 	"regexp"
 	"time"
 
 	"github.com/openrdap/rdap"
+	"github.com/openrdap/rdap/bootstrap"
 	"github.com/overmindtech/discovery"
 	"github.com/overmindtech/sdp-go"
 	"github.com/overmindtech/sdpcache"
 	"github.com/overmindtech/stdlib-source/adapters/test"
 	log "github.com/sirupsen/logrus"
-	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 
 	_ "embed"
 )
@@ -23,7 +24,52 @@ var Metadata = sdp.AdapterMetadataList{}
 // Cache duration for RDAP adapters, these things shouldn't change very often
 const RdapCacheDuration = 30 * time.Minute
 
-func InitializeEngine(ec *discovery.EngineConfig, reverseDNS bool) (*discovery.Engine, error) {
+// DNSOptions Configuration for the DNS adapter
+type DNSOptions struct {
+	// Whether to perform reverse lookups on IP addresses
+	ReverseLookup bool
+	// DNS servers to use in order of preference, see DNSAdapter.Servers
+	Servers []string
+	// The floor and ceiling for how long results are cached
+	MinCacheDuration time.Duration
+	MaxCacheDuration time.Duration
+	// How long after expiry a result can be served if every server is
+	// failing, negative values disable this
+	MaxStaleDuration time.Duration
+	// Whether to validate answers using DNSSEC
+	ValidateDNSSEC bool
+	// Zone transfer configuration, nil disables zone transfers
+	ZoneTransfer *ZoneTransferConfig
+}
+
+// HTTPOptions Configuration for the HTTP adapter
+type HTTPOptions struct {
+	// Send `GET` requests and fingerprint the body rather than sending `HEAD`
+	// requests
+	FullGet bool
+	// The maximum number of bytes of a body to read in GET mode
+	MaxBodySize int64
+	// The maximum number of redirects to follow when searching
+	MaxRedirects int
+}
+
+// TLSOptions Configuration for the TLS adapter
+type TLSOptions struct {
+	// Test every protocol version and cipher suite when finding TLS items
+	Scan bool
+	// How many scan handshakes run at the same time for each item
+	ScanConcurrency int
+	// The maximum number of scan handshakes per second to each host
+	ScanRate float64
+}
+
+// CertificateOptions Configuration for the certificate adapter
+type CertificateOptions struct {
+	// Passwords to try when decrypting PKCS#12 files
+	PKCS12Passwords []string
+}
+
+func InitializeEngine(ec *discovery.EngineConfig, dnsOptions DNSOptions, httpOptions HTTPOptions, tlsOptions TLSOptions, certificateOptions CertificateOptions, clientOptions HTTPClientOptions) (*discovery.Engine, error) {
 	e, err := discovery.NewEngine(ec)
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -38,14 +84,58 @@ func InitializeEngine(ec *discovery.EngineConfig, reverseDNS bool) (*discovery.E
 		}
 	}
 
+	// Every adapter that makes HTTP requests shares the same proxy and CAs
+	clientFactory, err := NewHTTPClientFactory(clientOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	rdapClientFac := newRdapClientFactory(clientFactory.Client())
+
+	dnsAdapter := &DNSAdapter{
+		Servers:          dnsOptions.Servers,
+		ReverseLookup:    dnsOptions.ReverseLookup,
+		ValidateDNSSEC:   dnsOptions.ValidateDNSSEC,
+		MinCacheDuration: dnsOptions.MinCacheDuration,
+		MaxCacheDuration: dnsOptions.MaxCacheDuration,
+		MaxStaleDuration: dnsOptions.MaxStaleDuration,
+		ZoneTransfer:     dnsOptions.ZoneTransfer,
+		ClientFactory:    clientFactory,
+	}
+
 	// Add the base adapters
 	adapters := []discovery.Adapter{
-		&CertificateAdapter{},
-		&DNSAdapter{
-			ReverseLookup: reverseDNS,
+		&CertificateAdapter{
+			RootCAs:         clientFactory.RootCAs(),
+			PKCS12Passwords: certificateOptions.PKCS12Passwords,
+		},
+		dnsAdapter,
+		&DNSConsistencyAdapter{
+			DNS: dnsAdapter,
+		},
+		&DNSTraceAdapter{},
+		&DomainAdapter{},
+		&EmailDomainAdapter{
+			// Share the DNS adapter so that server health is shared
+			DNS:           dnsAdapter,
+			ClientFactory: clientFactory,
+		},
+		&HTTPAdapter{
+			FullGet:       httpOptions.FullGet,
+			MaxBodySize:   httpOptions.MaxBodySize,
+			MaxRedirects:  httpOptions.MaxRedirects,
+			ClientFactory: clientFactory,
 		},
-		&HTTPAdapter{},
 		&IPAdapter{},
+		&SPFAdapter{
+			DNS: dnsAdapter,
+		},
+		&TLSAdapter{
+			ClientFactory:   clientFactory,
+			Scan:            tlsOptions.Scan,
+			ScanConcurrency: tlsOptions.ScanConcurrency,
+			ScanRate:        tlsOptions.ScanRate,
+		},
 		&test.TestDogAdapter{},
 		&test.TestGroupAdapter{},
 		&test.TestHobbyAdapter{},
@@ -53,37 +143,50 @@ func InitializeEngine(ec *discovery.EngineConfig, reverseDNS bool) (*discovery.E
 		&test.TestPersonAdapter{},
 		&test.TestRegionAdapter{},
 		&RdapIPNetworkAdapter{
-			ClientFac: newRdapClient,
+			ClientFac: rdapClientFac,
 			Cache:     sdpcache.NewCache(),
 			IPCache:   NewIPCache[*rdap.IPNetwork](),
 		},
 		&RdapASNAdapter{
-			ClientFac: newRdapClient,
+			ClientFac: rdapClientFac,
 			Cache:     sdpcache.NewCache(),
 		},
 		&RdapDomainAdapter{
-			ClientFac: newRdapClient,
+			ClientFac: rdapClientFac,
 			Cache:     sdpcache.NewCache(),
 		},
 		&RdapEntityAdapter{
-			ClientFac: newRdapClient,
+			ClientFac: rdapClientFac,
 			Cache:     sdpcache.NewCache(),
 		},
 		&RdapNameserverAdapter{
-			ClientFac: newRdapClient,
+			ClientFac: rdapClientFac,
 			Cache:     sdpcache.NewCache(),
 		},
 	}
 
+	// Each record type that a DNS search returns can also be fetched
+	// directly
+	for _, adapter := range NewDNSRecordAdapters(dnsAdapter) {
+		adapters = append(adapters, adapter)
+	}
+
 	err = e.AddAdapters(adapters...)
 
 	return e, err
 }
 
-// newRdapClient Creates a new RDAP client using otelhttp.DefaultClient. rdap is suspected to not be thread safe, so we create a new client for each request
-func newRdapClient() *rdap.Client {
-	return &rdap.Client{
-		HTTP: otelhttp.DefaultClient,
+// newRdapClientFactory Returns a function that creates RDAP clients using the
+// HTTP client. rdap is suspected to not be thread safe, so we create a new
+// client for each request
+func newRdapClientFactory(client *http.Client) func() *rdap.Client {
+	return func() *rdap.Client {
+		return &rdap.Client{
+			HTTP: client,
+			Bootstrap: &bootstrap.Client{
+				HTTP: client,
+			},
+		}
 	}
 }
 
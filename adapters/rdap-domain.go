@@ -2,6 +2,7 @@ package adapters
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 
@@ -33,7 +34,7 @@ var rdapDomainMetadata = Metadata.Register(&sdp.AdapterMetadata{
 	DescriptiveName: "RDAP Domain",
 	Type:            "rdap-domain",
 	SupportedQueryMethods: &sdp.AdapterSupportedQueryMethods{
-		SearchDescription: "Search for a domain record by the domain name e.g. \"www.google.com\"",
+		SearchDescription: "Search for a domain record by the domain name e.g. \"www.google.com\". The registrable domain is found using the Public Suffix List, so this will return the record for \"google.com\"",
 		Search:            true,
 	},
 	PotentialLinks: []string{"dns", "rdap-nameserver", "rdap-entity", "rdap-ip-network"},
@@ -81,12 +82,18 @@ func (s *RdapDomainAdapter) List(ctx context.Context, scope string, ignoreCache
 	}
 }
 
-// Search for the most specific domain that contains the specified domain. The
-// input should be something like "www.google.com". This will first search for
-// "www.google.com", then "google.com", then "com"
+// Search for the registration of the specified domain. The input should be
+// something like "www.google.com". The registrable domain, "google.com", is
+// found using the Public Suffix List and queried. If it is under a private
+// suffix such as "blogspot.com" the domain registered with ICANN is queried
+// after that
 func (s *RdapDomainAdapter) Search(ctx context.Context, scope string, query string, ignoreCache bool) ([]*sdp.Item, error) {
-	// Strip the trailing dot if it exists
-	query = strings.TrimSuffix(query, ".")
+	// Convert to A-labels and strip the trailing dot, RDAP servers expect
+	// A-labels and this means that each form of the name is cached together
+	query, qErr := normalizeDomainQuery(query, scope)
+	if qErr != nil {
+		return nil, qErr
+	}
 
 	hit, ck, items, sdpErr := s.Cache.Lookup(ctx, s.Name(), sdp.QueryMethod_SEARCH, scope, s.Type(), query, ignoreCache)
 
@@ -97,14 +104,22 @@ func (s *RdapDomainAdapter) Search(ctx context.Context, scope string, query stri
 		return items, nil
 	}
 
-	// Split the query into subdomains
-	sections := strings.Split(query, ".")
+	parts, err := SplitDomain(query)
+	if err != nil {
+		err := &sdp.QueryError{
+			ErrorType:   sdp.QueryError_NOTFOUND,
+			Scope:       scope,
+			ErrorString: err.Error(),
+		}
+
+		s.Cache.StoreError(err, RdapCacheDuration, ck)
 
-	// Start by querying the whole domain, then go down from there, however
-	// don't query for the top-level domain as it won't return anything useful
-	for i := 0; i < len(sections)-1; i++ {
-		domainName := strings.Join(sections[i:], ".")
+		return nil, err
+	}
 
+	// Only registrable domains are in RDAP, so there is no point querying
+	// subdomains or public suffixes
+	for _, domainName := range parts.rdapCandidates() {
 		request := &rdap.Request{
 			Type:  rdap.DomainRequest,
 			Query: domainName,
@@ -114,8 +129,19 @@ func (s *RdapDomainAdapter) Search(ctx context.Context, scope string, query stri
 		response, err := s.ClientFac().Do(request)
 
 		if err != nil {
-			// If there was an error, continue to the next domain
-			continue
+			err = wrapRdapError(err)
+
+			var qErr *sdp.QueryError
+			if errors.As(err, &qErr) && qErr.GetErrorType() == sdp.QueryError_NOTFOUND {
+				// Try the next candidate
+				continue
+			}
+
+			return nil, &sdp.QueryError{
+				ErrorType:   sdp.QueryError_OTHER,
+				Scope:       scope,
+				ErrorString: fmt.Sprintf("error looking up %v: %v", domainName, err),
+			}
 		}
 
 		if response.Object == nil {
@@ -132,6 +158,13 @@ func (s *RdapDomainAdapter) Search(ctx context.Context, scope string, query stri
 			return nil, fmt.Errorf("Unexpected response type %T", response.Object)
 		}
 
+		// Not every server returns the Unicode name, so fill it in to make
+		// sure that both forms are always available
+		unicodeName := domain.UnicodeName
+		if unicodeName == "" && domain.LDHName != "" {
+			unicodeName = UnicodeDomain(strings.ToLower(domain.LDHName))
+		}
+
 		attributes, err := sdp.ToAttributesCustom(map[string]interface{}{
 			"conformance":     domain.Conformance,
 			"events":          domain.Events,
@@ -145,7 +178,7 @@ func (s *RdapDomainAdapter) Search(ctx context.Context, scope string, query stri
 			"remarks":         domain.Remarks,
 			"secureDNS":       domain.SecureDNS,
 			"status":          domain.Status,
-			"unicodeName":     domain.UnicodeName,
+			"unicodeName":     unicodeName,
 			"variants":        domain.Variants,
 		}, true, RDAPTransforms)
 
@@ -228,7 +261,7 @@ func (s *RdapDomainAdapter) Search(ctx context.Context, scope string, query stri
 		return []*sdp.Item{item}, nil
 	}
 
-	err := &sdp.QueryError{
+	err = &sdp.QueryError{
 		ErrorType:   sdp.QueryError_NOTFOUND,
 		Scope:       scope,
 		ErrorString: fmt.Sprintf("No domain found for %s", query),
@@ -0,0 +1,636 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/overmindtech/sdp-go"
+	"github.com/overmindtech/sdpcache"
+)
+
+// spfLookupLimit The maximum number of DNS lookups that an SPF evaluation can
+// cause before it fails with a permerror, see RFC 7208 section 4.6.4
+const spfLookupLimit = 10
+
+// spfMaxDepth The maximum depth of includes that will be expanded. The lookup
+// limit means that a valid record can never be deeper than this
+const spfMaxDepth = spfLookupLimit
+
+// SPFMechanism A single mechanism from an SPF record e.g. "-include:foo.com"
+type SPFMechanism struct {
+	// The qualifier: "+", "-", "~" or "?"
+	Qualifier string
+	// The name of the mechanism e.g. "include", "ip4" or "all"
+	Type string
+	// The value after the colon, if any
+	Value string
+}
+
+// String Returns the mechanism in the same format as the record
+func (m SPFMechanism) String() string {
+	s := m.Type
+	if m.Qualifier != "+" {
+		s = m.Qualifier + s
+	}
+
+	switch {
+	case strings.HasPrefix(m.Value, "/"):
+		// Just a CIDR length e.g. "a/24"
+		s += m.Value
+	case m.Value != "":
+		s += ":" + m.Value
+	}
+
+	return s
+}
+
+// CausesLookup Whether the mechanism requires a DNS lookup and therefore
+// counts towards the limit
+func (m SPFMechanism) CausesLookup() bool {
+	switch m.Type {
+	case "include", "a", "mx", "ptr", "exists":
+		return true
+	default:
+		return false
+	}
+}
+
+// SPFRecord A parsed SPF record
+type SPFRecord struct {
+	Domain     string
+	Raw        string
+	Mechanisms []SPFMechanism
+	// The value of the redirect= modifier
+	Redirect string
+	// The value of the exp= modifier
+	Explanation string
+}
+
+// Lookups Returns the number of DNS lookups that this record causes, not
+// including those of the records that it includes
+func (r *SPFRecord) Lookups() int {
+	var lookups int
+
+	for _, m := range r.Mechanisms {
+		if m.CausesLookup() {
+			lookups++
+		}
+	}
+
+	// The redirect is ignored if there is an "all" mechanism
+	if r.Redirect != "" && r.All() == "" {
+		lookups++
+	}
+
+	return lookups
+}
+
+// All Returns the qualifier of the "all" mechanism, or an empty string if
+// there isn't one
+func (r *SPFRecord) All() string {
+	for _, m := range r.Mechanisms {
+		if m.Type == "all" {
+			return m.Qualifier
+		}
+	}
+
+	return ""
+}
+
+// isSPFRecord Checks whether a TXT record is an SPF record
+func isSPFRecord(txt string) bool {
+	return strings.EqualFold(txt, "v=spf1") || strings.HasPrefix(strings.ToLower(txt), "v=spf1 ")
+}
+
+// ParseSPF Parses an SPF record
+func ParseSPF(domain string, record string) (*SPFRecord, error) {
+	if !isSPFRecord(record) {
+		return nil, fmt.Errorf("%q is not an SPF record", record)
+	}
+
+	parsed := &SPFRecord{
+		Domain:     domain,
+		Raw:        record,
+		Mechanisms: make([]SPFMechanism, 0),
+	}
+
+	for _, term := range strings.Fields(record)[1:] {
+		// Modifiers are in the format name=value
+		if name, value, ok := strings.Cut(term, "="); ok && !strings.ContainsAny(name, ":/") {
+			switch strings.ToLower(name) {
+			case "redirect":
+				parsed.Redirect = value
+			case "exp":
+				parsed.Explanation = value
+			}
+
+			// Unknown modifiers must be ignored
+			continue
+		}
+
+		m := SPFMechanism{
+			Qualifier: "+",
+		}
+
+		if strings.ContainsAny(term[:1], "+-~?") {
+			m.Qualifier = term[:1]
+			term = term[1:]
+		}
+
+		// The value is separated by a colon, a and mx can also have just a
+		// CIDR length e.g. "a/24"
+		name, value, _ := strings.Cut(term, ":")
+		if before, cidr, ok := strings.Cut(name, "/"); ok {
+			name = before
+			value += "/" + cidr
+		}
+
+		m.Type = strings.ToLower(name)
+		m.Value = value
+
+		switch m.Type {
+		case "all", "include", "a", "mx", "ptr", "ip4", "ip6", "exists":
+		default:
+			return nil, fmt.Errorf("unknown mechanism %q", term)
+		}
+
+		parsed.Mechanisms = append(parsed.Mechanisms, m)
+	}
+
+	return parsed, nil
+}
+
+// SPFTree An SPF record along with the records that it includes
+type SPFTree struct {
+	Domain string
+	Record *SPFRecord
+	// Records referenced by include: mechanisms
+	Includes []*SPFTree
+	// The record referenced by the redirect= modifier
+	Redirect *SPFTree
+	// The total number of lookups including those of included records
+	Lookups int
+	// Errors encountered while expanding this record, not including errors
+	// in included records
+	Errors []string
+}
+
+// OverLimit Returns whether the total lookups are over the limit
+func (t *SPFTree) OverLimit() bool {
+	return t.Lookups > spfLookupLimit
+}
+
+// AllErrors Returns the errors from this record and all included records
+func (t *SPFTree) AllErrors() []string {
+	errs := append([]string{}, t.Errors...)
+
+	for _, child := range t.children() {
+		errs = append(errs, child.AllErrors()...)
+	}
+
+	return errs
+}
+
+func (t *SPFTree) children() []*SPFTree {
+	children := append([]*SPFTree{}, t.Includes...)
+	if t.Redirect != nil {
+		children = append(children, t.Redirect)
+	}
+
+	return children
+}
+
+// errNoSPFRecord is returned when a domain doesn't have an SPF record
+var errNoSPFRecord = errors.New("no SPF record found")
+
+// txtLookupFunc Returns the TXT records for a name
+type txtLookupFunc func(ctx context.Context, name string) ([]string, error)
+
+// fetchSPF Finds and parses the SPF record for a domain
+func fetchSPF(ctx context.Context, lookup txtLookupFunc, domain string) (*SPFRecord, error) {
+	txts, err := lookup(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]string, 0)
+	for _, txt := range txts {
+		if isSPFRecord(txt) {
+			records = append(records, txt)
+		}
+	}
+
+	switch len(records) {
+	case 0:
+		return nil, errNoSPFRecord
+	case 1:
+		return ParseSPF(domain, records[0])
+	default:
+		// RFC 7208 section 4.5, this is a permerror
+		return nil, fmt.Errorf("%v has %v SPF records, there must only be one", domain, len(records))
+	}
+}
+
+// ExpandSPF Fetches the SPF record for a domain and recursively expands its
+// includes and redirect, counting the total number of DNS lookups. Errors in
+// included records are recorded in the tree rather than returned, an error is
+// only returned if the record for the domain itself can't be fetched
+func ExpandSPF(ctx context.Context, lookup txtLookupFunc, domain string) (*SPFTree, error) {
+	record, err := fetchSPF(ctx, lookup, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	return expandSPFRecord(ctx, lookup, record, map[string]bool{strings.ToLower(domain): true}, 0), nil
+}
+
+func expandSPFRecord(ctx context.Context, lookup txtLookupFunc, record *SPFRecord, visited map[string]bool, depth int) *SPFTree {
+	tree := &SPFTree{
+		Domain:   record.Domain,
+		Record:   record,
+		Includes: make([]*SPFTree, 0),
+		Lookups:  record.Lookups(),
+		Errors:   make([]string, 0),
+	}
+
+	expand := func(target string) *SPFTree {
+		key := strings.ToLower(target)
+
+		if visited[key] {
+			tree.Errors = append(tree.Errors, fmt.Sprintf("loop detected including %v", target))
+			return nil
+		}
+
+		if depth >= spfMaxDepth {
+			tree.Errors = append(tree.Errors, fmt.Sprintf("maximum include depth reached at %v", target))
+			return nil
+		}
+
+		if strings.Contains(target, "%") {
+			// Macros are expanded per message, so can't be followed
+			tree.Errors = append(tree.Errors, fmt.Sprintf("cannot expand macro in %v", target))
+			return nil
+		}
+
+		child, err := fetchSPF(ctx, lookup, target)
+		if err != nil {
+			tree.Errors = append(tree.Errors, fmt.Sprintf("%v: %v", target, err))
+			return nil
+		}
+
+		visited[key] = true
+		defer delete(visited, key)
+
+		return expandSPFRecord(ctx, lookup, child, visited, depth+1)
+	}
+
+	for _, m := range record.Mechanisms {
+		if m.Type != "include" {
+			continue
+		}
+
+		if child := expand(m.Value); child != nil {
+			tree.Includes = append(tree.Includes, child)
+			tree.Lookups += child.Lookups
+		}
+	}
+
+	if record.Redirect != "" && record.All() == "" {
+		if child := expand(record.Redirect); child != nil {
+			tree.Redirect = child
+			tree.Lookups += child.Lookups
+		}
+	}
+
+	if depth == 0 && tree.OverLimit() {
+		tree.Errors = append(tree.Errors, fmt.Sprintf("record requires %v DNS lookups, the limit is %v", tree.Lookups, spfLookupLimit))
+	}
+
+	return tree
+}
+
+const spfCacheDuration = 5 * time.Minute
+
+// SPFAdapter Returns SPF records as items, linked to the records that they
+// include, the names that they reference and the networks that they allow.
+// Following the links shows everyone who is able to send mail as a domain
+type SPFAdapter struct {
+	// The DNS adapter to use for lookups. Defaults to a DNSAdapter using the
+	// default servers
+	DNS *DNSAdapter
+
+	defaultsOnce sync.Once
+
+	cache       *sdpcache.Cache // The sdpcache of this adapter
+	cacheInitMu sync.Mutex      // Mutex to ensure cache is only initialised once
+}
+
+func (s *SPFAdapter) ensureCache() {
+	s.cacheInitMu.Lock()
+	defer s.cacheInitMu.Unlock()
+
+	if s.cache == nil {
+		s.cache = sdpcache.NewCache()
+	}
+}
+
+func (s *SPFAdapter) Cache() *sdpcache.Cache {
+	s.ensureCache()
+	return s.cache
+}
+
+func (s *SPFAdapter) ensureDefaults() {
+	s.defaultsOnce.Do(func() {
+		if s.DNS == nil {
+			s.DNS = &DNSAdapter{}
+		}
+	})
+}
+
+// Type The type of items that this adapter is capable of finding
+func (s *SPFAdapter) Type() string {
+	return "spf"
+}
+
+// Descriptive name for the adapter, used in logging and metadata
+func (s *SPFAdapter) Name() string {
+	return "stdlib-spf"
+}
+
+// Weighting of duplicate adapters
+func (s *SPFAdapter) Weight() int {
+	return 100
+}
+
+// Metadata Returns metadata about the adapter
+func (s *SPFAdapter) Metadata() *sdp.AdapterMetadata {
+	return spfMetadata
+}
+
+var spfMetadata = Metadata.Register(&sdp.AdapterMetadata{
+	DescriptiveName: "SPF Record",
+	Type:            "spf",
+	SupportedQueryMethods: &sdp.AdapterSupportedQueryMethods{
+		Get:               true,
+		Search:            true,
+		GetDescription:    "A domain to get the SPF record of e.g. \"example.com\"",
+		SearchDescription: "A domain to get the SPF record of, along with every record that it includes or redirects to",
+	},
+	PotentialLinks: []string{"spf", "dns", "ip", "rdap-ip-network"},
+	Category:       sdp.AdapterCategory_ADAPTER_CATEGORY_SECURITY,
+})
+
+// List of scopes that this adapter is capable of find items for
+func (s *SPFAdapter) Scopes() []string {
+	return []string{
+		"global",
+	}
+}
+
+// Get Returns the SPF record for a domain. Expanding a record fetches
+// everything that it includes, so every record in the tree is cached at the
+// same time
+func (s *SPFAdapter) Get(ctx context.Context, scope string, query string, ignoreCache bool) (*sdp.Item, error) {
+	if scope != "global" {
+		return nil, &sdp.QueryError{
+			ErrorType:   sdp.QueryError_NOSCOPE,
+			ErrorString: "spf is only supported in the 'global' scope",
+			Scope:       scope,
+		}
+	}
+
+	domain, qErr := normalizeDomainQuery(query, scope)
+	if qErr != nil {
+		return nil, qErr
+	}
+
+	if net.ParseIP(domain) != nil {
+		return nil, &sdp.QueryError{
+			ErrorType:   sdp.QueryError_OTHER,
+			ErrorString: fmt.Sprintf("%v is not a valid domain", query),
+			Scope:       scope,
+		}
+	}
+
+	s.ensureCache()
+	hit, ck, cachedItems, qErr := s.cache.Lookup(ctx, s.Name(), sdp.QueryMethod_GET, scope, s.Type(), domain, ignoreCache)
+	if qErr != nil {
+		return nil, qErr
+	}
+	if hit && len(cachedItems) > 0 {
+		return cachedItems[0], nil
+	}
+
+	s.ensureDefaults()
+
+	tree, err := ExpandSPF(ctx, s.DNS.LookupTXT, domain)
+	if err != nil {
+		qErr = &sdp.QueryError{
+			ErrorType:   sdp.QueryError_OTHER,
+			ErrorString: err.Error(),
+			Scope:       scope,
+		}
+
+		if errors.Is(err, errNoSPFRecord) {
+			qErr.ErrorType = sdp.QueryError_NOTFOUND
+		}
+
+		s.cache.StoreError(qErr, spfCacheDuration, ck)
+
+		return nil, qErr
+	}
+
+	var root *sdp.Item
+	stored := make(map[string]bool)
+
+	var store func(t *SPFTree) error
+	store = func(t *SPFTree) error {
+		if stored[t.Domain] {
+			return nil
+		}
+		stored[t.Domain] = true
+
+		item, err := spfTreeToItem(t)
+		if err != nil {
+			return err
+		}
+
+		if root == nil {
+			root = item
+		}
+
+		s.cache.StoreItem(item, spfCacheDuration, sdpcache.CacheKeyFromParts(s.Name(), sdp.QueryMethod_GET, scope, s.Type(), t.Domain))
+
+		for _, child := range t.children() {
+			if err := store(child); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if err := store(tree); err != nil {
+		return nil, &sdp.QueryError{
+			ErrorType:   sdp.QueryError_OTHER,
+			ErrorString: err.Error(),
+			Scope:       scope,
+		}
+	}
+
+	return root, nil
+}
+
+// List is not supported
+func (s *SPFAdapter) List(ctx context.Context, scope string, ignoreCache bool) ([]*sdp.Item, error) {
+	return make([]*sdp.Item, 0), nil
+}
+
+// Search Returns the SPF record for a domain followed by every record that it
+// includes or redirects to. Records that can't be fetched are reported in the
+// errors of the record that references them
+func (s *SPFAdapter) Search(ctx context.Context, scope string, query string, ignoreCache bool) ([]*sdp.Item, error) {
+	root, err := s.Get(ctx, scope, query, ignoreCache)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []*sdp.Item{root}
+	seen := map[string]bool{root.UniqueAttributeValue(): true}
+
+	// The Get above cached the whole tree, so following the links is cheap
+	for i := 0; i < len(items); i++ {
+		for _, liq := range items[i].GetLinkedItemQueries() {
+			q := liq.GetQuery()
+			if q.GetType() != s.Type() || seen[q.GetQuery()] {
+				continue
+			}
+			seen[q.GetQuery()] = true
+
+			item, err := s.Get(ctx, scope, q.GetQuery(), false)
+			if err != nil {
+				continue
+			}
+
+			items = append(items, item)
+		}
+	}
+
+	return items, nil
+}
+
+// spfTreeToItem Converts a single record in the tree to an item. The total
+// lookups are those caused by the record and everything that it includes, so
+// an included record that is over the limit on its own can be spotted
+func spfTreeToItem(t *SPFTree) (*sdp.Item, error) {
+	links := &linkedQueries{}
+
+	mechanisms := make([]string, 0, len(t.Record.Mechanisms))
+	ip4 := make([]string, 0)
+	ip6 := make([]string, 0)
+
+	for _, m := range t.Record.Mechanisms {
+		mechanisms = append(mechanisms, m.String())
+
+		switch m.Type {
+		case "include":
+			if !strings.Contains(m.Value, "%") {
+				// Whoever controls the included record can send as this
+				// domain
+				links.add("spf", sdp.QueryMethod_GET, strings.ToLower(trimDnsSuffix(m.Value)), true, false)
+			}
+		case "a", "mx", "exists":
+			// a and mx refer to the domain itself if no name is given. The
+			// name may also have a CIDR length e.g. "a:example.com/24"
+			name, _, _ := strings.Cut(m.Value, "/")
+			if name == "" {
+				name = t.Domain
+			}
+
+			links.addName(name)
+		case "ip4", "ip6":
+			if m.Type == "ip4" {
+				ip4 = append(ip4, m.Value)
+			} else {
+				ip6 = append(ip6, m.Value)
+			}
+
+			if prefix, err := netip.ParsePrefix(m.Value); err == nil {
+				// Changing the network, for example by it being reassigned
+				// to someone else, changes who can send as this domain
+				links.add("rdap-ip-network", sdp.QueryMethod_SEARCH, prefix.String(), true, false)
+
+				if prefix.IsSingleIP() {
+					links.add("ip", sdp.QueryMethod_GET, prefix.Addr().String(), true, false)
+				}
+			} else if addr, err := netip.ParseAddr(m.Value); err == nil {
+				links.add("rdap-ip-network", sdp.QueryMethod_SEARCH, addr.String(), true, false)
+				links.add("ip", sdp.QueryMethod_GET, addr.String(), true, false)
+			}
+		}
+	}
+
+	if t.Record.Redirect != "" && t.Record.All() == "" && !strings.Contains(t.Record.Redirect, "%") {
+		links.add("spf", sdp.QueryMethod_GET, strings.ToLower(trimDnsSuffix(t.Record.Redirect)), true, false)
+	}
+
+	attributes := map[string]interface{}{
+		"domain":              t.Domain,
+		"record":              t.Record.Raw,
+		"mechanisms":          mechanisms,
+		"ip4":                 ip4,
+		"ip6":                 ip6,
+		"lookups":             t.Record.Lookups(),
+		"totalLookups":        t.Lookups,
+		"lookupLimit":         spfLookupLimit,
+		"lookupLimitExceeded": t.OverLimit(),
+	}
+
+	if all := t.Record.All(); all != "" {
+		attributes["all"] = all
+	}
+
+	if t.Record.Redirect != "" {
+		attributes["redirect"] = t.Record.Redirect
+	}
+
+	if t.Record.Explanation != "" {
+		attributes["explanation"] = t.Record.Explanation
+	}
+
+	if len(t.Errors) > 0 {
+		attributes["errors"] = t.Errors
+	}
+
+	attrs, err := sdp.ToAttributes(attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	item := &sdp.Item{
+		Type:              "spf",
+		UniqueAttribute:   "domain",
+		Scope:             "global",
+		Attributes:        attrs,
+		LinkedItemQueries: links.queries,
+	}
+
+	// A record that is over the limit fails with a permerror, so receivers
+	// may reject all mail from the domain
+	switch {
+	case t.OverLimit():
+		item.Health = sdp.Health_HEALTH_ERROR.Enum()
+	case len(t.AllErrors()) > 0:
+		item.Health = sdp.Health_HEALTH_WARNING.Enum()
+	default:
+		item.Health = sdp.Health_HEALTH_OK.Enum()
+	}
+
+	return item, nil
+}
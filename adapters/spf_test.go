@@ -0,0 +1,263 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/overmindtech/discovery"
+	"github.com/overmindtech/sdp-go"
+)
+
+// testTXTLookup Returns a lookup function that answers from a map
+func testTXTLookup(records map[string][]string) txtLookupFunc {
+	return func(ctx context.Context, name string) ([]string, error) {
+		return records[strings.ToLower(name)], nil
+	}
+}
+
+func TestParseSPF(t *testing.T) {
+	t.Parallel()
+
+	record, err := ParseSPF("example.com", "v=spf1 ip4:192.0.2.0/24 a/24 mx:mail.example.com include:_spf.example.net ?exists:%{i}.example.com -all exp=explain.example.com unknown=ignored")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mechanisms := make([]string, 0)
+	for _, m := range record.Mechanisms {
+		mechanisms = append(mechanisms, m.String())
+	}
+
+	expected := []string{
+		"ip4:192.0.2.0/24",
+		"a/24",
+		"mx:mail.example.com",
+		"include:_spf.example.net",
+		"?exists:%{i}.example.com",
+		"-all",
+	}
+
+	if !reflect.DeepEqual(mechanisms, expected) {
+		t.Errorf("expected %v, got %v", expected, mechanisms)
+	}
+
+	if record.All() != "-" {
+		t.Errorf("expected all to be -, got %v", record.All())
+	}
+
+	if record.Explanation != "explain.example.com" {
+		t.Errorf("expected explanation, got %v", record.Explanation)
+	}
+
+	// a, mx, include and exists
+	if record.Lookups() != 4 {
+		t.Errorf("expected 4 lookups, got %v", record.Lookups())
+	}
+
+	if _, err := ParseSPF("example.com", "v=spf1 foo:bar"); err == nil {
+		t.Error("expected unknown mechanism to fail")
+	}
+
+	if _, err := ParseSPF("example.com", "v=spf10"); err == nil {
+		t.Error("expected invalid version to fail")
+	}
+}
+
+func TestExpandSPF(t *testing.T) {
+	t.Parallel()
+
+	t.Run("includes and redirects", func(t *testing.T) {
+		lookup := testTXTLookup(map[string][]string{
+			"example.com":       {"v=spf1 include:_spf.example.net redirect=_spf.example.org"},
+			"_spf.example.net":  {"some-verification=abc", "v=spf1 ip4:192.0.2.1 a mx ~all"},
+			"_spf.example.org":  {"v=spf1 include:_spf2.example.org -all"},
+			"_spf2.example.org": {"v=spf1 ip6:2001:db8::/32 -all"},
+		})
+
+		tree, err := ExpandSPF(context.Background(), lookup, "example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// include + redirect, a + mx, include
+		if tree.Lookups != 5 {
+			t.Errorf("expected 5 lookups, got %v", tree.Lookups)
+		}
+
+		if len(tree.Includes) != 1 || tree.Includes[0].Domain != "_spf.example.net" {
+			t.Errorf("expected _spf.example.net to be included, got %v", tree.Includes)
+		}
+
+		if tree.Redirect == nil || len(tree.Redirect.Includes) != 1 {
+			t.Fatalf("expected redirect to be expanded, got %v", tree.Redirect)
+		}
+
+		if errs := tree.AllErrors(); len(errs) != 0 {
+			t.Errorf("expected no errors, got %v", errs)
+		}
+	})
+
+	t.Run("lookup limit", func(t *testing.T) {
+		records := map[string][]string{
+			"example.com":   {"v=spf1 include:a.example.com include:b.example.com -all"},
+			"a.example.com": {"v=spf1 a mx ptr exists:x.example.com include:c.example.com -all"},
+			"b.example.com": {"v=spf1 a mx a:foo.example.com -all"},
+			"c.example.com": {"v=spf1 a -all"},
+		}
+
+		tree, err := ExpandSPF(context.Background(), testTXTLookup(records), "example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if tree.Lookups != 11 {
+			t.Errorf("expected 11 lookups, got %v", tree.Lookups)
+		}
+
+		if !tree.OverLimit() {
+			t.Error("expected record to be over the limit")
+		}
+
+		if len(tree.Errors) != 1 {
+			t.Errorf("expected the limit to be reported, got %v", tree.Errors)
+		}
+	})
+
+	t.Run("loops and missing records", func(t *testing.T) {
+		lookup := testTXTLookup(map[string][]string{
+			"example.com":      {"v=spf1 include:loop.example.com include:missing.example.com -all"},
+			"loop.example.com": {"v=spf1 include:example.com -all"},
+		})
+
+		tree, err := ExpandSPF(context.Background(), lookup, "example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		errs := tree.AllErrors()
+		if len(errs) != 2 {
+			t.Fatalf("expected 2 errors, got %v", errs)
+		}
+
+		if !strings.Contains(strings.Join(errs, "\n"), "loop detected") {
+			t.Errorf("expected loop to be detected, got %v", errs)
+		}
+	})
+
+	t.Run("no record", func(t *testing.T) {
+		_, err := ExpandSPF(context.Background(), testTXTLookup(nil), "example.com")
+		if err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
+
+func TestSPFAdapter(t *testing.T) {
+	t.Parallel()
+
+	src := SPFAdapter{
+		DNS: &DNSAdapter{
+			Servers: []string{newTestDNSServer(t,
+				`example.com. 300 IN TXT "v=spf1 ip4:192.0.2.1 mx include:_spf.example.net include:missing.example.org -all"`,
+				`_spf.example.net. 300 IN TXT "v=spf1 ip4:198.51.100.0/24 ip6:2001:db8::/32 a:mail.example.net/24 ~all"`,
+			)},
+		},
+	}
+
+	t.Run("Get", func(t *testing.T) {
+		item, err := src.Get(context.Background(), "global", "example.com.", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		discovery.TestValidateItem(t, item)
+
+		// mx and two includes, then a in the included record
+		if lookups, _ := item.GetAttributes().Get("totalLookups"); lookups != float64(4) {
+			t.Errorf("expected 4 total lookups, got %v", lookups)
+		}
+
+		if lookups, _ := item.GetAttributes().Get("lookups"); lookups != float64(3) {
+			t.Errorf("expected 3 lookups, got %v", lookups)
+		}
+
+		if item.GetHealth() != sdp.Health_HEALTH_WARNING {
+			t.Errorf("expected the missing include to be a warning, got %v", item.GetHealth())
+		}
+
+		assertLinks(t, item, []string{
+			"ip GET 192.0.2.1",
+			"rdap-ip-network SEARCH 192.0.2.1",
+			"dns SEARCH example.com",
+			"spf GET _spf.example.net",
+			"spf GET missing.example.org",
+		})
+	})
+
+	t.Run("included record is cached", func(t *testing.T) {
+		item, err := src.Get(context.Background(), "global", "_spf.example.net", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if lookups, _ := item.GetAttributes().Get("totalLookups"); lookups != float64(1) {
+			t.Errorf("expected 1 total lookup, got %v", lookups)
+		}
+
+		if item.GetHealth() != sdp.Health_HEALTH_OK {
+			t.Errorf("expected health to be OK, got %v", item.GetHealth())
+		}
+
+		assertLinks(t, item, []string{
+			"rdap-ip-network SEARCH 198.51.100.0/24",
+			"rdap-ip-network SEARCH 2001:db8::/32",
+			"dns SEARCH mail.example.net",
+		})
+	})
+
+	t.Run("Search", func(t *testing.T) {
+		items, err := src.Search(context.Background(), "global", "example.com", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		domains := make([]string, 0)
+		for _, item := range items {
+			domains = append(domains, item.UniqueAttributeValue())
+		}
+
+		if !reflect.DeepEqual(domains, []string{"example.com", "_spf.example.net"}) {
+			t.Errorf("unexpected items %v", domains)
+		}
+	})
+
+	t.Run("no record", func(t *testing.T) {
+		_, err := src.Get(context.Background(), "global", "missing.example.org", false)
+
+		var qErr *sdp.QueryError
+		if !errors.As(err, &qErr) || qErr.GetErrorType() != sdp.QueryError_NOTFOUND {
+			t.Errorf("expected NOTFOUND, got %v", err)
+		}
+	})
+}
+
+// assertLinks Checks that the item links to each of the expected queries
+func assertLinks(t *testing.T, item *sdp.Item, expected []string) {
+	t.Helper()
+
+	found := make(map[string]bool)
+	for _, liq := range item.GetLinkedItemQueries() {
+		q := liq.GetQuery()
+		found[fmt.Sprintf("%v %v %v", q.GetType(), q.GetMethod(), q.GetQuery())] = true
+	}
+
+	for _, key := range expected {
+		if !found[key] {
+			t.Errorf("missing link %v", key)
+		}
+	}
+}
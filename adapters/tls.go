@@ -0,0 +1,615 @@
+package adapters
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/overmindtech/sdp-go"
+	"github.com/overmindtech/sdpcache"
+	"golang.org/x/crypto/ocsp"
+)
+
+// TLSAdapter Handshakes with any TCP endpoint that speaks TLS, either
+// directly or after upgrading the connection with STARTTLS, and reports what
+// was negotiated
+type TLSAdapter struct {
+	// The CAs to verify certificates against. Defaults to the roots of the
+	// client factory
+	RootCAs *x509.CertPool
+	// Provides the root CAs and client certificates. Connections are made
+	// directly rather than through the HTTP proxy
+	ClientFactory *HTTPClientFactory
+	// Test each protocol version and cipher suite in separate handshakes and
+	// record which were accepted. This makes dozens of handshakes per item
+	Scan bool
+	// How many handshakes a scan runs at the same time, defaults to
+	// DefaultTLSScanConcurrency
+	ScanConcurrency int
+	// The maximum number of scan handshakes per second to each host,
+	// defaults to DefaultTLSScanRate
+	ScanRate float64
+
+	limiter     *hostRateLimiter
+	limiterOnce sync.Once
+
+	cache       *sdpcache.Cache // The sdpcache of this adapter
+	cacheInitMu sync.Mutex      // Mutex to ensure cache is only initialised once
+}
+
+const tlsCacheDuration = 5 * time.Minute
+
+// tlsTimeout How long connecting, STARTTLS and the handshake can take in total
+const tlsTimeout = 10 * time.Second
+
+// tlsDefaultNextProtos The ALPN protocols offered when connecting directly.
+// Nothing is offered after STARTTLS since the protocol is already known
+var tlsDefaultNextProtos = []string{"h2", "http/1.1"}
+
+func (s *TLSAdapter) ensureCache() {
+	s.cacheInitMu.Lock()
+	defer s.cacheInitMu.Unlock()
+
+	if s.cache == nil {
+		s.cache = sdpcache.NewCache()
+	}
+}
+
+func (s *TLSAdapter) Cache() *sdpcache.Cache {
+	s.ensureCache()
+	return s.cache
+}
+
+func (s *TLSAdapter) rootCAs() *x509.CertPool {
+	if s.RootCAs != nil {
+		return s.RootCAs
+	}
+
+	return s.ClientFactory.RootCAs()
+}
+
+// Type The type of items that this adapter is capable of finding
+func (s *TLSAdapter) Type() string {
+	return "tls"
+}
+
+// Descriptive name for the adapter, used in logging and metadata
+func (s *TLSAdapter) Name() string {
+	return "stdlib-tls"
+}
+
+// Metadata Returns metadata about the adapter
+func (s *TLSAdapter) Metadata() *sdp.AdapterMetadata {
+	return tlsMetadata
+}
+
+var tlsMetadata = Metadata.Register(&sdp.AdapterMetadata{
+	DescriptiveName: "TLS Endpoint",
+	Type:            "tls",
+	SupportedQueryMethods: &sdp.AdapterSupportedQueryMethods{
+		Get:            true,
+		GetDescription: "A TLS endpoint as `host:port` e.g. `mail.example.com:465`. To use STARTTLS prefix it with the protocol e.g. `smtp://mail.example.com:587`, the port defaults to the protocol's standard port. Supported protocols are smtp, imap, pop3, ftp, ldap and postgres. If scanning is enabled every protocol version and cipher suite is tested",
+	},
+	Category:       sdp.AdapterCategory_ADAPTER_CATEGORY_NETWORK,
+	PotentialLinks: []string{"ip", "dns", "certificate"},
+})
+
+// List of scopes that this adapter is capable of find items for. If the
+// adapter supports all scopes the special value `AllScopes` ("*")
+// should be used
+func (s *TLSAdapter) Scopes() []string {
+	return []string{
+		"global", // This is a reserved word meaning that the items should be considered globally unique
+	}
+}
+
+// tlsTarget An endpoint to handshake with
+type tlsTarget struct {
+	// The STARTTLS protocol, empty if TLS is used directly
+	Protocol string
+	Host     string
+	Port     string
+}
+
+// Address The address to connect to
+func (t tlsTarget) Address() string {
+	return net.JoinHostPort(t.Host, t.Port)
+}
+
+// String The normalised query for the target
+func (t tlsTarget) String() string {
+	if t.Protocol == "" {
+		return t.Address()
+	}
+
+	return t.Protocol + "://" + t.Address()
+}
+
+// ServerName The name to send using SNI, empty for IPs since they can't be
+// sent
+func (t tlsTarget) ServerName() string {
+	if net.ParseIP(t.Host) != nil {
+		return ""
+	}
+
+	return t.Host
+}
+
+// parseTLSTarget Parses a query in the format `[protocol://]host[:port]`.
+// The port is only optional if a STARTTLS protocol is given
+func parseTLSTarget(query string) (tlsTarget, error) {
+	var t tlsTarget
+
+	rest := query
+
+	if protocol, after, ok := strings.Cut(query, "://"); ok {
+		t.Protocol = strings.ToLower(protocol)
+		rest = after
+
+		if t.Protocol == "tls" {
+			t.Protocol = ""
+		} else if _, ok := starttlsDefaultPorts[t.Protocol]; !ok {
+			return t, fmt.Errorf("unsupported STARTTLS protocol %v", protocol)
+		}
+	}
+
+	host, port, err := net.SplitHostPort(rest)
+	if err != nil && t.Protocol != "" {
+		host, port, err = net.SplitHostPort(rest + ":" + starttlsDefaultPorts[t.Protocol])
+	}
+	if err != nil {
+		return t, fmt.Errorf("expected host:port: %w", err)
+	}
+
+	if host == "" {
+		return t, errors.New("expected host:port: missing host")
+	}
+
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return t, fmt.Errorf("invalid port %v", port)
+	}
+
+	t.Host = strings.ToLower(strings.TrimSuffix(host, "."))
+	t.Port = port
+
+	return t, nil
+}
+
+// tlsVersionName Returns the name of a TLS version e.g. "TLSv1.3"
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLSv1.0"
+	case tls.VersionTLS11:
+		return "TLSv1.1"
+	case tls.VersionTLS12:
+		return "TLSv1.2"
+	case tls.VersionTLS13:
+		return "TLSv1.3"
+	default:
+		return "unknown"
+	}
+}
+
+// tlsHandshake The result of a successful handshake
+type tlsHandshake struct {
+	State      tls.ConnectionState
+	RemoteAddr net.Addr
+	Duration   time.Duration
+}
+
+// tlsConfig Returns the config to handshake with. Verification is skipped so
+// that untrusted endpoints can still be discovered
+func (s *TLSAdapter) tlsConfig(target tlsTarget, serverName string) *tls.Config {
+	config := s.ClientFactory.TLSConfig()
+	config.InsecureSkipVerify = true     // nolint:gosec // The chain is verified separately
+	config.MinVersion = tls.VersionTLS10 // nolint:gosec // Old versions are reported rather than refused
+	config.ServerName = serverName
+
+	if target.Protocol == "" {
+		config.NextProtos = tlsDefaultNextProtos
+	}
+
+	return config
+}
+
+// handshake Connects to the target, runs STARTTLS if required, and completes
+// a TLS handshake using the config. The connection is closed before
+// returning
+func (s *TLSAdapter) handshake(ctx context.Context, target tlsTarget, config *tls.Config) (*tlsHandshake, error) {
+	ctx, cancel := context.WithTimeout(ctx, tlsTimeout)
+	defer cancel()
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", target.Address())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// STARTTLS can't be cancelled directly, so close the connection instead
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	if target.Protocol != "" {
+		if err := startTLS(conn, target.Protocol); err != nil {
+			return nil, contextError(ctx, err)
+		}
+	}
+
+	tlsConn := tls.Client(conn, config)
+
+	start := time.Now()
+	err = tlsConn.HandshakeContext(ctx)
+	duration := time.Since(start)
+
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+
+	return &tlsHandshake{
+		State:      tlsConn.ConnectionState(),
+		RemoteAddr: conn.RemoteAddr(),
+		Duration:   duration,
+	}, nil
+}
+
+// contextError Returns the context's error if it is done, since closing the
+// connection when it is cancelled causes a less useful error
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+
+	return err
+}
+
+// TLS alerts that servers send when they refuse a handshake, see RFC 8446
+// section 6
+const (
+	tlsAlertHandshakeFailure     uint8 = 40
+	tlsAlertProtocolVersion      uint8 = 70
+	tlsAlertInsufficientSecurity uint8 = 71
+	tlsAlertUnrecognizedName     uint8 = 112
+)
+
+// tlsAlert Returns the alert that ended a handshake, either sent by the server
+// or by the client in response to what the server sent. Returns false if the
+// handshake failed for another reason, such as the connection being dropped
+func tlsAlert(err error) (uint8, bool) {
+	var alertErr tls.AlertError
+	if errors.As(err, &alertErr) {
+		return uint8(alertErr), true
+	}
+
+	// crypto/tls doesn't export the type of alerts received from the server,
+	// but it is always a uint8
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "remote error" {
+		if v := reflect.ValueOf(opErr.Err); v.Kind() == reflect.Uint8 {
+			return uint8(v.Uint()), true
+		}
+	}
+
+	return 0, false
+}
+
+// isTLSAlert Whether a handshake ended with one of the given alerts
+func isTLSAlert(err error, alerts ...uint8) bool {
+	alert, ok := tlsAlert(err)
+
+	return ok && slices.Contains(alerts, alert)
+}
+
+// Get Handshakes with the endpoint. The item's name is the normalised query,
+// which includes the default port if a STARTTLS protocol was given without
+// one
+func (s *TLSAdapter) Get(ctx context.Context, scope string, query string, ignoreCache bool) (*sdp.Item, error) {
+	if scope != "global" {
+		return nil, &sdp.QueryError{
+			ErrorType:   sdp.QueryError_NOSCOPE,
+			ErrorString: "tls is only supported in the 'global' scope",
+			Scope:       scope,
+		}
+	}
+
+	s.ensureCache()
+	cacheHit, ck, cachedItems, qErr := s.cache.Lookup(ctx, s.Name(), sdp.QueryMethod_GET, scope, s.Type(), query, ignoreCache)
+	if qErr != nil {
+		return nil, qErr
+	}
+	if cacheHit {
+		if len(cachedItems) > 0 {
+			return cachedItems[0], nil
+		} else {
+			return nil, nil
+		}
+	}
+
+	target, err := parseTLSTarget(query)
+	if err != nil {
+		err = &sdp.QueryError{
+			ErrorType:   sdp.QueryError_OTHER,
+			ErrorString: err.Error(),
+			Scope:       scope,
+		}
+		s.cache.StoreError(err, tlsCacheDuration, ck)
+		return nil, err
+	}
+
+	serverName := target.ServerName()
+
+	h, err := s.handshake(ctx, target, s.tlsConfig(target, serverName))
+	if err != nil {
+		err = &sdp.QueryError{
+			ErrorType:   sdp.QueryError_OTHER,
+			ErrorString: err.Error(),
+			Scope:       scope,
+		}
+		s.cache.StoreError(err, tlsCacheDuration, ck)
+		return nil, err
+	}
+
+	state := h.State
+	port, _ := strconv.Atoi(target.Port)
+
+	attrMap := map[string]interface{}{
+		"name":          target.String(),
+		"host":          target.Host,
+		"port":          port,
+		"version":       tlsVersionName(state.Version),
+		"cipherSuite":   tls.CipherSuiteName(state.CipherSuite),
+		"handshakeMs":   h.Duration.Milliseconds(),
+		"remoteAddress": h.RemoteAddr.String(),
+		"ocspStapled":   len(state.OCSPResponse) > 0,
+		"sni":           s.sniAttributes(ctx, target, serverName, state.PeerCertificates),
+	}
+
+	if target.Protocol != "" {
+		attrMap["starttls"] = target.Protocol
+	}
+
+	if state.NegotiatedProtocol != "" {
+		attrMap["alpn"] = state.NegotiatedProtocol
+	}
+
+	var health *sdp.Health
+
+	if state.Version < tls.VersionTLS12 {
+		// Deprecated by RFC 8996
+		health = sdp.Health_HEALTH_WARNING.Enum()
+	}
+
+	if len(state.OCSPResponse) > 0 {
+		staple, stapleHealth := ocspStapleAttributes(state.OCSPResponse, state.PeerCertificates)
+		attrMap["ocspStaple"] = staple
+
+		if stapleHealth != nil && (health == nil || *stapleHealth == sdp.Health_HEALTH_ERROR) {
+			health = stapleHealth
+		}
+	}
+
+	if s.Scan {
+		result, err := s.scan(ctx, target, serverName)
+		if err != nil {
+			err = &sdp.QueryError{
+				ErrorType:   sdp.QueryError_OTHER,
+				ErrorString: err.Error(),
+				Scope:       scope,
+			}
+			s.cache.StoreError(err, tlsCacheDuration, ck)
+			return nil, err
+		}
+
+		attrMap["scan"] = result.Attributes()
+
+		if len(result.Issues()) > 0 && health == nil {
+			health = sdp.Health_HEALTH_WARNING.Enum()
+		}
+	}
+
+	if len(state.PeerCertificates) > 0 {
+		attrMap["certificate"] = CertToName(state.PeerCertificates[0])
+	}
+
+	// The hostname is only checked for names since that is what clients
+	// would connect with
+	verification := verifyChain(state.PeerCertificates, s.rootCAs(), serverName, true)
+	for k, v := range verification.Attributes() {
+		attrMap[k] = v
+	}
+
+	if !verification.Trusted || (verification.HostnameMatch != nil && !*verification.HostnameMatch) {
+		// Clients will refuse to connect
+		health = sdp.Health_HEALTH_ERROR.Enum()
+	}
+
+	attributes, err := sdp.ToAttributes(attrMap)
+	if err != nil {
+		err = &sdp.QueryError{
+			ErrorType:   sdp.QueryError_OTHER,
+			ErrorString: err.Error(),
+			Scope:       scope,
+		}
+		s.cache.StoreError(err, tlsCacheDuration, ck)
+		return nil, err
+	}
+
+	item := sdp.Item{
+		Type:            "tls",
+		UniqueAttribute: "name",
+		Attributes:      attributes,
+		Scope:           "global",
+		Health:          health,
+	}
+
+	if serverName != "" {
+		item.LinkedItemQueries = append(item.LinkedItemQueries, &sdp.LinkedItemQuery{
+			Query: &sdp.Query{
+				Type:   "dns",
+				Method: sdp.QueryMethod_SEARCH,
+				Query:  target.Host,
+				Scope:  "global",
+			},
+			BlastPropagation: &sdp.BlastPropagation{
+				// DNS always linked
+				In:  true,
+				Out: true,
+			},
+		})
+	}
+
+	if tcp, ok := h.RemoteAddr.(*net.TCPAddr); ok {
+		item.LinkedItemQueries = append(item.LinkedItemQueries, &sdp.LinkedItemQuery{
+			Query: &sdp.Query{
+				Type:   "ip",
+				Method: sdp.QueryMethod_GET,
+				Query:  tcp.IP.String(),
+				Scope:  "global",
+			},
+			BlastPropagation: &sdp.BlastPropagation{
+				// IPs always linked
+				In:  true,
+				Out: true,
+			},
+		})
+	}
+
+	if len(state.PeerCertificates) > 0 {
+		item.LinkedItemQueries = append(item.LinkedItemQueries, certificateBundleLink(state.PeerCertificates, scope))
+	}
+
+	s.cache.StoreItem(&item, tlsCacheDuration, ck)
+
+	return &item, nil
+}
+
+// sniAttributes Describes how the server behaves without SNI by handshaking a
+// second time without it. Servers that host many names either refuse the
+// handshake or present a default certificate, which clients that don't send
+// SNI will see. If the handshake fails without the server saying why, it
+// isn't known whether SNI is required
+func (s *TLSAdapter) sniAttributes(ctx context.Context, target tlsTarget, serverName string, certs []*x509.Certificate) map[string]interface{} {
+	if serverName == "" {
+		return map[string]interface{}{
+			"sent": false,
+		}
+	}
+
+	attrs := map[string]interface{}{
+		"sent":       true,
+		"serverName": serverName,
+	}
+
+	h, err := s.handshake(ctx, target, s.tlsConfig(target, ""))
+	if err != nil {
+		attrs["errorWithoutSni"] = err.Error()
+
+		// Only an alert says that the server refused the handshake because
+		// of the missing name. Other failures, such as a timeout, could
+		// happen either way so `required` is left unknown
+		if isTLSAlert(err, tlsAlertUnrecognizedName, tlsAlertHandshakeFailure) {
+			attrs["required"] = true
+		}
+
+		return attrs
+	}
+
+	attrs["required"] = false
+
+	var same bool
+	if len(certs) > 0 && len(h.State.PeerCertificates) > 0 {
+		same = bytes.Equal(certs[0].Raw, h.State.PeerCertificates[0].Raw)
+
+		if !same {
+			attrs["certificateWithoutSni"] = CertToName(h.State.PeerCertificates[0])
+		}
+	}
+
+	attrs["sameCertificateWithoutSni"] = same
+
+	return attrs
+}
+
+// ocspStapleAttributes Parses a stapled OCSP response for the leaf. Returns
+// an error health if the certificate has been revoked, and a warning if the
+// response has expired or can't be parsed since clients that require
+// stapling will refuse it
+func ocspStapleAttributes(raw []byte, certs []*x509.Certificate) (map[string]interface{}, *sdp.Health) {
+	if len(certs) == 0 {
+		return map[string]interface{}{
+			"error": "no certificates",
+		}, sdp.Health_HEALTH_WARNING.Enum()
+	}
+
+	var issuer *x509.Certificate
+	if len(certs) > 1 {
+		issuer = certs[1]
+	}
+
+	res, err := ocsp.ParseResponseForCert(raw, certs[0], issuer)
+	if err != nil {
+		return map[string]interface{}{
+			"error": err.Error(),
+		}, sdp.Health_HEALTH_WARNING.Enum()
+	}
+
+	attrs := map[string]interface{}{
+		"producedAt": res.ProducedAt.String(),
+		"thisUpdate": res.ThisUpdate.String(),
+	}
+
+	var health *sdp.Health
+
+	switch res.Status {
+	case ocsp.Good:
+		attrs["status"] = "good"
+	case ocsp.Revoked:
+		attrs["status"] = "revoked"
+		attrs["revokedAt"] = res.RevokedAt.String()
+		attrs["revocationReason"] = res.RevocationReason
+		health = sdp.Health_HEALTH_ERROR.Enum()
+	default:
+		attrs["status"] = "unknown"
+		health = sdp.Health_HEALTH_WARNING.Enum()
+	}
+
+	if !res.NextUpdate.IsZero() {
+		attrs["nextUpdate"] = res.NextUpdate.String()
+
+		if time.Now().After(res.NextUpdate) {
+			attrs["expired"] = true
+
+			if health == nil {
+				health = sdp.Health_HEALTH_WARNING.Enum()
+			}
+		}
+	}
+
+	return attrs, health
+}
+
+// List is not implemented since there is no way to know which endpoints
+// exist
+func (s *TLSAdapter) List(ctx context.Context, scope string, ignoreCache bool) ([]*sdp.Item, error) {
+	return make([]*sdp.Item, 0), nil
+}
+
+// Weight Returns the priority weighting of items returned by this adapter.
+// This is used to resolve conflicts where two adapters of the same type
+// return an item for a GET request. In this instance only one item can be
+// sen on, so the one with the higher weight value will win.
+func (s *TLSAdapter) Weight() int {
+	return 100
+}
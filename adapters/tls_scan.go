@@ -0,0 +1,423 @@
+package adapters
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTLSScanConcurrency How many handshakes a scan runs at the same time
+const DefaultTLSScanConcurrency = 4
+
+// DefaultTLSScanRate The maximum number of handshakes per second that are
+// made to a single host when scanning
+const DefaultTLSScanRate = 10.0
+
+// tlsScanVersions The protocol versions that are tested. SSLv3 and earlier
+// aren't supported by crypto/tls so can't be tested
+var tlsScanVersions = []uint16{
+	tls.VersionTLS10,
+	tls.VersionTLS11,
+	tls.VersionTLS12,
+	tls.VersionTLS13,
+}
+
+// tlsScanCipherSuites The cipher suites that are tested, including insecure
+// ones. Only TLS 1.0-1.2 suites can be tested since crypto/tls doesn't allow
+// the TLS 1.3 suites to be chosen, the TLS 1.3 suite that the server picks is
+// reported instead
+var tlsScanCipherSuites = sync.OnceValue(func() []uint16 {
+	suites := make([]uint16, 0)
+
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		if slices.ContainsFunc(suite.SupportedVersions, func(v uint16) bool { return v <= tls.VersionTLS12 }) {
+			suites = append(suites, suite.ID)
+		}
+	}
+
+	slices.Sort(suites)
+
+	return suites
+})
+
+// errClientHelloCaptured Aborts the handshake once the ClientHello has been
+// captured
+var errClientHelloCaptured = errors.New("client hello captured")
+
+// tlsClientCipherOrder The order that crypto/tls offers the scanned cipher
+// suites in, which depends on whether the CPU has AES hardware. It ignores
+// the order of Config.CipherSuites, so this is found by capturing a
+// ClientHello over a pipe
+var tlsClientCipherOrder = sync.OnceValue(func() []uint16 {
+	client, server := net.Pipe()
+
+	var order []uint16
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		defer server.Close()
+
+		_ = tls.Server(server, &tls.Config{
+			GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+				order = hello.CipherSuites
+				return nil, errClientHelloCaptured
+			},
+		}).Handshake()
+	}()
+
+	_ = tls.Client(client, &tls.Config{
+		InsecureSkipVerify: true,             // nolint:gosec // The handshake is never completed
+		MinVersion:         tls.VersionTLS10, // nolint:gosec // All suites need to be offered
+		MaxVersion:         tls.VersionTLS12,
+		CipherSuites:       tlsScanCipherSuites(),
+	}).Handshake()
+
+	client.Close()
+	<-done
+
+	return order
+})
+
+// cipherSuiteWeakness Returns why a cipher suite is weak, or an empty string
+// if it isn't
+func cipherSuiteWeakness(id uint16) string {
+	name := tls.CipherSuiteName(id)
+
+	switch {
+	case strings.Contains(name, "_RC4_"):
+		return "RC4 is broken"
+	case strings.Contains(name, "_3DES_"):
+		return "3DES is vulnerable to Sweet32"
+	case strings.HasSuffix(name, "_CBC_SHA256"):
+		return "CBC with SHA-256 is vulnerable to Lucky13"
+	case strings.HasPrefix(name, "TLS_RSA_"):
+		return "RSA key exchange has no forward secrecy"
+	default:
+		return ""
+	}
+}
+
+// tlsScanResult What a server accepted when scanned
+type tlsScanResult struct {
+	// The accepted protocol versions
+	Versions []uint16
+	// The accepted TLS 1.0-1.2 cipher suites, in the order that the server
+	// chose them when offered the remaining suites
+	CipherSuites []uint16
+	// The suite chosen for TLS 1.3, zero if TLS 1.3 isn't accepted
+	TLS13CipherSuite uint16
+	// Whether the server chose suites in its own order rather than the
+	// client's. If the orders are the same this can't be detected
+	ServerPreference bool
+	// How many handshakes the scan took
+	Handshakes int
+	// Versions and cipher suites whose handshakes failed without the server
+	// refusing them, such as the connection being dropped or timing out
+	Untested []tlsScanFailure
+}
+
+// tlsScanFailure A version or cipher suite that couldn't be tested, and why
+type tlsScanFailure struct {
+	Option string
+	Error  string
+}
+
+// Issues Describes the deprecated versions and weak cipher suites that were
+// accepted
+func (r *tlsScanResult) Issues() []string {
+	issues := make([]string, 0)
+
+	for _, v := range r.Versions {
+		if v < tls.VersionTLS12 {
+			issues = append(issues, fmt.Sprintf("accepts deprecated %v", tlsVersionName(v)))
+		}
+	}
+
+	for _, suite := range r.CipherSuites {
+		if weakness := cipherSuiteWeakness(suite); weakness != "" {
+			issues = append(issues, fmt.Sprintf("accepts %v: %v", tls.CipherSuiteName(suite), weakness))
+		}
+	}
+
+	return issues
+}
+
+// Attributes Returns the result as item attributes
+func (r *tlsScanResult) Attributes() map[string]interface{} {
+	versions := make([]string, 0, len(r.Versions))
+	deprecated := make([]string, 0)
+
+	for _, v := range r.Versions {
+		versions = append(versions, tlsVersionName(v))
+
+		if v < tls.VersionTLS12 {
+			deprecated = append(deprecated, tlsVersionName(v))
+		}
+	}
+
+	suites := make([]string, 0, len(r.CipherSuites))
+	weak := make([]string, 0)
+
+	for _, suite := range r.CipherSuites {
+		suites = append(suites, tls.CipherSuiteName(suite))
+
+		if cipherSuiteWeakness(suite) != "" {
+			weak = append(weak, tls.CipherSuiteName(suite))
+		}
+	}
+
+	untested := make([]map[string]interface{}, 0, len(r.Untested))
+	for _, f := range r.Untested {
+		untested = append(untested, map[string]interface{}{
+			"option": f.Option,
+			"error":  f.Error,
+		})
+	}
+
+	attrs := map[string]interface{}{
+		"versions":           versions,
+		"deprecatedVersions": deprecated,
+		"cipherSuites":       suites,
+		"weakCipherSuites":   weak,
+		"issues":             r.Issues(),
+		"handshakes":         r.Handshakes,
+		"untested":           untested,
+	}
+
+	if r.TLS13CipherSuite != 0 {
+		attrs["tls13CipherSuite"] = tls.CipherSuiteName(r.TLS13CipherSuite)
+	}
+
+	if len(r.CipherSuites) > 1 {
+		attrs["serverCipherPreference"] = r.ServerPreference
+	}
+
+	return attrs
+}
+
+// hostRateLimiter Spaces out events to the same host so that each host sees
+// at most one per interval
+type hostRateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     map[string]time.Time
+}
+
+func newHostRateLimiter(perSecond float64) *hostRateLimiter {
+	return &hostRateLimiter{
+		interval: time.Duration(float64(time.Second) / perSecond),
+		next:     make(map[string]time.Time),
+	}
+}
+
+// Wait Blocks until the host's next slot, or the context is done
+func (l *hostRateLimiter) Wait(ctx context.Context, host string) error {
+	l.mu.Lock()
+
+	now := time.Now()
+
+	// Forget hosts whose slots have passed so the map doesn't grow forever
+	for h, next := range l.next {
+		if next.Before(now) {
+			delete(l.next, h)
+		}
+	}
+
+	at := l.next[host]
+	if at.Before(now) {
+		at = now
+	}
+	l.next[host] = at.Add(l.interval)
+
+	l.mu.Unlock()
+
+	delay := time.Until(at)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (s *TLSAdapter) scanConcurrency() int {
+	if s.ScanConcurrency > 0 {
+		return s.ScanConcurrency
+	}
+
+	return DefaultTLSScanConcurrency
+}
+
+func (s *TLSAdapter) scanLimiter() *hostRateLimiter {
+	s.limiterOnce.Do(func() {
+		rate := s.ScanRate
+		if rate <= 0 {
+			rate = DefaultTLSScanRate
+		}
+
+		s.limiter = newHostRateLimiter(rate)
+	})
+
+	return s.limiter
+}
+
+// scan Tests each protocol version and cipher suite in a separate handshake,
+// then works out the order the server prefers the accepted suites in. An
+// option is only treated as rejected if the handshake ends with an alert
+// saying so, other failures are recorded as untested
+func (s *TLSAdapter) scan(ctx context.Context, target tlsTarget, serverName string) (*tlsScanResult, error) {
+	result := &tlsScanResult{}
+
+	var mu sync.Mutex
+
+	// try Handshakes with the modified config, respecting the rate limit
+	try := func(configure func(config *tls.Config)) (*tlsHandshake, error) {
+		if err := s.scanLimiter().Wait(ctx, target.Host); err != nil {
+			return nil, err
+		}
+
+		config := s.tlsConfig(target, serverName)
+		configure(config)
+
+		mu.Lock()
+		result.Handshakes++
+		mu.Unlock()
+
+		return s.handshake(ctx, target, config)
+	}
+
+	suites := tlsScanCipherSuites()
+
+	type job struct {
+		version uint16
+		suite   uint16
+		h       *tlsHandshake
+		err     error
+	}
+
+	jobs := make([]job, 0, len(tlsScanVersions)+len(suites))
+	for _, v := range tlsScanVersions {
+		jobs = append(jobs, job{version: v})
+	}
+	for _, suite := range suites {
+		jobs = append(jobs, job{suite: suite})
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, s.scanConcurrency())
+
+	for i := range jobs {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(j *job) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			j.h, j.err = try(func(config *tls.Config) {
+				config.CipherSuites = suites
+
+				if j.version != 0 {
+					config.MinVersion = j.version
+					config.MaxVersion = j.version
+				} else {
+					config.MaxVersion = tls.VersionTLS12
+					config.CipherSuites = []uint16{j.suite}
+				}
+			})
+		}(&jobs[i])
+	}
+
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	accepted := make([]uint16, 0)
+
+	for _, j := range jobs {
+		if j.err != nil {
+			if !isTLSAlert(j.err, tlsAlertHandshakeFailure, tlsAlertProtocolVersion, tlsAlertInsufficientSecurity) {
+				option := tls.CipherSuiteName(j.suite)
+				if j.version != 0 {
+					option = tlsVersionName(j.version)
+				}
+
+				result.Untested = append(result.Untested, tlsScanFailure{
+					Option: option,
+					Error:  j.err.Error(),
+				})
+			}
+
+			continue
+		}
+
+		switch {
+		case j.version == tls.VersionTLS13:
+			result.Versions = append(result.Versions, j.version)
+			result.TLS13CipherSuite = j.h.State.CipherSuite
+		case j.version != 0:
+			result.Versions = append(result.Versions, j.version)
+		default:
+			accepted = append(accepted, j.suite)
+		}
+	}
+
+	// Offer the accepted suites and remove whichever is chosen until one is
+	// left. If the server ever doesn't choose the suite that the client
+	// prefers, it is using its own order
+	clientOrder := tlsClientCipherOrder()
+	rank := func(suite uint16) int {
+		if i := slices.Index(clientOrder, suite); i >= 0 {
+			return i
+		}
+		return len(clientOrder)
+	}
+
+	for len(accepted) > 1 {
+		h, err := try(func(config *tls.Config) {
+			config.MaxVersion = tls.VersionTLS12
+			config.CipherSuites = accepted
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			// The order of the rest is unknown
+			break
+		}
+
+		chosen := h.State.CipherSuite
+		if !slices.Contains(accepted, chosen) {
+			break
+		}
+
+		clientFirst := slices.MinFunc(accepted, func(a, b uint16) int { return rank(a) - rank(b) })
+		if chosen != clientFirst {
+			result.ServerPreference = true
+		}
+
+		result.CipherSuites = append(result.CipherSuites, chosen)
+		accepted = slices.DeleteFunc(slices.Clone(accepted), func(suite uint16) bool { return suite == chosen })
+	}
+
+	result.CipherSuites = append(result.CipherSuites, accepted...)
+
+	return result, nil
+}
@@ -0,0 +1,224 @@
+package adapters
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/overmindtech/sdp-go"
+)
+
+func TestTLSGetScan(t *testing.T) {
+	t.Parallel()
+
+	chain := newTestChain(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+	}, false)
+
+	serverSuites := []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+		tls.TLS_ECDHE_ECDSA_WITH_RC4_128_SHA,
+	}
+
+	newConfig := func() *tls.Config {
+		return &tls.Config{
+			Certificates: []tls.Certificate{testTLSCertificate(chain)},
+			MinVersion:   tls.VersionTLS11,
+			MaxVersion:   tls.VersionTLS12,
+			CipherSuites: serverSuites,
+		}
+	}
+
+	scan := func(t *testing.T, config *tls.Config) (*sdp.Item, map[string]interface{}) {
+		t.Helper()
+
+		port := startTestTLSServer(t, config, nil)
+
+		src := TLSAdapter{
+			RootCAs:  chain.Pool(),
+			Scan:     true,
+			ScanRate: 1000,
+		}
+
+		item, err := src.Get(context.Background(), "global", "127.0.0.1:"+port, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		attrs, err := item.GetAttributes().Get("scan")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return item, attrs.(map[string]interface{})
+	}
+
+	t.Run("accepted", func(t *testing.T) {
+		item, attrs := scan(t, newConfig())
+
+		if versions := attrs["versions"].([]interface{}); !slices.Equal(versions, []interface{}{"TLSv1.1", "TLSv1.2"}) {
+			t.Errorf("expected TLSv1.1 and TLSv1.2, got %v", versions)
+		}
+
+		if deprecated := attrs["deprecatedVersions"].([]interface{}); !slices.Equal(deprecated, []interface{}{"TLSv1.1"}) {
+			t.Errorf("expected TLSv1.1 to be deprecated, got %v", deprecated)
+		}
+
+		suites := attrs["cipherSuites"].([]interface{})
+		if len(suites) != len(serverSuites) {
+			t.Errorf("expected %v cipher suites, got %v", len(serverSuites), suites)
+		}
+
+		for _, suite := range serverSuites {
+			if !slices.Contains(suites, interface{}(tls.CipherSuiteName(suite))) {
+				t.Errorf("expected %v to be accepted, got %v", tls.CipherSuiteName(suite), suites)
+			}
+		}
+
+		if weak := attrs["weakCipherSuites"].([]interface{}); !slices.Equal(weak, []interface{}{"TLS_ECDHE_ECDSA_WITH_RC4_128_SHA"}) {
+			t.Errorf("expected RC4 to be weak, got %v", weak)
+		}
+
+		if issues := attrs["issues"].([]interface{}); len(issues) != 2 {
+			t.Errorf("expected 2 issues, got %v", issues)
+		}
+
+		if _, ok := attrs["tls13CipherSuite"]; ok {
+			t.Errorf("expected no TLS 1.3 suite, got %v", attrs["tls13CipherSuite"])
+		}
+
+		// Go servers use the same order as Go clients
+		if attrs["serverCipherPreference"] != false {
+			t.Errorf("expected the client's preference to be used, got %v", attrs["serverCipherPreference"])
+		}
+
+		expectedHandshakes := len(tlsScanVersions) + len(tlsScanCipherSuites()) + len(serverSuites) - 1
+		if attrs["handshakes"] != float64(expectedHandshakes) {
+			t.Errorf("expected %v handshakes, got %v", expectedHandshakes, attrs["handshakes"])
+		}
+
+		// Everything that wasn't accepted was refused with an alert
+		if untested := attrs["untested"].([]interface{}); len(untested) != 0 {
+			t.Errorf("expected everything to be tested, got %v", untested)
+		}
+
+		if item.GetHealth() != sdp.Health_HEALTH_WARNING {
+			t.Errorf("expected a warning health, got %v", item.GetHealth())
+		}
+	})
+
+	t.Run("dropped connection", func(t *testing.T) {
+		// Close the connection without an alert when only TLS 1.0 is
+		// offered, which is what some middleboxes do
+		config := newConfig()
+		config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			if slices.Max(hello.SupportedVersions) == tls.VersionTLS10 {
+				hello.Conn.Close()
+				return nil, errors.New("dropped")
+			}
+
+			return nil, nil
+		}
+
+		_, attrs := scan(t, config)
+
+		if versions := attrs["versions"].([]interface{}); !slices.Equal(versions, []interface{}{"TLSv1.1", "TLSv1.2"}) {
+			t.Errorf("expected TLSv1.1 and TLSv1.2, got %v", versions)
+		}
+
+		untested := attrs["untested"].([]interface{})
+		if len(untested) != 1 {
+			t.Fatalf("expected 1 untested option, got %v", untested)
+		}
+
+		if option := untested[0].(map[string]interface{})["option"]; option != "TLSv1.0" {
+			t.Errorf("expected TLSv1.0 to be untested, got %v", option)
+		}
+	})
+
+	t.Run("server preference", func(t *testing.T) {
+		// Always pick the suite the client likes least
+		config := newConfig()
+		config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			c := newConfig()
+
+			for i := len(hello.CipherSuites) - 1; i >= 0; i-- {
+				if slices.Contains(serverSuites, hello.CipherSuites[i]) {
+					c.CipherSuites = []uint16{hello.CipherSuites[i]}
+					break
+				}
+			}
+
+			return c, nil
+		}
+
+		_, attrs := scan(t, config)
+
+		if attrs["serverCipherPreference"] != true {
+			t.Errorf("expected the server's preference to be detected, got %v", attrs["serverCipherPreference"])
+		}
+
+		// The suites are listed in the reverse of the client's order
+		expected := make([]interface{}, 0)
+		order := tlsClientCipherOrder()
+		for i := len(order) - 1; i >= 0; i-- {
+			if suite := order[i]; slices.Contains(serverSuites, suite) {
+				expected = append(expected, tls.CipherSuiteName(suite))
+			}
+		}
+
+		if suites := attrs["cipherSuites"].([]interface{}); !slices.Equal(suites, expected) {
+			t.Errorf("expected %v, got %v", expected, suites)
+		}
+	})
+}
+
+func TestHostRateLimiter(t *testing.T) {
+	t.Parallel()
+
+	l := newHostRateLimiter(20)
+	ctx := context.Background()
+
+	start := time.Now()
+
+	for range 3 {
+		if err := l.Wait(ctx, "a.example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("expected the third wait to be at least 100ms after the first, got %v", elapsed)
+	}
+
+	// Other hosts aren't affected
+	start = time.Now()
+
+	if err := l.Wait(ctx, "b.example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 40*time.Millisecond {
+		t.Errorf("expected no wait for another host, got %v", elapsed)
+	}
+
+	// Waits can be cancelled
+	if err := l.Wait(ctx, "c.example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	if err := l.Wait(cancelled, "c.example.com"); err == nil {
+		t.Error("expected an error from a cancelled context")
+	}
+}
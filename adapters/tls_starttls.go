@@ -0,0 +1,421 @@
+package adapters
+
+import (
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"strings"
+)
+
+// starttlsDefaultPorts The STARTTLS protocols that are supported, and the
+// port used if the query doesn't include one
+var starttlsDefaultPorts = map[string]string{
+	"ftp":      "21",
+	"imap":     "143",
+	"ldap":     "389",
+	"pop3":     "110",
+	"postgres": "5432",
+	"smtp":     "25",
+}
+
+// startTLS Upgrades a plaintext connection so that it is ready for a TLS
+// handshake using the STARTTLS command of the protocol
+func startTLS(conn net.Conn, protocol string) error {
+	switch protocol {
+	case "smtp":
+		return startTLSSMTP(textproto.NewConn(conn))
+	case "imap":
+		return startTLSIMAP(textproto.NewConn(conn))
+	case "pop3":
+		return startTLSPOP3(textproto.NewConn(conn))
+	case "ftp":
+		return startTLSFTP(textproto.NewConn(conn))
+	case "ldap":
+		return startTLSLDAP(conn)
+	case "postgres":
+		return startTLSPostgres(conn)
+	default:
+		return fmt.Errorf("unsupported STARTTLS protocol %v", protocol)
+	}
+}
+
+// ehloName The name we introduce ourselves with to SMTP servers
+func ehloName() string {
+	if name, err := os.Hostname(); err == nil && name != "" {
+		return name
+	}
+
+	return "localhost"
+}
+
+func startTLSSMTP(c *textproto.Conn) error {
+	if _, _, err := c.ReadResponse(220); err != nil {
+		return fmt.Errorf("smtp greeting: %w", err)
+	}
+
+	if err := c.PrintfLine("EHLO %v", ehloName()); err != nil {
+		return err
+	}
+
+	_, msg, err := c.ReadResponse(250)
+	if err != nil {
+		return fmt.Errorf("smtp EHLO: %w", err)
+	}
+
+	// The first line is the server's name, the rest are extensions
+	var supported bool
+	for _, ext := range strings.Split(msg, "\n")[1:] {
+		if strings.EqualFold(strings.TrimSpace(ext), "STARTTLS") {
+			supported = true
+		}
+	}
+
+	if !supported {
+		return errors.New("smtp server does not support STARTTLS")
+	}
+
+	if err := c.PrintfLine("STARTTLS"); err != nil {
+		return err
+	}
+
+	if _, _, err := c.ReadResponse(220); err != nil {
+		return fmt.Errorf("smtp STARTTLS: %w", err)
+	}
+
+	return nil
+}
+
+func startTLSFTP(c *textproto.Conn) error {
+	if _, _, err := c.ReadResponse(220); err != nil {
+		return fmt.Errorf("ftp greeting: %w", err)
+	}
+
+	if err := c.PrintfLine("AUTH TLS"); err != nil {
+		return err
+	}
+
+	if _, _, err := c.ReadResponse(234); err != nil {
+		return fmt.Errorf("ftp AUTH TLS: %w", err)
+	}
+
+	return nil
+}
+
+func startTLSIMAP(c *textproto.Conn) error {
+	greeting, err := c.ReadLine()
+	if err != nil {
+		return fmt.Errorf("imap greeting: %w", err)
+	}
+
+	if !strings.HasPrefix(greeting, "* OK") {
+		return fmt.Errorf("imap greeting: %v", greeting)
+	}
+
+	if err := c.PrintfLine("a001 STARTTLS"); err != nil {
+		return err
+	}
+
+	// Skip any untagged responses until we get the response to our command
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return fmt.Errorf("imap STARTTLS: %w", err)
+		}
+
+		if strings.HasPrefix(line, "a001 ") {
+			if !strings.HasPrefix(line, "a001 OK") {
+				return fmt.Errorf("imap STARTTLS: %v", line)
+			}
+
+			return nil
+		}
+	}
+}
+
+func startTLSPOP3(c *textproto.Conn) error {
+	greeting, err := c.ReadLine()
+	if err != nil {
+		return fmt.Errorf("pop3 greeting: %w", err)
+	}
+
+	if !strings.HasPrefix(greeting, "+OK") {
+		return fmt.Errorf("pop3 greeting: %v", greeting)
+	}
+
+	if err := c.PrintfLine("STLS"); err != nil {
+		return err
+	}
+
+	line, err := c.ReadLine()
+	if err != nil {
+		return fmt.Errorf("pop3 STLS: %w", err)
+	}
+
+	if !strings.HasPrefix(line, "+OK") {
+		return fmt.Errorf("pop3 STLS: %v", line)
+	}
+
+	return nil
+}
+
+// postgresSSLRequestCode The code sent in place of a protocol version to ask
+// a Postgres server to start TLS
+const postgresSSLRequestCode = 80877103
+
+func startTLSPostgres(conn net.Conn) error {
+	req := make([]byte, 8)
+	binary.BigEndian.PutUint32(req[0:4], 8)
+	binary.BigEndian.PutUint32(req[4:8], postgresSSLRequestCode)
+
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	res := make([]byte, 1)
+	if _, err := io.ReadFull(conn, res); err != nil {
+		return fmt.Errorf("postgres SSLRequest: %w", err)
+	}
+
+	if res[0] != 'S' {
+		return errors.New("postgres server does not support TLS")
+	}
+
+	return nil
+}
+
+// ldapStartTLSOID The name of the LDAP StartTLS extended operation from RFC
+// 4511
+const ldapStartTLSOID = "1.3.6.1.4.1.1466.20037"
+
+// ldapMessage The envelope of an LDAP message, any controls are ignored
+type ldapMessage struct {
+	MessageID  int
+	ProtocolOp asn1.RawValue
+}
+
+func startTLSLDAP(conn net.Conn) error {
+	requestName, err := asn1.Marshal(asn1.RawValue{
+		Class: asn1.ClassContextSpecific,
+		Tag:   0,
+		Bytes: []byte(ldapStartTLSOID),
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := asn1.Marshal(ldapMessage{
+		MessageID: 1,
+		ProtocolOp: asn1.RawValue{
+			Class:      asn1.ClassApplication,
+			Tag:        23, // ExtendedRequest
+			IsCompound: true,
+			Bytes:      requestName,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	b, err := readBERElement(conn)
+	if err != nil {
+		return fmt.Errorf("ldap StartTLS: %w", err)
+	}
+
+	resultCode, diagnosticMessage, err := parseLDAPExtendedResponse(b)
+	if err != nil {
+		return fmt.Errorf("ldap StartTLS: %w", err)
+	}
+
+	if resultCode != 0 {
+		return fmt.Errorf("ldap StartTLS: result code %v: %s", resultCode, diagnosticMessage)
+	}
+
+	return nil
+}
+
+// parseLDAPExtendedResponse Returns the result code and diagnostic message of
+// an LDAP message containing an ExtendedResponse. This is parsed as BER
+// rather than DER since servers such as Active Directory use the five byte
+// long form for every length, which encoding/asn1 rejects
+func parseLDAPExtendedResponse(b []byte) (int, string, error) {
+	msg, _, err := parseBERElement(b)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if msg.Class != asn1.ClassUniversal || msg.Tag != asn1.TagSequence {
+		return 0, "", fmt.Errorf("expected an LDAPMessage sequence, got class %v tag %v", msg.Class, msg.Tag)
+	}
+
+	// Skip the message ID
+	_, rest, err := parseBERElement(msg.Content)
+	if err != nil {
+		return 0, "", err
+	}
+
+	op, _, err := parseBERElement(rest)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if op.Class != asn1.ClassApplication || op.Tag != 24 {
+		return 0, "", fmt.Errorf("expected an ExtendedResponse, got class %v tag %v", op.Class, op.Tag)
+	}
+
+	resultCode, rest, err := parseBERElement(op.Content)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if resultCode.Tag != asn1.TagEnum || len(resultCode.Content) == 0 || len(resultCode.Content) > 4 {
+		return 0, "", errors.New("invalid result code")
+	}
+
+	var code int
+	for _, b := range resultCode.Content {
+		code = code<<8 | int(b)
+	}
+
+	// The matched DN comes before the diagnostic message, neither of which
+	// are required to know the result
+	_, rest, err = parseBERElement(rest)
+	if err != nil {
+		return code, "", nil
+	}
+
+	diagnosticMessage, _, err := parseBERElement(rest)
+	if err != nil {
+		return code, "", nil
+	}
+
+	return code, string(diagnosticMessage.Content), nil
+}
+
+// berElement A single BER encoded element
+type berElement struct {
+	Class   int
+	Tag     int
+	Content []byte
+}
+
+// parseBERElement Parses the element at the start of b and returns it along
+// with the bytes that follow it. Unlike encoding/asn1 this accepts long form
+// lengths that could have been encoded in fewer bytes
+func parseBERElement(b []byte) (berElement, []byte, error) {
+	if len(b) < 2 {
+		return berElement{}, nil, errors.New("truncated BER element")
+	}
+
+	e := berElement{
+		Class: int(b[0] >> 6),
+		Tag:   int(b[0] & 0x1f),
+	}
+
+	i := 1
+
+	if e.Tag == 0x1f {
+		// High tag number form, the tag is base 128 with the top bit set
+		// on all but the last byte
+		e.Tag = 0
+		for {
+			if i >= len(b) || i > 4 {
+				return berElement{}, nil, errors.New("invalid BER tag")
+			}
+
+			e.Tag = e.Tag<<7 | int(b[i]&0x7f)
+			i++
+
+			if b[i-1]&0x80 == 0 {
+				break
+			}
+		}
+	}
+
+	if i >= len(b) {
+		return berElement{}, nil, errors.New("truncated BER element")
+	}
+
+	length := int(b[i])
+	i++
+
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 {
+			// Indefinite lengths aren't allowed in LDAP
+			return berElement{}, nil, fmt.Errorf("unsupported BER length of %v bytes", n)
+		}
+
+		if i+n > len(b) {
+			return berElement{}, nil, errors.New("truncated BER length")
+		}
+
+		length = 0
+		for _, lb := range b[i : i+n] {
+			length = length<<8 | int(lb)
+		}
+		i += n
+	}
+
+	if length < 0 || length > len(b)-i {
+		return berElement{}, nil, errors.New("truncated BER element")
+	}
+
+	e.Content = b[i : i+length]
+
+	return e, b[i+length:], nil
+}
+
+// ldapMaxMessageSize The largest LDAP response we'll read, a StartTLS
+// response is only a few bytes
+const ldapMaxMessageSize = 64 * 1024
+
+// readBERElement Reads a single BER encoded element, including its tag and
+// length, from the reader
+func readBERElement(r io.Reader) ([]byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	length := int(header[1])
+
+	if length&0x80 != 0 {
+		// Long form, the lower bits are the number of length bytes
+		n := length & 0x7f
+		if n == 0 || n > 4 {
+			return nil, fmt.Errorf("unsupported BER length of %v bytes", n)
+		}
+
+		lengthBytes := make([]byte, n)
+		if _, err := io.ReadFull(r, lengthBytes); err != nil {
+			return nil, err
+		}
+
+		header = append(header, lengthBytes...)
+
+		length = 0
+		for _, b := range lengthBytes {
+			length = length<<8 | int(b)
+		}
+	}
+
+	if length > ldapMaxMessageSize {
+		return nil, fmt.Errorf("message of %v bytes is too large", length)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	return append(header, body...), nil
+}
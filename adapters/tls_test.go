@@ -0,0 +1,510 @@
+package adapters
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/overmindtech/sdp-go"
+	"golang.org/x/crypto/ocsp"
+)
+
+// startTestTLSServer Accepts connections on localhost, runs `preamble` if
+// given to emulate STARTTLS, and then completes a TLS handshake. Returns the
+// port that it is listening on
+func startTestTLSServer(t *testing.T, config *tls.Config, preamble func(conn net.Conn) error) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+				if preamble != nil {
+					if err := preamble(conn); err != nil {
+						return
+					}
+				}
+
+				_ = tls.Server(conn, config).Handshake()
+			}()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	return port
+}
+
+func testTLSCertificate(chain testChain) tls.Certificate {
+	return tls.Certificate{
+		Certificate: [][]byte{chain.Leaf.Raw, chain.Intermediate.Raw},
+		PrivateKey:  chain.LeafKey,
+	}
+}
+
+func testOCSPStaple(t *testing.T, chain testChain, status int, nextUpdate time.Time) []byte {
+	t.Helper()
+
+	staple, err := ocsp.CreateResponse(chain.Intermediate, chain.Intermediate, ocsp.Response{
+		Status:       status,
+		SerialNumber: chain.Leaf.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Hour),
+		NextUpdate:   nextUpdate,
+		RevokedAt:    time.Now().Add(-time.Hour),
+	}, chain.IntermediateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return staple
+}
+
+func TestParseTLSTarget(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		Query    string
+		Expected string
+		Error    bool
+	}{
+		{Query: "example.com:443", Expected: "example.com:443"},
+		{Query: "Example.COM.:8443", Expected: "example.com:8443"},
+		{Query: "tls://example.com:993", Expected: "example.com:993"},
+		{Query: "[2001:db8::1]:443", Expected: "[2001:db8::1]:443"},
+		{Query: "smtp://mail.example.com", Expected: "smtp://mail.example.com:25"},
+		{Query: "SMTP://mail.example.com:587", Expected: "smtp://mail.example.com:587"},
+		{Query: "ldap://[2001:db8::1]", Expected: "ldap://[2001:db8::1]:389"},
+		{Query: "postgres://db.example.com", Expected: "postgres://db.example.com:5432"},
+		{Query: "example.com", Error: true},
+		{Query: ":443", Error: true},
+		{Query: "example.com:0", Error: true},
+		{Query: "example.com:https", Error: true},
+		{Query: "gopher://example.com:70", Error: true},
+	}
+
+	for _, test := range tests {
+		target, err := parseTLSTarget(test.Query)
+
+		if test.Error {
+			if err == nil {
+				t.Errorf("expected an error for %v, got %v", test.Query, target)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("unexpected error for %v: %v", test.Query, err)
+		} else if target.String() != test.Expected {
+			t.Errorf("expected %v to be %v, got %v", test.Query, test.Expected, target)
+		}
+	}
+}
+
+func TestTLSGet(t *testing.T) {
+	t.Parallel()
+
+	chain := newTestChain(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+	}, false)
+
+	// Clients that don't send SNI get a different certificate
+	fallback := newTestChain(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "default"},
+		DNSNames: []string{"default.example.com"},
+	}, false)
+
+	cert := testTLSCertificate(chain)
+	cert.OCSPStaple = testOCSPStaple(t, chain, ocsp.Good, time.Now().Add(time.Hour))
+	fallbackCert := testTLSCertificate(fallback)
+
+	port := startTestTLSServer(t, &tls.Config{
+		NextProtos: []string{"h2"},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if hello.ServerName == "" {
+				return &fallbackCert, nil
+			}
+			return &cert, nil
+		},
+	}, nil)
+
+	src := TLSAdapter{RootCAs: chain.Pool()}
+
+	item, err := src.Get(context.Background(), "global", "localhost:"+port, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := item.Validate(); err != nil {
+		t.Error(err)
+	}
+
+	expected := map[string]interface{}{
+		"name":          "localhost:" + port,
+		"version":       "TLSv1.3",
+		"alpn":          "h2",
+		"trusted":       true,
+		"hostnameMatch": true,
+		"ocspStapled":   true,
+		"certificate":   CertToName(chain.Leaf),
+	}
+
+	for k, v := range expected {
+		if actual, _ := item.GetAttributes().Get(k); actual != v {
+			t.Errorf("expected %v to be %v, got %v", k, v, actual)
+		}
+	}
+
+	if suite, _ := item.GetAttributes().Get("cipherSuite"); !strings.HasPrefix(suite.(string), "TLS_") {
+		t.Errorf("expected a cipher suite, got %v", suite)
+	}
+
+	staple, _ := item.GetAttributes().Get("ocspStaple")
+	if status := staple.(map[string]interface{})["status"]; status != "good" {
+		t.Errorf("expected a good OCSP staple, got %v", staple)
+	}
+
+	sniAttrs, _ := item.GetAttributes().Get("sni")
+	sni := sniAttrs.(map[string]interface{})
+
+	if sni["serverName"] != "localhost" || sni["required"] != false || sni["sameCertificateWithoutSni"] != false {
+		t.Errorf("expected a different certificate without SNI, got %v", sni)
+	}
+
+	if sni["certificateWithoutSni"] != CertToName(fallback.Leaf) {
+		t.Errorf("expected the default certificate, got %v", sni["certificateWithoutSni"])
+	}
+
+	if item.Health != nil {
+		t.Errorf("expected no health, got %v", item.GetHealth())
+	}
+
+	assertLinks(t, item, []string{
+		"dns SEARCH localhost",
+		"ip GET 127.0.0.1",
+	})
+
+	var bundle string
+	for _, liq := range item.GetLinkedItemQueries() {
+		if liq.GetQuery().GetType() == "certificate" {
+			bundle = liq.GetQuery().GetQuery()
+		}
+	}
+
+	certs, err := (&CertificateAdapter{RootCAs: chain.Pool()}).Search(context.Background(), "global", bundle, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(certs) != 2 {
+		t.Errorf("expected the leaf and intermediate to be linked, got %v certificates", len(certs))
+	}
+
+	t.Run("untrusted", func(t *testing.T) {
+		item, err := (&TLSAdapter{}).Get(context.Background(), "global", "127.0.0.1:"+port, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if trusted, _ := item.GetAttributes().Get("trusted"); trusted != false {
+			t.Errorf("expected the chain not to be trusted, got %v", trusted)
+		}
+
+		if sni, _ := item.GetAttributes().Get("sni"); sni.(map[string]interface{})["sent"] != false {
+			t.Errorf("expected SNI not to be sent for an IP, got %v", sni)
+		}
+
+		if item.GetHealth() != sdp.Health_HEALTH_ERROR {
+			t.Errorf("expected an error health, got %v", item.GetHealth())
+		}
+	})
+}
+
+func TestTLSGetSNIRequired(t *testing.T) {
+	t.Parallel()
+
+	chain := newTestChain(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "localhost"},
+		DNSNames: []string{"localhost"},
+	}, false)
+
+	cert := testTLSCertificate(chain)
+
+	tests := []struct {
+		Name string
+		// What the server does without SNI
+		WithoutSNI func(hello *tls.ClientHelloInfo) (*tls.Config, error)
+		// The expected value of `required`, nil if it should be unknown
+		Required interface{}
+	}{
+		{
+			Name: "unrecognized name",
+			WithoutSNI: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+				// With no certificates the server sends unrecognized_name
+				return &tls.Config{}, nil
+			},
+			Required: true,
+		},
+		{
+			Name: "dropped connection",
+			WithoutSNI: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+				hello.Conn.Close()
+				return nil, errors.New("dropped")
+			},
+			Required: nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			port := startTestTLSServer(t, &tls.Config{
+				Certificates: []tls.Certificate{cert},
+				GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+					if hello.ServerName == "" {
+						return test.WithoutSNI(hello)
+					}
+					return nil, nil
+				},
+			}, nil)
+
+			item, err := (&TLSAdapter{RootCAs: chain.Pool()}).Get(context.Background(), "global", "localhost:"+port, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sniAttrs, _ := item.GetAttributes().Get("sni")
+			sni := sniAttrs.(map[string]interface{})
+
+			if sni["required"] != test.Required {
+				t.Errorf("expected required to be %v, got %v", test.Required, sni)
+			}
+
+			if _, ok := sni["errorWithoutSni"]; !ok {
+				t.Errorf("expected the error without SNI, got %v", sni)
+			}
+		})
+	}
+}
+
+func TestTLSGetStartTLS(t *testing.T) {
+	t.Parallel()
+
+	chain := newTestChain(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+	}, false)
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{testTLSCertificate(chain)},
+	}
+
+	// smtp Emulates an SMTP server, only advertising STARTTLS if supported
+	smtp := func(supported bool) func(conn net.Conn) error {
+		return func(conn net.Conn) error {
+			r := bufio.NewReader(conn)
+
+			conn.Write([]byte("220 mail.example.com ESMTP\r\n"))
+
+			if _, err := r.ReadString('\n'); err != nil {
+				return err
+			}
+
+			if supported {
+				conn.Write([]byte("250-mail.example.com\r\n250-PIPELINING\r\n250 STARTTLS\r\n"))
+			} else {
+				conn.Write([]byte("250-mail.example.com\r\n250 PIPELINING\r\n"))
+			}
+
+			if _, err := r.ReadString('\n'); err != nil {
+				return err
+			}
+
+			conn.Write([]byte("220 Ready to start TLS\r\n"))
+
+			return nil
+		}
+	}
+
+	// ldap Returns an ExtendedResponse with the result code. Active
+	// Directory uses the long form for every length, even when the short
+	// form would do
+	ldap := func(resultCode byte, longForm bool) func(conn net.Conn) error {
+		ber := func(tag byte, content ...[]byte) []byte {
+			joined := bytes.Join(content, nil)
+			if longForm {
+				return append([]byte{tag, 0x84, 0, 0, 0, byte(len(joined))}, joined...)
+			}
+
+			return append([]byte{tag, byte(len(joined))}, joined...)
+		}
+
+		return func(conn net.Conn) error {
+			b, err := readBERElement(conn)
+			if err != nil {
+				return err
+			}
+
+			var req ldapMessage
+			if _, err := asn1.Unmarshal(b, &req); err != nil {
+				return err
+			}
+
+			res := ber(0x30,
+				ber(0x02, []byte{byte(req.MessageID)}),
+				ber(0x78,
+					ber(0x0a, []byte{resultCode}),
+					ber(0x04),
+					ber(0x04, []byte("unavailable")),
+				),
+			)
+
+			_, err = conn.Write(res)
+
+			return err
+		}
+	}
+
+	postgres := func(conn net.Conn) error {
+		b := make([]byte, 8)
+		if _, err := conn.Read(b); err != nil {
+			return err
+		}
+
+		_, err := conn.Write([]byte("S"))
+
+		return err
+	}
+
+	imap := func(conn net.Conn) error {
+		r := bufio.NewReader(conn)
+
+		conn.Write([]byte("* OK IMAP4rev1 ready\r\n"))
+
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+
+		tag, _, _ := strings.Cut(line, " ")
+		conn.Write([]byte("* CAPABILITY IMAP4rev1\r\n" + tag + " OK Begin TLS negotiation now\r\n"))
+
+		return nil
+	}
+
+	tests := []struct {
+		Protocol string
+		Preamble func(conn net.Conn) error
+		Error    bool
+	}{
+		{Protocol: "smtp", Preamble: smtp(true)},
+		{Protocol: "smtp", Preamble: smtp(false), Error: true},
+		{Protocol: "ldap", Preamble: ldap(0, false)},
+		{Protocol: "ldap", Preamble: ldap(0, true)},
+		{Protocol: "ldap", Preamble: ldap(52, true), Error: true},
+		{Protocol: "postgres", Preamble: postgres},
+		{Protocol: "imap", Preamble: imap},
+	}
+
+	for _, test := range tests {
+		port := startTestTLSServer(t, config, test.Preamble)
+		query := test.Protocol + "://127.0.0.1:" + port
+
+		item, err := (&TLSAdapter{RootCAs: chain.Pool()}).Get(context.Background(), "global", query, false)
+
+		if test.Error {
+			if err == nil {
+				t.Errorf("expected an error for %v", query)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("unexpected error for %v: %v", query, err)
+			continue
+		}
+
+		if starttls, _ := item.GetAttributes().Get("starttls"); starttls != test.Protocol {
+			t.Errorf("expected starttls to be %v, got %v", test.Protocol, starttls)
+		}
+
+		if trusted, _ := item.GetAttributes().Get("trusted"); trusted != true {
+			t.Errorf("expected %v to be trusted, got %v", query, trusted)
+		}
+
+		if alpn, err := item.GetAttributes().Get("alpn"); err == nil {
+			t.Errorf("expected no ALPN after STARTTLS, got %v", alpn)
+		}
+	}
+}
+
+func TestOCSPStapleAttributes(t *testing.T) {
+	t.Parallel()
+
+	chain := newTestChain(t, &x509.Certificate{
+		Subject: pkix.Name{CommonName: "www.example.com"},
+	}, false)
+
+	certs := []*x509.Certificate{chain.Leaf, chain.Intermediate}
+
+	t.Run("revoked", func(t *testing.T) {
+		attrs, health := ocspStapleAttributes(testOCSPStaple(t, chain, ocsp.Revoked, time.Now().Add(time.Hour)), certs)
+
+		if attrs["status"] != "revoked" || attrs["revokedAt"] == nil {
+			t.Errorf("expected a revoked status, got %v", attrs)
+		}
+
+		if health == nil || *health != sdp.Health_HEALTH_ERROR {
+			t.Errorf("expected an error health, got %v", health)
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		attrs, health := ocspStapleAttributes(testOCSPStaple(t, chain, ocsp.Good, time.Now().Add(-time.Minute)), certs)
+
+		if attrs["status"] != "good" || attrs["expired"] != true {
+			t.Errorf("expected an expired response, got %v", attrs)
+		}
+
+		if health == nil || *health != sdp.Health_HEALTH_WARNING {
+			t.Errorf("expected a warning health, got %v", health)
+		}
+	})
+
+	t.Run("wrong issuer", func(t *testing.T) {
+		other := newTestChain(t, &x509.Certificate{
+			Subject: pkix.Name{CommonName: "www.example.com"},
+		}, false)
+
+		attrs, _ := ocspStapleAttributes(testOCSPStaple(t, other, ocsp.Good, time.Now().Add(time.Hour)), certs)
+
+		if attrs["error"] == nil {
+			t.Errorf("expected an error, got %v", attrs)
+		}
+	})
+}